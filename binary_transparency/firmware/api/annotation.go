@@ -14,16 +14,37 @@
 
 package api
 
-import "fmt"
+import (
+	"bytes"
+	"fmt"
+)
 
 // StatementType is an enum that describes the type of statement in a SignedStatement.
 type StatementType byte
 
 // Enum values for the different types of statement.
 const (
-	FirmwareMetadataType    StatementType = 'f'
-	MalwareStatementType    StatementType = 'm'
-	RevocationStatementType StatementType = 'r'
+	FirmwareMetadataType       StatementType = 'f'
+	MalwareStatementType       StatementType = 'm'
+	RevocationStatementType    StatementType = 'r'
+	BuildStatementType         StatementType = 'b'
+	SBOMStatementType          StatementType = 's'
+	VulnerabilityStatementType StatementType = 'v'
+)
+
+// SignatureAlgorithm identifies the scheme used to create the Signature in a SignedStatement.
+type SignatureAlgorithm string
+
+// Enum values for the supported signature algorithms.
+const (
+	// RSAPSSSHA512 is RSA-PSS over the SHA-512 hash of the signed bytes.
+	// Statements with no Algorithm set are assumed to use this.
+	RSAPSSSHA512 SignatureAlgorithm = "RSA-PSS-SHA512"
+	// Ed25519 is a pure Ed25519 signature over the signed bytes.
+	Ed25519 SignatureAlgorithm = "Ed25519"
+	// ECDSAP256SHA256 is an ASN.1 encoded ECDSA P-256 signature over the
+	// SHA-256 hash of the signed bytes.
+	ECDSAP256SHA256 SignatureAlgorithm = "ECDSA-P256-SHA256"
 )
 
 // SignedStatement is a Statement signed by the Claimant.
@@ -32,11 +53,18 @@ type SignedStatement struct {
 	// Statement should be interpreted as.
 	Type StatementType
 	// The serialised Claim in json form.
-	// This is one of MalwareStatement or BuildStatement.
+	// This is one of FirmwareMetadata, or one of the annotations below.
 	Statement []byte
 
 	// Signature is the bytestream of the signature over (Type || Statement).
 	Signature []byte
+
+	// KeyID optionally identifies the key which made the signature.
+	// This is a hint for verifiers and is not covered by the signature.
+	KeyID string `json:",omitempty"`
+	// Algorithm optionally identifies the scheme used to create the signature.
+	// This is a hint for verifiers and is not covered by the signature.
+	Algorithm SignatureAlgorithm `json:",omitempty"`
 }
 
 // FirmwareID is a pointer to a firmware version.
@@ -55,20 +83,100 @@ type MalwareStatement struct {
 	// FirmwareID is the SignedStatement in the log being annotated.
 	FirmwareID FirmwareID
 
-	// Good is a crude signal of goodness.
-	// TODO(mhutchinson): Add more fields as needed for the demo (e.g. Timestamp).
+	// Good is a crude signal of goodness. It is false if any of the Scans found malware.
 	Good bool
+
+	// Scans are the verdicts of each of the scanners which checked the firmware.
+	Scans []ScanResult `json:",omitempty"`
+	// TimestampNanos is when the firmware was scanned, in nanoseconds since the Unix epoch.
+	TimestampNanos uint64 `json:",omitempty"`
 }
 
 func (s MalwareStatement) String() string {
-	return fmt.Sprintf("MalwareStatement{fw %s, good %t}", s.FirmwareID, s.Good)
+	return fmt.Sprintf("MalwareStatement{fw %s, good %t, scans %v}", s.FirmwareID, s.Good, s.Scans)
+}
+
+// ScanResult is the verdict of a single malware scanner on a firmware image.
+type ScanResult struct {
+	// Scanner names the scanner which checked the firmware.
+	Scanner string
+	// Good is false if the scanner found malware.
+	Good bool
+	// Hits names the rules or signatures which matched the firmware.
+	Hits []string `json:",omitempty"`
+}
+
+func (r ScanResult) String() string {
+	return fmt.Sprintf("%s{good %t, hits %q}", r.Scanner, r.Good, r.Hits)
 }
 
 // RevocationStatement is an annotation that marks a build as revoked.
 // This statement simply being present for a build marks it as revoked.
 // There is no way to unrevoke something; this can be done by re-releasing it.
-// TODO(mhutchinson): Wire this up in the personality.
 type RevocationStatement struct {
 	// FirmwareID is the SignedStatement in the log being annotated.
 	FirmwareID FirmwareID
 }
+
+func (s RevocationStatement) String() string {
+	return fmt.Sprintf("RevocationStatement{fw %s}", s.FirmwareID)
+}
+
+// BuildStatement is an annotation attesting to the result of rebuilding a firmware
+// version from its source.
+type BuildStatement struct {
+	// FirmwareID is the SignedStatement in the log being annotated.
+	FirmwareID FirmwareID
+
+	// SourceURI identifies the source that the firmware was rebuilt from, e.g. a
+	// repository URL and revision.
+	SourceURI string `json:",omitempty"`
+	// RebuiltImageSHA512 is the SHA512 hash of the image built by the claimant.
+	RebuiltImageSHA512 []byte
+}
+
+// Reproducible returns whether the rebuilt image is identical to the image named by FirmwareID.
+// This says nothing about the logged firmware unless FirmwareID has been checked against it.
+func (s BuildStatement) Reproducible() bool {
+	return bytes.Equal(s.RebuiltImageSHA512, s.FirmwareID.FirmwareImageSHA512)
+}
+
+func (s BuildStatement) String() string {
+	return fmt.Sprintf("BuildStatement{fw %s, reproducible %t}", s.FirmwareID, s.Reproducible())
+}
+
+// SBOMStatement is an annotation which references the software bill of materials (SBOM)
+// for a firmware version. The SBOM itself is not logged.
+type SBOMStatement struct {
+	// FirmwareID is the SignedStatement in the log being annotated.
+	FirmwareID FirmwareID
+
+	// Format is the format of the SBOM, e.g. "SPDX-2.2" or "CycloneDX-1.3".
+	Format string
+	// SBOMSHA512 is the SHA512 hash of the SBOM document.
+	SBOMSHA512 []byte
+	// URI is where the SBOM can be fetched from, if it is published.
+	URI string `json:",omitempty"`
+}
+
+func (s SBOMStatement) String() string {
+	return fmt.Sprintf("SBOMStatement{fw %s, %s sbom %x}", s.FirmwareID, s.Format, s.SBOMSHA512)
+}
+
+// VulnerabilityStatement is an annotation which gives notice of a known vulnerability
+// in a firmware version.
+type VulnerabilityStatement struct {
+	// FirmwareID is the SignedStatement in the log being annotated.
+	FirmwareID FirmwareID
+
+	// ID identifies the vulnerability, e.g. "CVE-2021-12345".
+	ID string
+	// Severity is a rating of the vulnerability, e.g. "HIGH".
+	Severity string `json:",omitempty"`
+	// Description describes the vulnerability and how it affects the firmware.
+	Description string `json:",omitempty"`
+}
+
+func (s VulnerabilityStatement) String() string {
+	return fmt.Sprintf("VulnerabilityStatement{fw %s, %s}", s.FirmwareID, s.ID)
+}
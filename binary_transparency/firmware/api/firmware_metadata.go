@@ -14,15 +14,22 @@
 
 package api
 
-import "fmt"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
 
 // FirmwareMetadata represents a firmware image and related info.
 type FirmwareMetadata struct {
 	////// What's this firmware for? //////
 
-	// TODO(al): consider a vendor/device/publisher model
-
 	// DeviceID specifies the target device for this firmware.
+	// Which publishers may release firmware for a DeviceID is a matter of
+	// policy, configured in the claimant registry.
 	DeviceID string
 
 	////// What's its identity? //////
@@ -44,9 +51,86 @@ type FirmwareMetadata struct {
 	// BuildTimestamp is the time at which this build was published in RFC3339 format.
 	// e.g. "1985-04-12T23:20:50.52Z"
 	BuildTimestamp string
+
+	// SourceURI locates a tarball of the source this firmware was built from,
+	// which allows anybody to rebuild it and check that it's reproducible.
+	SourceURI string `json:",omitempty"`
+
+	// SourceRepository is the URL of the version control repository that the
+	// firmware was built from, e.g. "https://github.com/example/firmware".
+	SourceRepository string `json:",omitempty"`
+
+	// SourceCommit is the lowercase hex commit hash in SourceRepository that the
+	// firmware was built from.
+	SourceCommit string `json:",omitempty"`
+
+	// ToolchainSHA256 is the SHA256 digest of the toolchain used for the build,
+	// e.g. the digest of the builder container image.
+	ToolchainSHA256 []byte `json:",omitempty"`
+
+	// BuilderID identifies the entity that ran the build, e.g. the URI of a CI
+	// system, or an email address.
+	BuilderID string `json:",omitempty"`
+
+	// ProvenanceSHA256 is the SHA256 digest of a detailed provenance document
+	// for the build, such as an in-toto or SLSA provenance attestation.
+	ProvenanceSHA256 []byte `json:",omitempty"`
 }
 
 // String returns a human-readable representation of the firmware metadata info.
 func (m FirmwareMetadata) String() string {
-	return fmt.Sprintf("%s/v%d built at %s with image hash 0x%x", m.DeviceID, m.FirmwareRevision, m.BuildTimestamp, m.FirmwareImageSHA512)
+	s := fmt.Sprintf("%s/v%d built at %s with image hash 0x%x", m.DeviceID, m.FirmwareRevision, m.BuildTimestamp, m.FirmwareImageSHA512)
+	if len(m.SourceCommit) > 0 {
+		s += fmt.Sprintf(" from %s@%s", m.SourceRepository, m.SourceCommit)
+	}
+	return s
+}
+
+// Validate returns an error if the metadata is malformed. The provenance fields
+// are optional, but must be well formed if they are present.
+func (m FirmwareMetadata) Validate() error {
+	if _, err := time.Parse(time.RFC3339, m.BuildTimestamp); err != nil {
+		return fmt.Errorf("BuildTimestamp %q is not in RFC3339 format: %v", m.BuildTimestamp, err)
+	}
+	if len(m.SourceRepository) > 0 {
+		u, err := url.Parse(m.SourceRepository)
+		if err != nil {
+			return fmt.Errorf("SourceRepository %q is not a URL: %v", m.SourceRepository, err)
+		}
+		if !u.IsAbs() {
+			return fmt.Errorf("SourceRepository %q is not an absolute URL", m.SourceRepository)
+		}
+	}
+	if len(m.SourceCommit) > 0 {
+		if len(m.SourceRepository) == 0 {
+			return errors.New("SourceCommit requires SourceRepository")
+		}
+		if err := validateCommit(m.SourceCommit); err != nil {
+			return err
+		}
+	}
+	if l := len(m.ToolchainSHA256); l > 0 && l != sha256.Size {
+		return fmt.Errorf("ToolchainSHA256 has %d bytes, want %d", l, sha256.Size)
+	}
+	if l := len(m.ProvenanceSHA256); l > 0 && l != sha256.Size {
+		return fmt.Errorf("ProvenanceSHA256 has %d bytes, want %d", l, sha256.Size)
+	}
+	return nil
+}
+
+// validateCommit checks that the commit is a lowercase hex SHA-1 or SHA-256 hash,
+// as used by git, so that each commit has exactly one representation.
+func validateCommit(c string) error {
+	if len(c) != 2*20 && len(c) != 2*sha256.Size {
+		return fmt.Errorf("SourceCommit %q has %d characters, want 40 or 64", c, len(c))
+	}
+	if _, err := hex.DecodeString(c); err != nil {
+		return fmt.Errorf("SourceCommit %q is not hex: %v", c, err)
+	}
+	for _, r := range c {
+		if r >= 'A' && r <= 'F' {
+			return fmt.Errorf("SourceCommit %q is not lowercase", c)
+		}
+	}
+	return nil
 }
@@ -18,6 +18,7 @@ package api_test
 import (
 	"fmt"
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/google/trillian-examples/binary_transparency/firmware/api"
@@ -59,3 +60,83 @@ func TestFirmwareMetadataToString(t *testing.T) {
 		})
 	}
 }
+
+func TestFirmwareMetadataValidate(t *testing.T) {
+	valid := api.FirmwareMetadata{
+		DeviceID:            "device",
+		FirmwareRevision:    1,
+		FirmwareImageSHA512: []byte("this is an image hash"),
+		BuildTimestamp:      "2021-10-10T15:30:20.19Z",
+	}
+	withProvenance := valid
+	withProvenance.SourceRepository = "https://github.com/example/firmware"
+	withProvenance.SourceCommit = "0123456789abcdef0123456789abcdef01234567"
+	withProvenance.ToolchainSHA256 = make([]byte, 32)
+	withProvenance.BuilderID = "https://ci.example.com/builder"
+	withProvenance.ProvenanceSHA256 = make([]byte, 32)
+
+	for _, test := range []struct {
+		desc    string
+		modify  func(m *api.FirmwareMetadata)
+		wantErr bool
+	}{
+		{
+			desc:   "no provenance",
+			modify: func(m *api.FirmwareMetadata) { *m = valid },
+		}, {
+			desc:   "all provenance",
+			modify: func(m *api.FirmwareMetadata) {},
+		}, {
+			desc:   "sha256 commit",
+			modify: func(m *api.FirmwareMetadata) { m.SourceCommit = strings.Repeat("ab", 32) },
+		}, {
+			desc:    "bad timestamp",
+			modify:  func(m *api.FirmwareMetadata) { m.BuildTimestamp = "noon" },
+			wantErr: true,
+		}, {
+			desc:    "missing timestamp",
+			modify:  func(m *api.FirmwareMetadata) { m.BuildTimestamp = "" },
+			wantErr: true,
+		}, {
+			desc:    "relative repository",
+			modify:  func(m *api.FirmwareMetadata) { m.SourceRepository = "example/firmware" },
+			wantErr: true,
+		}, {
+			desc:    "commit without repository",
+			modify:  func(m *api.FirmwareMetadata) { m.SourceRepository = "" },
+			wantErr: true,
+		}, {
+			desc:    "short commit",
+			modify:  func(m *api.FirmwareMetadata) { m.SourceCommit = "0123456" },
+			wantErr: true,
+		}, {
+			desc:    "uppercase commit",
+			modify:  func(m *api.FirmwareMetadata) { m.SourceCommit = strings.ToUpper(m.SourceCommit) },
+			wantErr: true,
+		}, {
+			desc:    "non-hex commit",
+			modify:  func(m *api.FirmwareMetadata) { m.SourceCommit = strings.Repeat("xy", 20) },
+			wantErr: true,
+		}, {
+			desc:    "short toolchain digest",
+			modify:  func(m *api.FirmwareMetadata) { m.ToolchainSHA256 = []byte("short") },
+			wantErr: true,
+		}, {
+			desc:    "short provenance digest",
+			modify:  func(m *api.FirmwareMetadata) { m.ProvenanceSHA256 = []byte("short") },
+			wantErr: true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			m := withProvenance
+			test.modify(&m)
+			err := m.Validate()
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("unexpected error: %v", err)
+			case err == nil && test.wantErr:
+				t.Fatal("expected error, got none")
+			}
+		})
+	}
+}
@@ -16,6 +16,7 @@ package api
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -29,6 +30,14 @@ const (
 	HTTPAddFirmware = "ft/v0/add-firmware"
 	// HTTPAddAnnotationMalware is the path of the URL to publish annotations about malware scans.
 	HTTPAddAnnotationMalware = "ft/v0/add-annotation-malware"
+	// HTTPAddRevocation is the path of the URL to publish revocations of logged firmware.
+	HTTPAddRevocation = "ft/v0/add-revocation"
+	// HTTPAddAnnotationBuild is the path of the URL to publish annotations about rebuilding firmware.
+	HTTPAddAnnotationBuild = "ft/v0/add-annotation-build"
+	// HTTPAddAnnotationSBOM is the path of the URL to publish annotations referencing SBOMs.
+	HTTPAddAnnotationSBOM = "ft/v0/add-annotation-sbom"
+	// HTTPAddAnnotationVulnerability is the path of the URL to publish annotations about vulnerabilities.
+	HTTPAddAnnotationVulnerability = "ft/v0/add-annotation-vulnerability"
 	// HTTPGetConsistency is the path of the URL to get a consistency proof between log roots.
 	HTTPGetConsistency = "ft/v0/get-consistency"
 	// HTTPGetInclusion is the path of the URL to get inclusion proofs for entries in the log.
@@ -39,9 +48,12 @@ const (
 	HTTPGetFirmwareImage = "ft/v0/get-firmware-image"
 	// HTTPGetRoot is the path of the URL to get a recent log root.
 	HTTPGetRoot = "ft/v0/get-root"
+	// HTTPTiles is the path prefix of the log in the tile-based (serverless) layout, if
+	// the personality publishes it. e.g. the checkpoint is at HTTPTiles + "/checkpoint".
+	HTTPTiles = "ft/v0/tiles"
 
 	// FTLogOrigin is the identifier of the demo log.
-	// TODO(al): extract this so it's a config option on the log.
+	// Deployments may configure their own origin; see LogVerifier.
 	FTLogOrigin = "Firmware Transparency Log"
 )
 
@@ -70,11 +82,79 @@ func (l LogCheckpoint) Marshal() []byte {
 	return b.Bytes()
 }
 
-// ParseCheckpoint wraps `log.ParseCheckpoint` with the additional behaviour of
-// enforcing that a timestamp is included in the `otherdata`, and returning a
-// LogCheckpoint constructed from this data.
-func ParseCheckpoint(chkpt []byte, logVerifier note.Verifier, otherVerifiers ...note.Verifier) (*LogCheckpoint, error) {
-	cp, otherData, _, err := log.ParseCheckpoint(chkpt, FTLogOrigin, logVerifier, otherVerifiers...)
+// LogVerifier verifies checkpoints signed by a log.
+// During a key rotation the log co-signs its checkpoints with both the old and new
+// keys, so a checkpoint is accepted if it has a valid signature from any of Verifiers.
+type LogVerifier struct {
+	// Origin is the expected first line of checkpoints from the log.
+	Origin string
+	// Verifiers are the public keys of the log.
+	Verifiers []note.Verifier
+}
+
+// NewLogVerifier returns a LogVerifier for the log with the given origin, which
+// signs checkpoints with any of the given note verifier keys.
+func NewLogVerifier(origin string, vkeys ...string) (LogVerifier, error) {
+	if len(vkeys) == 0 {
+		return LogVerifier{}, errors.New("at least one log key is required")
+	}
+	lv := LogVerifier{Origin: origin}
+	for _, k := range vkeys {
+		v, err := note.NewVerifier(k)
+		if err != nil {
+			return LogVerifier{}, fmt.Errorf("invalid log key %q: %w", k, err)
+		}
+		lv.Verifiers = append(lv.Verifiers, v)
+	}
+	return lv, nil
+}
+
+// signedBy returns true if the note has a signature from one of the log keys.
+// Only verified signatures are present in the note returned by note.Open.
+func (lv LogVerifier) signedBy(n *note.Note) bool {
+	for _, s := range n.Sigs {
+		for _, v := range lv.Verifiers {
+			if s.Hash == v.KeyHash() && s.Name == v.Name() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// VerifyCheckpoint parses a checkpoint signed by the log, returning the checkpoint
+// and any extension lines (`otherdata`) following it. Unlike ParseCheckpoint, no
+// extension lines are required, so this works for checkpoints from any log.
+// Signatures from otherVerifiers are checked if present, but are not required.
+func VerifyCheckpoint(chkpt []byte, lv LogVerifier, otherVerifiers ...note.Verifier) (*log.Checkpoint, []byte, error) {
+	if len(lv.Verifiers) == 0 {
+		return nil, nil, errors.New("no log keys to verify checkpoint with")
+	}
+	vs := append(append(make([]note.Verifier, 0, len(lv.Verifiers)+len(otherVerifiers)), lv.Verifiers...), otherVerifiers...)
+	n, err := note.Open(chkpt, note.VerifierList(vs...))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to verify signatures on checkpoint: %v", err)
+	}
+	if !lv.signedBy(n) {
+		return nil, nil, errors.New("no log signature found on note")
+	}
+	cp := &log.Checkpoint{}
+	otherData, err := cp.Unmarshal([]byte(n.Text))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal checkpoint: %v", err)
+	}
+	if cp.Origin != lv.Origin {
+		return nil, nil, fmt.Errorf("got Origin %q but expected %q", cp.Origin, lv.Origin)
+	}
+	return cp, otherData, nil
+}
+
+// ParseCheckpoint parses a checkpoint signed by the log, with the additional
+// behaviour of enforcing that a timestamp is included in the `otherdata`, and
+// returning a LogCheckpoint constructed from this data.
+// Signatures from otherVerifiers are checked if present, but are not required.
+func ParseCheckpoint(chkpt []byte, lv LogVerifier, otherVerifiers ...note.Verifier) (*LogCheckpoint, error) {
+	cp, otherData, err := VerifyCheckpoint(chkpt, lv, otherVerifiers...)
 	if err != nil {
 		return nil, err
 	}
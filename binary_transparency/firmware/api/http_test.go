@@ -15,10 +15,12 @@
 package api_test
 
 import (
+	"crypto/rand"
 	"testing"
 
 	"github.com/google/trillian-examples/binary_transparency/firmware/api"
 	"github.com/transparency-dev/formats/log"
+	"golang.org/x/mod/sumdb/note"
 )
 
 func TestLogCheckpointString(t *testing.T) {
@@ -45,3 +47,86 @@ func TestLogCheckpointString(t *testing.T) {
 		}
 	}
 }
+
+func TestParseCheckpoint(t *testing.T) {
+	mustKey := func(name string) (note.Signer, string) {
+		t.Helper()
+		skey, vkey, err := note.GenerateKey(rand.Reader, name)
+		if err != nil {
+			t.Fatalf("GenerateKey(): %v", err)
+		}
+		s, err := note.NewSigner(skey)
+		if err != nil {
+			t.Fatalf("NewSigner(): %v", err)
+		}
+		return s, vkey
+	}
+	oldS, oldV := mustKey("log-2020")
+	newS, newV := mustKey("log-2021")
+	otherS, _ := mustKey("someone-else")
+
+	const origin = "Test Log"
+	lv, err := api.NewLogVerifier(origin, oldV, newV)
+	if err != nil {
+		t.Fatalf("NewLogVerifier(): %v", err)
+	}
+
+	for _, test := range []struct {
+		desc    string
+		origin  string
+		signers []note.Signer
+		wantErr bool
+	}{
+		{
+			desc:    "old key",
+			origin:  origin,
+			signers: []note.Signer{oldS},
+		}, {
+			desc:    "new key",
+			origin:  origin,
+			signers: []note.Signer{newS},
+		}, {
+			desc:    "co-signed",
+			origin:  origin,
+			signers: []note.Signer{oldS, newS},
+		}, {
+			desc:    "co-signed with unknown key",
+			origin:  origin,
+			signers: []note.Signer{otherS, newS},
+		}, {
+			desc:    "unknown key",
+			origin:  origin,
+			signers: []note.Signer{otherS},
+			wantErr: true,
+		}, {
+			desc:    "wrong origin",
+			origin:  api.FTLogOrigin,
+			signers: []note.Signer{oldS},
+			wantErr: true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			cp := api.LogCheckpoint{
+				Checkpoint: log.Checkpoint{
+					Origin: test.origin,
+					Size:   10,
+					Hash:   []byte("abcdefghijklmnopqrstuvwxyz012345"),
+				},
+				TimestampNanos: 234,
+			}
+			n, err := note.Sign(&note.Note{Text: string(cp.Marshal())}, test.signers...)
+			if err != nil {
+				t.Fatalf("Sign(): %v", err)
+			}
+			got, err := api.ParseCheckpoint(n, lv)
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("Got unexpected error %q", err)
+			case err == nil && test.wantErr:
+				t.Fatal("Got no error, but wanted error")
+			case err == nil && (got.Size != cp.Size || got.TimestampNanos != cp.TimestampNanos):
+				t.Errorf("got checkpoint %s, want %s", got, cp)
+			}
+		})
+	}
+}
@@ -14,7 +14,19 @@
 
 package api
 
-import "fmt"
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/transparency-dev/merkle/compact"
+	"golang.org/x/mod/sumdb/note"
+)
 
 const (
 	// MapHTTPGetCheckpoint is the path of the URL to get a recent map checkpoint.
@@ -23,11 +35,20 @@ const (
 	MapHTTPGetTile = "ftmap/v0/tile"
 	// MapHTTPGetAggregation is the path of the URL to get aggregated FW info.
 	MapHTTPGetAggregation = "ftmap/v0/aggregation"
+	// MapHTTPGetCommitFirmware is the path of the URL to get the firmware built from a source commit.
+	MapHTTPGetCommitFirmware = "ftmap/v0/commit-firmware"
+	// MapHTTPGetDeviceLog is the path of the URL to get the release log for a device, with its map inclusion proof.
+	MapHTTPGetDeviceLog = "ftmap/v0/device-log"
+	// MapHTTPGetProof is the path of the URL to get an inclusion or non-inclusion proof for a key.
+	MapHTTPGetProof = "ftmap/v0/proof"
 
 	// MapPrefixStrata is the number of prefix strata in the FT map.
 	MapPrefixStrata = 1
 	// MapTreeID is the unique tree ID salted into the map's hash functions.
 	MapTreeID = 12345
+
+	// MapCheckpointOrigin is the first line of checkpoints signed by the map operator.
+	MapCheckpointOrigin = "Firmware Transparency Map"
 )
 
 // AggregatedFirmware represents the results of aggregating a single piece of firmware
@@ -35,6 +56,40 @@ const (
 type AggregatedFirmware struct {
 	Index uint64
 	Good  bool
+	// Revoked is true if any revocation statement has been logged for the firmware.
+	Revoked bool `json:",omitempty"`
+	// Rebuilt is true if any build annotation has been logged for the firmware. Along with
+	// Reproducible this distinguishes firmware which hasn't been rebuilt from firmware which
+	// was rebuilt to a different image.
+	Rebuilt bool `json:",omitempty"`
+	// Reproducible is true if the firmware has been rebuilt from source to an identical
+	// image, and no build annotations claim otherwise.
+	Reproducible bool `json:",omitempty"`
+	// SBOMs are the SHA512 hashes of the software bills of materials annotated for the
+	// firmware, sorted and without duplicates.
+	SBOMs [][]byte `json:",omitempty"`
+	// Vulnerabilities are the IDs of the vulnerabilities annotated for the firmware,
+	// sorted and without duplicates.
+	Vulnerabilities []string `json:",omitempty"`
+	// FirmwareImageSHA512 is the hash of the aggregated firmware image, which annotations
+	// must match to be applied. It is not part of the value committed to by the map.
+	FirmwareImageSHA512 []byte `json:"-"`
+}
+
+// CommitFirmware lists the firmware whose provenance says it was built from a
+// single source commit. The same commit hash in different repositories is a
+// different commit, as any publisher can name any repository.
+type CommitFirmware struct {
+	Repository string
+	Commit     string
+	// FirmwareLogIndices are the indices of the firmware metadata in the log, in ascending order.
+	FirmwareLogIndices []uint64
+}
+
+// CommitMapKey returns the map key under which the CommitFirmware for the commit
+// in the repository is committed to.
+func CommitMapKey(repository, commit string) string {
+	return fmt.Sprintf("commit:%s:%s", commit, repository)
 }
 
 // DeviceReleaseLog represents firmware releases found for a single device ID.
@@ -44,17 +99,123 @@ type DeviceReleaseLog struct {
 	Revisions []uint64
 }
 
+// LogRoot returns the root hash of the log of revisions, which is the value
+// committed to by the map under the key SHA512_256(DeviceID).
+func (l DeviceReleaseLog) LogRoot() ([]byte, error) {
+	logRange := (&compact.RangeFactory{Hash: deviceLogNodeHash}).NewEmptyRange(0)
+	for _, v := range l.Revisions {
+		bs := make([]byte, 8)
+		binary.LittleEndian.PutUint64(bs, v)
+		if err := logRange.Append(deviceLogRecordHash(bs), nil); err != nil {
+			return nil, err
+		}
+	}
+	root, err := logRange.GetRootHash(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log for %q: %v", l.DeviceID, err)
+	}
+	return root, nil
+}
+
+// deviceLogRecordHash returns the content hash for a record in a device release log.
+func deviceLogRecordHash(data []byte) []byte {
+	// SHA256(0x00 || data)
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// deviceLogNodeHash returns the hash for an interior node in a device release log.
+func deviceLogNodeHash(left, right []byte) []byte {
+	// SHA256(0x01 || left || right)
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// DeviceReleaseLogProof is returned by the map server for a device ID. It contains the
+// release log for the device, and the proof that the map commits to the log's root hash.
+type DeviceReleaseLogProof struct {
+	Log   DeviceReleaseLog
+	Proof MapInclusionProof
+}
+
 // MapCheckpoint is a commitment to a map built from the FW Log at a given size.
 // The map checkpoint contains the checkpoint of the log this was built from, with
 // the number of entries consumed from that input log. This allows clients to check
 // they are seeing the same version of the log as the map was built from. This also
 // provides information to allow verifiers of the map to confirm correct construction.
+//
+// Map checkpoints are signed notes, signed by the map operator, whose text is:
+//
+//	Firmware Transparency Map
+//	<revision>
+//	<log size>
+//	<base64 root hash>
+//	<base64 log checkpoint>
 type MapCheckpoint struct {
-	// LogCheckpoint is the json encoded api.LogCheckpoint.
+	// LogCheckpoint is the signed note of the log checkpoint the map was built from.
 	LogCheckpoint []byte
 	LogSize       uint64
 	RootHash      []byte
 	Revision      uint64
+
+	// If set, Envelope contains the signed note from which this MapCheckpoint was parsed.
+	Envelope []byte
+}
+
+// Marshal serialises the map checkpoint into the text to be signed.
+func (m MapCheckpoint) Marshal() []byte {
+	b := bytes.Buffer{}
+	b.WriteString(fmt.Sprintf("%s\n%d\n%d\n", MapCheckpointOrigin, m.Revision, m.LogSize))
+	b.WriteString(fmt.Sprintf("%s\n%s\n", base64.StdEncoding.EncodeToString(m.RootHash), base64.StdEncoding.EncodeToString(m.LogCheckpoint)))
+	return b.Bytes()
+}
+
+// ParseMapCheckpoint verifies the signature on a map checkpoint from the map operator,
+// and returns the MapCheckpoint it contains. The log checkpoint inside is not verified.
+func ParseMapCheckpoint(chkpt []byte, v note.Verifier) (*MapCheckpoint, error) {
+	n, err := note.Open(chkpt, note.VerifierList(v))
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify signature on map checkpoint: %v", err)
+	}
+	const delim = "\n"
+	lines := strings.Split(strings.TrimSuffix(n.Text, delim), delim)
+	if l := len(lines); l != 5 {
+		return nil, fmt.Errorf("expected 5 lines in map checkpoint, got %d", l)
+	}
+	if lines[0] != MapCheckpointOrigin {
+		return nil, fmt.Errorf("got Origin %q but expected %q", lines[0], MapCheckpointOrigin)
+	}
+	rev, err := strconv.ParseUint(lines[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse revision: %w", err)
+	}
+	size, err := strconv.ParseUint(lines[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse log size: %w", err)
+	}
+	root, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse root hash: %w", err)
+	}
+	if len(root) == 0 {
+		return nil, errors.New("empty root hash")
+	}
+	lcp, err := base64.StdEncoding.DecodeString(lines[4])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse log checkpoint: %w", err)
+	}
+	return &MapCheckpoint{
+		LogCheckpoint: lcp,
+		LogSize:       size,
+		RootHash:      root,
+		Revision:      rev,
+		Envelope:      chkpt,
+	}, nil
 }
 
 // MapTile is a subtree of the whole map.
@@ -78,8 +239,12 @@ type MapTileLeaf struct {
 
 // MapInclusionProof contains the value at the requested key and the proof to the
 // requested Checkpoint.
+// If the key is not in the map then Value is nil, and the proof shows that the
+// leaf for the key is empty.
 type MapInclusionProof struct {
-	Key   []byte
+	// Key is the SHA512/256 hash of the map key.
+	Key []byte
+	// Value is the leaf hash committing to the value, or nil if the leaf is empty.
 	Value []byte
 	// Proof is all of the sibling hashes down the path, keyed by the bit length of the parent node ID.
 	// A nil entry means that this branch is empty.
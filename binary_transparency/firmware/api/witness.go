@@ -14,10 +14,49 @@
 
 package api
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"golang.org/x/mod/sumdb/note"
+)
+
 const (
 	// WitnessGetCheckpoint is the path of the URL to get witness checkpoint.
+	// The checkpoint for a particular log is at WitnessGetCheckpoint + "/" + log ID;
+	// without a log ID, the checkpoint of the witness's default log is returned.
 	WitnessGetCheckpoint = "ft/witness/v0/get-checkpoint"
+	// WitnessAddCheckpoint is the path of the URL to submit a new checkpoint to the witness.
+	// As with WitnessGetCheckpoint, the log ID may be appended to the path.
+	WitnessAddCheckpoint = "ft/witness/v0/add-checkpoint"
+	// WitnessEvidence is the path of the URL listing evidence of split views found by the witness.
+	// Each piece of evidence is at WitnessEvidence + "/" + its name.
+	WitnessEvidence = "ft/witness/v0/evidence"
+	// MonitorEvidence is the path of the URL listing evidence of split views found by the monitor.
+	MonitorEvidence = "ft/monitor/v0/evidence"
 )
 
+// WitnessLogID returns the ID a witness uses for the log with the given origin and key,
+// unless the witness is configured otherwise. Logs with the same origin but different keys
+// have different IDs. For a log with several keys, the ID is that of its first key, so it
+// remains the same when the log rotates in new keys after it.
+func WitnessLogID(origin string, key note.Verifier) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s+%08x", origin, key.Name(), key.KeyHash())))
+	return hex.EncodeToString(h[:])
+}
+
+// AddCheckpointRequest is the body of a request to the witness to accept a new checkpoint.
+// If OldSize doesn't match the size of the witness's checkpoint, the witness responds with
+// 409 Conflict and its current checkpoint, so the submitter can retry with the right proof.
+type AddCheckpointRequest struct {
+	// OldSize is the tree size of the checkpoint that the submitter believes the witness has.
+	OldSize uint64
+	// Checkpoint is the new checkpoint note, signed by the log.
+	Checkpoint []byte
+	// Proof is the consistency proof from OldSize to the size of Checkpoint.
+	Proof [][]byte
+}
+
 // Please refer to LogCheckpoint structure in http.go for the CheckPoint returned.
 // Semantics for LogCheckpoint is identical between FT log and Witness
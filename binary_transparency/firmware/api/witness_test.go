@@ -0,0 +1,44 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api_test
+
+import (
+	"testing"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
+	"golang.org/x/mod/sumdb/note"
+)
+
+func TestWitnessLogID(t *testing.T) {
+	logKey, err := note.NewVerifier(crypto.TestFTPersonalityPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := note.NewVerifier(crypto.TestWitnessPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := api.WitnessLogID(api.FTLogOrigin, logKey)
+	if got := api.WitnessLogID(api.FTLogOrigin, logKey); got != id {
+		t.Errorf("got different IDs %q and %q for the same log", id, got)
+	}
+	if got := api.WitnessLogID(api.FTLogOrigin, otherKey); got == id {
+		t.Error("got the same ID for logs with the same origin and different keys")
+	}
+	if got := api.WitnessLogID("Other Log", logKey); got == id {
+		t.Error("got the same ID for logs with different origins and the same key")
+	}
+}
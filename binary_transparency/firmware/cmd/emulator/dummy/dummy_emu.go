@@ -29,20 +29,32 @@ package main
 
 import (
 	"flag"
+	"strings"
 
 	"github.com/golang/glog"
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
 	"github.com/google/trillian-examples/binary_transparency/firmware/cmd/emulator/dummy/impl"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
 )
 
 var (
 	dummyDirectory = flag.String("dummy_storage_dir", "/tmp/dummy_device", "Directory path of the dummy device's state storage")
+
+	logOrigin     = flag.String("log_origin", api.FTLogOrigin, "Origin line expected on checkpoints from the log")
+	logPublicKeys = flag.String("log_public_keys", crypto.TestFTPersonalityPub, "Comma separated note verifier keys for the log trusted by the device's ROM; checkpoints signed by any of them are accepted")
 )
 
 func main() {
 	flag.Parse()
 
+	logSigV, err := api.NewLogVerifier(*logOrigin, strings.Split(*logPublicKeys, ",")...)
+	if err != nil {
+		glog.Exitf("Failed to create log verifier: %v", err)
+	}
+
 	if err := impl.Main(impl.EmulatorOpts{
-		DeviceStorage: *dummyDirectory,
+		DeviceStorage:  *dummyDirectory,
+		LogSigVerifier: logSigV,
 	}); err != nil {
 		glog.Exit(err.Error())
 	}
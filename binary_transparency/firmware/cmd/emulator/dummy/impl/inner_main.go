@@ -18,17 +18,20 @@ package impl
 import (
 	"fmt"
 
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
 	"github.com/google/trillian-examples/binary_transparency/firmware/devices/dummy/rom"
 )
 
 // EmulatorOpts encapsulates the parameters for running the emulator.
 type EmulatorOpts struct {
 	DeviceStorage string
+	// LogSigVerifier holds the log keys trusted by the device's ROM.
+	LogSigVerifier api.LogVerifier
 }
 
 // Main is the entry point for the dummy emulator
 func Main(opts EmulatorOpts) error {
-	boot, err := rom.Reset(opts.DeviceStorage)
+	boot, err := rom.Reset(opts.DeviceStorage, opts.LogSigVerifier)
 	if err != nil {
 		return fmt.Errorf("ROM: %w", err)
 	}
@@ -29,6 +29,9 @@ type ErrNeedsInit = error
 type Device interface {
 	// DeviceCheckpoint returns the log checkpoint note used during the last firmware update.
 	DeviceCheckpoint() ([]byte, error)
+	// DeviceManifest returns the SignedStatement for the currently installed firmware,
+	// or an empty slice if the device has no firmware installed.
+	DeviceManifest() ([]byte, error)
 	// ApplyUpdate applies the provided update to the device.
 	ApplyUpdate(api.UpdatePackage) error
 }
@@ -29,8 +29,11 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"strings"
 
 	"github.com/golang/glog"
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
 	"github.com/google/trillian-examples/binary_transparency/firmware/cmd/flash_tool/impl"
 	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
 	"golang.org/x/mod/sumdb/note"
@@ -39,31 +42,97 @@ import (
 var (
 	deviceID      = flag.String("device", "", "One of [dummy, armory]")
 	logURL        = flag.String("log_url", "http://localhost:8000", "Base URL of the log HTTP API")
+	useTiles      = flag.Bool("use_tiles", false, "Build log proofs from the tiles published by the log, which may be served by a cache, rather than asking the log")
 	mapURL        = flag.String("map_url", "", "Base URL of the map HTTP API. Map checks are not performed if this is absent.")
-	witnessURL    = flag.String("witness_url", "", "Base URL of the Witness, or empty if no witness checks needed")
+	mapPublicKey  = flag.String("map_public_key", crypto.TestMapPub, "Note verifier key for the map operator, which must have signed the map checkpoint")
+	witnessURLs   = flag.String("witness_urls", "", "Comma separated base URLs of the Witnesses, or empty if no witness checks needed")
+	witnessKeys   = flag.String("witness_public_keys", crypto.TestWitnessPub, "Comma separated note verifier keys for the Witnesses, in the same order as --witness_urls")
+	witnessLogIDs = flag.String("witness_log_ids", "", "Comma separated IDs under which each Witness tracks the log, in the same order as --witness_urls; empty entries, or an empty flag, use the ID derived from --log_origin and the first of --log_public_keys")
+	witnessQuorum = flag.Int("witness_quorum", 0, "Number of Witnesses which must have a view of the log consistent with the update, or 0 to require all of them")
 	updateFile    = flag.String("update_file", "", "File path to read the update package from")
 	force         = flag.Bool("force", false, "Ignore errors and force update")
+	allowRollback = flag.Bool("allow_rollback", true, "Allow flashing firmware which is not newer than the installed firmware; set to false to refuse rollbacks")
 	deviceStorage = flag.String("device_storage", "", "Storage description string for selected device")
+
+	requireReproducible = flag.Bool("require_reproducible", false, "Only flash firmware which the map records as reproducibly built; requires --map_url")
+	requireSBOM         = flag.Bool("require_sbom", false, "Only flash firmware which the map records as having an SBOM; requires --map_url")
+	rejectVulnerable    = flag.Bool("reject_vulnerable", false, "Refuse to flash firmware which the map records as having known vulnerabilities; requires --map_url")
+
+	claimantsConfig = flag.String("claimants_config", "", "Path to a JSON file listing the keys trusted to sign statements, or empty to trust only the TEST/DEMO keys")
+
+	logOrigin     = flag.String("log_origin", api.FTLogOrigin, "Origin line expected on checkpoints from the log")
+	logPublicKeys = flag.String("log_public_keys", crypto.TestFTPersonalityPub, "Comma separated note verifier keys for the log; checkpoints signed by any of them are accepted")
 )
 
 func main() {
 	flag.Parse()
 
-	v, err := note.NewVerifier(crypto.TestFTPersonalityPub)
+	v, err := api.NewLogVerifier(*logOrigin, strings.Split(*logPublicKeys, ",")...)
+	if err != nil {
+		glog.Exitf("Failed to create log verifier: %v", err)
+	}
+	mapV, err := note.NewVerifier(*mapPublicKey)
+	if err != nil {
+		glog.Exitf("Failed to create map verifier: %v", err)
+	}
+	witnesses, err := parseWitnesses(*witnessURLs, *witnessKeys, *witnessLogIDs)
 	if err != nil {
-		glog.Exitf("Failed to create CP verifier: %q", err)
+		glog.Exitf("Failed to configure witnesses: %v", err)
+	}
+	claimants := crypto.TestClaimantRegistry()
+	if len(*claimantsConfig) > 0 {
+		if claimants, err = crypto.LoadClaimantRegistry(*claimantsConfig); err != nil {
+			glog.Exitf("Failed to load claimants: %v", err)
+		}
 	}
 
 	if err := impl.Main(context.Background(), impl.FlashOpts{
 		DeviceID:       *deviceID,
 		LogURL:         *logURL,
 		LogSigVerifier: v,
+		UseTiles:       *useTiles,
+		Claimants:      claimants,
 		MapURL:         *mapURL,
-		WitnessURL:     *witnessURL,
-		UpdateFile:     *updateFile,
-		Force:          *force,
-		DeviceStorage:  *deviceStorage,
+		MapVerifier:    mapV,
+		Policy: impl.AnnotationPolicy{
+			RequireReproducible: *requireReproducible,
+			RequireSBOM:         *requireSBOM,
+			RejectVulnerable:    *rejectVulnerable,
+		},
+		Witnesses:     witnesses,
+		WitnessQuorum: *witnessQuorum,
+		UpdateFile:    *updateFile,
+		Force:         *force,
+		AllowRollback: *allowRollback,
+		DeviceStorage: *deviceStorage,
 	}); err != nil {
 		glog.Exit(err.Error())
 	}
 }
+
+// parseWitnesses pairs up the comma separated witness URLs with their keys and log IDs.
+// The log IDs may be empty, in which case the default log ID is used for all witnesses.
+func parseWitnesses(urls, keys, logIDs string) ([]impl.Witness, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	us, ks := strings.Split(urls, ","), strings.Split(keys, ",")
+	if len(us) != len(ks) {
+		return nil, fmt.Errorf("got %d witness URLs but %d keys", len(us), len(ks))
+	}
+	ids := make([]string, len(us))
+	if len(logIDs) > 0 {
+		if ids = strings.Split(logIDs, ","); len(ids) != len(us) {
+			return nil, fmt.Errorf("got %d witness URLs but %d log IDs", len(us), len(ids))
+		}
+	}
+	var ws []impl.Witness
+	for i := range us {
+		v, err := note.NewVerifier(ks[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid key for witness %q: %w", us[i], err)
+		}
+		ws = append(ws, impl.Witness{URL: us[i], Verifier: v, LogID: ids[i]})
+	}
+	return ws, nil
+}
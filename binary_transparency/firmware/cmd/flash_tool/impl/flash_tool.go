@@ -18,7 +18,6 @@
 package impl
 
 import (
-	"bytes"
 	"context"
 	"crypto/sha512"
 	"encoding/json"
@@ -26,6 +25,8 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/golang/glog"
 	"github.com/google/trillian-examples/binary_transparency/firmware/api"
@@ -33,9 +34,8 @@ import (
 	"github.com/google/trillian-examples/binary_transparency/firmware/devices/dummy"
 	armory_flash "github.com/google/trillian-examples/binary_transparency/firmware/devices/usbarmory/flash"
 	"github.com/google/trillian-examples/binary_transparency/firmware/internal/client"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
 	"github.com/google/trillian-examples/binary_transparency/firmware/internal/verify"
-	"github.com/google/trillian/merkle/coniks"
-	"github.com/google/trillian/merkle/smt/node"
 	"golang.org/x/mod/sumdb/note"
 )
 
@@ -43,12 +43,62 @@ import (
 type FlashOpts struct {
 	DeviceID       string
 	LogURL         string
-	LogSigVerifier note.Verifier
-	MapURL         string
-	WitnessURL     string
-	UpdateFile     string
-	Force          bool
-	DeviceStorage  string
+	LogSigVerifier api.LogVerifier
+	// UseTiles fetches proofs from the tile-based view of the log rather than its proof endpoints.
+	UseTiles  bool
+	Claimants *crypto.ClaimantRegistry
+	MapURL    string
+	// MapVerifier checks the map operator's signature on map checkpoints.
+	MapVerifier note.Verifier
+	// Policy lists the annotations which the map must hold for the firmware, and needs MapURL.
+	Policy AnnotationPolicy
+	// Witnesses are asked for their view of the log, which the update must be consistent with.
+	Witnesses []Witness
+	// WitnessQuorum is the number of witnesses which must agree with the update, or 0 for all of them.
+	WitnessQuorum int
+	UpdateFile    string
+	Force         bool
+	// AllowRollback permits updates which are not newer than the installed firmware.
+	AllowRollback bool
+	DeviceStorage string
+}
+
+// AnnotationPolicy describes the annotations which firmware must have in the map,
+// in addition to not being revoked or marked as bad, in order to be flashed.
+type AnnotationPolicy struct {
+	// RequireReproducible rejects firmware which hasn't been reproducibly built.
+	RequireReproducible bool
+	// RequireSBOM rejects firmware which doesn't have an SBOM.
+	RequireSBOM bool
+	// RejectVulnerable rejects firmware which has any known vulnerabilities.
+	RejectVulnerable bool
+}
+
+// enabled returns whether the policy needs anything from the map.
+func (p AnnotationPolicy) enabled() bool {
+	return p.RequireReproducible || p.RequireSBOM || p.RejectVulnerable
+}
+
+// check returns an error if the aggregated annotations don't satisfy the policy.
+func (p AnnotationPolicy) check(agg api.AggregatedFirmware) error {
+	if p.RequireReproducible && !agg.Reproducible {
+		return errors.New("firmware has not been reproducibly built")
+	}
+	if p.RequireSBOM && len(agg.SBOMs) == 0 {
+		return errors.New("firmware has no SBOM")
+	}
+	if p.RejectVulnerable && len(agg.Vulnerabilities) > 0 {
+		return fmt.Errorf("firmware has known vulnerabilities: %s", strings.Join(agg.Vulnerabilities, ", "))
+	}
+	return nil
+}
+
+// Witness identifies a witness server and the key it countersigns checkpoints with.
+type Witness struct {
+	URL      string
+	Verifier note.Verifier
+	// LogID is the witness's ID for the log, which defaults to api.WitnessLogID of the log origin and first key.
+	LogID string
 }
 
 // Main flashes the device according to the options provided.
@@ -57,7 +107,10 @@ func Main(ctx context.Context, opts FlashOpts) error {
 	if err != nil {
 		return fmt.Errorf("log_url is invalid: %w", err)
 	}
-	c := &client.ReadonlyClient{LogURL: logURL}
+	if opts.Policy.enabled() && len(opts.MapURL) == 0 {
+		return errors.New("annotation policy requires map_url to be set")
+	}
+	c := &client.ReadonlyClient{LogURL: logURL, LogSigVerifier: opts.LogSigVerifier, UseTiles: opts.UseTiles}
 
 	up, err := readUpdateFile(opts.UpdateFile)
 	if err != nil {
@@ -69,7 +122,7 @@ func Main(ctx context.Context, opts FlashOpts) error {
 		return fmt.Errorf("failed to get device: %w", err)
 	}
 
-	pb, fwMeta, err := verifyUpdate(c, opts.LogSigVerifier, up, dev)
+	pb, fwMeta, err := verifyUpdate(c, opts.LogSigVerifier, opts.Claimants, up, dev, opts.AllowRollback)
 	if err != nil {
 		err := fmt.Errorf("failed to validate update: %w", err)
 		if !opts.Force {
@@ -78,8 +131,8 @@ func Main(ctx context.Context, opts FlashOpts) error {
 		glog.Warning(err)
 	}
 
-	if len(opts.WitnessURL) > 0 {
-		err := verifyWitness(c, opts.LogSigVerifier, pb, opts.WitnessURL)
+	if len(opts.Witnesses) > 0 {
+		err := verifyWitnesses(c, opts.LogSigVerifier, pb, opts.Witnesses, opts.WitnessQuorum)
 		if err != nil {
 			if !opts.Force {
 				return err
@@ -89,7 +142,7 @@ func Main(ctx context.Context, opts FlashOpts) error {
 	}
 
 	if len(opts.MapURL) > 0 {
-		err := verifyAnnotations(ctx, c, opts.LogSigVerifier, pb, fwMeta, opts.MapURL)
+		err := verifyAnnotations(ctx, c, opts.LogSigVerifier, pb, fwMeta, opts.MapURL, opts.MapVerifier, opts.Policy)
 		if err != nil {
 			if !opts.Force {
 				return fmt.Errorf("verifyAnnotations: %w", err)
@@ -176,8 +229,9 @@ func getConsistencyFunc(c *client.ReadonlyClient) func(from, to uint64) ([][]byt
 	return cpFunc
 }
 
-// verifyUpdate checks that an update package is self-consistent and returns a verified proof bundle
-func verifyUpdate(c *client.ReadonlyClient, logSigVerifier note.Verifier, up api.UpdatePackage, dev devices.Device) (api.ProofBundle, api.FirmwareMetadata, error) {
+// verifyUpdate checks that an update package is self-consistent and returns a verified proof bundle.
+// Unless allowRollback is set, the update must also be newer than the firmware installed on the device.
+func verifyUpdate(c *client.ReadonlyClient, logSigVerifier api.LogVerifier, claimants *crypto.ClaimantRegistry, up api.UpdatePackage, dev devices.Device, allowRollback bool) (api.ProofBundle, api.FirmwareMetadata, error) {
 	var pb api.ProofBundle
 	var fwMeta api.FirmwareMetadata
 
@@ -191,40 +245,111 @@ func verifyUpdate(c *client.ReadonlyClient, logSigVerifier note.Verifier, up api
 		return pb, fwMeta, fmt.Errorf("failed to open the device checkpoint: %w", err)
 	}
 
+	var installed *api.FirmwareMetadata
+	if !allowRollback {
+		installed, err = installedFirmware(dev)
+		if err != nil {
+			return pb, fwMeta, err
+		}
+	}
+
 	cpFunc := getConsistencyFunc(c)
 	fwHash := sha512.Sum512(up.FirmwareImage)
-	pb, fwMeta, err = verify.BundleForUpdate(up.ProofBundle, fwHash[:], *dc, cpFunc, logSigVerifier)
+	pb, fwMeta, err = verify.BundleForUpdate(up.ProofBundle, fwHash[:], *dc, cpFunc, logSigVerifier, claimants, installed)
 	if err != nil {
 		return pb, fwMeta, fmt.Errorf("failed to verify proof bundle: %w", err)
 	}
 	return pb, fwMeta, nil
 }
 
-func verifyWitness(c *client.ReadonlyClient, logSigVerifier note.Verifier, pb api.ProofBundle, witnessURL string) error {
-	wURL, err := url.Parse(witnessURL)
+// installedFirmware returns the metadata for the firmware installed on the device,
+// or nil if the device has no firmware installed.
+func installedFirmware(dev devices.Device) (*api.FirmwareMetadata, error) {
+	m, err := dev.DeviceManifest()
 	if err != nil {
-		return fmt.Errorf("witness_url is invalid: %w", err)
+		return nil, fmt.Errorf("failed to fetch the device manifest: %w", err)
+	}
+	if len(m) == 0 {
+		return nil, nil
+	}
+	var stmt api.SignedStatement
+	if err := json.Unmarshal(m, &stmt); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal device manifest: %w", err)
+	}
+	var meta api.FirmwareMetadata
+	if err := json.Unmarshal(stmt.Statement, &meta); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal device firmware metadata: %w", err)
+	}
+	return &meta, nil
+}
+
+// verifyWitnesses fetches checkpoints from all of the witnesses concurrently, and checks
+// that the bundle is consistent with the checkpoints from at least quorum of them.
+func verifyWitnesses(c *client.ReadonlyClient, logSigVerifier api.LogVerifier, pb api.ProofBundle, witnesses []Witness, quorum int) error {
+	if quorum == 0 {
+		quorum = len(witnesses)
+	}
+	if quorum > len(witnesses) {
+		return fmt.Errorf("witness quorum %d is larger than the number of witnesses %d", quorum, len(witnesses))
+	}
+
+	wcps := make([]*api.LogCheckpoint, len(witnesses))
+	var wg sync.WaitGroup
+	for i, w := range witnesses {
+		wg.Add(1)
+		go func(i int, w Witness) {
+			defer wg.Done()
+			wcp, err := getWitnessCheckpoint(logSigVerifier, w)
+			if err != nil {
+				glog.Warningf("Witness %q: %v", w.URL, err)
+				return
+			}
+			wcps[i] = wcp
+		}(i, w)
+	}
+	wg.Wait()
+
+	var witnessed []verify.WitnessedCheckpoint
+	for i, wcp := range wcps {
+		if wcp != nil {
+			witnessed = append(witnessed, verify.WitnessedCheckpoint{Witness: witnesses[i].Verifier.Name(), Checkpoint: *wcp})
+		}
+	}
+	if err := verify.BundleWitnessQuorum(pb, witnessed, quorum, getConsistencyFunc(c), logSigVerifier); err != nil {
+		return fmt.Errorf("failed to verify checkpoint consistency against witnesses: %w", err)
+	}
+	return nil
+}
+
+// getWitnessCheckpoint returns the checkpoint countersigned by the witness.
+func getWitnessCheckpoint(logSigVerifier api.LogVerifier, w Witness) (*api.LogCheckpoint, error) {
+	wURL, err := url.Parse(w.URL)
+	if err != nil {
+		return nil, fmt.Errorf("witness URL is invalid: %w", err)
+	}
+	logID := w.LogID
+	if len(logID) == 0 {
+		logID = api.WitnessLogID(logSigVerifier.Origin, logSigVerifier.Verifiers[0])
 	}
 	wc := client.WitnessClient{
-		URL:            wURL,
-		LogSigVerifier: logSigVerifier,
+		URL:             wURL,
+		LogSigVerifier:  logSigVerifier,
+		WitnessVerifier: w.Verifier,
+		LogID:           logID,
 	}
 
 	wcp, err := wc.GetWitnessCheckpoint()
 	if err != nil {
-		return fmt.Errorf("failed to fetch the witness checkpoint: %w", err)
+		return nil, fmt.Errorf("failed to fetch the witness checkpoint: %w", err)
 	}
 	if wcp.Size == 0 {
-		return fmt.Errorf("no witness checkpoint to verify")
-	}
-	if err := verify.BundleConsistency(pb, *wcp, getConsistencyFunc(c), logSigVerifier); err != nil {
-		return fmt.Errorf("failed to verify checkpoint consistency against witness: %w", err)
+		return nil, fmt.Errorf("no witness checkpoint to verify")
 	}
-	return nil
+	return wcp, nil
 }
 
-func verifyAnnotations(ctx context.Context, c *client.ReadonlyClient, logSigVerifier note.Verifier, pb api.ProofBundle, fwMeta api.FirmwareMetadata, mapURL string) error {
-	mc, err := client.NewMapClient(mapURL)
+func verifyAnnotations(ctx context.Context, c *client.ReadonlyClient, logSigVerifier api.LogVerifier, pb api.ProofBundle, fwMeta api.FirmwareMetadata, mapURL string, mapVerifier note.Verifier, policy AnnotationPolicy) error {
+	mc, err := client.NewMapClient(mapURL, mapVerifier)
 	if err != nil {
 		return fmt.Errorf("failed to create map client: %w", err)
 	}
@@ -237,62 +362,40 @@ func verifyAnnotations(ctx context.Context, c *client.ReadonlyClient, logSigVeri
 	// Without this, the client is at risk of being given a custom map root that
 	// nobody else in the world sees.
 	glog.V(1).Infof("Received map checkpoint: %s", mcp.LogCheckpoint)
-	var lcp api.LogCheckpoint
-	if err := json.Unmarshal(mcp.LogCheckpoint, &lcp); err != nil {
-		return fmt.Errorf("failed to unmarshal log checkpoint: %w", err)
+	lcp, err := api.ParseCheckpoint(mcp.LogCheckpoint, logSigVerifier)
+	if err != nil {
+		return fmt.Errorf("failed to parse log checkpoint in map checkpoint: %w", err)
+	}
+	if lcp.Size < mcp.LogSize {
+		return fmt.Errorf("map was built from %d entries but its log checkpoint has size %d", mcp.LogSize, lcp.Size)
 	}
 	// TODO(mhutchinson): check consistency with the largest checkpoint found thus far
 	// in order to detect a class of fork; it could be that the checkpoint in the update
 	// is consistent with the map and the witness, but the map and the witness aren't
 	// consistent with each other.
-	if err := verify.BundleConsistency(pb, lcp, getConsistencyFunc(c), logSigVerifier); err != nil {
+	if err := verify.BundleConsistency(pb, *lcp, getConsistencyFunc(c), logSigVerifier); err != nil {
 		return fmt.Errorf("failed to verify update with map checkpoint: %w", err)
 	}
 
 	// Get the aggregation and proof, and then check everything about it.
-	// This is a little paranoid as the inclusion proof is generated client-side.
+	// This is a little paranoid as the map client has already checked the proof.
 	// The pretense here is that there is a trust boundary between the code in this class,
-	// and everything else. In a production system, it is likely that the proof generation
-	// would live elsewhere (e.g. in the OTA packaging process), and the shorter proof
+	// and everything else. In a production system, it is likely that the proof would be
+	// fetched elsewhere (e.g. in the OTA packaging process), and the shorter proof
 	// bundle would be provided to the device.
 	preimage, ip, err := mc.Aggregation(ctx, mcp.Revision, pb.InclusionProof.LeafIndex)
 	if err != nil {
 		return fmt.Errorf("failed to get map value for %q: %w", pb.InclusionProof.LeafIndex, err)
 	}
-	// 1. Check: the proof is for the correct key.
+	// Check that the proof is for the correct key, that it commits to the value received,
+	// and that it evaluates to the map root that we've obtained.
 	kbs := sha512.Sum512_256([]byte(fmt.Sprintf("summary:%d", pb.InclusionProof.LeafIndex)))
-	if !bytes.Equal(ip.Key, kbs[:]) {
-		return fmt.Errorf("received inclusion proof for key %x but wanted %x", ip.Key, kbs[:])
-	}
-	// 2. Check: the proof is for the correct value.
-	leafID := node.NewID(string(kbs[:]), 256)
-	hasher := coniks.Default
-	expectedCommitment := hasher.HashLeaf(api.MapTreeID, leafID, preimage)
-	if !bytes.Equal(ip.Value, expectedCommitment) {
+	if err := verify.MapInclusion(mcp.RootHash, kbs[:], preimage, ip); err != nil {
 		// This could happen if the JSON roundtripping was not stable. If we see that happen,
 		// then we'll need to pass out the raw bytes received from the server and parse into
 		// the struct at a higher level.
 		// It could also happen because the value returned is not actually committed to by the map.
-		return fmt.Errorf("received inclusion proof for value %x but wanted %x", ip.Value, expectedCommitment)
-	}
-	// 3. Check: the inclusion proof evaluates to the map root that we've obtained.
-	// The calculation starts from the leaf, and uses the siblings from the inclusion proof
-	// to generate the root, which is then compared with the map checkpoint.
-	calc := expectedCommitment
-	for pd := hasher.BitLen(); pd > 0; pd-- {
-		sib := ip.Proof[pd-1]
-		stem := leafID.Prefix(uint(pd))
-		if sib == nil {
-			sib = hasher.HashEmpty(api.MapTreeID, stem.Sibling())
-		}
-		left, right := calc, sib
-		if !isLeftChild(stem) {
-			left, right = right, left
-		}
-		calc = hasher.HashChildren(left, right)
-	}
-	if !bytes.Equal(calc, mcp.RootHash) {
-		return fmt.Errorf("inclusion proof calculated root %x but wanted %x", calc, mcp.RootHash)
+		return fmt.Errorf("failed to verify map inclusion proof: %w", err)
 	}
 
 	var agg api.AggregatedFirmware
@@ -300,14 +403,11 @@ func verifyAnnotations(ctx context.Context, c *client.ReadonlyClient, logSigVeri
 		return fmt.Errorf("failed to decode aggregation: %w", err)
 	}
 	// Now we're certain that the aggregation is contained in the map, we can use the value.
+	if agg.Revoked {
+		return errors.New("firmware has been revoked")
+	}
 	if !agg.Good {
 		return errors.New("firmware is marked as bad")
 	}
-	return nil
-}
-
-// isLeftChild returns whether the given node is a left child.
-func isLeftChild(id node.ID) bool {
-	last, bits := id.LastByte()
-	return last&(1<<(8-bits)) == 0
+	return policy.check(agg)
 }
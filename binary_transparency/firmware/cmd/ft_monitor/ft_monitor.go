@@ -20,45 +20,132 @@
 // TODO(al): Extend monitor to verify claims.
 //
 // Start the monitor using:
-// go run ./cmd/ft_monitor/main.go --logtostderr -v=2 --ftlog=http://localhost:8000/  --state_file=/tmp/ftmon.state
+// go run ./cmd/ft_monitor/main.go --logtostderr -v=2 --ftlog=http://localhost:8000/  --state_file=/tmp/ftmon.db
 package main
 
 import (
 	"context"
 	"flag"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
 	"github.com/google/trillian-examples/binary_transparency/firmware/api"
 	"github.com/google/trillian-examples/binary_transparency/firmware/cmd/ft_monitor/impl"
+	"github.com/google/trillian-examples/binary_transparency/firmware/cmd/ft_monitor/internal/scanner"
 	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
-	"golang.org/x/mod/sumdb/note"
 )
 
 var (
 	ftLog        = flag.String("ftlog", "http://localhost:8000", "Base URL of FT Log server")
+	useTiles     = flag.Bool("use_tiles", false, "Read entries and proofs from the tiles published by the log rather than asking the log")
 	pollInterval = flag.Duration("poll_interval", 5*time.Second, "Duration to wait between polling for new entries")
-	keyWord      = flag.String("keyword", "trojan", "Example keyword for malware")
+	keyWord      = flag.String("keyword", "trojan", "Example keyword for malware, or empty to only use the other scanners")
+	rulesFiles   = flag.String("rules_files", "", "Comma separated paths to files of YARA-style rules which match malware")
+	badHashes    = flag.String("bad_hashes_file", "", "Path to a file listing the SHA-256 or SHA-512 hashes of known bad images")
+	scanCommand  = flag.String("scan_command", "", "Command line of an external scanner, which reads the image on stdin and writes a JSON verdict to stdout")
+	scanTimeout  = flag.Duration("scanner_timeout", 5*time.Minute, "Longest time the external scanner may take to scan an image, or 0 for no limit")
 	annotate     = flag.Bool("annotate", false, "If true then this will add annotations to the log in addition to local logging")
-	stateFile    = flag.String("state_file", "", "Filepath to persist monitor state to")
+	stateFile    = flag.String("state_file", "", "Path of the sqlite database to persist monitor state to")
+	evidenceDir  = flag.String("evidence_dir", "", "Directory to store evidence of split views in; if empty, split views are only logged")
+	listenAddr   = flag.String("listen", "", "address:port to serve evidence of split views on, or empty to not serve it")
+
+	claimantsConfig  = flag.String("claimants_config", "", "Path to a JSON file listing the keys trusted to sign statements, or empty to trust only the TEST/DEMO keys")
+	annotatorKeyFile = flag.String("annotator_key_file", "", "Path to a PEM private key used to sign malware annotations, or empty to use the TEST/DEMO key")
+	annotatorKeyID   = flag.String("annotator_key_id", "", "ID of the key in --annotator_key_file in the log's claimants config, included as a hint to verifiers")
+
+	logOrigin     = flag.String("log_origin", api.FTLogOrigin, "Origin line expected on checkpoints from the log")
+	logPublicKeys = flag.String("log_public_keys", crypto.TestFTPersonalityPub, "Comma separated note verifier keys for the log; checkpoints signed by any of them are accepted")
 )
 
 func main() {
 	flag.Parse()
 
-	testLogSigV, _ := note.NewVerifier(crypto.TestFTPersonalityPub)
+	logSigV, err := api.NewLogVerifier(*logOrigin, strings.Split(*logPublicKeys, ",")...)
+	if err != nil {
+		glog.Exitf("Failed to create log verifier: %v", err)
+	}
+
+	claimants := crypto.TestClaimantRegistry()
+	if len(*claimantsConfig) > 0 {
+		var err error
+		if claimants, err = crypto.LoadClaimantRegistry(*claimantsConfig); err != nil {
+			glog.Exitf("Failed to load claimants: %v", err)
+		}
+	}
+	annotator := &crypto.AnnotatorMalware
+	if len(*annotatorKeyFile) > 0 {
+		var err error
+		if annotator, err = crypto.LoadSigningClaimant(*annotatorKeyFile, *annotatorKeyID); err != nil {
+			glog.Exitf("Failed to load annotator key: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	if flag.Arg(0) == "compare" {
+		// Compare checkpoints from other parties, given as files or URLs, with the monitor's.
+		if err := impl.Compare(ctx, impl.CompareOpts{
+			LogURL:         *ftLog,
+			LogSigVerifier: logSigV,
+			UseTiles:       *useTiles,
+			StateFile:      *stateFile,
+			EvidenceDir:    *evidenceDir,
+			Sources:        flag.Args()[1:],
+		}); err != nil {
+			glog.Exit(err.Error())
+		}
+		return
+	}
 
-	if err := impl.Main(context.Background(), impl.MonitorOpts{
+	scanners, err := loadScanners()
+	if err != nil {
+		glog.Exitf("Failed to load scanners: %v", err)
+	}
+
+	if err := impl.Main(ctx, impl.MonitorOpts{
 		LogURL:       *ftLog,
 		PollInterval: *pollInterval,
 		Keyword:      *keyWord,
+		Scanners:     scanners,
 		Matched: func(idx uint64, fw api.FirmwareMetadata) {
 			glog.Warningf("Malware detected at log index %d, in firmware: %v", idx, fw)
 		},
 		Annotate:       *annotate,
 		StateFile:      *stateFile,
-		LogSigVerifier: testLogSigV,
+		LogSigVerifier: logSigV,
+		UseTiles:       *useTiles,
+		Claimants:      claimants,
+		Annotator:      annotator,
+		EvidenceDir:    *evidenceDir,
+		ListenAddr:     *listenAddr,
 	}); err != nil {
 		glog.Exitf(err.Error())
 	}
 }
+
+// loadScanners returns the malware scanners configured by flags, other than the keyword.
+func loadScanners() ([]scanner.Scanner, error) {
+	var scanners []scanner.Scanner
+	if len(*rulesFiles) > 0 {
+		for _, f := range strings.Split(*rulesFiles, ",") {
+			rs, err := scanner.LoadRules(f)
+			if err != nil {
+				return nil, err
+			}
+			glog.Infof("Loaded %d rules from %q", rs.Len(), f)
+			scanners = append(scanners, rs)
+		}
+	}
+	if len(*badHashes) > 0 {
+		h, err := scanner.LoadHashList(*badHashes)
+		if err != nil {
+			return nil, err
+		}
+		glog.Infof("Loaded %d known bad hashes from %q", h.Len(), *badHashes)
+		scanners = append(scanners, h)
+	}
+	if args := strings.Fields(*scanCommand); len(args) > 0 {
+		scanners = append(scanners, scanner.Command{Path: args[0], Args: args[1:], Timeout: *scanTimeout})
+	}
+	return scanners, nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package impl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/golang/glog"
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/client"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/evidence"
+)
+
+// CompareOpts encapsulates options for comparing checkpoints from other parties
+// against the monitor's view of the log.
+type CompareOpts struct {
+	LogURL         string
+	LogSigVerifier api.LogVerifier
+	UseTiles       bool
+	StateFile      string
+	EvidenceDir    string
+	// Sources are files or URLs of checkpoints from other parties, e.g. witnesses.
+	Sources []string
+}
+
+// Compare checks each checkpoint from the sources against the last checkpoint verified
+// by the monitor, or the log's latest checkpoint if the monitor hasn't run yet. Evidence
+// of any split views is stored, and an error returned.
+func Compare(ctx context.Context, opts CompareOpts) error {
+	if len(opts.LogURL) == 0 {
+		return errors.New("log URL is required")
+	}
+	ftURL, err := url.Parse(opts.LogURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse FT log URL: %w", err)
+	}
+	c := client.ReadonlyClient{
+		LogURL:         ftURL,
+		LogSigVerifier: opts.LogSigVerifier,
+		UseTiles:       opts.UseTiles,
+	}
+	ev, err := openEvidence(opts.EvidenceDir)
+	if err != nil {
+		return err
+	}
+
+	var ours api.LogCheckpoint
+	if len(opts.StateFile) > 0 {
+		st, err := openState(opts.StateFile, opts.LogSigVerifier)
+		if err != nil {
+			return err
+		}
+		head, err := st.Head()
+		if err != nil {
+			return fmt.Errorf("failed to read state: %w", err)
+		}
+		if ours, err = headCheckpoint(head, opts.LogSigVerifier); err != nil {
+			return err
+		}
+	}
+	if ours.Size == 0 {
+		cp, err := c.GetCheckpoint()
+		if err != nil {
+			return fmt.Errorf("failed to get log checkpoint: %w", err)
+		}
+		ours = *cp
+	}
+	cpFunc := func(from, to uint64) ([][]byte, error) {
+		cp, err := c.GetConsistencyProof(api.GetConsistencyRequest{From: from, To: to})
+		if err != nil {
+			return nil, err
+		}
+		return cp.Proof, nil
+	}
+
+	var splits int
+	for _, src := range opts.Sources {
+		theirs, err := evidence.ReadCheckpoint(src)
+		if err != nil {
+			return fmt.Errorf("failed to read checkpoint from %q: %w", src, err)
+		}
+		sv, err := evidence.Compare(ours, theirs, opts.LogSigVerifier, cpFunc)
+		if err != nil {
+			return fmt.Errorf("failed to compare with checkpoint from %q: %w", src, err)
+		}
+		if sv == nil {
+			glog.Infof("Checkpoint from %q is consistent with %s", src, ours)
+			continue
+		}
+		ev.Record(*sv)
+		splits++
+	}
+	if splits > 0 {
+		return fmt.Errorf("found %d split views", splits)
+	}
+	return nil
+}
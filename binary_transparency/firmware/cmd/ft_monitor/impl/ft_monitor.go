@@ -24,19 +24,31 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha512"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
-	"regexp"
 	"time"
 
 	"github.com/golang/glog"
 	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian-examples/binary_transparency/firmware/cmd/ft_monitor/internal/scanner"
+	"github.com/google/trillian-examples/binary_transparency/firmware/cmd/ft_monitor/internal/state"
 	"github.com/google/trillian-examples/binary_transparency/firmware/internal/client"
 	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
-	"golang.org/x/mod/sumdb/note"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/evidence"
+
+	_ "github.com/mattn/go-sqlite3" // Load drivers for sqlite3
+)
+
+const (
+	// maxAttempts is the number of times an entry is processed before giving up on it.
+	maxAttempts = 10
+	// maxRetryBackoff is the longest time to wait before processing an entry again.
+	maxRetryBackoff = time.Hour
 )
 
 // MatchFunc is the signature of a function which can be called by the monitor
@@ -46,12 +58,27 @@ type MatchFunc func(index uint64, fw api.FirmwareMetadata)
 // MonitorOpts encapsulates options for running the monitor.
 type MonitorOpts struct {
 	LogURL         string
-	LogSigVerifier note.Verifier
-	PollInterval   time.Duration
-	Keyword        string
-	Matched        MatchFunc
-	Annotate       bool
-	StateFile      string
+	LogSigVerifier api.LogVerifier
+	// UseTiles fetches entries and proofs from the tile-based view of the log rather than its proof endpoints.
+	UseTiles     bool
+	PollInterval time.Duration
+	// Keyword is a regular expression which marks firmware as malware if it matches the image.
+	// If empty, only the Scanners are used.
+	Keyword string
+	// Scanners check firmware images for malware in addition to the Keyword.
+	Scanners []scanner.Scanner
+	Matched  MatchFunc
+	Annotate bool
+	// StateFile is the sqlite database which records the progress of the monitor.
+	StateFile string
+	// Claimants are the keys trusted to sign statements in the log.
+	Claimants *crypto.ClaimantRegistry
+	// Annotator signs malware annotations. Required if Annotate is set.
+	Annotator *crypto.Claimant
+	// EvidenceDir is where evidence of split views is stored. If empty, split views are only logged.
+	EvidenceDir string
+	// ListenAddr is the address to serve the evidence on, or empty to not serve it.
+	ListenAddr string
 }
 
 // Main runs the monitor until the context is canceled.
@@ -62,100 +89,330 @@ func Main(ctx context.Context, opts MonitorOpts) error {
 	if len(opts.StateFile) == 0 {
 		return errors.New("state file is required")
 	}
+	if opts.Claimants == nil {
+		return errors.New("claimant registry is required")
+	}
+	if opts.Annotate && opts.Annotator == nil {
+		return errors.New("annotator key is required to annotate")
+	}
 
 	ftURL, err := url.Parse(opts.LogURL)
 	if err != nil {
 		return fmt.Errorf("failed to parse FT log URL: %w", err)
 	}
 
-	// Parse the input keywords as regular expression
-	matcher := regexp.MustCompile(opts.Keyword)
+	ev, err := openEvidence(opts.EvidenceDir)
+	if err != nil {
+		return err
+	}
+	if len(opts.ListenAddr) > 0 {
+		if ev == nil {
+			return errors.New("evidence directory is required to serve evidence")
+		}
+		go serveEvidence(ctx, opts.ListenAddr, ev)
+	}
+
+	// Parse the input keywords as regular expression, and run it before the other scanners.
+	scanners := opts.Scanners
+	if len(opts.Keyword) > 0 {
+		k, err := scanner.NewKeyword(opts.Keyword)
+		if err != nil {
+			return err
+		}
+		scanners = append([]scanner.Scanner{k}, scanners...)
+	}
+	if len(scanners) == 0 {
+		return errors.New("at least one of keyword or scanners is required")
+	}
 
 	c := client.ReadonlyClient{
 		LogURL:         ftURL,
 		LogSigVerifier: opts.LogSigVerifier,
+		UseTiles:       opts.UseTiles,
 	}
 
-	// Initialize the checkpoint from persisted state.
-	var latestCP api.LogCheckpoint
-	if state, err := os.ReadFile(opts.StateFile); err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
-			return fmt.Errorf("failed to read state: %w", err)
-		}
+	// Initialize the checkpoint and head index from persisted state.
+	st, err := openState(opts.StateFile, opts.LogSigVerifier)
+	if err != nil {
+		return err
+	}
+	head, err := st.Head()
+	if err != nil {
+		return fmt.Errorf("failed to read state: %w", err)
+	}
+	latestCP, err := headCheckpoint(head, opts.LogSigVerifier)
+	if err != nil {
+		return err
+	}
+	if latestCP.Size == 0 {
 		// This could fail here unless a force flag is provided, for better security.
-		glog.Warningf("State file %q did not exist; first log checkpoint will be trusted implicitly", opts.StateFile)
-	} else {
-		cp, err := api.ParseCheckpoint(state, opts.LogSigVerifier)
-		if err != nil {
-			return fmt.Errorf("failed to open state: %w", err)
-		}
-		latestCP = *cp
+		glog.Warningf("No checkpoint in state file %q; first log checkpoint will be trusted implicitly", opts.StateFile)
 	}
-	head := latestCP.Size
-	follow := client.NewLogFollower(c)
+	follow := client.NewLogFollower(c, opts.Claimants)
+	// Statements from unknown claimants are recorded by processEntry, rather than stopping
+	// the monitor at them.
+	follow.AllowUnverified = true
 
-	glog.Infof("Monitoring FT log (%q) starting from index %d", opts.LogURL, head)
+	glog.Infof("Monitoring FT log (%q) starting from index %d", opts.LogURL, head.Next)
 	cpc, cperrc := follow.Checkpoints(ctx, opts.PollInterval, latestCP)
-	ec, eerrc := follow.Entries(ctx, cpc, head)
+	ec, eerrc := follow.Entries(ctx, cpc, head.Next)
 
+	retry := time.NewTicker(opts.PollInterval)
+	defer retry.Stop()
 	for {
 		var entry client.LogEntry
 		select {
 		case err = <-cperrc:
+			var cerr client.ErrConsistency
+			if errors.As(err, &cerr) {
+				ev.Record(evidence.New(cerr.Golden, cerr.Latest, cerr.Proof))
+			}
 			return err
 		case err = <-eerrc:
 			return err
 		case <-ctx.Done():
 			return ctx.Err()
+		case <-retry.C:
+			if err := retryEntries(ctx, st, c, opts, scanners); err != nil {
+				return err
+			}
+			continue
 		case entry = <-ec:
 		}
 
-		if err := processEntry(entry, c, opts, matcher); err != nil {
-			// TODO(mhutchinson): Consider a flag that causes processing errors to hard-fail.
-			glog.Warningf("Warning processing entry at index %d: %q", entry.Index, err)
+		// TODO(mhutchinson): Consider a flag that causes processing errors to hard-fail.
+		outcome, err := processEntry(ctx, entry, c, opts, scanners)
+		r, err := newRecord(entry, 1, outcome, err, opts.PollInterval)
+		if err != nil {
+			return err
+		}
+		// Persist the outcome along with the checkpoint so that we don't repeat work on startup,
+		// even if we stop part way through the entries in a checkpoint.
+		if err := st.Advance(r, entry.Root.Envelope); err != nil {
+			return fmt.Errorf("failed to update state: %w", err)
 		}
+	}
+}
 
-		if entry.Index == entry.Root.Size-1 {
-			// If we have processed all leaves in the current checkpoint, then persist this checkpoint
-			// so that we don't repeat work on startup.
-			if err := os.WriteFile(opts.StateFile, entry.Root.Envelope, 0o755); err != nil {
-				glog.Errorf("os.WriteFile(): %v", err)
-			}
+// retryEntries processes the entries in the retry queue which are due to be tried again.
+func retryEntries(ctx context.Context, st *state.Storage, c client.ReadonlyClient, opts MonitorOpts, scanners []scanner.Scanner) error {
+	rs, err := st.Due(time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to read retry queue: %w", err)
+	}
+	for _, r := range rs {
+		entry := client.LogEntry{Index: r.Index}
+		if err := json.Unmarshal(r.Entry, &entry.Value); err != nil {
+			return fmt.Errorf("failed to decode entry %d in retry queue: %w", r.Index, err)
+		}
+		glog.Infof("Retrying entry at index %d (attempt %d)", r.Index, r.Attempts+1)
+		outcome, err := processEntry(ctx, entry, c, opts, scanners)
+		nr, err := newRecord(entry, r.Attempts+1, outcome, err, opts.PollInterval)
+		if err != nil {
+			return err
+		}
+		if err := st.Update(nr); err != nil {
+			return fmt.Errorf("failed to update state: %w", err)
 		}
 	}
+	return nil
 }
 
-func processEntry(entry client.LogEntry, c client.ReadonlyClient, opts MonitorOpts, matcher *regexp.Regexp) error {
+// newRecord returns the record of an attempt to process the entry. Entries which failed in
+// a way that may succeed later are queued to be retried, backing off after each attempt.
+func newRecord(entry client.LogEntry, attempts int, outcome state.Outcome, err error, pollInterval time.Duration) (state.Record, error) {
+	r := state.Record{Index: entry.Index, Outcome: outcome, Attempts: attempts}
+	if err == nil {
+		return r, nil
+	}
+	r.Detail = err.Error()
+	if outcome != state.Retry {
+		glog.Warningf("Warning processing entry at index %d: %q", entry.Index, err)
+		return r, nil
+	}
+	if attempts >= maxAttempts {
+		glog.Errorf("Giving up on entry at index %d after %d attempts: %q", entry.Index, attempts, err)
+		r.Outcome = state.Failed
+		return r, nil
+	}
+	b, merr := json.Marshal(entry.Value)
+	if merr != nil {
+		return state.Record{}, fmt.Errorf("failed to marshal entry %d: %w", entry.Index, merr)
+	}
+	backoff := pollInterval
+	for i := 1; i < attempts && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	r.Entry, r.RetryAt = b, time.Now().Add(backoff)
+	glog.Warningf("Failed to process entry at index %d, will retry after %v: %q", entry.Index, backoff, err)
+	return r, nil
+}
+
+// openState opens the monitor state in the sqlite database at the given path.
+//
+// A state file written by earlier versions of the monitor, which held only the checkpoint
+// of the log once all of its entries had been processed, is migrated to a database whose
+// head is that checkpoint, and the original is kept alongside with a ".legacy" suffix.
+func openState(path string, lv api.LogVerifier) (*state.Storage, error) {
+	legacy, err := readLegacyState(path)
+	if err != nil {
+		return nil, err
+	}
+	var head state.Head
+	if legacy != nil {
+		cp, err := api.ParseCheckpoint(legacy, lv)
+		if err != nil {
+			return nil, fmt.Errorf("state file %q is neither a sqlite database nor a checkpoint from this log written by an earlier monitor: %w", path, err)
+		}
+		head = state.Head{Checkpoint: legacy, Next: cp.Size}
+		backup := path + ".legacy"
+		if err := os.Rename(path, backup); err != nil {
+			return nil, fmt.Errorf("failed to move legacy state file aside: %w", err)
+		}
+		glog.Infof("Migrating legacy state file %q at checkpoint size %d; the original is kept in %q", path, cp.Size, backup)
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state DB: %w", err)
+	}
+	st, err := state.NewStorage(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect state DB: %w", err)
+	}
+	if legacy != nil {
+		if err := st.Import(head); err != nil {
+			return nil, fmt.Errorf("failed to migrate legacy state file: %w", err)
+		}
+	}
+	return st, nil
+}
+
+// readLegacyState returns the checkpoint in the file at path if it is a legacy state file,
+// or nil if the file is missing, empty, or a sqlite database.
+func readLegacyState(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read state: %w", err)
+	case len(b) == 0, bytes.HasPrefix(b, []byte("SQLite format 3\x00")):
+		return nil, nil
+	}
+	return b, nil
+}
+
+// headCheckpoint returns the checkpoint at the head of the persisted state, which is
+// empty if there is no state yet.
+func headCheckpoint(head state.Head, lv api.LogVerifier) (api.LogCheckpoint, error) {
+	if len(head.Checkpoint) == 0 {
+		return api.LogCheckpoint{}, nil
+	}
+	cp, err := api.ParseCheckpoint(head.Checkpoint, lv)
+	if err != nil {
+		return api.LogCheckpoint{}, fmt.Errorf("failed to open state: %w", err)
+	}
+	return *cp, nil
+}
+
+// openEvidence returns the store for evidence in dir, or nil if dir is empty.
+func openEvidence(dir string) (*evidence.Store, error) {
+	if len(dir) == 0 {
+		glog.Warning("No evidence directory configured; split views will only be logged")
+		return nil, nil
+	}
+	ev, err := evidence.NewStore(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open evidence store: %w", err)
+	}
+	return ev, nil
+}
+
+// serveEvidence serves the evidence found by the monitor until the context is canceled.
+func serveEvidence(ctx context.Context, addr string, ev *evidence.Store) {
+	prefix := fmt.Sprintf("/%s", api.MonitorEvidence)
+	mux := http.NewServeMux()
+	mux.Handle(prefix+"/", http.StripPrefix(prefix, evidence.Handler(ev)))
+	mux.Handle(prefix, http.StripPrefix(prefix, evidence.Handler(ev)))
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		if err := srv.Shutdown(context.Background()); err != nil {
+			glog.Errorf("server.Shutdown(): %v", err)
+		}
+	}()
+	glog.Infof("Serving evidence on %s", addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		glog.Errorf("Failed to serve evidence: %v", err)
+	}
+}
+
+// processEntry inspects the firmware in the entry, and returns the outcome. Failures to
+// fetch or scan the firmware image, or to publish the malware annotation, have the Retry
+// outcome, as they may succeed later.
+// Firmware from a publisher which isn't known, or isn't authorized for the device, has the
+// Unauthorized outcome, which is recorded in the state along with the policy violation.
+// Other statements whose signature couldn't be verified have the Failed outcome.
+func processEntry(ctx context.Context, entry client.LogEntry, c client.ReadonlyClient, opts MonitorOpts, scanners []scanner.Scanner) (state.Outcome, error) {
 	stmt := entry.Value
+	if entry.Unverified {
+		if stmt.Type == api.FirmwareMetadataType {
+			glog.Errorf("Firmware at index %d isn't signed by a known publisher", entry.Index)
+			return state.Unauthorized, errors.New("firmware isn't signed by a known publisher")
+		}
+		return state.Failed, fmt.Errorf("statement of type %v isn't signed by a known claimant", stmt.Type)
+	}
 	if stmt.Type != api.FirmwareMetadataType {
 		// Only analyze firmware statements in the monitor.
-		return nil
+		return state.OK, nil
 	}
 
 	// Parse the firmware metadata:
 	var meta api.FirmwareMetadata
 	if err := json.Unmarshal(stmt.Statement, &meta); err != nil {
-		return fmt.Errorf("unable to decode FW Metadata from Statement %q", err)
+		return state.Failed, fmt.Errorf("unable to decode FW Metadata from Statement %q", err)
 	}
 
 	glog.Infof("Found firmware (@%d): %s", entry.Index, meta)
 
+	// The follower has already checked the signature, but not whether the
+	// publisher is allowed to release firmware for this device.
+	publisher, err := opts.Claimants.VerifyStatement(stmt)
+	if err != nil {
+		return state.Failed, fmt.Errorf("failed to verify statement: %w", err)
+	}
+	if err := opts.Claimants.AuthorizeFirmware(meta.DeviceID, publisher); err != nil {
+		glog.Errorf("Firmware at index %d violates publisher policy: %v", entry.Index, err)
+		return state.Unauthorized, fmt.Errorf("firmware violates publisher policy: %w", err)
+	}
+
 	// Fetch the Image from FT Personality
 	image, err := c.GetFirmwareImage(meta.FirmwareImageSHA512)
 	if err != nil {
-		return fmt.Errorf("unable to GetFirmwareImage for Firmware with Hash 0x%x , reason %q", meta.FirmwareImageSHA512, err)
+		return state.Retry, fmt.Errorf("unable to GetFirmwareImage for Firmware with Hash 0x%x , reason %q", meta.FirmwareImageSHA512, err)
 	}
 	// Verify Image Hash from log Manifest matches the actual image hash
 	h := sha512.Sum512(image)
 	if !bytes.Equal(h[:], meta.FirmwareImageSHA512) {
-		return fmt.Errorf("downloaded image does not match SHA512 in metadata (%x != %x)", h[:], meta.FirmwareImageSHA512)
+		return state.Failed, fmt.Errorf("downloaded image does not match SHA512 in metadata (%x != %x)", h[:], meta.FirmwareImageSHA512)
 	}
 	glog.V(1).Infof("Image Hash Verified for image at leaf index %d", entry.Index)
 
-	// Search for specific keywords inside firmware image
-	malwareDetected := matcher.Match(image)
+	// Scan the firmware image for malware
+	scans, good, err := scanner.ScanAll(ctx, image, scanners...)
+	if err != nil {
+		return state.Retry, fmt.Errorf("failed to scan image: %w", err)
+	}
+	scanned := time.Now()
 
-	if malwareDetected {
+	outcome := state.OK
+	if !good {
+		outcome = state.Matched
+		glog.V(1).Infof("Scans of firmware at index %d: %v", entry.Index, scans)
 		opts.Matched(entry.Index, meta)
 	}
 	if opts.Annotate {
@@ -164,38 +421,34 @@ func processEntry(entry client.LogEntry, c client.ReadonlyClient, opts MonitorOp
 				LogIndex:            entry.Index,
 				FirmwareImageSHA512: h[:],
 			},
-			Good: !malwareDetected,
+			Good:           good,
+			Scans:          scans,
+			TimestampNanos: uint64(scanned.UnixNano()),
 		}
 		glog.V(1).Infof("Annotating %s", ms)
-		js, err := createStatementJSON(ms)
+		js, err := createStatementJSON(ms, opts.Annotator)
 		if err != nil {
-			return fmt.Errorf("failed to create annotation: %q", err)
+			return state.Failed, fmt.Errorf("failed to create annotation: %q", err)
 		}
 		sc := client.SubmitClient{
 			ReadonlyClient: &c,
 		}
 		if err := sc.PublishAnnotationMalware(js); err != nil {
-			return fmt.Errorf("failed to publish annotation: %q", err)
+			return state.Retry, fmt.Errorf("failed to publish annotation: %q", err)
 		}
 	}
-	return nil
+	return outcome, nil
 }
 
-func createStatementJSON(m api.MalwareStatement) ([]byte, error) {
+func createStatementJSON(m api.MalwareStatement, annotator *crypto.Claimant) ([]byte, error) {
 	js, err := json.Marshal(m)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal metadata: %w", err)
 	}
-	sig, err := crypto.AnnotatorMalware.SignMessage(api.MalwareStatementType, js)
+	statement, err := annotator.SignStatement(api.MalwareStatementType, js)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate signature: %w", err)
 	}
 
-	statement := api.SignedStatement{
-		Type:      api.MalwareStatementType,
-		Statement: js,
-		Signature: sig,
-	}
-
 	return json.Marshal(statement)
 }
@@ -0,0 +1,330 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package impl
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian-examples/binary_transparency/firmware/cmd/ft_monitor/internal/scanner"
+	"github.com/google/trillian-examples/binary_transparency/firmware/cmd/ft_monitor/internal/state"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/client"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
+	"golang.org/x/mod/sumdb/note"
+)
+
+func TestProcessEntryPublisherPolicy(t *testing.T) {
+	claimants, err := crypto.NewClaimantRegistry(crypto.RegistryConfig{
+		Claimants: []crypto.ClaimantConfig{
+			{ID: "vendor-a", Type: "f", PublicKey: crypto.TestVendorRSAPub},
+			{ID: "vendor-b", Type: "f", PublicKey: crypto.TestAnnotationPub},
+		},
+		Devices: []crypto.DevicePolicyConfig{
+			{DeviceIDPrefix: "acme-", Publishers: []string{"vendor-a"}},
+		},
+	}, "")
+	if err != nil {
+		t.Fatalf("NewClaimantRegistry(): %v", err)
+	}
+	vendorA, err := crypto.NewSigningClaimant(crypto.TestVendorRSAPriv, "vendor-a")
+	if err != nil {
+		t.Fatalf("NewSigningClaimant(): %v", err)
+	}
+	vendorB, err := crypto.NewSigningClaimant(crypto.TestAnnotationPriv, "vendor-b")
+	if err != nil {
+		t.Fatalf("NewSigningClaimant(): %v", err)
+	}
+	kw, err := scanner.NewKeyword("H4x0r3d")
+	if err != nil {
+		t.Fatalf("NewKeyword(): %v", err)
+	}
+
+	for _, test := range []struct {
+		desc        string
+		image       string
+		signer      *crypto.Claimant
+		unverified  bool
+		wantOutcome state.Outcome
+		wantErr     bool
+		wantMatch   bool
+		wantFetch   bool
+	}{
+		{
+			desc:        "authorized",
+			image:       "good firmware",
+			signer:      vendorA,
+			wantOutcome: state.OK,
+			wantFetch:   true,
+		}, {
+			desc:        "authorized malware",
+			image:       "H4x0r3d firmware",
+			signer:      vendorA,
+			wantOutcome: state.Matched,
+			wantMatch:   true,
+			wantFetch:   true,
+		}, {
+			desc:        "unauthorized publisher",
+			image:       "good firmware",
+			signer:      vendorB,
+			wantOutcome: state.Unauthorized,
+			wantErr:     true,
+		}, {
+			desc:        "unknown publisher",
+			image:       "good firmware",
+			signer:      &crypto.Publisher,
+			unverified:  true,
+			wantOutcome: state.Unauthorized,
+			wantErr:     true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			fetched := false
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fetched = true
+				_, _ = w.Write([]byte(test.image))
+			}))
+			defer ts.Close()
+			u, err := url.Parse(ts.URL)
+			if err != nil {
+				t.Fatalf("url.Parse(): %v", err)
+			}
+
+			h := sha512.Sum512([]byte(test.image))
+			js, err := json.Marshal(api.FirmwareMetadata{
+				DeviceID:            "acme-kettle",
+				FirmwareRevision:    1,
+				FirmwareImageSHA512: h[:],
+			})
+			if err != nil {
+				t.Fatalf("json.Marshal(): %v", err)
+			}
+			stmt, err := test.signer.SignStatement(api.FirmwareMetadataType, js)
+			if err != nil {
+				t.Fatalf("SignStatement(): %v", err)
+			}
+
+			matched := false
+			opts := MonitorOpts{
+				Claimants: claimants,
+				Matched:   func(uint64, api.FirmwareMetadata) { matched = true },
+			}
+			c := client.ReadonlyClient{LogURL: u}
+			outcome, err := processEntry(context.Background(), client.LogEntry{Index: 3, Value: stmt, Unverified: test.unverified}, c, opts, []scanner.Scanner{kw})
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("Got unexpected error %q", err)
+			case err == nil && test.wantErr:
+				t.Fatal("Got no error, but wanted error")
+			}
+			if outcome != test.wantOutcome {
+				t.Errorf("got outcome %q, want %q", outcome, test.wantOutcome)
+			}
+			if matched != test.wantMatch {
+				t.Errorf("got matched %t, want %t", matched, test.wantMatch)
+			}
+			if fetched != test.wantFetch {
+				t.Errorf("got image fetched %t, want %t", fetched, test.wantFetch)
+			}
+
+			// The outcome and the policy violation are persisted in the state.
+			r, err := newRecord(client.LogEntry{Index: 3, Value: stmt}, 1, outcome, err, opts.PollInterval)
+			if err != nil {
+				t.Fatalf("newRecord(): %v", err)
+			}
+			if r.Outcome != test.wantOutcome {
+				t.Errorf("got recorded outcome %q, want %q", r.Outcome, test.wantOutcome)
+			}
+			if got, want := len(r.Detail) > 0, test.wantErr; got != want {
+				t.Errorf("got detail %q, want detail %t", r.Detail, want)
+			}
+		})
+	}
+}
+
+func TestProcessEntryRetriesPublish(t *testing.T) {
+	claimants, err := crypto.NewClaimantRegistry(crypto.RegistryConfig{
+		Claimants: []crypto.ClaimantConfig{
+			{ID: "vendor-a", Type: "f", PublicKey: crypto.TestVendorRSAPub},
+		},
+	}, "")
+	if err != nil {
+		t.Fatalf("NewClaimantRegistry(): %v", err)
+	}
+	vendorA, err := crypto.NewSigningClaimant(crypto.TestVendorRSAPriv, "vendor-a")
+	if err != nil {
+		t.Fatalf("NewSigningClaimant(): %v", err)
+	}
+	annotator, err := crypto.NewSigningClaimant(crypto.TestAnnotationPriv, "annotator")
+	if err != nil {
+		t.Fatalf("NewSigningClaimant(): %v", err)
+	}
+	kw, err := scanner.NewKeyword("H4x0r3d")
+	if err != nil {
+		t.Fatalf("NewKeyword(): %v", err)
+	}
+
+	image := []byte("H4x0r3d firmware")
+	published, outage := 0, true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/"+api.HTTPAddAnnotationMalware {
+			if outage {
+				http.Error(w, "personality unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			published++
+			return
+		}
+		_, _ = w.Write(image)
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(): %v", err)
+	}
+
+	h := sha512.Sum512(image)
+	js, err := json.Marshal(api.FirmwareMetadata{
+		DeviceID:            "acme-kettle",
+		FirmwareRevision:    1,
+		FirmwareImageSHA512: h[:],
+	})
+	if err != nil {
+		t.Fatalf("json.Marshal(): %v", err)
+	}
+	stmt, err := vendorA.SignStatement(api.FirmwareMetadataType, js)
+	if err != nil {
+		t.Fatalf("SignStatement(): %v", err)
+	}
+	entry := client.LogEntry{Index: 3, Value: stmt}
+	opts := MonitorOpts{
+		Claimants:    claimants,
+		Annotate:     true,
+		Annotator:    annotator,
+		Matched:      func(uint64, api.FirmwareMetadata) {},
+		PollInterval: time.Minute,
+	}
+	c := client.ReadonlyClient{LogURL: u}
+
+	// A personality outage mustn't drop the malware finding: the entry is queued for retry.
+	outcome, err := processEntry(context.Background(), entry, c, opts, []scanner.Scanner{kw})
+	if err == nil {
+		t.Fatal("Got no error, but wanted error")
+	}
+	if outcome != state.Retry {
+		t.Fatalf("got outcome %q, want %q", outcome, state.Retry)
+	}
+	r, err := newRecord(entry, 1, outcome, err, opts.PollInterval)
+	if err != nil {
+		t.Fatalf("newRecord(): %v", err)
+	}
+	if r.Outcome != state.Retry || len(r.Entry) == 0 || r.RetryAt.IsZero() {
+		t.Fatalf("got record %+v, want entry queued for retry", r)
+	}
+
+	// Once the personality is back, the retried entry publishes the annotation.
+	var queued api.SignedStatement
+	if err := json.Unmarshal(r.Entry, &queued); err != nil {
+		t.Fatalf("json.Unmarshal(): %v", err)
+	}
+	outage = false
+	outcome, err = processEntry(context.Background(), client.LogEntry{Index: r.Index, Value: queued}, c, opts, []scanner.Scanner{kw})
+	if err != nil {
+		t.Fatalf("processEntry(): %v", err)
+	}
+	if outcome != state.Matched {
+		t.Errorf("got outcome %q, want %q", outcome, state.Matched)
+	}
+	if published != 1 {
+		t.Errorf("got %d annotations published, want 1", published)
+	}
+}
+
+func TestOpenStateMigratesLegacy(t *testing.T) {
+	s, err := note.NewSigner(crypto.TestFTPersonalityPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp, err := note.Sign(&note.Note{Text: api.FTLogOrigin + "\n5\nAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=\n123\n"}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lv, err := api.NewLogVerifier(api.FTLogOrigin, crypto.TestFTPersonalityPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		desc     string
+		legacy   []byte
+		wantNext uint64
+		wantErr  bool
+	}{
+		{
+			desc: "no state",
+		}, {
+			desc:     "legacy state",
+			legacy:   cp,
+			wantNext: 5,
+		}, {
+			desc:    "legacy state garbage",
+			legacy:  []byte("garbage"),
+			wantErr: true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ftmon.db")
+			if test.legacy != nil {
+				if err := os.WriteFile(path, test.legacy, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			st, err := openState(path, lv)
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("Got unexpected error %q", err)
+			case err == nil && test.wantErr:
+				t.Fatal("Got no error, but wanted error")
+			case err != nil && test.wantErr:
+				// The legacy state must be left untouched.
+				if got, _ := os.ReadFile(path); !bytes.Equal(got, test.legacy) {
+					t.Errorf("legacy state modified: got %q, want %q", got, test.legacy)
+				}
+				return
+			}
+			h, err := st.Head()
+			if err != nil {
+				t.Fatalf("Head(): %v", err)
+			}
+			if h.Next != test.wantNext || !bytes.Equal(h.Checkpoint, test.legacy) {
+				t.Errorf("got head %+v, want checkpoint %q and next %d", h, test.legacy, test.wantNext)
+			}
+
+			// Reopening the migrated state must not migrate again.
+			if _, err := openState(path, lv); err != nil {
+				t.Fatalf("reopening state: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+)
+
+// Command is a Scanner which runs an external command to check images. The image is
+// written to the command's stdin, and the command must write its verdict to stdout as
+// JSON, e.g. {"Good": false, "Hits": ["Some.Signature"]}. The command exiting with a
+// non-zero status means that it couldn't reach a verdict, not that it found malware.
+type Command struct {
+	// Path is the command to run.
+	Path string
+	// Args are the arguments to pass to the command.
+	Args []string
+	// Timeout bounds how long a scan may take, or is 0 for no limit. A scan which
+	// times out is an error, so the image can be scanned again later.
+	Timeout time.Duration
+}
+
+// Name implements Scanner.
+func (c Command) Name() string {
+	return "command:" + filepath.Base(c.Path)
+}
+
+// Scan implements Scanner.
+func (c Command) Scan(ctx context.Context, image []byte) (api.ScanResult, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.Path, c.Args...)
+	cmd.Stdin = bytes.NewReader(image)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return api.ScanResult{}, fmt.Errorf("%v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	var verdict struct {
+		Good *bool
+		Hits []string
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &verdict); err != nil {
+		return api.ScanResult{}, fmt.Errorf("failed to parse verdict %q: %w", stdout.Bytes(), err)
+	}
+	if verdict.Good == nil {
+		return api.ScanResult{}, fmt.Errorf("verdict %q has no Good field", stdout.Bytes())
+	}
+	return api.ScanResult{Scanner: c.Name(), Good: *verdict.Good, Hits: verdict.Hits}, nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+)
+
+// HashList is a Scanner which finds malware by looking up the hash of the image in a
+// list of hashes of known bad images.
+type HashList struct {
+	name string
+	// bad maps the hex SHA-256 or SHA-512 hash of each bad image to its description.
+	bad map[string]string
+}
+
+// LoadHashList reads a list of hashes of known bad images from the file at path.
+// See ParseHashList for the format.
+func LoadHashList(path string) (*HashList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseHashList(filepath.Base(path), f)
+}
+
+// ParseHashList reads a list of hashes of known bad images. Each line has a hex encoded
+// SHA-256 or SHA-512 hash, optionally followed by a description of the image. Empty
+// lines and lines starting with # are ignored.
+func ParseHashList(name string, r io.Reader) (*HashList, error) {
+	h := &HashList{name: name, bad: make(map[string]string)}
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hash, desc, _ := strings.Cut(line, " ")
+		b, err := hex.DecodeString(hash)
+		if err != nil || (len(b) != sha256.Size && len(b) != sha512.Size) {
+			return nil, fmt.Errorf("%s:%d: %q is not a SHA-256 or SHA-512 hash", name, n, hash)
+		}
+		hash = hex.EncodeToString(b)
+		if desc = strings.TrimSpace(desc); len(desc) == 0 {
+			desc = hash
+		}
+		h.bad[hash] = desc
+	}
+	return h, s.Err()
+}
+
+// Name implements Scanner.
+func (h *HashList) Name() string {
+	return "hashes:" + h.name
+}
+
+// Scan implements Scanner.
+func (h *HashList) Scan(_ context.Context, image []byte) (api.ScanResult, error) {
+	r := api.ScanResult{Scanner: h.Name(), Good: true}
+	h256, h512 := sha256.Sum256(image), sha512.Sum512(image)
+	for _, hash := range [][]byte{h256[:], h512[:]} {
+		if desc, ok := h.bad[hex.EncodeToString(hash)]; ok {
+			r.Good = false
+			r.Hits = append(r.Hits, desc)
+		}
+	}
+	return r, nil
+}
+
+// Len returns the number of hashes in the list.
+func (h *HashList) Len() int {
+	return len(h.bad)
+}
@@ -0,0 +1,306 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+)
+
+// Rules is a Scanner which matches images against rules written in a subset of the
+// YARA language. The image is malware if any of the rules match. For example:
+//
+//	// Comments are on lines of their own.
+//	rule Backdoor {
+//	  strings:
+//	    $text = "H4x0r3d" nocase
+//	    $hex = { 4D 5A ?? 00 }
+//	    $re = /evil[0-9]+/i
+//	  condition:
+//	    $text or $hex and $re
+//	}
+//
+// Strings are text, which may be matched case insensitively with the nocase modifier,
+// hex bytes with ?? wildcards, or regular expressions with optional i and s modifiers.
+// Conditions are either "any of them", "all of them", "<n> of them", or string
+// identifiers joined by "and" and "or", where "and" binds more tightly.
+type Rules struct {
+	name  string
+	rules []rule
+}
+
+// rule is a single parsed rule.
+type rule struct {
+	name    string
+	strings map[string]func([]byte) bool
+	// atLeast is the number of strings which must match for "of them" conditions.
+	atLeast int
+	// anyOf is a disjunction of conjunctions of string identifiers, for other conditions.
+	anyOf [][]string
+}
+
+// LoadRules reads the rules in the file at path.
+func LoadRules(path string) (*Rules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseRules(filepath.Base(path), f)
+}
+
+// ParseRules reads the rules from r. The name identifies the rules in verdicts and errors.
+func ParseRules(name string, r io.Reader) (*Rules, error) {
+	rs := &Rules{name: name}
+	var cur *rule
+	var section, condition string
+	s := bufio.NewScanner(r)
+	n := 0
+	errorf := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%s:%d: %s", name, n, fmt.Sprintf(format, args...))
+	}
+	for s.Scan() {
+		n++
+		line := strings.TrimSpace(s.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "//") {
+			continue
+		}
+		if cur == nil {
+			f := strings.Fields(strings.TrimSuffix(line, "{"))
+			if len(f) != 2 || f[0] != "rule" || !strings.HasSuffix(line, "{") {
+				return nil, errorf("expected \"rule <name> {\", got %q", line)
+			}
+			cur = &rule{name: f[1], strings: make(map[string]func([]byte) bool)}
+			section, condition = "", ""
+			continue
+		}
+		switch {
+		case line == "strings:" || line == "condition:":
+			section = strings.TrimSuffix(line, ":")
+		case line == "}":
+			if err := cur.parseCondition(condition); err != nil {
+				return nil, errorf("rule %s: %v", cur.name, err)
+			}
+			rs.rules = append(rs.rules, *cur)
+			cur = nil
+		case section == "strings":
+			id, m, err := parseString(line)
+			if err != nil {
+				return nil, errorf("rule %s: %v", cur.name, err)
+			}
+			if _, ok := cur.strings[id]; ok {
+				return nil, errorf("rule %s: duplicate string %s", cur.name, id)
+			}
+			cur.strings[id] = m
+		case section == "condition":
+			condition = strings.TrimSpace(condition + " " + line)
+		default:
+			return nil, errorf("rule %s: unexpected %q", cur.name, line)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if cur != nil {
+		return nil, errorf("rule %s is not closed", cur.name)
+	}
+	return rs, nil
+}
+
+// parseString parses a string definition, returning its identifier and a function which
+// reports whether it matches an image.
+func parseString(line string) (string, func([]byte) bool, error) {
+	id, def, ok := strings.Cut(line, "=")
+	id, def = strings.TrimSpace(id), strings.TrimSpace(def)
+	if !ok || !strings.HasPrefix(id, "$") || len(id) == 1 {
+		return "", nil, fmt.Errorf("expected \"$<id> = <string>\", got %q", line)
+	}
+	switch {
+	case strings.HasPrefix(def, "\""):
+		q, err := strconv.QuotedPrefix(def)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid text string %s: %v", id, err)
+		}
+		text, _ := strconv.Unquote(q)
+		switch mod := strings.TrimSpace(def[len(q):]); mod {
+		case "":
+			return id, func(image []byte) bool { return bytes.Contains(image, []byte(text)) }, nil
+		case "nocase":
+			lower := bytes.ToLower([]byte(text))
+			return id, func(image []byte) bool { return bytes.Contains(bytes.ToLower(image), lower) }, nil
+		default:
+			return "", nil, fmt.Errorf("unsupported modifier %q for %s", mod, id)
+		}
+	case strings.HasPrefix(def, "{") && strings.HasSuffix(def, "}"):
+		pattern, err := parseHex(strings.Join(strings.Fields(def[1:len(def)-1]), ""))
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid hex string %s: %v", id, err)
+		}
+		return id, func(image []byte) bool { return matchHex(image, pattern) }, nil
+	case strings.HasPrefix(def, "/"):
+		end := strings.LastIndex(def, "/")
+		if end == 0 {
+			return "", nil, fmt.Errorf("unterminated regular expression %s", id)
+		}
+		expr, mods := def[1:end], def[end+1:]
+		if strings.Trim(mods, "is") != "" {
+			return "", nil, fmt.Errorf("unsupported modifiers %q for %s", mods, id)
+		}
+		if len(mods) > 0 {
+			expr = "(?" + mods + ")" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid regular expression %s: %v", id, err)
+		}
+		return id, re.Match, nil
+	}
+	return "", nil, fmt.Errorf("unsupported string %s: %q", id, def)
+}
+
+// parseHex parses hex bytes, with ?? as a wildcard which is returned as -1.
+func parseHex(h string) ([]int, error) {
+	if len(h) == 0 || len(h)%2 != 0 {
+		return nil, fmt.Errorf("%q is not a whole number of bytes", h)
+	}
+	pattern := make([]int, 0, len(h)/2)
+	for i := 0; i < len(h); i += 2 {
+		if h[i:i+2] == "??" {
+			pattern = append(pattern, -1)
+			continue
+		}
+		b, err := hex.DecodeString(h[i : i+2])
+		if err != nil {
+			return nil, err
+		}
+		pattern = append(pattern, int(b[0]))
+	}
+	return pattern, nil
+}
+
+// matchHex reports whether the pattern from parseHex occurs anywhere in the image.
+func matchHex(image []byte, pattern []int) bool {
+	for i := 0; i+len(pattern) <= len(image); i++ {
+		match := true
+		for j, p := range pattern {
+			if p >= 0 && int(image[i+j]) != p {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCondition parses the condition of the rule, checking that it only refers to
+// strings which the rule defines.
+func (r *rule) parseCondition(cond string) error {
+	f := strings.Fields(cond)
+	if len(f) == 0 {
+		return fmt.Errorf("no condition")
+	}
+	if len(f) == 3 && f[1] == "of" && f[2] == "them" {
+		if len(r.strings) == 0 {
+			return fmt.Errorf("condition %q but no strings", cond)
+		}
+		switch f[0] {
+		case "any":
+			r.atLeast = 1
+		case "all":
+			r.atLeast = len(r.strings)
+		default:
+			n, err := strconv.Atoi(f[0])
+			if err != nil || n < 1 || n > len(r.strings) {
+				return fmt.Errorf("invalid condition %q", cond)
+			}
+			r.atLeast = n
+		}
+		return nil
+	}
+	for _, or := range strings.Split(" "+strings.Join(f, " ")+" ", " or ") {
+		var all []string
+		for _, id := range strings.Split(" "+strings.TrimSpace(or)+" ", " and ") {
+			id = strings.TrimSpace(id)
+			if _, ok := r.strings[id]; !ok {
+				return fmt.Errorf("condition %q refers to unknown string %q", cond, id)
+			}
+			all = append(all, id)
+		}
+		r.anyOf = append(r.anyOf, all)
+	}
+	return nil
+}
+
+// match reports whether the rule matches the image.
+func (r rule) match(image []byte) bool {
+	if r.atLeast > 0 {
+		n := 0
+		for _, m := range r.strings {
+			if m(image) {
+				n++
+			}
+		}
+		return n >= r.atLeast
+	}
+	for _, all := range r.anyOf {
+		match := true
+		for _, id := range all {
+			if !r.strings[id](image) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of rules.
+func (r *Rules) Len() int {
+	return len(r.rules)
+}
+
+// Name implements Scanner.
+func (r *Rules) Name() string {
+	return "rules:" + r.name
+}
+
+// Scan implements Scanner.
+func (r *Rules) Scan(_ context.Context, image []byte) (api.ScanResult, error) {
+	res := api.ScanResult{Scanner: r.Name(), Good: true}
+	for _, rl := range r.rules {
+		if rl.match(image) {
+			res.Good = false
+			res.Hits = append(res.Hits, rl.name)
+		}
+	}
+	return res, nil
+}
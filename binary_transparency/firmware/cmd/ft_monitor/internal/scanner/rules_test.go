@@ -0,0 +1,111 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scanner
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const testRules = `
+// Rules for testing.
+rule Text {
+  strings:
+    $a = "H4x0r3d" nocase
+  condition:
+    $a
+}
+
+rule Hex {
+  strings:
+    $mz = { 4D 5A ?? 00 }
+    $re = /evil[0-9]+/
+  condition:
+    $mz and $re
+}
+
+rule Many {
+  strings:
+    $a = "one"
+    $b = "two"
+    $c = "three"
+  condition:
+    2 of them
+}
+`
+
+func TestRules(t *testing.T) {
+	rs, err := ParseRules("test.yar", strings.NewReader(testRules))
+	if err != nil {
+		t.Fatalf("ParseRules(): %v", err)
+	}
+	if got, want := rs.Len(), 3; got != want {
+		t.Fatalf("got %d rules, want %d", got, want)
+	}
+
+	for _, test := range []struct {
+		desc     string
+		image    string
+		wantHits []string
+	}{
+		{desc: "clean", image: "nothing to see here"},
+		{desc: "text", image: "owned by h4X0R3D", wantHits: []string{"Text"}},
+		{desc: "hex and regexp", image: "MZ\xff\x00 evil42", wantHits: []string{"Hex"}},
+		{desc: "hex only", image: "MZ\xff\x00 evil"},
+		{desc: "one of them", image: "one"},
+		{desc: "two of them", image: "three two", wantHits: []string{"Many"}},
+		{desc: "everything", image: "H4x0r3d MZ\x01\x00 evil1 one two three", wantHits: []string{"Text", "Hex", "Many"}},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			r, err := rs.Scan(context.Background(), []byte(test.image))
+			if err != nil {
+				t.Fatalf("Scan(): %v", err)
+			}
+			if got, want := r.Good, len(test.wantHits) == 0; got != want {
+				t.Errorf("got good %t, want %t", got, want)
+			}
+			if got, want := strings.Join(r.Hits, ","), strings.Join(test.wantHits, ","); got != want {
+				t.Errorf("got hits %q, want %q", got, want)
+			}
+			if got, want := r.Scanner, "rules:test.yar"; got != want {
+				t.Errorf("got scanner %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	for _, test := range []struct {
+		desc  string
+		rules string
+	}{
+		{desc: "no rule", rules: "strings:"},
+		{desc: "not closed", rules: "rule A {\nstrings:\n$a = \"a\"\ncondition:\n$a"},
+		{desc: "no condition", rules: "rule A {\nstrings:\n$a = \"a\"\n}"},
+		{desc: "unknown string", rules: "rule A {\nstrings:\n$a = \"a\"\ncondition:\n$a or $b\n}"},
+		{desc: "duplicate string", rules: "rule A {\nstrings:\n$a = \"a\"\n$a = \"b\"\ncondition:\n$a\n}"},
+		{desc: "bad hex", rules: "rule A {\nstrings:\n$a = { 4D 5 }\ncondition:\n$a\n}"},
+		{desc: "bad regexp", rules: "rule A {\nstrings:\n$a = /(/\ncondition:\n$a\n}"},
+		{desc: "bad modifier", rules: "rule A {\nstrings:\n$a = \"a\" wide\ncondition:\n$a\n}"},
+		{desc: "too many of them", rules: "rule A {\nstrings:\n$a = \"a\"\ncondition:\n2 of them\n}"},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			if _, err := ParseRules("test.yar", strings.NewReader(test.rules)); err == nil {
+				t.Error("ParseRules() succeeded, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package scanner contains the malware scanners used by the FT monitor to check
+// firmware images.
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+)
+
+// Scanner checks firmware images for malware.
+type Scanner interface {
+	// Name identifies the scanner in the verdicts it returns.
+	Name() string
+	// Scan checks the image and returns the verdict. An error is returned if the
+	// scanner couldn't reach a verdict.
+	Scan(ctx context.Context, image []byte) (api.ScanResult, error)
+}
+
+// ScanAll checks the image with each of the scanners. The image is good if none
+// of the scanners found malware.
+func ScanAll(ctx context.Context, image []byte, scanners ...Scanner) ([]api.ScanResult, bool, error) {
+	results := make([]api.ScanResult, 0, len(scanners))
+	good := true
+	for _, s := range scanners {
+		r, err := s.Scan(ctx, image)
+		if err != nil {
+			return nil, false, fmt.Errorf("scanner %q failed: %w", s.Name(), err)
+		}
+		results = append(results, r)
+		good = good && r.Good
+	}
+	return results, good, nil
+}
+
+// Keyword is a Scanner which finds malware by matching a regular expression against the image.
+type Keyword struct {
+	re *regexp.Regexp
+}
+
+// NewKeyword returns a Scanner which considers images matching the regular expression to be malware.
+func NewKeyword(expr string) (*Keyword, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keyword %q: %w", expr, err)
+	}
+	return &Keyword{re: re}, nil
+}
+
+// Name implements Scanner.
+func (k *Keyword) Name() string {
+	return "keyword"
+}
+
+// Scan implements Scanner.
+func (k *Keyword) Scan(_ context.Context, image []byte) (api.ScanResult, error) {
+	r := api.ScanResult{Scanner: k.Name(), Good: true}
+	if k.re.Match(image) {
+		r.Good, r.Hits = false, []string{k.re.String()}
+	}
+	return r, nil
+}
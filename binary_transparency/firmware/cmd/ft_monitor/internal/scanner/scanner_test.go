@@ -0,0 +1,151 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scanner
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+)
+
+func TestScanAll(t *testing.T) {
+	keyword, err := NewKeyword("H4x0r3d")
+	if err != nil {
+		t.Fatalf("NewKeyword(): %v", err)
+	}
+	bad := []byte("known bad image")
+	h512 := sha512.Sum512(bad)
+	hashes, err := ParseHashList("bad.txt", strings.NewReader(fmt.Sprintf("# Known bad images\n\n%x Bad.Image\n", h512)))
+	if err != nil {
+		t.Fatalf("ParseHashList(): %v", err)
+	}
+
+	for _, test := range []struct {
+		desc      string
+		image     string
+		scanners  []Scanner
+		wantGood  bool
+		wantScans []string
+		wantErr   bool
+	}{
+		{
+			desc:      "clean",
+			image:     "a good image",
+			scanners:  []Scanner{keyword, hashes},
+			wantGood:  true,
+			wantScans: []string{"keyword{good true, hits []}", "hashes:bad.txt{good true, hits []}"},
+		}, {
+			desc:      "keyword",
+			image:     "H4x0r3d image",
+			scanners:  []Scanner{keyword, hashes},
+			wantScans: []string{`keyword{good false, hits ["H4x0r3d"]}`, "hashes:bad.txt{good true, hits []}"},
+		}, {
+			desc:      "hash",
+			image:     string(bad),
+			scanners:  []Scanner{keyword, hashes},
+			wantScans: []string{"keyword{good true, hits []}", `hashes:bad.txt{good false, hits ["Bad.Image"]}`},
+		}, {
+			desc:      "command",
+			image:     "anything",
+			scanners:  []Scanner{keyword, Command{Path: "sh", Args: []string{"-c", `cat >/dev/null; echo '{"Good": false, "Hits": ["Ext.Sig"]}'`}}},
+			wantScans: []string{"keyword{good true, hits []}", `command:sh{good false, hits ["Ext.Sig"]}`},
+		}, {
+			desc:     "command fails",
+			image:    "anything",
+			scanners: []Scanner{keyword, Command{Path: "sh", Args: []string{"-c", "exit 1"}}},
+			wantErr:  true,
+		}, {
+			desc:     "command gives no verdict",
+			image:    "anything",
+			scanners: []Scanner{Command{Path: "sh", Args: []string{"-c", "echo '{}'"}}},
+			wantErr:  true,
+		}, {
+			desc:     "command times out",
+			image:    "anything",
+			scanners: []Scanner{Command{Path: "sh", Args: []string{"-c", "exec sleep 60"}, Timeout: 100 * time.Millisecond}},
+			wantErr:  true,
+		}, {
+			desc:     "no scanners",
+			image:    "H4x0r3d",
+			wantGood: true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			scans, good, err := ScanAll(context.Background(), []byte(test.image), test.scanners...)
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("unexpected error: %v", err)
+			case err == nil && test.wantErr:
+				t.Fatal("expected error, got none")
+			case err != nil && test.wantErr:
+				return
+			}
+			if good != test.wantGood {
+				t.Errorf("got good %t, want %t", good, test.wantGood)
+			}
+			var got []string
+			for _, s := range scans {
+				got = append(got, s.String())
+			}
+			if g, w := strings.Join(got, "\n"), strings.Join(test.wantScans, "\n"); g != w {
+				t.Errorf("got scans:\n%s\nwant:\n%s", g, w)
+			}
+		})
+	}
+}
+
+func TestHashList(t *testing.T) {
+	image := []byte("firmware")
+	h256, h512 := sha256.Sum256(image), sha512.Sum512(image)
+	for _, test := range []struct {
+		desc     string
+		list     string
+		wantHits []string
+		wantErr  bool
+	}{
+		{desc: "empty"},
+		{desc: "sha256", list: fmt.Sprintf("%X\n", h256), wantHits: []string{fmt.Sprintf("%x", h256)}},
+		{desc: "sha512", list: fmt.Sprintf("  %x   Bad firmware  \n", h512), wantHits: []string{"Bad firmware"}},
+		{desc: "not matching", list: fmt.Sprintf("%x\n", sha256.Sum256([]byte("other")))},
+		{desc: "bad length", list: "abcd\n", wantErr: true},
+		{desc: "not hex", list: "xyz\n", wantErr: true},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			h, err := ParseHashList("test", strings.NewReader(test.list))
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("unexpected error: %v", err)
+			case err == nil && test.wantErr:
+				t.Fatal("expected error, got none")
+			case err != nil && test.wantErr:
+				return
+			}
+			r, err := h.Scan(context.Background(), image)
+			if err != nil {
+				t.Fatalf("Scan(): %v", err)
+			}
+			want := api.ScanResult{Scanner: "hashes:test", Good: len(test.wantHits) == 0, Hits: test.wantHits}
+			if r.String() != want.String() {
+				t.Errorf("got %s, want %s", r, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package state contains the persistent state of the FT monitor, backed by a SQL database.
+package state
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Outcome is the result of processing a log entry.
+type Outcome string
+
+const (
+	// OK means that the entry was processed without finding a match.
+	OK Outcome = "ok"
+	// Matched means that the entry was processed and the firmware matched the keyword.
+	Matched Outcome = "matched"
+	// Retry means that processing failed in a way which may succeed later, e.g.
+	// the firmware image couldn't be fetched. The entry is in the retry queue.
+	Retry Outcome = "retry"
+	// Failed means that processing failed and the entry won't be retried.
+	Failed Outcome = "failed"
+	// Unauthorized means that the firmware was signed by a publisher which isn't known, or
+	// which the device policy doesn't allow to publish for the device. Such firmware isn't scanned.
+	Unauthorized Outcome = "unauthorized"
+)
+
+// Head is the progress of the monitor through the log.
+type Head struct {
+	// Checkpoint is the last checkpoint which entries were verified against, or nil if there is none.
+	Checkpoint []byte
+	// Next is the index of the next entry to process; all entries before it have been processed.
+	Next uint64
+}
+
+// Record is the outcome of processing a single log entry.
+type Record struct {
+	Index   uint64
+	Outcome Outcome
+	// Detail describes why processing failed, and is empty otherwise.
+	Detail string
+	// Attempts is the number of times the entry has been processed.
+	Attempts int
+	// Entry is the logged statement, kept so that it can be processed again without
+	// fetching it from the log. It is only kept for entries in the retry queue.
+	Entry []byte
+	// RetryAt is when the entry should next be retried, if it is in the retry queue.
+	RetryAt time.Time
+}
+
+// Storage stores the state of the monitor.
+type Storage struct {
+	db *sql.DB
+}
+
+// NewStorage creates a new Storage that uses the given DB as a backend.
+// The DB will be initialized if needed.
+func NewStorage(db *sql.DB) (*Storage, error) {
+	s := &Storage{
+		db: db,
+	}
+	return s, s.init()
+}
+
+// init creates the database tables if needed.
+func (s *Storage) init() error {
+	if _, err := s.db.Exec("CREATE TABLE IF NOT EXISTS head (id INTEGER PRIMARY KEY CHECK (id = 0), next INTEGER, checkpoint BLOB)"); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("CREATE TABLE IF NOT EXISTS entries (idx INTEGER PRIMARY KEY, outcome TEXT, detail TEXT, attempts INTEGER, entry BLOB, retryAt INTEGER)")
+	return err
+}
+
+// Head returns the progress of the monitor, which is empty if nothing has been processed yet.
+func (s *Storage) Head() (Head, error) {
+	var h Head
+	err := s.db.QueryRow("SELECT next, checkpoint FROM head WHERE id=0").Scan(&h.Next, &h.Checkpoint)
+	if err == sql.ErrNoRows {
+		return Head{}, nil
+	}
+	return h, err
+}
+
+// Import sets the head of storage which has no head yet, without recording any entries.
+// It is used to carry over the progress recorded by earlier versions of the monitor.
+func (s *Storage) Import(h Head) error {
+	cur, err := s.Head()
+	if err != nil {
+		return err
+	}
+	if cur.Checkpoint != nil || cur.Next != 0 {
+		return fmt.Errorf("can't import head into storage which already has head at %d", cur.Next)
+	}
+	if _, err := s.db.Exec("INSERT INTO head (id, next, checkpoint) VALUES (0, ?, ?)", h.Next, h.Checkpoint); err != nil {
+		return fmt.Errorf("failed to import head: %w", err)
+	}
+	return nil
+}
+
+// Advance records the outcome of processing the entry at the head, and moves the head
+// past it. The checkpoint that the entry was verified against becomes the head checkpoint.
+// Both are updated together, so the state is consistent even if the monitor is stopped.
+func (s *Storage) Advance(r Record, checkpoint []byte) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := putRecord(tx, r); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("INSERT OR REPLACE INTO head (id, next, checkpoint) VALUES (0, ?, ?)", r.Index+1, checkpoint); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("failed to update head: %w", err)
+	}
+	return tx.Commit()
+}
+
+// Update records a new outcome for an entry which has already been processed, e.g. after a retry.
+func (s *Storage) Update(r Record) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := putRecord(tx, r); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Record returns the outcome of processing the entry at index.
+// Returns sql.ErrNoRows if the entry hasn't been processed.
+func (s *Storage) Record(index uint64) (Record, error) {
+	r := Record{Index: index}
+	var retryAt int64
+	if err := s.db.QueryRow("SELECT outcome, detail, attempts, entry, retryAt FROM entries WHERE idx=?", index).Scan(&r.Outcome, &r.Detail, &r.Attempts, &r.Entry, &retryAt); err != nil {
+		return Record{}, err
+	}
+	if r.Outcome == Retry {
+		r.RetryAt = time.Unix(0, retryAt)
+	}
+	return r, nil
+}
+
+// Due returns the entries in the retry queue which should be retried at or before now,
+// in index order.
+func (s *Storage) Due(now time.Time) ([]Record, error) {
+	rows, err := s.db.Query("SELECT idx, detail, attempts, entry, retryAt FROM entries WHERE outcome=? AND retryAt<=? ORDER BY idx", Retry, now.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rs []Record
+	for rows.Next() {
+		r := Record{Outcome: Retry}
+		var retryAt int64
+		if err := rows.Scan(&r.Index, &r.Detail, &r.Attempts, &r.Entry, &retryAt); err != nil {
+			return nil, err
+		}
+		r.RetryAt = time.Unix(0, retryAt)
+		rs = append(rs, r)
+	}
+	return rs, rows.Err()
+}
+
+func putRecord(tx *sql.Tx, r Record) error {
+	var retryAt int64
+	entry := []byte{}
+	if r.Outcome == Retry {
+		retryAt = r.RetryAt.UnixNano()
+		entry = r.Entry
+	}
+	if _, err := tx.Exec("INSERT OR REPLACE INTO entries (idx, outcome, detail, attempts, entry, retryAt) VALUES (?, ?, ?, ?, ?, ?)", r.Index, r.Outcome, r.Detail, r.Attempts, entry, retryAt); err != nil {
+		return fmt.Errorf("failed to record entry %d: %w", r.Index, err)
+	}
+	return nil
+}
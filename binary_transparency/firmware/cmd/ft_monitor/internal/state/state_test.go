@@ -0,0 +1,172 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // Load drivers for sqlite3
+)
+
+func mustOpenStorage(t *testing.T) *Storage {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal("failed to open temporary in-memory DB", err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("db.Close(): %v", err)
+		}
+	})
+	s, err := NewStorage(db)
+	if err != nil {
+		t.Fatal("failed to create storage", err)
+	}
+	return s
+}
+
+func TestAdvance(t *testing.T) {
+	s := mustOpenStorage(t)
+	h, err := s.Head()
+	if err != nil {
+		t.Fatalf("Head(): %v", err)
+	}
+	if h.Checkpoint != nil || h.Next != 0 {
+		t.Fatalf("got head %+v for new storage, want empty", h)
+	}
+
+	for i, cp := range []string{"checkpoint 3", "checkpoint 3", "checkpoint 5"} {
+		if err := s.Advance(Record{Index: uint64(i), Outcome: OK, Attempts: 1}, []byte(cp)); err != nil {
+			t.Fatalf("Advance(%d): %v", i, err)
+		}
+		h, err := s.Head()
+		if err != nil {
+			t.Fatalf("Head(): %v", err)
+		}
+		if got, want := h.Next, uint64(i+1); got != want {
+			t.Errorf("got next index %d, want %d", got, want)
+		}
+		if !bytes.Equal(h.Checkpoint, []byte(cp)) {
+			t.Errorf("got checkpoint %q, want %q", h.Checkpoint, cp)
+		}
+	}
+
+	r, err := s.Record(1)
+	if err != nil {
+		t.Fatalf("Record(1): %v", err)
+	}
+	if r.Outcome != OK || r.Attempts != 1 {
+		t.Errorf("got record %+v, want outcome %q after 1 attempt", r, OK)
+	}
+	if _, err := s.Record(3); err != sql.ErrNoRows {
+		t.Errorf("Record() for unprocessed entry got err %v, want %v", err, sql.ErrNoRows)
+	}
+}
+
+func TestRetryQueue(t *testing.T) {
+	s := mustOpenStorage(t)
+	now := time.Unix(1000, 0)
+	for _, r := range []Record{
+		{Index: 0, Outcome: OK, Attempts: 1},
+		{Index: 1, Outcome: Retry, Detail: "not found", Attempts: 1, Entry: []byte("entry 1"), RetryAt: now},
+		{Index: 2, Outcome: Retry, Detail: "not found", Attempts: 1, Entry: []byte("entry 2"), RetryAt: now.Add(time.Minute)},
+		{Index: 3, Outcome: Failed, Detail: "bad hash", Attempts: 1, Entry: []byte("entry 3")},
+	} {
+		if err := s.Advance(r, []byte("checkpoint")); err != nil {
+			t.Fatalf("Advance(%d): %v", r.Index, err)
+		}
+	}
+
+	for _, test := range []struct {
+		desc string
+		now  time.Time
+		want []uint64
+	}{
+		{desc: "before any", now: now.Add(-time.Second)},
+		{desc: "first due", now: now, want: []uint64{1}},
+		{desc: "all due", now: now.Add(time.Hour), want: []uint64{1, 2}},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			rs, err := s.Due(test.now)
+			if err != nil {
+				t.Fatalf("Due(): %v", err)
+			}
+			var got []uint64
+			for _, r := range rs {
+				got = append(got, r.Index)
+				if want := []byte(fmt.Sprintf("entry %d", r.Index)); !bytes.Equal(r.Entry, want) {
+					t.Errorf("got entry %q for index %d, want %q", r.Entry, r.Index, want)
+				}
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got due indices %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("got due indices %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+
+	// Retrying successfully takes the entry out of the queue, without moving the head.
+	if err := s.Update(Record{Index: 1, Outcome: Matched, Attempts: 2}); err != nil {
+		t.Fatalf("Update(): %v", err)
+	}
+	rs, err := s.Due(now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Due(): %v", err)
+	}
+	if len(rs) != 1 || rs[0].Index != 2 {
+		t.Errorf("got due records %+v after retry, want only index 2", rs)
+	}
+	r, err := s.Record(1)
+	if err != nil {
+		t.Fatalf("Record(1): %v", err)
+	}
+	if r.Outcome != Matched || r.Attempts != 2 || len(r.Entry) != 0 {
+		t.Errorf("got record %+v after retry, want outcome %q after 2 attempts with no entry", r, Matched)
+	}
+	h, err := s.Head()
+	if err != nil {
+		t.Fatalf("Head(): %v", err)
+	}
+	if h.Next != 4 {
+		t.Errorf("got next index %d after retry, want 4", h.Next)
+	}
+}
+
+func TestImport(t *testing.T) {
+	s := mustOpenStorage(t)
+	want := Head{Checkpoint: []byte("checkpoint 5"), Next: 5}
+	if err := s.Import(want); err != nil {
+		t.Fatalf("Import(): %v", err)
+	}
+	h, err := s.Head()
+	if err != nil {
+		t.Fatalf("Head(): %v", err)
+	}
+	if h.Next != want.Next || !bytes.Equal(h.Checkpoint, want.Checkpoint) {
+		t.Errorf("got head %+v, want %+v", h, want)
+	}
+	if err := s.Import(Head{Checkpoint: []byte("checkpoint 8"), Next: 8}); err == nil {
+		t.Error("Import() succeeded for storage with a head")
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// cas_migrate moves firmware images out of the personality's sqlite DB and
+// into one of the other CAS backends.
+//
+// Usage:
+//
+//	go run ./cmd/ft_personality/cas_migrate/ \
+//	   --logtostderr \
+//	   --cas_db_file=/tmp/ft.db \
+//	   --cas_backend=fs \
+//	   --cas_dir=/tmp/ft_images
+//
+// The personality should then be restarted with the same --cas_backend flags.
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/google/trillian-examples/binary_transparency/firmware/cmd/ft_personality/cas_migrate/impl"
+	"github.com/google/trillian-examples/binary_transparency/firmware/cmd/ft_personality/internal/cas"
+)
+
+var (
+	casDBFile    = flag.String("cas_db_file", "", "Path to the personality's sqlite3 DB holding the images to migrate")
+	casBackend   = flag.String("cas_backend", "", "Where to move firmware images to, one of [fs, s3]")
+	casDir       = flag.String("cas_dir", "", "Directory to store firmware images in when --cas_backend=fs")
+	s3Endpoint   = flag.String("s3_endpoint", "", "Base URL of the S3-compatible object store when --cas_backend=s3. Credentials are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	s3Bucket     = flag.String("s3_bucket", "", "Bucket to store firmware images in when --cas_backend=s3")
+	s3Prefix     = flag.String("s3_prefix", "", "Prefix for the names of firmware image objects when --cas_backend=s3")
+	s3Region     = flag.String("s3_region", "us-east-1", "Region of the S3 bucket when --cas_backend=s3")
+	deleteSource = flag.Bool("delete_source", false, "Delete each image from the sqlite DB once it has been copied and verified")
+)
+
+func main() {
+	flag.Parse()
+
+	if err := impl.Main(impl.MigrateOpts{
+		CASFile:      *casDBFile,
+		CASBackend:   *casBackend,
+		CASDir:       *casDir,
+		DeleteSource: *deleteSource,
+		S3: cas.S3Opts{
+			Endpoint:        *s3Endpoint,
+			Bucket:          *s3Bucket,
+			Prefix:          *s3Prefix,
+			Region:          *s3Region,
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		},
+	}); err != nil {
+		glog.Exit(err.Error())
+	}
+}
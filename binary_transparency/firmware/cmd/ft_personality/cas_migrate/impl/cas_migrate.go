@@ -0,0 +1,128 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package impl is the implementation of a tool for moving firmware images out of
+// the personality's sqlite DB and into another CAS backend.
+package impl
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/golang/glog"
+	"github.com/google/trillian-examples/binary_transparency/firmware/cmd/ft_personality/internal/cas"
+
+	_ "github.com/mattn/go-sqlite3" // Load drivers for sqlite3
+)
+
+// MigrateOpts encapsulates parameters for the migration Main below.
+type MigrateOpts struct {
+	CASFile string
+	// CASBackend is the destination for images; one of "fs" or "s3".
+	CASBackend string
+	CASDir     string
+	S3         cas.S3Opts
+	// DeleteSource removes images from the sqlite DB once they have been migrated.
+	DeleteSource bool
+}
+
+// store is the subset of the personality CAS interface needed for migration.
+type store interface {
+	Store([]byte, io.Reader) error
+	Retrieve([]byte) (io.ReadSeekCloser, error)
+}
+
+// Main copies every image from the sqlite CAS to the destination backend.
+func Main(opts MigrateOpts) error {
+	if len(opts.CASFile) == 0 {
+		return errors.New("CAS file is required")
+	}
+
+	var dst store
+	var err error
+	switch opts.CASBackend {
+	case "fs":
+		dst, err = cas.NewFileStorage(opts.CASDir)
+	case "s3":
+		dst, err = cas.NewS3Storage(opts.S3)
+	default:
+		return fmt.Errorf("cas_backend must be one of: 'fs', 's3'; got %q", opts.CASBackend)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create destination CAS: %w", err)
+	}
+
+	db, err := sql.Open("sqlite3", opts.CASFile)
+	if err != nil {
+		return fmt.Errorf("failed to connect to DB: %w", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			glog.Errorf("db.Close(): %v", err)
+		}
+	}()
+	src, err := cas.NewBinaryStorage(db)
+	if err != nil {
+		return fmt.Errorf("failed to connect CAS to DB: %w", err)
+	}
+
+	n, err := migrate(src, dst, opts.DeleteSource)
+	glog.Infof("Migrated %d images", n)
+	return err
+}
+
+// migrate copies all images from src into dst, checking each one was stored intact.
+// If deleteSource is set then each image is removed from src after it has been copied.
+// Returns the number of images migrated.
+func migrate(src *cas.BinaryStorage, dst store, deleteSource bool) (int, error) {
+	n := 0
+	err := src.ForEach(func(key, image []byte) error {
+		if err := dst.Store(key, bytes.NewReader(image)); err != nil {
+			return fmt.Errorf("failed to store image %x: %w", key, err)
+		}
+		got, err := readAll(dst, key)
+		if err != nil {
+			return fmt.Errorf("failed to read back image %x: %w", key, err)
+		}
+		if !bytes.Equal(got, image) {
+			return fmt.Errorf("image %x was not stored intact", key)
+		}
+		if deleteSource {
+			if err := src.Delete(key); err != nil {
+				return fmt.Errorf("failed to delete image %x from DB: %w", key, err)
+			}
+		}
+		glog.V(1).Infof("Migrated image %x", key)
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// readAll returns the full contents of the image stored under key.
+func readAll(s store, key []byte) ([]byte, error) {
+	r, err := s.Retrieve(key)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			glog.Errorf("Close(): %v", err)
+		}
+	}()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,90 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package impl
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"database/sql"
+	"testing"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/cmd/ft_personality/internal/cas"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMigrate(t *testing.T) {
+	for _, test := range []struct {
+		desc         string
+		deleteSource bool
+	}{
+		{
+			desc: "copy",
+		}, {
+			desc:         "move",
+			deleteSource: true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			db, err := sql.Open("sqlite3", ":memory:")
+			if err != nil {
+				t.Fatal("failed to open temporary in-memory DB", err)
+			}
+			defer func() {
+				if err := db.Close(); err != nil {
+					t.Errorf("db.Close(): %v", err)
+				}
+			}()
+			src, err := cas.NewBinaryStorage(db)
+			if err != nil {
+				t.Fatal("failed to create source CAS", err)
+			}
+			dst, err := cas.NewFileStorage(t.TempDir())
+			if err != nil {
+				t.Fatal("failed to create destination CAS", err)
+			}
+
+			images := [][]byte{[]byte("one"), []byte("two"), []byte("three")}
+			for _, image := range images {
+				h := sha512.Sum512(image)
+				if err := src.Store(h[:], bytes.NewReader(image)); err != nil {
+					t.Fatal("failed to store into CAS", err)
+				}
+			}
+
+			n, err := migrate(src, dst, test.deleteSource)
+			if err != nil {
+				t.Fatalf("migrate(): %v", err)
+			}
+			if got, want := n, len(images); got != want {
+				t.Errorf("migrated %d images, want %d", got, want)
+			}
+			for _, image := range images {
+				h := sha512.Sum512(image)
+				got, err := readAll(dst, h[:])
+				if err != nil {
+					t.Fatalf("failed to retrieve migrated image: %v", err)
+				}
+				if !bytes.Equal(got, image) {
+					t.Errorf("got != want (%x, %x)", got, image)
+				}
+				_, err = src.Retrieve(h[:])
+				if got, want := status.Code(err) == codes.NotFound, test.deleteSource; got != want {
+					t.Errorf("image deleted from source = %t, want %t (%v)", got, want, err)
+				}
+			}
+		})
+	}
+}
@@ -20,10 +20,15 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
 	"github.com/google/trillian-examples/binary_transparency/firmware/cmd/ft_personality/impl"
+	"github.com/google/trillian-examples/binary_transparency/firmware/cmd/ft_personality/internal/cas"
 	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
 	"golang.org/x/mod/sumdb/note"
 )
@@ -34,17 +39,41 @@ var (
 	connectTimeout = flag.Duration("connect_timeout", time.Second, "the timeout for connecting to the backend")
 	trillianAddr   = flag.String("trillian", ":8090", "address:port of Trillian Log gRPC service")
 
-	casDBFile = flag.String("cas_db_file", "", "Path to a file to be used as sqlite3 storage for images, e.g. /tmp/ft.db")
+	casDBFile  = flag.String("cas_db_file", "", "Path to a file to be used as sqlite3 storage for images, e.g. /tmp/ft.db")
+	casBackend = flag.String("cas_backend", "sqlite", "Where to store firmware images, one of [sqlite, fs, s3]")
+	casDir     = flag.String("cas_dir", "", "Directory to store firmware images in when --cas_backend=fs")
+	s3Endpoint = flag.String("s3_endpoint", "", "Base URL of the S3-compatible object store when --cas_backend=s3. Credentials are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	s3Bucket   = flag.String("s3_bucket", "", "Bucket to store firmware images in when --cas_backend=s3")
+	s3Prefix   = flag.String("s3_prefix", "", "Prefix for the names of firmware image objects when --cas_backend=s3")
+	s3Region   = flag.String("s3_region", "us-east-1", "Region of the S3 bucket when --cas_backend=s3")
+
+	maxUploadSize  = flag.Int64("max_upload_size", 1<<30, "Largest firmware upload request accepted, in bytes, or 0 for no limit")
+	allowRollbacks = flag.Bool("allow_rollbacks", false, "Accept firmware revisions older than, or conflicting with, the latest revision logged for the device")
 
 	sthRefresh = flag.Duration("sth_refresh_interval", 5*time.Second, "how often to fetch the latest log root from Trillian")
+	tilesDir   = flag.String("tiles_dir", "", "Directory to publish the log in the tile-based (serverless) layout, served under /ft/v0/tiles/, or empty to disable")
+
+	origin          = flag.String("origin", api.FTLogOrigin, "Origin line to put on checkpoints, identifying this log")
+	signingKeyFiles = flag.String("signing_key_files", "", "Comma separated paths to files containing note signer keys used to co-sign checkpoints, or empty to use the TEST/DEMO key. List the new key alongside the old one while rotating keys")
+
+	claimantsConfig = flag.String("claimants_config", "", "Path to a JSON file listing the keys trusted to sign statements, or empty to trust only the TEST/DEMO keys")
 )
 
 func main() {
 	flag.Parse()
 
-	signer, err := note.NewSigner(crypto.TestFTPersonalityPriv)
+	signers, err := checkpointSigners()
 	if err != nil {
-		panic(err)
+		glog.Exitf("Failed to create checkpoint signers: %v", err)
+	}
+
+	claimants := crypto.TestClaimantRegistry()
+	if len(*claimantsConfig) > 0 {
+		if claimants, err = crypto.LoadClaimantRegistry(*claimantsConfig); err != nil {
+			glog.Exitf("Failed to load claimants: %v", err)
+		}
+	} else {
+		glog.Warning("No --claimants_config provided; trusting TEST/DEMO keys only")
 	}
 
 	ctx := context.Background()
@@ -53,9 +82,50 @@ func main() {
 		ConnectTimeout: *connectTimeout,
 		TrillianAddr:   *trillianAddr,
 		CASFile:        *casDBFile,
+		CASBackend:     *casBackend,
+		CASDir:         *casDir,
+		MaxUploadSize:  *maxUploadSize,
 		STHRefresh:     *sthRefresh,
-		Signer:         signer,
+		TilesDir:       *tilesDir,
+		Origin:         *origin,
+		Signers:        signers,
+		Claimants:      claimants,
+		AllowRollbacks: *allowRollbacks,
+		S3: cas.S3Opts{
+			Endpoint:        *s3Endpoint,
+			Bucket:          *s3Bucket,
+			Prefix:          *s3Prefix,
+			Region:          *s3Region,
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		},
 	}); err != nil {
 		glog.Exitf("Error running personality: %q", err)
 	}
 }
+
+// checkpointSigners returns a signer for each key listed in --signing_key_files,
+// or the TEST/DEMO key if none are provided.
+func checkpointSigners() ([]note.Signer, error) {
+	if len(*signingKeyFiles) == 0 {
+		glog.Warning("No --signing_key_files provided; signing checkpoints with the TEST/DEMO key")
+		s, err := note.NewSigner(crypto.TestFTPersonalityPriv)
+		if err != nil {
+			return nil, err
+		}
+		return []note.Signer{s}, nil
+	}
+	var signers []note.Signer
+	for _, f := range strings.Split(*signingKeyFiles, ",") {
+		k, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read signing key: %w", err)
+		}
+		s, err := note.NewSigner(strings.TrimSpace(string(k)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing key in %q: %w", f, err)
+		}
+		signers = append(signers, s)
+	}
+	return signers, nil
+}
@@ -26,10 +26,14 @@ import (
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
 	"github.com/google/trillian-examples/binary_transparency/firmware/cmd/ft_personality/internal/cas"
 	ih "github.com/google/trillian-examples/binary_transparency/firmware/cmd/ft_personality/internal/http"
+	"github.com/google/trillian-examples/binary_transparency/firmware/cmd/ft_personality/internal/releases"
 	"github.com/google/trillian-examples/binary_transparency/firmware/cmd/ft_personality/internal/trees"
 	"github.com/google/trillian-examples/binary_transparency/firmware/cmd/ft_personality/internal/trillian"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/tiles"
 	"github.com/gorilla/mux"
 	"golang.org/x/mod/sumdb/note"
 
@@ -38,12 +42,32 @@ import (
 
 // PersonalityOpts encapsulates options for running an FT personality.
 type PersonalityOpts struct {
-	ListenAddr     string
-	CASFile        string
+	ListenAddr string
+	// CASFile is the sqlite3 DB used for the tree config and release tracking, and
+	// for firmware images when using the sqlite CAS backend.
+	CASFile string
+	// CASBackend selects where firmware images are stored; one of "sqlite" (the default),
+	// "fs" to store them under CASDir, or "s3" to store them in the bucket described by S3.
+	CASBackend string
+	CASDir     string
+	S3         cas.S3Opts
+	// MaxUploadSize is the largest add-firmware request accepted, in bytes, or zero for no limit.
+	MaxUploadSize  int64
 	TrillianAddr   string
 	ConnectTimeout time.Duration
 	STHRefresh     time.Duration
-	Signer         note.Signer
+	// TilesDir is where the log is published in the tile-based layout, or empty to not publish tiles.
+	TilesDir string
+	// Origin is the first line of checkpoints issued by the log.
+	Origin string
+	// Signers all co-sign each checkpoint. Add a new key here alongside the old one
+	// to rotate keys without breaking clients that only trust the old key.
+	Signers []note.Signer
+	// Claimants are the keys trusted to sign statements submitted to the log.
+	Claimants *crypto.ClaimantRegistry
+	// AllowRollbacks accepts firmware revisions older than, or conflicting with, the
+	// latest revision logged for the device. By default these are refused.
+	AllowRollbacks bool
 }
 
 // Main runs the FT personality server until the context is canceled.
@@ -51,15 +75,32 @@ func Main(ctx context.Context, opts PersonalityOpts) error {
 	if len(opts.CASFile) == 0 {
 		return errors.New("CAS file is required")
 	}
+	if len(opts.Origin) == 0 {
+		return errors.New("log origin is required")
+	}
+	if len(opts.Signers) == 0 {
+		return errors.New("at least one checkpoint signer is required")
+	}
+	if opts.Claimants == nil {
+		return errors.New("claimant registry is required")
+	}
 
 	glog.Infof("Connecting to local DB at %q", opts.CASFile)
 	db, err := sql.Open("sqlite3", opts.CASFile)
 	if err != nil {
 		return fmt.Errorf("failed to connect to DB: %w", err)
 	}
-	cas, err := cas.NewBinaryStorage(db)
+	cas, err := newCAS(opts, db)
 	if err != nil {
-		return fmt.Errorf("failed to connect CAS to DB: %w", err)
+		return fmt.Errorf("failed to create CAS: %w", err)
+	}
+	var rs ih.Releases
+	if opts.AllowRollbacks {
+		glog.Warning("Firmware rollbacks and conflicting images for a revision will be accepted")
+	} else {
+		if rs, err = releases.NewReleaseStorage(db); err != nil {
+			return fmt.Errorf("failed to connect release storage to DB: %w", err)
+		}
 	}
 
 	// TODO(mhutchinson): This is putting the tree config in the CAS DB.
@@ -73,12 +114,34 @@ func Main(ctx context.Context, opts PersonalityOpts) error {
 	}
 	defer tclient.Close()
 
-	// Periodically sync the golden STH in the background.
+	srv := ih.NewServer(tclient, cas, rs, opts.MaxUploadSize, opts.Origin, opts.Signers, opts.Claimants)
+
+	var tp *tiles.Publisher
+	if len(opts.TilesDir) > 0 {
+		glog.Infof("Publishing tiles under %q", opts.TilesDir)
+		st, err := tiles.NewStorage(opts.TilesDir)
+		if err != nil {
+			return fmt.Errorf("failed to create tile storage: %w", err)
+		}
+		if tp, err = tiles.NewPublisher(tclient, st); err != nil {
+			return fmt.Errorf("failed to create tile publisher: %w", err)
+		}
+	}
+
+	// Periodically sync the golden STH in the background, signing a checkpoint for each new root.
 	go func() {
 		for ctx.Err() == nil {
 			if err := tclient.UpdateRoot(ctx); err != nil {
 				glog.Warningf("error updating STH: %v", err)
 			}
+			cpRaw, err := srv.UpdateCheckpoint()
+			if err != nil {
+				glog.Warningf("error signing checkpoint: %v", err)
+			} else if tp != nil {
+				if err := tp.Publish(ctx, cpRaw); err != nil {
+					glog.Warningf("error publishing tiles: %v", err)
+				}
+			}
 
 			select {
 			case <-ctx.Done():
@@ -88,9 +151,12 @@ func Main(ctx context.Context, opts PersonalityOpts) error {
 	}()
 
 	glog.Infof("Starting FT personality server...")
-	srv := ih.NewServer(tclient, cas, opts.Signer)
 	r := mux.NewRouter()
 	srv.RegisterHandlers(r)
+	if tp != nil {
+		prefix := fmt.Sprintf("/%s", api.HTTPTiles)
+		r.PathPrefix(prefix + "/").Handler(http.StripPrefix(prefix, tiles.Handler(opts.TilesDir)))
+	}
 	hServer := &http.Server{
 		Addr:    opts.ListenAddr,
 		Handler: r,
@@ -107,3 +173,20 @@ func Main(ctx context.Context, opts PersonalityOpts) error {
 	}
 	return <-e
 }
+
+// newCAS returns the image storage selected by the options.
+func newCAS(opts PersonalityOpts, db *sql.DB) (ih.CAS, error) {
+	switch opts.CASBackend {
+	case "", "sqlite":
+		glog.Info("Storing images in the local DB")
+		return cas.NewBinaryStorage(db)
+	case "fs":
+		glog.Infof("Storing images under %q", opts.CASDir)
+		return cas.NewFileStorage(opts.CASDir)
+	case "s3":
+		glog.Infof("Storing images in bucket %q at %q", opts.S3.Bucket, opts.S3.Endpoint)
+		return cas.NewS3Storage(opts.S3)
+	default:
+		return nil, fmt.Errorf("unknown CAS backend %q", opts.CASBackend)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cas
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FileStorage is a CAS intended for storing binary images keyed by their hash
+// that uses a directory on the local filesystem as its backing store.
+// Images are sharded into subdirectories by the first bytes of their key so
+// that no single directory grows too large.
+type FileStorage struct {
+	root string
+}
+
+// NewFileStorage creates a new CAS that stores images under the given directory.
+// The directory will be created if needed.
+func NewFileStorage(root string) (*FileStorage, error) {
+	if len(root) == 0 {
+		return nil, errors.New("CAS directory is required")
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create CAS directory: %w", err)
+	}
+	return &FileStorage{root: root}, nil
+}
+
+// path returns the location of the image stored under the given key.
+// e.g. key 0xabcdef... is stored at <root>/ab/cd/abcdef...
+func (fs *FileStorage) path(key []byte) (string, error) {
+	if len(key) < 2 {
+		return "", status.Errorf(codes.InvalidArgument, "key %x is too short", key)
+	}
+	k := hex.EncodeToString(key)
+	return filepath.Join(fs.root, k[0:2], k[2:4], k), nil
+}
+
+// Store reads a binary image from r and stores it under the given key, which must be
+// the SHA512 hash of its data. If there was an existing value under the key then it
+// will not be updated.
+// Images are streamed to a temporary file and renamed into place once their hash has
+// been checked, so that a partially written or mismatched image is never visible.
+func (fs *FileStorage) Store(key []byte, r io.Reader) error {
+	p, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(p); err == nil {
+		// Drain the reader so that a mismatched image is still reported.
+		h := sha512.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return fmt.Errorf("failed to read image: %w", err)
+		}
+		return checkHash(key, h)
+	}
+	dir := filepath.Dir(p)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create shard directory: %w", err)
+	}
+	f, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() {
+		// Remove is a no-op failure once the file has been renamed into place.
+		_ = os.Remove(f.Name())
+	}()
+	h := sha512.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), r); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write image: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close image: %w", err)
+	}
+	if err := checkHash(key, h); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), p); err != nil {
+		return fmt.Errorf("failed to move image into place: %w", err)
+	}
+	return nil
+}
+
+// Retrieve opens a binary image that was previously stored.
+func (fs *FileStorage) Retrieve(key []byte) (io.ReadSeekCloser, error) {
+	p, err := fs.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, status.Errorf(codes.NotFound, "unknown hash: %x", key)
+		}
+		return nil, err
+	}
+	return f, nil
+}
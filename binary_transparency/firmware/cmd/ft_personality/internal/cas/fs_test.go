@@ -0,0 +1,83 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cas
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFileStorageRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	store, err := NewFileStorage(root)
+	if err != nil {
+		t.Fatal("failed to create CAS", err)
+	}
+
+	image := []byte("simple")
+	keyBs := sha512.Sum512(image)
+	key := keyBs[:]
+
+	// Storing twice must not fail.
+	for i := 0; i < 2; i++ {
+		if err := store.Store(key, bytes.NewReader(image)); err != nil {
+			t.Fatal("failed to store into CAS", err)
+		}
+		got := mustRetrieve(t, store, key)
+		if !bytes.Equal(got, image) {
+			t.Errorf("got != want (%x, %x)", got, image)
+		}
+	}
+
+	k := hex.EncodeToString(key)
+	if _, err := os.Stat(filepath.Join(root, k[0:2], k[2:4], k)); err != nil {
+		t.Errorf("image not stored in expected shard: %v", err)
+	}
+
+	if err := store.Store(key, bytes.NewReader([]byte("something else"))); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v storing mismatched image, want code %s", err, codes.InvalidArgument)
+	}
+	other := sha512.Sum512([]byte("other"))
+	if err := store.Store(other[:], bytes.NewReader(image)); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v storing mismatched image, want code %s", err, codes.InvalidArgument)
+	}
+	if _, err := store.Retrieve(other[:]); status.Code(err) != codes.NotFound {
+		t.Errorf("mismatched image was stored: %v", err)
+	}
+}
+
+func TestFileStorageUnknownHash(t *testing.T) {
+	store, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal("failed to create CAS", err)
+	}
+
+	keyBs := sha512.Sum512([]byte("This doesn't exist"))
+
+	r, err := store.Retrieve(keyBs[:])
+	if err == nil {
+		t.Fatalf("want error, but got none, result: %v", r)
+	}
+	if got, want := status.Code(err), codes.NotFound; got != want {
+		t.Fatalf("got error code %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cas
+
+import (
+	"bytes"
+	"hash"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// checkHash returns an InvalidArgument error if the hash of the image data, which
+// has been written to h, does not match the key it is being stored under.
+func checkHash(key []byte, h hash.Hash) error {
+	if got := h.Sum(nil); !bytes.Equal(got, key) {
+		return status.Errorf(codes.InvalidArgument, "image does not match key (%x != %x)", got, key)
+	}
+	return nil
+}
+
+// memImage is an image held in memory.
+type memImage struct {
+	*bytes.Reader
+}
+
+// Close is a no-op.
+func (memImage) Close() error {
+	return nil
+}
@@ -0,0 +1,297 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cas
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// S3Opts describes the S3-compatible bucket used by an S3Storage.
+type S3Opts struct {
+	// Endpoint is the base URL of the object store, e.g. https://s3.eu-west-2.amazonaws.com
+	// or http://localhost:9000 for a local MinIO instance. Buckets are addressed path-style.
+	Endpoint string
+	// Bucket is the name of the bucket to store images in.
+	Bucket string
+	// Prefix is prepended to the object name of every image, e.g. "firmware/".
+	Prefix string
+	// Region is used when signing requests. Defaults to us-east-1.
+	Region string
+	// AccessKeyID and SecretAccessKey are the credentials used to sign requests.
+	// If AccessKeyID is empty then requests are sent unsigned.
+	AccessKeyID     string
+	SecretAccessKey string
+	// Client is used to make requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// S3Storage is a CAS intended for storing binary images keyed by their hash
+// that uses an S3-compatible object store as its backing store.
+type S3Storage struct {
+	opts     S3Opts
+	endpoint *url.URL
+	// now returns the time used to sign requests, and is replaced in tests.
+	now func() time.Time
+}
+
+// NewS3Storage creates a new CAS that stores images in the configured bucket.
+// The bucket must already exist.
+func NewS3Storage(opts S3Opts) (*S3Storage, error) {
+	if len(opts.Endpoint) == 0 {
+		return nil, errors.New("S3 endpoint is required")
+	}
+	if len(opts.Bucket) == 0 {
+		return nil, errors.New("S3 bucket is required")
+	}
+	u, err := url.Parse(opts.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	if len(opts.Region) == 0 {
+		opts.Region = "us-east-1"
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	return &S3Storage{opts: opts, endpoint: u, now: time.Now}, nil
+}
+
+// objectURL returns the URL of the object holding the image with the given key.
+func (s *S3Storage) objectURL(key []byte) *url.URL {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.opts.Bucket + "/" + s.opts.Prefix + hex.EncodeToString(key)
+	return &u
+}
+
+// Store reads a binary image from r and stores it under the given key, which must be
+// the SHA512 hash of its data. As the content under a key never changes, overwriting
+// an existing object is harmless.
+// The image is spooled to a temporary file while its hash is checked, so that a
+// mismatched image is never uploaded and the upload can be signed.
+func (s *S3Storage) Store(key []byte, r io.Reader) error {
+	f, err := os.CreateTemp("", "ft-s3-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+	h, ph := sha512.New(), sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h, ph), r)
+	if err != nil {
+		return fmt.Errorf("failed to read image: %w", err)
+	}
+	if err := checkHash(key, h); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, s.objectURL(key).String(), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := s.do(req, hex.EncodeToString(ph.Sum(nil)))
+	if err != nil {
+		return fmt.Errorf("failed to put object: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to put object: %s: %s", resp.Status, body)
+	}
+	return nil
+}
+
+// Retrieve opens a binary image that was previously stored.
+// The returned image fetches data from the object store as it is read.
+func (s *S3Storage) Retrieve(key []byte) (io.ReadSeekCloser, error) {
+	req, err := http.NewRequest(http.MethodHead, s.objectURL(key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat object: %w", err)
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return &s3Object{s: s, key: key, size: resp.ContentLength}, nil
+	case http.StatusNotFound:
+		return nil, status.Errorf(codes.NotFound, "unknown hash: %x", key)
+	default:
+		return nil, fmt.Errorf("failed to stat object: %s", resp.Status)
+	}
+}
+
+// do signs the request with the given payload hash if credentials are configured, and sends it.
+func (s *S3Storage) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	if len(s.opts.AccessKeyID) > 0 {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+		req.Header.Set("X-Amz-Date", s.now().UTC().Format("20060102T150405Z"))
+		signV4(req, s.opts.AccessKeyID, s.opts.SecretAccessKey, s.opts.Region, "s3")
+	}
+	return s.opts.Client.Do(req)
+}
+
+// emptyPayloadHash is the hex encoded SHA256 hash of an empty request body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3Object reads an image from the object store. Sequential reads are served from
+// a single GET request, which is reissued with a Range header after a Seek.
+type s3Object struct {
+	s    *S3Storage
+	key  []byte
+	size int64
+	off  int64
+	body io.ReadCloser
+}
+
+// Read reads from the current offset, opening a request for the rest of the object if needed.
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.off >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		req, err := http.NewRequest(http.MethodGet, o.s.objectURL(o.key).String(), nil)
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", o.off))
+		resp, err := o.s.do(req, emptyPayloadHash)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get object: %w", err)
+		}
+		if resp.StatusCode != http.StatusPartialContent && !(resp.StatusCode == http.StatusOK && o.off == 0) {
+			resp.Body.Close()
+			return 0, fmt.Errorf("failed to get object: %s", resp.Status)
+		}
+		o.body = resp.Body
+	}
+	n, err := o.body.Read(p)
+	o.off += int64(n)
+	return n, err
+}
+
+// Seek sets the offset for the next Read, discarding any open request.
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.off
+	case io.SeekEnd:
+		offset += o.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	if offset != o.off && o.body != nil {
+		_ = o.body.Close()
+		o.body = nil
+	}
+	o.off = offset
+	return offset, nil
+}
+
+// Close closes any open request.
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
+
+// signV4 adds an AWS Signature Version 4 Authorization header to the request.
+// The X-Amz-Date and X-Amz-Content-Sha256 headers must already be set, and all
+// headers present on the request are signed.
+// See https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html.
+func signV4(req *http.Request, accessKeyID, secretAccessKey, region, service string) {
+	amzDate := req.Header.Get("X-Amz-Date")
+	date := amzDate[:8]
+
+	host := req.Host
+	if len(host) == 0 {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", k, headers[k])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if len(path) == 0 {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	crh := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(crh[:])}, "\n")
+
+	k := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	k = hmacSHA256(k, region)
+	k = hmacSHA256(k, service)
+	k = hmacSHA256(k, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(k, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKeyID, scope, signedHeaders, sig))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
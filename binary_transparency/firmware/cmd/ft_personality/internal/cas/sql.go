@@ -16,7 +16,11 @@
 package cas
 
 import (
+	"bytes"
+	"crypto/sha512"
 	"database/sql"
+	"fmt"
+	"io"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -43,17 +47,34 @@ func (bs *BinaryStorage) init() error {
 	return err
 }
 
-// Store stores a binary image under the given key (which should be a hash of its data).
-// If there was an existing value under the key then it will not be updated.
-// TODO(mhutchinson): Consider validating that the image is exactly the same if the key
-// already exists. This should only happen if the hash function isn't strong.
-func (bs *BinaryStorage) Store(key, image []byte) error {
-	_, err := bs.db.Exec("INSERT OR IGNORE INTO images (key, data) VALUES (?, ?)", key, image)
+// Store reads a binary image from r and stores it under the given key, which must be
+// the SHA512 hash of its data. If there was an existing value under the key then it
+// will not be updated.
+// The image is buffered in memory as it must be written to the DB as a single value.
+func (bs *BinaryStorage) Store(key []byte, r io.Reader) error {
+	h := sha512.New()
+	image, err := io.ReadAll(io.TeeReader(r, h))
+	if err != nil {
+		return fmt.Errorf("failed to read image: %w", err)
+	}
+	if err := checkHash(key, h); err != nil {
+		return err
+	}
+	_, err = bs.db.Exec("INSERT OR IGNORE INTO images (key, data) VALUES (?, ?)", key, image)
 	return err
 }
 
-// Retrieve gets a binary image that was previously stored.
-func (bs *BinaryStorage) Retrieve(key []byte) ([]byte, error) {
+// Retrieve opens a binary image that was previously stored.
+func (bs *BinaryStorage) Retrieve(key []byte) (io.ReadSeekCloser, error) {
+	image, err := bs.retrieve(key)
+	if err != nil {
+		return nil, err
+	}
+	return memImage{bytes.NewReader(image)}, nil
+}
+
+// retrieve gets the data for a binary image that was previously stored.
+func (bs *BinaryStorage) retrieve(key []byte) ([]byte, error) {
 	var res []byte
 	row := bs.db.QueryRow("SELECT data FROM images WHERE key=?", key)
 	if err := row.Err(); err != nil {
@@ -69,3 +90,44 @@ func (bs *BinaryStorage) Retrieve(key []byte) ([]byte, error) {
 	}
 	return res, nil
 }
+
+// ForEach calls f with every image in the store, in key order.
+// Iteration stops at the first error returned by f.
+func (bs *BinaryStorage) ForEach(f func(key, image []byte) error) error {
+	rows, err := bs.db.Query("SELECT key FROM images ORDER BY key")
+	if err != nil {
+		return err
+	}
+	// Collect the keys first so that f is free to modify the table.
+	var keys [][]byte
+	for rows.Next() {
+		var k []byte
+		if err := rows.Scan(&k); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		image, err := bs.retrieve(k)
+		if err != nil {
+			return err
+		}
+		if err := f(k, image); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes the image stored under the given key, if any.
+func (bs *BinaryStorage) Delete(key []byte) error {
+	_, err := bs.db.Exec("DELETE FROM images WHERE key=?", key)
+	return err
+}
@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"crypto/sha512"
 	"database/sql"
+	"io"
 	"testing"
 
 	_ "github.com/mattn/go-sqlite3" // Load drivers for sqlite3
@@ -60,13 +61,10 @@ func TestRoundTrip(t *testing.T) {
 			key, value := keyBs[:], test.image
 
 			for i := 0; i < test.extraRuns+1; i++ {
-				if err := store.Store(key, value); err != nil {
+				if err := store.Store(key, bytes.NewReader(value)); err != nil {
 					t.Error("failed to store into CAS", err)
 				}
-				got, err := store.Retrieve(key)
-				if err != nil {
-					t.Error("failed to retrieve from CAS", err)
-				}
+				got := mustRetrieve(t, store, key)
 				if !bytes.Equal(got, value) {
 					t.Errorf("got != want (%x, %x)", got, value)
 				}
@@ -74,6 +72,33 @@ func TestRoundTrip(t *testing.T) {
 		})
 	}
 }
+
+func TestMismatchedImage(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Error("failed to open temporary in-memory DB", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("db.Close(): %v", err)
+		}
+	}()
+
+	store, err := NewBinaryStorage(db)
+	if err != nil {
+		t.Error("failed to create CAS", err)
+	}
+
+	keyBs := sha512.Sum512([]byte("expected"))
+	err = store.Store(keyBs[:], bytes.NewReader([]byte("something else")))
+	if got, want := status.Code(err), codes.InvalidArgument; got != want {
+		t.Fatalf("got error code %s, want %s", got, want)
+	}
+	if _, err := store.Retrieve(keyBs[:]); status.Code(err) != codes.NotFound {
+		t.Errorf("mismatched image was stored: %v", err)
+	}
+}
+
 func TestUnknownHash(t *testing.T) {
 	db, err := sql.Open("sqlite3", ":memory:")
 	if err != nil {
@@ -100,3 +125,27 @@ func TestUnknownHash(t *testing.T) {
 		t.Fatalf("got error code %s, want %s", got, want)
 	}
 }
+
+// retriever is implemented by all of the CAS backends.
+type retriever interface {
+	Retrieve([]byte) (io.ReadSeekCloser, error)
+}
+
+// mustRetrieve returns the full contents of the image stored under key.
+func mustRetrieve(t *testing.T, store retriever, key []byte) []byte {
+	t.Helper()
+	r, err := store.Retrieve(key)
+	if err != nil {
+		t.Fatal("failed to retrieve from CAS", err)
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			t.Errorf("Close(): %v", err)
+		}
+	}()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal("failed to read image from CAS", err)
+	}
+	return got
+}
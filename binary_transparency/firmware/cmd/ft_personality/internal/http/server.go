@@ -18,9 +18,12 @@ package http
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
@@ -28,6 +31,8 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/google/trillian-examples/binary_transparency/firmware/api"
@@ -61,36 +66,129 @@ type Trillian interface {
 
 // CAS is the interface to the Content Addressable Store for firmware images.
 type CAS interface {
-	// Store puts the image under the key.
-	Store([]byte, []byte) error
+	// Store reads an image and puts it under the key, which must be the SHA512 hash
+	// of the image. Must return status code InvalidArgument if the image doesn't
+	// match the key, in which case it must not be stored.
+	Store([]byte, io.Reader) error
 
-	// Retrieve gets a binary image that was previously stored.
+	// Retrieve opens a binary image that was previously stored.
 	// Must return status code NotFound if no such image exists.
-	Retrieve([]byte) ([]byte, error)
+	Retrieve([]byte) (io.ReadSeekCloser, error)
+}
+
+// Releases tracks the firmware revisions which have been logged for each device.
+type Releases interface {
+	// Check returns the error that Record would return for the release, without recording it.
+	Check(deviceID string, revision uint64, imageHash []byte) error
+
+	// Record notes that the image with the given hash has been logged as the given
+	// revision for the device.
+	// Must return status code FailedPrecondition if a later revision has already been
+	// logged for the device, and AlreadyExists if a different image was logged for
+	// the same revision.
+	Record(deviceID string, revision uint64, imageHash []byte) error
 }
 
 // Server is the core state & handler implementation of the FT personality.
 type Server struct {
-	c      Trillian
-	cas    CAS
-	signer note.Signer
+	c        Trillian
+	cas      CAS
+	releases Releases
+	// releasesMu guards deviceLocks, which serialize logging firmware for each device
+	// when releases is set; see lockDevice.
+	releasesMu  sync.Mutex
+	deviceLocks map[string]*deviceLock
+	// maxUploadSize limits the size of add-firmware requests, if positive.
+	maxUploadSize int64
+	// origin is the first line of each checkpoint, identifying this log.
+	origin string
+	// signers all sign each checkpoint; during key rotation this holds both the
+	// outgoing and incoming keys so that clients trusting either keep working.
+	signers   []note.Signer
+	claimants *crypto.ClaimantRegistry
+
+	// cpMu guards the signed checkpoints below, which are only updated by UpdateCheckpoint.
+	cpMu sync.RWMutex
+	// latestCP is the checkpoint for the most recent root, served by getRoot.
+	latestCP *signedCheckpoint
+	// cpHistory holds recent checkpoints by tree size, with their sizes in cpSizes, oldest first.
+	cpHistory map[uint64]*signedCheckpoint
+	cpSizes   []uint64
+}
+
+// checkpointHistorySize is the number of recent checkpoints that can be fetched by tree size.
+const checkpointHistorySize = 256
+
+// signedCheckpoint is a checkpoint note signed by the log, ready to be served.
+type signedCheckpoint struct {
+	size uint64
+	hash []byte
+	note []byte
+	// etag is a strong ETag for the note.
+	etag string
 }
 
 // NewServer creates a new server that interfaces with the given Trillian logger.
-func NewServer(c Trillian, cas CAS, signer note.Signer) *Server {
+// Only statements signed by a key in the claimant registry will be accepted, and
+// firmware revisions for each device must increase as recorded in releases, unless
+// releases is nil, in which case rollbacks and conflicting images are accepted.
+// Requests to add firmware larger than maxUploadSize bytes are rejected, unless it is zero.
+// Checkpoints are issued for the given origin and co-signed by all of the signers.
+func NewServer(c Trillian, cas CAS, releases Releases, maxUploadSize int64, origin string, signers []note.Signer, claimants *crypto.ClaimantRegistry) *Server {
 	return &Server{
-		c:      c,
-		cas:    cas,
-		signer: signer,
+		c:             c,
+		cas:           cas,
+		releases:      releases,
+		maxUploadSize: maxUploadSize,
+		origin:        origin,
+		signers:       signers,
+		claimants:     claimants,
+		cpHistory:     make(map[uint64]*signedCheckpoint),
+		deviceLocks:   make(map[string]*deviceLock),
+	}
+}
+
+// deviceLock serializes logging firmware for a single device.
+type deviceLock struct {
+	mu sync.Mutex
+	// refs counts the holders and waiters, and is guarded by Server.releasesMu.
+	refs int
+}
+
+// lockDevice locks logging firmware for the device, so that conflicting submissions can't
+// both pass the release check before either is recorded. Submissions for other devices
+// aren't blocked while the firmware is logged. The returned function releases the lock.
+func (s *Server) lockDevice(deviceID string) func() {
+	s.releasesMu.Lock()
+	l, ok := s.deviceLocks[deviceID]
+	if !ok {
+		l = &deviceLock{}
+		s.deviceLocks[deviceID] = l
+	}
+	l.refs++
+	s.releasesMu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		s.releasesMu.Lock()
+		defer s.releasesMu.Unlock()
+		if l.refs--; l.refs == 0 {
+			delete(s.deviceLocks, deviceID)
+		}
 	}
 }
 
 // addFirmware handles requests to log new firmware images.
 // It expects a mime/multipart POST consisting of SignedStatement and then firmware bytes.
+// The firmware is streamed into the CAS, which checks it against the hash in the statement.
 func (s *Server) addFirmware(w http.ResponseWriter, r *http.Request) {
+	if s.maxUploadSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxUploadSize)
+	}
 	statement, image, err := parseAddFirmwareRequest(r)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to parse request: %q", err.Error()), http.StatusBadRequest)
+		http.Error(w, fmt.Sprintf("failed to parse request: %q", err.Error()), uploadErrStatus(err, http.StatusBadRequest))
 		return
 	}
 
@@ -101,7 +199,8 @@ func (s *Server) addFirmware(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Verify the signature:
-	if err := crypto.Publisher.VerifySignature(stmt.Type, stmt.Statement, stmt.Signature); err != nil {
+	publisher, err := s.claimants.VerifyStatement(stmt)
+	if err != nil {
 		http.Error(w, fmt.Sprintf("signature verification failed! %v", err), http.StatusBadRequest)
 		return
 	}
@@ -117,27 +216,54 @@ func (s *Server) addFirmware(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := meta.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("invalid metadata: %v", err), http.StatusBadRequest)
+		return
+	}
+
 	glog.V(1).Infof("Got firmware %+v", meta)
 
-	// Check the firmware bytes matches the manifest
-	h := sha512.Sum512(image)
-	if !bytes.Equal(h[:], meta.FirmwareImageSHA512) {
-		http.Error(w, fmt.Sprintf("uploaded image does not match SHA512 in metadata (%x != %x)", h[:], meta.FirmwareImageSHA512), http.StatusBadRequest)
+	if err := s.claimants.AuthorizeFirmware(meta.DeviceID, publisher); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// Store the firmware, which checks the bytes match the manifest.
+	if err := s.cas.Store(meta.FirmwareImageSHA512, image); err != nil {
+		if status.Code(err) == codes.InvalidArgument {
+			http.Error(w, fmt.Sprintf("uploaded image does not match SHA512 in metadata: %v", err), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, fmt.Sprintf("failed to store image in CAS %v", err), uploadErrStatus(err, http.StatusInternalServerError))
 		return
 	}
 
-	if err := s.cas.Store((h[:]), image); err != nil {
-		http.Error(w, fmt.Sprintf("failed to store image in CAS %v", err), http.StatusInternalServerError)
+	// Refuse rollbacks and conflicting images for a revision. The release is only recorded
+	// once the firmware has been logged, so that a failure to log it doesn't block a retry.
+	if s.releases != nil {
+		defer s.lockDevice(meta.DeviceID)()
+		if err := s.releases.Check(meta.DeviceID, meta.FirmwareRevision, meta.FirmwareImageSHA512); err != nil {
+			http.Error(w, fmt.Sprintf("firmware revision rejected: %v", err), httpStatusForErr(err))
+			return
+		}
 	}
 	if err := s.c.AddSignedStatement(r.Context(), statement); err != nil {
 		http.Error(w, fmt.Sprintf("failed to log firmware to Trillian %v", err), http.StatusInternalServerError)
+		return
+	}
+	if s.releases != nil {
+		if err := s.releases.Record(meta.DeviceID, meta.FirmwareRevision, meta.FirmwareImageSHA512); err != nil {
+			// The firmware is logged, so resubmitting it is safe and will record the release.
+			http.Error(w, fmt.Sprintf("firmware logged, but failed to record release: %v", err), http.StatusInternalServerError)
+			return
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 }
 
-// parseAddFirmwareRequest returns the bytes for the SignedStatement, and the firmware image respectively.
-func parseAddFirmwareRequest(r *http.Request) ([]byte, []byte, error) {
+// parseAddFirmwareRequest returns the bytes for the SignedStatement, and a reader for the firmware image respectively.
+func parseAddFirmwareRequest(r *http.Request) ([]byte, io.Reader, error) {
 	h := r.Header["Content-Type"]
 	if len(h) == 0 {
 		return nil, nil, fmt.Errorf("no content-type header")
@@ -159,23 +285,19 @@ func parseAddFirmwareRequest(r *http.Request) ([]byte, []byte, error) {
 	// Get firmware statement (JSON)
 	p, err := mr.NextPart()
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to find firmware statement in request body: %v", err)
+		return nil, nil, fmt.Errorf("failed to find firmware statement in request body: %w", err)
 	}
 	rawJSON, err := io.ReadAll(p)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read body of firmware statement: %v", err)
+		return nil, nil, fmt.Errorf("failed to read body of firmware statement: %w", err)
 	}
 
-	// Get firmware binary image
+	// Get firmware binary image, which is left to be streamed by the caller
 	p, err = mr.NextPart()
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to find firmware image in request body: %v", err)
+		return nil, nil, fmt.Errorf("failed to find firmware image in request body: %w", err)
 	}
-	image, err := io.ReadAll(p)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read body of firmware image: %v", err)
-	}
-	return rawJSON, image, nil
+	return rawJSON, p, nil
 }
 
 // getConsistency returns consistency proofs between published tree sizes.
@@ -317,12 +439,29 @@ func (s *Server) getManifestEntryAndProof(w http.ResponseWriter, r *http.Request
 	}
 }
 
-// getRoot returns a recent tree root.
-func (s *Server) getRoot(w http.ResponseWriter, r *http.Request) {
+// UpdateCheckpoint signs a checkpoint for the latest root from Trillian, unless it
+// has already been signed, and returns the signed checkpoint. This should be called
+// whenever the root is updated so that requests for checkpoints are served from the
+// cache, rather than hitting the signers.
+func (s *Server) UpdateCheckpoint() ([]byte, error) {
+	cp, err := s.updateCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	return cp.note, nil
+}
+
+func (s *Server) updateCheckpoint() (*signedCheckpoint, error) {
 	sth := s.c.Root()
+	s.cpMu.Lock()
+	defer s.cpMu.Unlock()
+	if cp := s.latestCP; cp != nil && cp.size == sth.TreeSize && bytes.Equal(cp.hash, sth.RootHash) {
+		return cp, nil
+	}
+
 	checkpoint := api.LogCheckpoint{
 		Checkpoint: log.Checkpoint{
-			Origin: api.FTLogOrigin,
+			Origin: s.origin,
 			Size:   sth.TreeSize,
 			Hash:   sth.RootHash,
 		},
@@ -331,18 +470,75 @@ func (s *Server) getRoot(w http.ResponseWriter, r *http.Request) {
 	n := &note.Note{
 		Text: string(checkpoint.Marshal()),
 	}
-	b, err := note.Sign(n, s.signer)
+	b, err := note.Sign(n, s.signers...)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, fmt.Errorf("failed to sign checkpoint: %w", err)
+	}
+	h := sha256.Sum256(b)
+	cp := &signedCheckpoint{
+		size: sth.TreeSize,
+		hash: sth.RootHash,
+		note: b,
+		etag: fmt.Sprintf("%q", hex.EncodeToString(h[:])),
+	}
+	s.latestCP = cp
+	if _, ok := s.cpHistory[cp.size]; !ok {
+		s.cpSizes = append(s.cpSizes, cp.size)
+	}
+	s.cpHistory[cp.size] = cp
+	for len(s.cpSizes) > checkpointHistorySize {
+		delete(s.cpHistory, s.cpSizes[0])
+		s.cpSizes = s.cpSizes[1:]
+	}
+	return cp, nil
+}
+
+// getRoot returns the checkpoint for a recent tree root.
+// The checkpoint is only signed once per root, and clients can revalidate with If-None-Match.
+func (s *Server) getRoot(w http.ResponseWriter, r *http.Request) {
+	s.cpMu.RLock()
+	cp := s.latestCP
+	s.cpMu.RUnlock()
+	if cp == nil {
+		// Nothing has been signed yet, e.g. the first request raced the refresh loop.
+		var err error
+		if cp, err = s.updateCheckpoint(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	// The latest checkpoint changes as the log grows, so caches must revalidate it.
+	serveCheckpoint(w, r, cp, "no-cache")
+}
+
+// getRootAtSize returns a recently signed checkpoint for the requested tree size.
+func (s *Server) getRootAtSize(w http.ResponseWriter, r *http.Request) {
+	size, err := parseIntParam(r, "treesize")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	w.Header().Set("Content-Type", "text/plain")
-	if _, err := w.Write(b); err != nil {
-		glog.Errorf("w.Write(): %v", err)
+	s.cpMu.RLock()
+	cp, ok := s.cpHistory[size]
+	s.cpMu.RUnlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("no recent checkpoint for tree size %d", size), http.StatusNotFound)
+		return
 	}
+	// A checkpoint for a given size never changes once it is signed.
+	serveCheckpoint(w, r, cp, "public, max-age=86400")
 }
 
-// getFirmwareImage returns a firmware image stored in the CAS.
+// serveCheckpoint writes the signed checkpoint note, or Not Modified if the client already has it.
+func serveCheckpoint(w http.ResponseWriter, r *http.Request, cp *signedCheckpoint, cacheControl string) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("ETag", cp.etag)
+	w.Header().Set("Cache-Control", cacheControl)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(cp.note))
+}
+
+// getFirmwareImage streams a firmware image stored in the CAS.
+// HTTP Range requests are supported so that large downloads can be resumed.
 func (s *Server) getFirmwareImage(w http.ResponseWriter, r *http.Request) {
 	hash, err := parseBase64Param(r, "hash")
 	if err != nil {
@@ -355,76 +551,163 @@ func (s *Server) getFirmwareImage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), httpStatusForErr(err))
 		return
 	}
+	defer func() {
+		if err := image.Close(); err != nil {
+			glog.Errorf("image.Close(): %v", err)
+		}
+	}()
 
 	w.Header().Set("Content-Type", "application/binary")
-	w.Header().Set("Content-Length", strconv.Itoa(len(image)))
-	if _, err := w.Write(image); err != nil {
-		glog.Errorf("w.Write(): %v", err)
+	// The content under a hash never changes, so the hash makes a strong ETag.
+	w.Header().Set("ETag", fmt.Sprintf("%q", base64.URLEncoding.EncodeToString(hash)))
+	http.ServeContent(w, r, "", time.Time{}, image)
+}
+
+// annotationHandler returns a handler for requests to annotate a logged firmware with an
+// annotation of the given type, including revocations. The decode function parses the statement, and returns the
+// firmware which it annotates.
+func (s *Server) annotationHandler(t api.StatementType, decode func([]byte) (api.FirmwareID, fmt.Stringer, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rawStmt, ss, code, err := s.readStatement(r, t)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+
+		id, annotation, err := decode(ss.Statement)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if code, err := s.checkFirmwareID(r.Context(), id); err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+
+		glog.V(1).Infof("Got %s", annotation)
+
+		if err := s.c.AddSignedStatement(r.Context(), rawStmt); err != nil {
+			http.Error(w, fmt.Sprintf("failed to log annotation to Trillian %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
 	}
 }
 
-// addAnnotationMalware handles requests to annotate a logged firmware with a malware annotation.
-func (s *Server) addAnnotationMalware(w http.ResponseWriter, r *http.Request) {
-	ss := api.SignedStatement{}
+// decodeMalwareStatement parses a MalwareStatement for annotationHandler.
+func decodeMalwareStatement(bs []byte) (api.FirmwareID, fmt.Stringer, error) {
+	var a api.MalwareStatement
+	if err := json.Unmarshal(bs, &a); err != nil {
+		return api.FirmwareID{}, nil, fmt.Errorf("failed to unmarshal MalwareStatement: %q", err.Error())
+	}
+	return a.FirmwareID, a, nil
+}
+
+// decodeRevocationStatement parses a RevocationStatement for annotationHandler.
+func decodeRevocationStatement(bs []byte) (api.FirmwareID, fmt.Stringer, error) {
+	var a api.RevocationStatement
+	if err := json.Unmarshal(bs, &a); err != nil {
+		return api.FirmwareID{}, nil, fmt.Errorf("failed to unmarshal RevocationStatement: %q", err.Error())
+	}
+	return a.FirmwareID, a, nil
+}
+
+// decodeBuildStatement parses a BuildStatement for annotationHandler.
+func decodeBuildStatement(bs []byte) (api.FirmwareID, fmt.Stringer, error) {
+	var a api.BuildStatement
+	if err := json.Unmarshal(bs, &a); err != nil {
+		return api.FirmwareID{}, nil, fmt.Errorf("failed to unmarshal BuildStatement: %q", err.Error())
+	}
+	if len(a.RebuiltImageSHA512) != sha512.Size {
+		return api.FirmwareID{}, nil, fmt.Errorf("rebuilt image hash has length %d, want %d", len(a.RebuiltImageSHA512), sha512.Size)
+	}
+	return a.FirmwareID, a, nil
+}
+
+// decodeSBOMStatement parses an SBOMStatement for annotationHandler.
+func decodeSBOMStatement(bs []byte) (api.FirmwareID, fmt.Stringer, error) {
+	var a api.SBOMStatement
+	if err := json.Unmarshal(bs, &a); err != nil {
+		return api.FirmwareID{}, nil, fmt.Errorf("failed to unmarshal SBOMStatement: %q", err.Error())
+	}
+	if len(a.SBOMSHA512) != sha512.Size {
+		return api.FirmwareID{}, nil, fmt.Errorf("SBOM hash has length %d, want %d", len(a.SBOMSHA512), sha512.Size)
+	}
+	if len(a.Format) == 0 {
+		return api.FirmwareID{}, nil, errors.New("SBOM format is required")
+	}
+	return a.FirmwareID, a, nil
+}
+
+// decodeVulnerabilityStatement parses a VulnerabilityStatement for annotationHandler.
+func decodeVulnerabilityStatement(bs []byte) (api.FirmwareID, fmt.Stringer, error) {
+	var a api.VulnerabilityStatement
+	if err := json.Unmarshal(bs, &a); err != nil {
+		return api.FirmwareID{}, nil, fmt.Errorf("failed to unmarshal VulnerabilityStatement: %q", err.Error())
+	}
+	if len(a.ID) == 0 {
+		return api.FirmwareID{}, nil, errors.New("vulnerability ID is required")
+	}
+	return a.FirmwareID, a, nil
+}
 
-	// Store the original bytes as statement to avoid a round-trip (de)serialization.
+// readStatement reads a SignedStatement of the wanted type from the request body and
+// verifies its signature. The original bytes are returned alongside the parsed statement
+// to avoid a round-trip (de)serialization when logging it. On error, the HTTP status
+// code to return is provided.
+func (s *Server) readStatement(r *http.Request, want api.StatementType) ([]byte, api.SignedStatement, int, error) {
+	ss := api.SignedStatement{}
 	rawStmt, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		return nil, ss, http.StatusBadRequest, err
 	}
 	if err := json.NewDecoder(bytes.NewReader(rawStmt)).Decode(&ss); err != nil {
-		http.Error(w, fmt.Sprintf("failed to decode statement: %q", err.Error()), http.StatusBadRequest)
-		return
+		return nil, ss, http.StatusBadRequest, fmt.Errorf("failed to decode statement: %q", err.Error())
 	}
 
-	if err := crypto.AnnotatorMalware.VerifySignature(ss.Type, ss.Statement, ss.Signature); err != nil {
-		http.Error(w, fmt.Sprintf("signature verification failed! %v", err), http.StatusBadRequest)
-		return
-	}
-	if ss.Type != api.MalwareStatementType {
-		http.Error(w, fmt.Sprintf("expected statement type %q, but got %q", api.MalwareStatementType, ss.Type), http.StatusBadRequest)
-		return
+	if _, err := s.claimants.VerifyStatement(ss); err != nil {
+		return nil, ss, http.StatusBadRequest, fmt.Errorf("signature verification failed! %v", err)
 	}
-
-	var malwareStmt api.MalwareStatement
-	if err := json.Unmarshal(ss.Statement, &malwareStmt); err != nil {
-		http.Error(w, fmt.Sprintf("failed to unmarshal MalwareStatement: %q", err.Error()), http.StatusBadRequest)
-		return
+	if ss.Type != want {
+		return nil, ss, http.StatusBadRequest, fmt.Errorf("expected statement type %q, but got %q", want, ss.Type)
 	}
+	return rawStmt, ss, http.StatusOK, nil
+}
 
-	// Now check that the annotation points at something which is actually in this log.
-	fwbs, _, err := s.c.FirmwareManifestAtIndex(r.Context(), malwareStmt.FirmwareID.LogIndex, malwareStmt.FirmwareID.LogIndex+1)
+// checkFirmwareID checks that the FirmwareID points at firmware which is actually in this log.
+// On error, the HTTP status code to return is provided.
+func (s *Server) checkFirmwareID(ctx context.Context, id api.FirmwareID) (int, error) {
+	fwbs, _, err := s.c.FirmwareManifestAtIndex(ctx, id.LogIndex, id.LogIndex+1)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to validate firmware: %q", err.Error()), http.StatusInternalServerError)
-		return
+		return http.StatusInternalServerError, fmt.Errorf("failed to validate firmware: %q", err.Error())
 	}
 	var fwss api.SignedStatement
 	if err := json.Unmarshal(fwbs, &fwss); err != nil {
-		http.Error(w, fmt.Sprintf("failed to unmarshal statement: %q", err.Error()), http.StatusInternalServerError)
-		return
+		return http.StatusInternalServerError, fmt.Errorf("failed to unmarshal statement: %q", err.Error())
 	}
 	if fwss.Type != api.FirmwareMetadataType {
-		http.Error(w, fmt.Sprintf("statement at index %d is not firmware", malwareStmt.FirmwareID.LogIndex), http.StatusBadRequest)
-		return
+		return http.StatusBadRequest, fmt.Errorf("statement at index %d is not firmware", id.LogIndex)
 	}
 	var meta api.FirmwareMetadata
 	if err := json.Unmarshal(fwss.Statement, &meta); err != nil {
-		http.Error(w, fmt.Sprintf("failed to unmarshal firmware: %q", err.Error()), http.StatusInternalServerError)
-		return
+		return http.StatusInternalServerError, fmt.Errorf("failed to unmarshal firmware: %q", err.Error())
 	}
-	if !bytes.Equal(meta.FirmwareImageSHA512, malwareStmt.FirmwareID.FirmwareImageSHA512) {
-		http.Error(w, fmt.Sprintf("wrong firmware hash at index %d; got %x but expected %x", malwareStmt.FirmwareID.LogIndex, malwareStmt.FirmwareID.FirmwareImageSHA512, meta.FirmwareImageSHA512), http.StatusInternalServerError)
-		return
+	if !bytes.Equal(meta.FirmwareImageSHA512, id.FirmwareImageSHA512) {
+		return http.StatusBadRequest, fmt.Errorf("wrong firmware hash at index %d; got %x but expected %x", id.LogIndex, id.FirmwareImageSHA512, meta.FirmwareImageSHA512)
 	}
+	return http.StatusOK, nil
+}
 
-	glog.V(1).Infof("Got MalwareStatement %+v", malwareStmt)
-
-	if err := s.c.AddSignedStatement(r.Context(), rawStmt); err != nil {
-		http.Error(w, fmt.Sprintf("failed to log firmware to Trillian %v", err), http.StatusInternalServerError)
+// uploadErrStatus returns the HTTP status for an error reading an uploaded request body;
+// StatusRequestEntityTooLarge if the upload size limit was hit, otherwise the fallback.
+func uploadErrStatus(err error, fallback int) int {
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		return http.StatusRequestEntityTooLarge
 	}
-
-	w.Header().Set("Content-Type", "application/json")
+	return fallback
 }
 
 // httpStatusForErr maps status codes to HTTP errors.
@@ -434,6 +717,10 @@ func httpStatusForErr(e error) int {
 		return http.StatusOK
 	case codes.NotFound:
 		return http.StatusNotFound
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.AlreadyExists, codes.FailedPrecondition:
+		return http.StatusConflict
 	default:
 		return http.StatusInternalServerError
 	}
@@ -443,12 +730,17 @@ func httpStatusForErr(e error) int {
 // RegisterHandlers registers HTTP handlers for firmware transparency endpoints.
 func (s *Server) RegisterHandlers(r *mux.Router) {
 	r.HandleFunc(fmt.Sprintf("/%s", api.HTTPAddFirmware), s.addFirmware).Methods("POST")
-	r.HandleFunc(fmt.Sprintf("/%s", api.HTTPAddAnnotationMalware), s.addAnnotationMalware).Methods("POST")
+	r.HandleFunc(fmt.Sprintf("/%s", api.HTTPAddAnnotationMalware), s.annotationHandler(api.MalwareStatementType, decodeMalwareStatement)).Methods("POST")
+	r.HandleFunc(fmt.Sprintf("/%s", api.HTTPAddRevocation), s.annotationHandler(api.RevocationStatementType, decodeRevocationStatement)).Methods("POST")
+	r.HandleFunc(fmt.Sprintf("/%s", api.HTTPAddAnnotationBuild), s.annotationHandler(api.BuildStatementType, decodeBuildStatement)).Methods("POST")
+	r.HandleFunc(fmt.Sprintf("/%s", api.HTTPAddAnnotationSBOM), s.annotationHandler(api.SBOMStatementType, decodeSBOMStatement)).Methods("POST")
+	r.HandleFunc(fmt.Sprintf("/%s", api.HTTPAddAnnotationVulnerability), s.annotationHandler(api.VulnerabilityStatementType, decodeVulnerabilityStatement)).Methods("POST")
 	r.HandleFunc(fmt.Sprintf("/%s/from/{from:[0-9]+}/to/{to:[0-9]+}", api.HTTPGetConsistency), s.getConsistency).Methods("GET")
 	r.HandleFunc(fmt.Sprintf("/%s/for-leaf-hash/{hash}/in-tree-of/{treesize:[0-9]+}", api.HTTPGetInclusion), s.getInclusionByHash).Methods("GET")
 	r.HandleFunc(fmt.Sprintf("/%s/at/{index:[0-9]+}/in-tree-of/{treesize:[0-9]+}", api.HTTPGetManifestEntryAndProof), s.getManifestEntryAndProof).Methods("GET")
 	r.HandleFunc(fmt.Sprintf("/%s/with-hash/{hash}", api.HTTPGetFirmwareImage), s.getFirmwareImage).Methods("GET")
 	r.HandleFunc(fmt.Sprintf("/%s", api.HTTPGetRoot), s.getRoot).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/%s/at-size/{treesize:[0-9]+}", api.HTTPGetRoot), s.getRootAtSize).Methods("GET")
 }
 
 func parseBase64Param(r *http.Request, name string) ([]byte, error) {
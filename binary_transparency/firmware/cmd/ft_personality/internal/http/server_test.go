@@ -15,6 +15,9 @@
 package http
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -24,6 +27,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	gomock "github.com/golang/mock/gomock"
 	"github.com/google/go-cmp/cmp"
@@ -39,26 +43,48 @@ import (
 func TestRoot(t *testing.T) {
 	testSigner, _ := note.NewSigner(crypto.TestFTPersonalityPriv)
 	testVerifier, _ := note.NewVerifier(crypto.TestFTPersonalityPub)
+	newSKey, newVKey, err := note.GenerateKey(rand.Reader, "rotated")
+	if err != nil {
+		t.Fatalf("GenerateKey(): %v", err)
+	}
+	newSigner, _ := note.NewSigner(newSKey)
+	newVerifier, _ := note.NewVerifier(newVKey)
 
 	for _, test := range []struct {
 		desc     string
+		origin   string
+		signers  []note.Signer
 		root     types.LogRootV1
 		wantBody string
+		wantSigs int
 	}{
 		{
 			desc:     "valid 1",
+			origin:   api.FTLogOrigin,
+			signers:  []note.Signer{testSigner},
 			root:     types.LogRootV1{TreeSize: 1, TimestampNanos: 123, RootHash: []byte{0x12, 0x34}},
 			wantBody: "Firmware Transparency Log\n1\nEjQ=\n123\n",
+			wantSigs: 1,
 		}, {
 			desc:     "valid 2",
+			origin:   api.FTLogOrigin,
+			signers:  []note.Signer{testSigner},
 			root:     types.LogRootV1{TreeSize: 10, TimestampNanos: 1230, RootHash: []byte{0x34, 0x12}},
 			wantBody: "Firmware Transparency Log\n10\nNBI=\n1230\n",
+			wantSigs: 1,
+		}, {
+			desc:     "co-signed with custom origin",
+			origin:   "Example Firmware Log",
+			signers:  []note.Signer{testSigner, newSigner},
+			root:     types.LogRootV1{TreeSize: 10, TimestampNanos: 1230, RootHash: []byte{0x34, 0x12}},
+			wantBody: "Example Firmware Log\n10\nNBI=\n1230\n",
+			wantSigs: 2,
 		},
 	} {
 		t.Run(test.desc, func(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			mt := NewMockTrillian(ctrl)
-			server := NewServer(mt, FakeCAS{}, testSigner)
+			server := NewServer(mt, FakeCAS{}, &FakeReleases{}, 0, test.origin, test.signers, crypto.TestClaimantRegistry())
 
 			mt.EXPECT().Root().Return(&test.root)
 
@@ -77,17 +103,101 @@ func TestRoot(t *testing.T) {
 			if err != nil {
 				t.Errorf("failed to read body: %v", err)
 			}
-			got, err := note.Open(body, note.VerifierList(testVerifier))
+			got, err := note.Open(body, note.VerifierList(testVerifier, newVerifier))
 			if err != nil {
 				t.Fatalf("Failed to open returned checkpoint: %q", err)
 			}
 			if got := got.Text; got != test.wantBody {
 				t.Errorf("got '%s' want '%s'", got, test.wantBody)
 			}
+			if got, want := len(got.Sigs), test.wantSigs; got != want {
+				t.Errorf("got %d verified signatures, want %d", got, want)
+			}
 		})
 	}
 }
 
+func TestRootCaching(t *testing.T) {
+	testSigner, _ := note.NewSigner(crypto.TestFTPersonalityPriv)
+	root1 := types.LogRootV1{TreeSize: 1, TimestampNanos: 123, RootHash: []byte{0x12, 0x34}}
+	root2 := types.LogRootV1{TreeSize: 10, TimestampNanos: 1230, RootHash: []byte{0x34, 0x12}}
+
+	ctrl := gomock.NewController(t)
+	mt := NewMockTrillian(ctrl)
+	server := NewServer(mt, FakeCAS{}, &FakeReleases{}, 0, api.FTLogOrigin, []note.Signer{testSigner}, crypto.TestClaimantRegistry())
+	r := mux.NewRouter()
+	server.RegisterHandlers(r)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	get := func(path, etag string) (*http.Response, []byte) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/%s", ts.URL, path), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(etag) > 0 {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("error response: %v", err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %v", err)
+		}
+		return resp, body
+	}
+
+	// Root is only consulted when updating the checkpoint, not on each request.
+	mt.EXPECT().Root().Return(&root1).Times(2)
+	if _, err := server.UpdateCheckpoint(); err != nil {
+		t.Fatalf("UpdateCheckpoint(): %v", err)
+	}
+	if _, err := server.UpdateCheckpoint(); err != nil {
+		t.Fatalf("UpdateCheckpoint(): %v", err)
+	}
+	resp1, body1 := get(api.HTTPGetRoot, "")
+	resp2, body2 := get(api.HTTPGetRoot, "")
+	if resp1.StatusCode != http.StatusOK || resp2.StatusCode != http.StatusOK {
+		t.Fatalf("status codes not OK: %d, %d", resp1.StatusCode, resp2.StatusCode)
+	}
+	if !bytes.Equal(body1, body2) {
+		t.Errorf("got different checkpoints for the same root:\n%s\n%s", body1, body2)
+	}
+	etag := resp1.Header.Get("ETag")
+	if len(etag) == 0 || etag != resp2.Header.Get("ETag") {
+		t.Errorf("got ETags %q and %q, want equal non-empty tags", etag, resp2.Header.Get("ETag"))
+	}
+	if got, want := resp1.Header.Get("Cache-Control"), "no-cache"; got != want {
+		t.Errorf("got Cache-Control %q, want %q", got, want)
+	}
+	if resp, _ := get(api.HTTPGetRoot, etag); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("got status %d with matching If-None-Match, want %d", resp.StatusCode, http.StatusNotModified)
+	}
+
+	// A new root is signed, and the old checkpoint remains available by size.
+	mt.EXPECT().Root().Return(&root2)
+	if _, err := server.UpdateCheckpoint(); err != nil {
+		t.Fatalf("UpdateCheckpoint(): %v", err)
+	}
+	resp3, body3 := get(api.HTTPGetRoot, etag)
+	if resp3.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d for new root, want %d", resp3.StatusCode, http.StatusOK)
+	}
+	if !strings.HasPrefix(string(body3), "Firmware Transparency Log\n10\n") {
+		t.Errorf("got checkpoint %q, want one for size 10", body3)
+	}
+	if resp, body := get(api.HTTPGetRoot+"/at-size/1", ""); resp.StatusCode != http.StatusOK || !bytes.Equal(body, body1) {
+		t.Errorf("got status %d and checkpoint %q for size 1, want %q", resp.StatusCode, body, body1)
+	}
+	if resp, _ := get(api.HTTPGetRoot+"/at-size/5", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d for unknown size, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
 func b64Decode(t *testing.T, b64 string) []byte {
 	t.Helper()
 	st, err := base64.StdEncoding.DecodeString(b64)
@@ -110,12 +220,69 @@ func TestAddFirmware(t *testing.T) {
 	}
 
 	s := string(js)
+
+	// The malware annotator is not trusted to make firmware statements.
+	untrustedSig, err := crypto.AnnotatorMalware.SignMessage(api.FirmwareMetadataType, st)
+	if err != nil {
+		t.Fatalf("signing failed, bailing out!: %v", err)
+	}
+	untrustedJS, err := json.Marshal(api.SignedStatement{Type: api.FirmwareMetadataType, Statement: st, Signature: untrustedSig})
+	if err != nil {
+		t.Fatalf("marshaling failed, bailing out!: %v", err)
+	}
+
+	// Metadata with a malformed build timestamp is rejected.
+	var badMeta api.FirmwareMetadata
+	if err := json.Unmarshal(st, &badMeta); err != nil {
+		t.Fatalf("unmarshaling failed, bailing out!: %v", err)
+	}
+	badMeta.BuildTimestamp = "yesterday"
+	badSt, err := json.Marshal(badMeta)
+	if err != nil {
+		t.Fatalf("marshaling failed, bailing out!: %v", err)
+	}
+	badStmt, err := crypto.Publisher.SignStatement(api.FirmwareMetadataType, badSt)
+	if err != nil {
+		t.Fatalf("signing failed, bailing out!: %v", err)
+	}
+	badJS, err := json.Marshal(badStmt)
+	if err != nil {
+		t.Fatalf("marshaling failed, bailing out!: %v", err)
+	}
+
+	// Only allow the test vendor to publish for some other device.
+	otherDeviceOnly, err := crypto.NewClaimantRegistry(crypto.RegistryConfig{
+		Claimants: []crypto.ClaimantConfig{{ID: crypto.Publisher.ID, Type: "f", PublicKey: crypto.TestVendorRSAPub}},
+		Devices:   []crypto.DevicePolicyConfig{{DeviceIDPrefix: "Toaster", Publishers: []string{crypto.Publisher.ID}}},
+	}, "")
+	if err != nil {
+		t.Fatalf("NewClaimantRegistry(): %v", err)
+	}
+	validBody := strings.Join([]string{"--mimeisfunlolol",
+		"Content-Type: application/json",
+		"",
+		s,
+		"--mimeisfunlolol",
+		"Content-Type: application/octet-stream",
+		"",
+		"hi",
+		"",
+		"--mimeisfunlolol--",
+		"",
+	}, "\n")
+
 	for _, test := range []struct {
 		desc             string
 		body             string
+		claimants        *crypto.ClaimantRegistry
+		releasesErr      error
+		recordErr        error
+		allowRollbacks   bool
+		maxUploadSize    int64
 		trillianErr      error
 		wantTrillianCall bool
 		wantManifest     string
+		wantRecorded     bool
 		wantStatus       int
 	}{
 		{
@@ -138,6 +305,7 @@ func TestAddFirmware(t *testing.T) {
 			}, "\n"),
 			wantTrillianCall: true,
 			wantManifest:     s,
+			wantRecorded:     true,
 			wantStatus:       http.StatusOK,
 		}, {
 			desc: "firmware image does not match manifest",
@@ -155,6 +323,80 @@ func TestAddFirmware(t *testing.T) {
 			}, "\n"),
 			wantManifest: s,
 			wantStatus:   http.StatusBadRequest,
+		}, {
+			desc: "statement signed by untrusted key",
+			body: strings.Join([]string{"--mimeisfunlolol",
+				"Content-Type: application/json",
+				"",
+				string(untrustedJS),
+				"--mimeisfunlolol",
+				"Content-Type: application/octet-stream",
+				"",
+				"hi",
+				"",
+				"--mimeisfunlolol--",
+				"",
+			}, "\n"),
+			wantStatus: http.StatusBadRequest,
+		}, {
+			desc: "invalid metadata",
+			body: strings.Join([]string{"--mimeisfunlolol",
+				"Content-Type: application/json",
+				"",
+				string(badJS),
+				"--mimeisfunlolol",
+				"Content-Type: application/octet-stream",
+				"",
+				"hi",
+				"",
+				"--mimeisfunlolol--",
+				"",
+			}, "\n"),
+			wantStatus: http.StatusBadRequest,
+		}, {
+			desc:       "publisher not authorized for device",
+			body:       validBody,
+			claimants:  otherDeviceOnly,
+			wantStatus: http.StatusForbidden,
+		}, {
+			desc:          "request too large",
+			body:          validBody,
+			maxUploadSize: 100,
+			wantStatus:    http.StatusRequestEntityTooLarge,
+		}, {
+			desc:        "revision older than logged",
+			body:        validBody,
+			releasesErr: status.Error(codes.FailedPrecondition, "rollback"),
+			wantStatus:  http.StatusConflict,
+		}, {
+			desc:        "different image logged for revision",
+			body:        validBody,
+			releasesErr: status.Error(codes.AlreadyExists, "conflict"),
+			wantStatus:  http.StatusConflict,
+		}, {
+			desc:             "revision older than logged with rollbacks allowed",
+			body:             validBody,
+			releasesErr:      status.Error(codes.FailedPrecondition, "rollback"),
+			allowRollbacks:   true,
+			wantTrillianCall: true,
+			wantManifest:     s,
+			wantStatus:       http.StatusOK,
+		}, {
+			desc:             "different image logged for revision with rollbacks allowed",
+			body:             validBody,
+			releasesErr:      status.Error(codes.AlreadyExists, "conflict"),
+			allowRollbacks:   true,
+			wantTrillianCall: true,
+			wantManifest:     s,
+			wantStatus:       http.StatusOK,
+		}, {
+			desc:             "logged but failed to record release",
+			body:             validBody,
+			recordErr:        errors.New("disk full"),
+			wantTrillianCall: true,
+			wantManifest:     s,
+			wantRecorded:     true,
+			wantStatus:       http.StatusInternalServerError,
 		}, {
 			desc: "valid request but trillian failure",
 			body: strings.Join([]string{"--mimeisfunlolol",
@@ -178,7 +420,16 @@ func TestAddFirmware(t *testing.T) {
 		t.Run(test.desc, func(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			mt := NewMockTrillian(ctrl)
-			server := NewServer(mt, FakeCAS{}, testSigner)
+			claimants := test.claimants
+			if claimants == nil {
+				claimants = crypto.TestClaimantRegistry()
+			}
+			releases := &FakeReleases{err: test.releasesErr, recordErr: test.recordErr}
+			var rs Releases = releases
+			if test.allowRollbacks {
+				rs = nil
+			}
+			server := NewServer(mt, FakeCAS{}, rs, test.maxUploadSize, api.FTLogOrigin, []note.Signer{testSigner}, claimants)
 
 			if test.wantTrillianCall {
 				mt.EXPECT().AddSignedStatement(gomock.Any(), gomock.Eq([]byte(test.wantManifest))).
@@ -200,6 +451,309 @@ func TestAddFirmware(t *testing.T) {
 				body, _ := io.ReadAll(resp.Body)
 				t.Errorf("status code got != want (%d, %d): %q", got, want, body)
 			}
+			// Releases are only recorded once the firmware is logged.
+			if got, want := releases.recorded, test.wantRecorded; got != want {
+				t.Errorf("release recorded got != want (%t, %t)", got, want)
+			}
+		})
+	}
+}
+
+func TestLockDevice(t *testing.T) {
+	server := NewServer(nil, FakeCAS{}, &FakeReleases{}, 0, api.FTLogOrigin, nil, crypto.TestClaimantRegistry())
+	unlockA := server.lockDevice("a")
+
+	// Another device isn't blocked while the first is locked.
+	done := make(chan struct{})
+	go func() {
+		server.lockDevice("b")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("locking another device blocked")
+	}
+
+	// The same device is blocked until the first lock is released.
+	done = make(chan struct{})
+	go func() {
+		server.lockDevice("a")()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("locked the same device twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlockA()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("device still locked after release")
+	}
+
+	server.releasesMu.Lock()
+	defer server.releasesMu.Unlock()
+	if got := len(server.deviceLocks); got != 0 {
+		t.Errorf("got %d device locks after release, want 0", got)
+	}
+}
+
+func TestAddRevocation(t *testing.T) {
+	testSigner, _ := note.NewSigner(crypto.TestFTPersonalityPriv)
+	mbs, err := json.Marshal(api.FirmwareMetadata{DeviceID: "TalkieToaster", FirmwareRevision: 1, FirmwareImageSHA512: []byte("fwhash")})
+	if err != nil {
+		t.Fatalf("marshaling failed, bailing out!: %v", err)
+	}
+	fwStmt, err := crypto.Publisher.SignStatement(api.FirmwareMetadataType, mbs)
+	if err != nil {
+		t.Fatalf("signing failed, bailing out!: %v", err)
+	}
+	fwbs, err := json.Marshal(fwStmt)
+	if err != nil {
+		t.Fatalf("marshaling failed, bailing out!: %v", err)
+	}
+
+	mustRevocation := func(c *crypto.Claimant, hash []byte) string {
+		t.Helper()
+		rbs, err := json.Marshal(api.RevocationStatement{FirmwareID: api.FirmwareID{LogIndex: 5, FirmwareImageSHA512: hash}})
+		if err != nil {
+			t.Fatalf("marshaling failed, bailing out!: %v", err)
+		}
+		ss, err := c.SignStatement(api.RevocationStatementType, rbs)
+		if err != nil {
+			t.Fatalf("signing failed, bailing out!: %v", err)
+		}
+		js, err := json.Marshal(ss)
+		if err != nil {
+			t.Fatalf("marshaling failed, bailing out!: %v", err)
+		}
+		return string(js)
+	}
+
+	for _, test := range []struct {
+		desc             string
+		body             string
+		manifest         []byte
+		wantTrillianCall bool
+		wantStatus       int
+	}{
+		{
+			desc:             "valid request",
+			body:             mustRevocation(&crypto.Revoker, []byte("fwhash")),
+			manifest:         fwbs,
+			wantTrillianCall: true,
+			wantStatus:       http.StatusOK,
+		}, {
+			desc:       "malformed request",
+			body:       "garbage",
+			wantStatus: http.StatusBadRequest,
+		}, {
+			desc:       "not signed by revoker",
+			body:       mustRevocation(&crypto.AnnotatorMalware, []byte("fwhash")),
+			wantStatus: http.StatusBadRequest,
+		}, {
+			desc:       "wrong firmware hash",
+			body:       mustRevocation(&crypto.Revoker, []byte("otherhash")),
+			manifest:   fwbs,
+			wantStatus: http.StatusBadRequest,
+		}, {
+			desc:       "target is not firmware",
+			body:       mustRevocation(&crypto.Revoker, []byte("fwhash")),
+			manifest:   []byte(mustRevocation(&crypto.Revoker, []byte("fwhash"))),
+			wantStatus: http.StatusBadRequest,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mt := NewMockTrillian(ctrl)
+			server := NewServer(mt, FakeCAS{}, &FakeReleases{}, 0, api.FTLogOrigin, []note.Signer{testSigner}, crypto.TestClaimantRegistry())
+
+			if test.manifest != nil {
+				mt.EXPECT().FirmwareManifestAtIndex(gomock.Any(), gomock.Eq(uint64(5)), gomock.Eq(uint64(6))).
+					Return(test.manifest, nil, nil)
+			}
+			if test.wantTrillianCall {
+				mt.EXPECT().AddSignedStatement(gomock.Any(), gomock.Eq([]byte(test.body))).
+					Return(nil)
+			}
+
+			r := mux.NewRouter()
+			server.RegisterHandlers(r)
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+
+			client := ts.Client()
+			url := fmt.Sprintf("%s/%s", ts.URL, api.HTTPAddRevocation)
+			resp, err := client.Post(url, "application/json", strings.NewReader(test.body))
+			if err != nil {
+				t.Fatalf("error response: %v", err)
+			}
+			if got, want := resp.StatusCode, test.wantStatus; got != want {
+				body, _ := io.ReadAll(resp.Body)
+				t.Errorf("status code got != want (%d, %d): %q", got, want, body)
+			}
+		})
+	}
+}
+
+func TestAddAnnotations(t *testing.T) {
+	testSigner, _ := note.NewSigner(crypto.TestFTPersonalityPriv)
+	mbs, err := json.Marshal(api.FirmwareMetadata{DeviceID: "TalkieToaster", FirmwareRevision: 1, FirmwareImageSHA512: []byte("fwhash")})
+	if err != nil {
+		t.Fatalf("marshaling failed, bailing out!: %v", err)
+	}
+	fwStmt, err := crypto.Publisher.SignStatement(api.FirmwareMetadataType, mbs)
+	if err != nil {
+		t.Fatalf("signing failed, bailing out!: %v", err)
+	}
+	fwbs, err := json.Marshal(fwStmt)
+	if err != nil {
+		t.Fatalf("marshaling failed, bailing out!: %v", err)
+	}
+
+	mustAnnotation := func(c *crypto.Claimant, st api.StatementType, a interface{}) string {
+		t.Helper()
+		abs, err := json.Marshal(a)
+		if err != nil {
+			t.Fatalf("marshaling failed, bailing out!: %v", err)
+		}
+		ss, err := c.SignStatement(st, abs)
+		if err != nil {
+			t.Fatalf("signing failed, bailing out!: %v", err)
+		}
+		js, err := json.Marshal(ss)
+		if err != nil {
+			t.Fatalf("marshaling failed, bailing out!: %v", err)
+		}
+		return string(js)
+	}
+	fwID := api.FirmwareID{LogIndex: 5, FirmwareImageSHA512: []byte("fwhash")}
+	otherID := api.FirmwareID{LogIndex: 5, FirmwareImageSHA512: []byte("otherhash")}
+	digest := sha512.Sum512([]byte("some document"))
+
+	for _, test := range []struct {
+		desc             string
+		path             string
+		body             string
+		manifest         []byte
+		wantTrillianCall bool
+		wantStatus       int
+	}{
+		{
+			desc:             "valid malware",
+			path:             api.HTTPAddAnnotationMalware,
+			body:             mustAnnotation(&crypto.AnnotatorMalware, api.MalwareStatementType, api.MalwareStatement{FirmwareID: fwID, Good: true}),
+			manifest:         fwbs,
+			wantTrillianCall: true,
+			wantStatus:       http.StatusOK,
+		}, {
+			desc:       "malware for wrong firmware hash",
+			path:       api.HTTPAddAnnotationMalware,
+			body:       mustAnnotation(&crypto.AnnotatorMalware, api.MalwareStatementType, api.MalwareStatement{FirmwareID: otherID, Good: true}),
+			manifest:   fwbs,
+			wantStatus: http.StatusBadRequest,
+		}, {
+			desc:       "malware signed by wrong claimant",
+			path:       api.HTTPAddAnnotationMalware,
+			body:       mustAnnotation(&crypto.AnnotatorBuild, api.MalwareStatementType, api.MalwareStatement{FirmwareID: fwID, Good: true}),
+			wantStatus: http.StatusBadRequest,
+		}, {
+			desc:             "valid build",
+			path:             api.HTTPAddAnnotationBuild,
+			body:             mustAnnotation(&crypto.AnnotatorBuild, api.BuildStatementType, api.BuildStatement{FirmwareID: fwID, RebuiltImageSHA512: digest[:]}),
+			manifest:         fwbs,
+			wantTrillianCall: true,
+			wantStatus:       http.StatusOK,
+		}, {
+			desc:       "build without rebuilt hash",
+			path:       api.HTTPAddAnnotationBuild,
+			body:       mustAnnotation(&crypto.AnnotatorBuild, api.BuildStatementType, api.BuildStatement{FirmwareID: fwID}),
+			wantStatus: http.StatusBadRequest,
+		}, {
+			desc:       "build signed by wrong claimant",
+			path:       api.HTTPAddAnnotationBuild,
+			body:       mustAnnotation(&crypto.AnnotatorSBOM, api.BuildStatementType, api.BuildStatement{FirmwareID: fwID, RebuiltImageSHA512: digest[:]}),
+			wantStatus: http.StatusBadRequest,
+		}, {
+			desc:       "build for wrong firmware hash",
+			path:       api.HTTPAddAnnotationBuild,
+			body:       mustAnnotation(&crypto.AnnotatorBuild, api.BuildStatementType, api.BuildStatement{FirmwareID: otherID, RebuiltImageSHA512: digest[:]}),
+			manifest:   fwbs,
+			wantStatus: http.StatusBadRequest,
+		}, {
+			desc:             "valid sbom",
+			path:             api.HTTPAddAnnotationSBOM,
+			body:             mustAnnotation(&crypto.AnnotatorSBOM, api.SBOMStatementType, api.SBOMStatement{FirmwareID: fwID, Format: "SPDX-2.2", SBOMSHA512: digest[:]}),
+			manifest:         fwbs,
+			wantTrillianCall: true,
+			wantStatus:       http.StatusOK,
+		}, {
+			desc:       "sbom without format",
+			path:       api.HTTPAddAnnotationSBOM,
+			body:       mustAnnotation(&crypto.AnnotatorSBOM, api.SBOMStatementType, api.SBOMStatement{FirmwareID: fwID, SBOMSHA512: digest[:]}),
+			wantStatus: http.StatusBadRequest,
+		}, {
+			desc:       "sbom for wrong firmware hash",
+			path:       api.HTTPAddAnnotationSBOM,
+			body:       mustAnnotation(&crypto.AnnotatorSBOM, api.SBOMStatementType, api.SBOMStatement{FirmwareID: otherID, Format: "SPDX-2.2", SBOMSHA512: digest[:]}),
+			manifest:   fwbs,
+			wantStatus: http.StatusBadRequest,
+		}, {
+			desc:       "sbom statement on build endpoint",
+			path:       api.HTTPAddAnnotationBuild,
+			body:       mustAnnotation(&crypto.AnnotatorSBOM, api.SBOMStatementType, api.SBOMStatement{FirmwareID: fwID, Format: "SPDX-2.2", SBOMSHA512: digest[:]}),
+			wantStatus: http.StatusBadRequest,
+		}, {
+			desc:             "valid vulnerability",
+			path:             api.HTTPAddAnnotationVulnerability,
+			body:             mustAnnotation(&crypto.AnnotatorVulnerability, api.VulnerabilityStatementType, api.VulnerabilityStatement{FirmwareID: fwID, ID: "CVE-2021-0001"}),
+			manifest:         fwbs,
+			wantTrillianCall: true,
+			wantStatus:       http.StatusOK,
+		}, {
+			desc:       "vulnerability for wrong firmware hash",
+			path:       api.HTTPAddAnnotationVulnerability,
+			body:       mustAnnotation(&crypto.AnnotatorVulnerability, api.VulnerabilityStatementType, api.VulnerabilityStatement{FirmwareID: otherID, ID: "CVE-2021-0001"}),
+			manifest:   fwbs,
+			wantStatus: http.StatusBadRequest,
+		}, {
+			desc:       "vulnerability without ID",
+			path:       api.HTTPAddAnnotationVulnerability,
+			body:       mustAnnotation(&crypto.AnnotatorVulnerability, api.VulnerabilityStatementType, api.VulnerabilityStatement{FirmwareID: fwID}),
+			wantStatus: http.StatusBadRequest,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mt := NewMockTrillian(ctrl)
+			server := NewServer(mt, FakeCAS{}, &FakeReleases{}, 0, api.FTLogOrigin, []note.Signer{testSigner}, crypto.TestClaimantRegistry())
+
+			if test.manifest != nil {
+				mt.EXPECT().FirmwareManifestAtIndex(gomock.Any(), gomock.Eq(uint64(5)), gomock.Eq(uint64(6))).
+					Return(test.manifest, nil, nil)
+			}
+			if test.wantTrillianCall {
+				mt.EXPECT().AddSignedStatement(gomock.Any(), gomock.Eq([]byte(test.body))).
+					Return(nil)
+			}
+
+			r := mux.NewRouter()
+			server.RegisterHandlers(r)
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+
+			client := ts.Client()
+			url := fmt.Sprintf("%s/%s", ts.URL, test.path)
+			resp, err := client.Post(url, "application/json", strings.NewReader(test.body))
+			if err != nil {
+				t.Fatalf("error response: %v", err)
+			}
+			if got, want := resp.StatusCode, test.wantStatus; got != want {
+				body, _ := io.ReadAll(resp.Body)
+				t.Errorf("status code got != want (%d, %d): %q", got, want, body)
+			}
 		})
 	}
 }
@@ -248,7 +802,7 @@ func TestGetConsistency(t *testing.T) {
 		t.Run(test.desc, func(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			mt := NewMockTrillian(ctrl)
-			server := NewServer(mt, FakeCAS{}, testSigner)
+			server := NewServer(mt, FakeCAS{}, &FakeReleases{}, 0, api.FTLogOrigin, []note.Signer{testSigner}, crypto.TestClaimantRegistry())
 			mt.EXPECT().Root().AnyTimes().
 				Return(&root)
 
@@ -336,7 +890,7 @@ func TestGetManifestEntries(t *testing.T) {
 		t.Run(test.desc, func(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			mt := NewMockTrillian(ctrl)
-			server := NewServer(mt, FakeCAS{}, testSigner)
+			server := NewServer(mt, FakeCAS{}, &FakeReleases{}, 0, api.FTLogOrigin, []note.Signer{testSigner}, crypto.TestClaimantRegistry())
 
 			mt.EXPECT().Root().AnyTimes().
 				Return(&root)
@@ -414,7 +968,7 @@ func TestGetInclusionProofByHash(t *testing.T) {
 		t.Run(test.desc, func(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			mt := NewMockTrillian(ctrl)
-			server := NewServer(mt, FakeCAS{}, testSigner)
+			server := NewServer(mt, FakeCAS{}, &FakeReleases{}, 0, api.FTLogOrigin, []note.Signer{testSigner}, crypto.TestClaimantRegistry())
 
 			mt.EXPECT().Root().AnyTimes().
 				Return(&root)
@@ -460,16 +1014,121 @@ func TestGetInclusionProofByHash(t *testing.T) {
 	}
 }
 
+func TestGetFirmwareImage(t *testing.T) {
+	testSigner, _ := note.NewSigner(crypto.TestFTPersonalityPriv)
+	image := []byte("this is a firmware image")
+	h := sha512.Sum512(image)
+	cas := FakeCAS{string(h[:]): image}
+	unknown := sha512.Sum512([]byte("unknown"))
+
+	for _, test := range []struct {
+		desc       string
+		hash       []byte
+		rangeHdr   string
+		wantStatus int
+		wantBody   []byte
+	}{
+		{
+			desc:       "whole image",
+			hash:       h[:],
+			wantStatus: http.StatusOK,
+			wantBody:   image,
+		}, {
+			desc:       "range",
+			hash:       h[:],
+			rangeHdr:   "bytes=10-17",
+			wantStatus: http.StatusPartialContent,
+			wantBody:   image[10:18],
+		}, {
+			desc:       "unsatisfiable range",
+			hash:       h[:],
+			rangeHdr:   "bytes=1000-",
+			wantStatus: http.StatusRequestedRangeNotSatisfiable,
+		}, {
+			desc:       "unknown image",
+			hash:       unknown[:],
+			wantStatus: http.StatusNotFound,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mt := NewMockTrillian(ctrl)
+			server := NewServer(mt, cas, &FakeReleases{}, 0, api.FTLogOrigin, []note.Signer{testSigner}, crypto.TestClaimantRegistry())
+
+			r := mux.NewRouter()
+			server.RegisterHandlers(r)
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+
+			url := fmt.Sprintf("%s/%s/with-hash/%s", ts.URL, api.HTTPGetFirmwareImage, base64.URLEncoding.EncodeToString(test.hash))
+			req, err := http.NewRequest(http.MethodGet, url, nil)
+			if err != nil {
+				t.Fatalf("NewRequest(): %v", err)
+			}
+			if len(test.rangeHdr) > 0 {
+				req.Header.Set("Range", test.rangeHdr)
+			}
+			resp, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatalf("error response: %v", err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("failed to read body: %v", err)
+			}
+			if got, want := resp.StatusCode, test.wantStatus; got != want {
+				t.Fatalf("status code got != want (%d, %d): %q", got, want, body)
+			}
+			if test.wantBody != nil && !bytes.Equal(body, test.wantBody) {
+				t.Errorf("got body %q, want %q", body, test.wantBody)
+			}
+		})
+	}
+}
+
 type FakeCAS map[string][]byte
 
-func (f FakeCAS) Store(key, image []byte) error {
+func (f FakeCAS) Store(key []byte, r io.Reader) error {
+	image, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if h := sha512.Sum512(image); !bytes.Equal(h[:], key) {
+		return status.Error(codes.InvalidArgument, "mismatch")
+	}
 	f[string(key)] = image
 	return nil
 }
 
-func (f FakeCAS) Retrieve(key []byte) ([]byte, error) {
+func (f FakeCAS) Retrieve(key []byte) (io.ReadSeekCloser, error) {
 	if image, ok := f[string(key)]; ok {
-		return image, nil
+		return nopCloser{bytes.NewReader(image)}, nil
+	}
+	return nil, status.Error(codes.NotFound, "nope")
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
+
+type FakeReleases struct {
+	err       error
+	recordErr error
+	// recorded is set once Record is called.
+	recorded bool
+}
+
+func (f *FakeReleases) Check(deviceID string, revision uint64, imageHash []byte) error {
+	return f.err
+}
+
+func (f *FakeReleases) Record(deviceID string, revision uint64, imageHash []byte) error {
+	f.recorded = true
+	if f.err != nil {
+		return f.err
 	}
-	return nil, errors.New("nope")
+	return f.recordErr
 }
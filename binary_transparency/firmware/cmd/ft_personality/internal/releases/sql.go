@@ -0,0 +1,124 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package releases tracks the latest firmware revision logged for each device.
+package releases
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"math"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ReleaseStorage records the highest firmware revision logged for each device,
+// using a SQL Database as its backing store.
+type ReleaseStorage struct {
+	db *sql.DB
+	// mu serializes Record calls so that the check and update are atomic
+	// with respect to other submissions made through this storage.
+	mu sync.Mutex
+}
+
+// NewReleaseStorage creates a new ReleaseStorage that uses the given DB as a backend.
+// The DB will be initialized if needed.
+func NewReleaseStorage(db *sql.DB) (*ReleaseStorage, error) {
+	rs := &ReleaseStorage{
+		db: db,
+	}
+	return rs, rs.init()
+}
+
+// init creates the database tables if needed.
+func (rs *ReleaseStorage) init() error {
+	_, err := rs.db.Exec("CREATE TABLE IF NOT EXISTS releases (deviceID TEXT PRIMARY KEY, revision INTEGER, imageHash BLOB)")
+	return err
+}
+
+// Check returns the error that Record would return for the release, without
+// recording anything.
+func (rs *ReleaseStorage) Check(deviceID string, revision uint64, imageHash []byte) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	_, _, err := check(rs.db, deviceID, revision, imageHash)
+	return err
+}
+
+// Record notes that the image with the given hash has been logged as the given
+// revision for the device. Submitting the same image for the latest revision again
+// is allowed. Returns status code FailedPrecondition if a later revision has already
+// been recorded for the device, and AlreadyExists if a different image was recorded
+// for this revision.
+func (rs *ReleaseStorage) Record(deviceID string, revision uint64, imageHash []byte) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	tx, err := rs.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Rollback is a no-op if the transaction has already been committed.
+		_ = tx.Rollback()
+	}()
+
+	found, latest, err := check(tx, deviceID, revision, imageHash)
+	switch {
+	case err != nil:
+		return err
+	case !found:
+		if _, err := tx.Exec("INSERT INTO releases (deviceID, revision, imageHash) VALUES (?, ?, ?)", deviceID, int64(revision), imageHash); err != nil {
+			return fmt.Errorf("failed to record release: %w", err)
+		}
+	case latest == revision:
+		return nil
+	default:
+		if _, err := tx.Exec("UPDATE releases SET revision=?, imageHash=? WHERE deviceID=?", int64(revision), imageHash, deviceID); err != nil {
+			return fmt.Errorf("failed to record release: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// check returns whether a release has been recorded for the device and its latest revision,
+// and an error if the given release would be a rollback or conflict with the latest one.
+func check(q queryer, deviceID string, revision uint64, imageHash []byte) (bool, uint64, error) {
+	if revision > math.MaxInt64 {
+		return false, 0, status.Errorf(codes.InvalidArgument, "revision %d is too large", revision)
+	}
+	var latest int64
+	var latestHash []byte
+	err := q.QueryRow("SELECT revision, imageHash FROM releases WHERE deviceID=?", deviceID).Scan(&latest, &latestHash)
+	switch {
+	case err == sql.ErrNoRows:
+		return false, 0, nil
+	case err != nil:
+		return false, 0, err
+	case uint64(latest) > revision:
+		return true, uint64(latest), status.Errorf(codes.FailedPrecondition, "revision %d for device %q is older than logged revision %d", revision, deviceID, latest)
+	case uint64(latest) == revision && !bytes.Equal(latestHash, imageHash):
+		return true, uint64(latest), status.Errorf(codes.AlreadyExists, "revision %d for device %q was already logged with a different image (%x)", revision, deviceID, latestHash)
+	}
+	return true, uint64(latest), nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package releases
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3" // Load drivers for sqlite3
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRecord(t *testing.T) {
+	type release struct {
+		deviceID string
+		revision uint64
+		hash     string
+	}
+	for _, test := range []struct {
+		desc     string
+		previous []release
+		release  release
+		wantCode codes.Code
+	}{
+		{
+			desc:    "first release",
+			release: release{"dummy", 1, "one"},
+		}, {
+			desc:     "newer release",
+			previous: []release{{"dummy", 1, "one"}},
+			release:  release{"dummy", 2, "two"},
+		}, {
+			desc:     "identical resubmission",
+			previous: []release{{"dummy", 1, "one"}},
+			release:  release{"dummy", 1, "one"},
+		}, {
+			desc:     "other device",
+			previous: []release{{"armory", 5, "five"}},
+			release:  release{"dummy", 1, "one"},
+		}, {
+			desc:     "rollback",
+			previous: []release{{"dummy", 1, "one"}, {"dummy", 2, "two"}},
+			release:  release{"dummy", 1, "one"},
+			wantCode: codes.FailedPrecondition,
+		}, {
+			desc:     "conflicting image",
+			previous: []release{{"dummy", 1, "one"}},
+			release:  release{"dummy", 1, "evil"},
+			wantCode: codes.AlreadyExists,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			db, err := sql.Open("sqlite3", ":memory:")
+			if err != nil {
+				t.Fatal("failed to open temporary in-memory DB", err)
+			}
+			defer func() {
+				if err := db.Close(); err != nil {
+					t.Errorf("db.Close(): %v", err)
+				}
+			}()
+
+			store, err := NewReleaseStorage(db)
+			if err != nil {
+				t.Fatal("failed to create release storage", err)
+			}
+			for _, p := range test.previous {
+				if err := store.Record(p.deviceID, p.revision, []byte(p.hash)); err != nil {
+					t.Fatalf("failed to record previous release %+v: %v", p, err)
+				}
+			}
+
+			err = store.Check(test.release.deviceID, test.release.revision, []byte(test.release.hash))
+			if got, want := status.Code(err), test.wantCode; got != want {
+				t.Fatalf("Check() got error code %s (%v), want %s", got, err, want)
+			}
+			err = store.Record(test.release.deviceID, test.release.revision, []byte(test.release.hash))
+			if got, want := status.Code(err), test.wantCode; got != want {
+				t.Fatalf("got error code %s (%v), want %s", got, err, want)
+			}
+		})
+	}
+}
+
+func TestCheckDoesNotRecord(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal("failed to open temporary in-memory DB", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("db.Close(): %v", err)
+		}
+	}()
+	store, err := NewReleaseStorage(db)
+	if err != nil {
+		t.Fatal("failed to create release storage", err)
+	}
+
+	if err := store.Check("dummy", 2, []byte("two")); err != nil {
+		t.Fatalf("Check(): %v", err)
+	}
+	// Revision 2 was only checked, so revision 1 isn't a rollback.
+	if err := store.Record("dummy", 1, []byte("one")); err != nil {
+		t.Fatalf("Record() after Check() of a later revision: %v", err)
+	}
+}
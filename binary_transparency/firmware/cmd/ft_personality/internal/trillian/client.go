@@ -186,6 +186,23 @@ func (c *Client) FirmwareManifestAtIndex(ctx context.Context, index, treeSize ui
 	return ip.Leaf.LeafValue, ip.Proof.Hashes, nil
 }
 
+// LeavesByRange gets the values of up to count leaves starting at index start.
+func (c *Client) LeavesByRange(ctx context.Context, start, count uint64) ([][]byte, error) {
+	resp, err := c.client.GetLeavesByRange(ctx, &trillian.GetLeavesByRangeRequest{
+		LogId:      c.logID,
+		StartIndex: int64(start),
+		Count:      int64(count),
+	})
+	if err != nil {
+		return nil, err
+	}
+	leaves := make([][]byte, 0, len(resp.Leaves))
+	for _, l := range resp.Leaves {
+		leaves = append(leaves, l.LeafValue)
+	}
+	return leaves, nil
+}
+
 // InclusionProofByHash gets an inclusion proof in the specified tree size for the
 // first leaf found with the specified hash.
 func (c *Client) InclusionProofByHash(ctx context.Context, hash []byte, treeSize uint64) (uint64, [][]byte, error) {
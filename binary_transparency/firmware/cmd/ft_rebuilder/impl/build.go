@@ -0,0 +1,258 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package impl
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+)
+
+// maxBuildOutput is the number of bytes of build output included in errors.
+const maxBuildOutput = 4096
+
+// Recipe describes how to build firmware from its source.
+type Recipe struct {
+	// Command is the program and arguments to run in the root of the unpacked source.
+	// It must write the firmware image to the path in the FT_OUTPUT environment variable.
+	// FT_DEVICE_ID, FT_FIRMWARE_REVISION and SOURCE_DATE_EPOCH are also set from the
+	// firmware metadata, for builds which embed them in the image.
+	Command []string
+	// Timeout bounds how long a build may take, or is 0 for no limit.
+	Timeout time.Duration
+	// MaxUnpackedSize bounds the total size in bytes of the files unpacked from the
+	// source tarball, or is 0 for no limit.
+	MaxUnpackedSize int64
+}
+
+// Build unpacks the source tarball into a temporary directory, runs the recipe's
+// command in it, and returns the image that it built.
+func (r Recipe) Build(ctx context.Context, meta api.FirmwareMetadata, src []byte) ([]byte, error) {
+	if len(r.Command) == 0 {
+		return nil, errors.New("no build command")
+	}
+	dir, err := os.MkdirTemp("", "ft_rebuilder")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create build directory: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			glog.Errorf("Failed to remove build directory: %v", err)
+		}
+	}()
+	srcDir, out := filepath.Join(dir, "src"), filepath.Join(dir, "firmware.out")
+	if err := Extract(src, srcDir, r.MaxUnpackedSize); err != nil {
+		return nil, fmt.Errorf("failed to unpack source: %w", err)
+	}
+
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, r.Command[0], r.Command[1:]...)
+	cmd.Dir = srcDir
+	cmd.Env = append(os.Environ(),
+		"FT_OUTPUT="+out,
+		"FT_DEVICE_ID="+meta.DeviceID,
+		"FT_FIRMWARE_REVISION="+strconv.FormatUint(meta.FirmwareRevision, 10))
+	if t, err := time.Parse(time.RFC3339, meta.BuildTimestamp); err == nil {
+		cmd.Env = append(cmd.Env, "SOURCE_DATE_EPOCH="+strconv.FormatInt(t.Unix(), 10))
+	}
+	var output bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &output, &output
+	if err := cmd.Run(); err != nil {
+		b := output.Bytes()
+		if len(b) > maxBuildOutput {
+			b = b[len(b)-maxBuildOutput:]
+		}
+		return nil, fmt.Errorf("build command failed: %w, output:\n%s", err, b)
+	}
+	glog.V(2).Infof("Build output:\n%s", output.Bytes())
+
+	image, err := os.ReadFile(out)
+	if err != nil {
+		return nil, fmt.Errorf("build command didn't write the firmware image: %w", err)
+	}
+	return image, nil
+}
+
+// Source fetches the source tarballs referenced by firmware metadata.
+type Source struct {
+	// LocalDirs are the directories which sources may be read from with file URLs or
+	// local paths. If empty, only http(s) URLs are fetched, as the URI comes from the
+	// log and could otherwise name any file readable by the rebuilder.
+	LocalDirs []string
+	// MaxSize bounds the size in bytes of a source tarball, or is 0 for no limit.
+	MaxSize int64
+}
+
+// Fetch returns the source tarball at the given URI, which is either an http(s) URL,
+// or a file URL or local path inside one of the LocalDirs.
+func (s Source) Fetch(ctx context.Context, uri string) ([]byte, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source URI %q: %w", uri, err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch source from %q: %w", uri, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to fetch source from %q: %s", uri, resp.Status)
+		}
+		return readAtMost(resp.Body, s.MaxSize)
+	case "file":
+		return s.readLocal(u.Path)
+	case "":
+		return s.readLocal(uri)
+	default:
+		return nil, fmt.Errorf("unsupported source URI scheme %q", u.Scheme)
+	}
+}
+
+// readLocal reads the file at path, which must be inside one of the LocalDirs once
+// any symlinks are resolved.
+func (s Source) readLocal(path string) ([]byte, error) {
+	if len(s.LocalDirs) == 0 {
+		return nil, fmt.Errorf("local source %q isn't allowed; only http(s) sources are fetched unless local directories are configured", path)
+	}
+	p, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve local source %q: %w", path, err)
+	}
+	if p, err = filepath.Abs(p); err != nil {
+		return nil, err
+	}
+	for _, d := range s.LocalDirs {
+		rd, err := filepath.EvalSymlinks(d)
+		if err != nil {
+			glog.Warningf("Failed to resolve local source directory %q: %v", d, err)
+			continue
+		}
+		if rd, err = filepath.Abs(rd); err != nil {
+			return nil, err
+		}
+		if rel, err := filepath.Rel(rd, p); err == nil && filepath.IsLocal(rel) {
+			f, err := os.Open(p)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			return readAtMost(f, s.MaxSize)
+		}
+	}
+	return nil, fmt.Errorf("local source %q isn't inside an allowed directory", path)
+}
+
+// readAtMost reads all of r, failing if it holds more than max bytes. A max of 0 means no limit.
+func readAtMost(r io.Reader, max int64) ([]byte, error) {
+	if max <= 0 {
+		return io.ReadAll(r)
+	}
+	b, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > max {
+		return nil, fmt.Errorf("source is larger than the limit of %d bytes", max)
+	}
+	return b, nil
+}
+
+// Extract unpacks the tarball, which may be gzipped, into dir. Only regular files and
+// directories are supported, and they must all be inside dir. The files may total at
+// most maxSize bytes, or any size if maxSize is 0.
+func Extract(src []byte, dir string, maxSize int64) error {
+	var r io.Reader = bytes.NewReader(src)
+	if bytes.HasPrefix(src, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		r = zr
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	var total int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		name := filepath.FromSlash(hdr.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("tarball entry %q is outside the source directory", hdr.Name)
+		}
+		p := filepath.Join(dir, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(p, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, hdr.FileInfo().Mode().Perm()|0o600)
+			if err != nil {
+				return err
+			}
+			var fr io.Reader = tr
+			if maxSize > 0 {
+				fr = io.LimitReader(tr, maxSize-total+1)
+			}
+			n, err := io.Copy(f, fr)
+			if err != nil {
+				f.Close()
+				return err
+			}
+			if total += n; maxSize > 0 && total > maxSize {
+				f.Close()
+				return fmt.Errorf("unpacked source is larger than the limit of %d bytes", maxSize)
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("tarball entry %q has unsupported type %q", hdr.Name, hdr.Typeflag)
+		}
+	}
+}
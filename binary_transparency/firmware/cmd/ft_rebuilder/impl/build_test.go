@@ -0,0 +1,250 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package impl
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+)
+
+type tarEntry struct {
+	name, body string
+	typ        byte
+}
+
+func mustTarball(t *testing.T, gz bool, entries ...tarEntry) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typ, Mode: 0o644, Size: int64(len(e.body))}
+		if e.typ == tar.TypeSymlink {
+			hdr.Linkname, hdr.Size = e.body, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if e.typ == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !gz {
+		return b.Bytes()
+	}
+	var z bytes.Buffer
+	zw := gzip.NewWriter(&z)
+	if _, err := zw.Write(b.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return z.Bytes()
+}
+
+func TestExtract(t *testing.T) {
+	for _, test := range []struct {
+		desc    string
+		src     []byte
+		maxSize int64
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			desc: "tar",
+			src:  mustTarball(t, false, tarEntry{name: "a.txt", body: "A", typ: tar.TypeReg}),
+			want: map[string]string{"a.txt": "A"},
+		}, {
+			desc: "gzipped with directories",
+			src: mustTarball(t, true,
+				tarEntry{name: "dir/", typ: tar.TypeDir},
+				tarEntry{name: "dir/b.txt", body: "B", typ: tar.TypeReg},
+				tarEntry{name: "other/c.txt", body: "C", typ: tar.TypeReg}),
+			want: map[string]string{"dir/b.txt": "B", "other/c.txt": "C"},
+		}, {
+			desc: "at size limit",
+			src: mustTarball(t, true,
+				tarEntry{name: "a.txt", body: "AAAA", typ: tar.TypeReg},
+				tarEntry{name: "b.txt", body: "BBBB", typ: tar.TypeReg}),
+			maxSize: 8,
+			want:    map[string]string{"a.txt": "AAAA", "b.txt": "BBBB"},
+		}, {
+			desc: "over size limit",
+			src: mustTarball(t, true,
+				tarEntry{name: "a.txt", body: "AAAA", typ: tar.TypeReg},
+				tarEntry{name: "b.txt", body: "BBBBB", typ: tar.TypeReg}),
+			maxSize: 8,
+			wantErr: true,
+		}, {
+			desc:    "escapes directory",
+			src:     mustTarball(t, false, tarEntry{name: "../evil", body: "X", typ: tar.TypeReg}),
+			wantErr: true,
+		}, {
+			desc:    "absolute path",
+			src:     mustTarball(t, false, tarEntry{name: "/evil", body: "X", typ: tar.TypeReg}),
+			wantErr: true,
+		}, {
+			desc:    "symlink",
+			src:     mustTarball(t, false, tarEntry{name: "link", body: "/etc/passwd", typ: tar.TypeSymlink}),
+			wantErr: true,
+		}, {
+			desc:    "garbage",
+			src:     []byte("not a tarball at all, but long enough to be read as a header"),
+			wantErr: true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "src")
+			err := Extract(test.src, dir, test.maxSize)
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("unexpected error: %v", err)
+			case err == nil && test.wantErr:
+				t.Fatal("expected error, got none")
+			case err != nil && test.wantErr:
+				return
+			}
+			for name, want := range test.want {
+				got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+				if err != nil {
+					t.Fatalf("failed to read %q: %v", name, err)
+				}
+				if string(got) != want {
+					t.Errorf("got %q in %q, want %q", got, name, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBuild(t *testing.T) {
+	src := mustTarball(t, true,
+		tarEntry{name: "firmware.bin", body: "the firmware", typ: tar.TypeReg},
+		tarEntry{name: "build.sh", body: `printf "%s/%s:" "$FT_DEVICE_ID" "$FT_FIRMWARE_REVISION" > "$FT_OUTPUT" && cat firmware.bin >> "$FT_OUTPUT"`, typ: tar.TypeReg},
+		tarEntry{name: "fail.sh", body: "echo oops; exit 1", typ: tar.TypeReg})
+	meta := api.FirmwareMetadata{DeviceID: "dummy", FirmwareRevision: 3}
+
+	for _, test := range []struct {
+		desc    string
+		command []string
+		want    string
+		wantErr bool
+	}{
+		{
+			desc:    "builds",
+			command: []string{"sh", "build.sh"},
+			want:    "dummy/3:the firmware",
+		}, {
+			desc:    "command fails",
+			command: []string{"sh", "fail.sh"},
+			wantErr: true,
+		}, {
+			desc:    "no output",
+			command: []string{"true"},
+			wantErr: true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := Recipe{Command: test.command}.Build(context.Background(), meta, src)
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("unexpected error: %v", err)
+			case err == nil && test.wantErr:
+				t.Fatal("expected error, got none")
+			case err != nil && test.wantErr:
+				return
+			}
+			if string(got) != test.want {
+				t.Errorf("got image %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFetchSource(t *testing.T) {
+	want := []byte("source tarball")
+	srcDir, otherDir := t.TempDir(), t.TempDir()
+	path := filepath.Join(srcDir, "src.tar.gz")
+	if err := os.WriteFile(path, want, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	otherPath := filepath.Join(otherDir, "secret")
+	if err := os.WriteFile(otherPath, want, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(srcDir, "link")
+	if err := os.Symlink(otherPath, link); err != nil {
+		t.Fatal(err)
+	}
+	local := Source{LocalDirs: []string{srcDir}}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/src.tar.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(want)
+	}))
+	defer ts.Close()
+
+	for _, test := range []struct {
+		desc    string
+		source  Source
+		uri     string
+		wantErr bool
+	}{
+		{desc: "path", source: local, uri: path},
+		{desc: "file URL", source: local, uri: "file://" + path},
+		{desc: "path without local dirs", uri: path, wantErr: true},
+		{desc: "file URL without local dirs", uri: "file://" + path, wantErr: true},
+		{desc: "path outside local dirs", source: local, uri: otherPath, wantErr: true},
+		{desc: "relative path escaping local dirs", source: local, uri: filepath.Join(srcDir, "..", filepath.Base(otherDir), "secret"), wantErr: true},
+		{desc: "symlink out of local dirs", source: local, uri: link, wantErr: true},
+		{desc: "http URL", uri: ts.URL + "/src.tar.gz"},
+		{desc: "http URL at size limit", source: Source{MaxSize: int64(len(want))}, uri: ts.URL + "/src.tar.gz"},
+		{desc: "http URL over size limit", source: Source{MaxSize: int64(len(want)) - 1}, uri: ts.URL + "/src.tar.gz", wantErr: true},
+		{desc: "path over size limit", source: Source{LocalDirs: []string{srcDir}, MaxSize: 1}, uri: path, wantErr: true},
+		{desc: "missing", uri: ts.URL + "/missing.tar.gz", wantErr: true},
+		{desc: "unsupported scheme", uri: "ftp://example.com/src.tar.gz", wantErr: true},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := test.source.Fetch(context.Background(), test.uri)
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("unexpected error: %v", err)
+			case err == nil && test.wantErr:
+				t.Fatal("expected error, got none")
+			case err != nil && test.wantErr:
+				return
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package impl is the implementation of the Firmware Transparency rebuilder.
+// The rebuilder follows the log, rebuilds each piece of firmware from the
+// source referenced in its metadata, and checks that the result matches the
+// logged image.
+package impl
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/client"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
+)
+
+// RebuildOpts encapsulates options for running the rebuilder.
+type RebuildOpts struct {
+	LogURL         string
+	LogSigVerifier api.LogVerifier
+	// UseTiles fetches entries and proofs from the tile-based view of the log rather than its proof endpoints.
+	UseTiles     bool
+	PollInterval time.Duration
+	// Source fetches the source of firmware to rebuild.
+	Source Source
+	// Recipe builds firmware images from their source.
+	Recipe Recipe
+	// Annotate publishes the result of each rebuild to the log.
+	Annotate bool
+	// StateFile records the progress of the rebuilder through the log. If empty, the
+	// rebuilder starts from the beginning of the log each time.
+	StateFile string
+	// Claimants are the keys trusted to sign statements in the log.
+	Claimants *crypto.ClaimantRegistry
+	// Builder signs build annotations. Required if Annotate is set.
+	Builder *crypto.Claimant
+}
+
+// state is the progress of the rebuilder through the log.
+type state struct {
+	// Checkpoint is the signed checkpoint which the processed entries were verified against.
+	Checkpoint []byte
+	// Next is the index of the next entry to rebuild.
+	Next uint64
+}
+
+// Main runs the rebuilder until the context is canceled.
+func Main(ctx context.Context, opts RebuildOpts) error {
+	if len(opts.LogURL) == 0 {
+		return errors.New("log URL is required")
+	}
+	if len(opts.Recipe.Command) == 0 {
+		return errors.New("build command is required")
+	}
+	if opts.Claimants == nil {
+		return errors.New("claimant registry is required")
+	}
+	if opts.Annotate && opts.Builder == nil {
+		return errors.New("builder key is required to annotate")
+	}
+
+	ftURL, err := url.Parse(opts.LogURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse FT log URL: %w", err)
+	}
+	c := client.ReadonlyClient{
+		LogURL:         ftURL,
+		LogSigVerifier: opts.LogSigVerifier,
+		UseTiles:       opts.UseTiles,
+	}
+
+	st, err := readState(opts.StateFile)
+	if err != nil {
+		return err
+	}
+	var latestCP api.LogCheckpoint
+	if len(st.Checkpoint) > 0 {
+		cp, err := api.ParseCheckpoint(st.Checkpoint, opts.LogSigVerifier)
+		if err != nil {
+			return fmt.Errorf("failed to open state: %w", err)
+		}
+		latestCP = *cp
+	} else {
+		glog.Warning("No checkpoint in state; first log checkpoint will be trusted implicitly")
+	}
+	follow := client.NewLogFollower(c, opts.Claimants)
+	// Statements from unknown claimants are skipped by processEntry, rather than stopping
+	// the rebuilder at them.
+	follow.AllowUnverified = true
+
+	glog.Infof("Rebuilding firmware in FT log (%q) starting from index %d", opts.LogURL, st.Next)
+	cpc, cperrc := follow.Checkpoints(ctx, opts.PollInterval, latestCP)
+	ec, eerrc := follow.Entries(ctx, cpc, st.Next)
+
+	for {
+		var entry client.LogEntry
+		select {
+		case err = <-cperrc:
+			return err
+		case err = <-eerrc:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		case entry = <-ec:
+		}
+
+		// Failures to rebuild aren't evidence that the firmware isn't reproducible, so
+		// they're logged rather than annotated.
+		if err := processEntry(ctx, entry, c, opts); err != nil {
+			glog.Warningf("Failed to rebuild entry at index %d: %v", entry.Index, err)
+		}
+		st = state{Checkpoint: entry.Root.Envelope, Next: entry.Index + 1}
+		if err := writeState(opts.StateFile, st); err != nil {
+			return err
+		}
+	}
+}
+
+// processEntry rebuilds the firmware in the entry, if it references its source, and
+// annotates whether the rebuilt image matches the logged one. Statements whose signature
+// couldn't be verified are skipped.
+func processEntry(ctx context.Context, entry client.LogEntry, c client.ReadonlyClient, opts RebuildOpts) error {
+	stmt := entry.Value
+	if entry.Unverified {
+		glog.Warningf("Skipping statement at index %d, as its signature couldn't be verified", entry.Index)
+		return nil
+	}
+	if stmt.Type != api.FirmwareMetadataType {
+		// Only firmware can be rebuilt.
+		return nil
+	}
+	var meta api.FirmwareMetadata
+	if err := json.Unmarshal(stmt.Statement, &meta); err != nil {
+		return fmt.Errorf("unable to decode FW Metadata from Statement: %w", err)
+	}
+	if len(meta.SourceURI) == 0 {
+		glog.V(1).Infof("No source for firmware (@%d): %s", entry.Index, meta)
+		return nil
+	}
+	glog.Infof("Rebuilding firmware (@%d) from %q: %s", entry.Index, meta.SourceURI, meta)
+
+	src, err := opts.Source.Fetch(ctx, meta.SourceURI)
+	if err != nil {
+		return err
+	}
+	image, err := opts.Recipe.Build(ctx, meta, src)
+	if err != nil {
+		return err
+	}
+	h := sha512.Sum512(image)
+	if bytes.Equal(h[:], meta.FirmwareImageSHA512) {
+		glog.Infof("Firmware at index %d was reproducibly built", entry.Index)
+	} else {
+		glog.Warningf("Firmware at index %d is not reproducible: rebuilt image hash 0x%x != 0x%x", entry.Index, h[:], meta.FirmwareImageSHA512)
+	}
+
+	if opts.Annotate {
+		bs := api.BuildStatement{
+			FirmwareID: api.FirmwareID{
+				LogIndex:            entry.Index,
+				FirmwareImageSHA512: meta.FirmwareImageSHA512,
+			},
+			SourceURI:          meta.SourceURI,
+			RebuiltImageSHA512: h[:],
+		}
+		glog.V(1).Infof("Annotating %s", bs)
+		js, err := createStatementJSON(bs, opts.Builder)
+		if err != nil {
+			return fmt.Errorf("failed to create annotation: %w", err)
+		}
+		sc := client.SubmitClient{
+			ReadonlyClient: &c,
+		}
+		if err := sc.PublishAnnotationBuild(js); err != nil {
+			return fmt.Errorf("failed to publish annotation: %w", err)
+		}
+	}
+	return nil
+}
+
+func createStatementJSON(b api.BuildStatement, builder *crypto.Claimant) ([]byte, error) {
+	js, err := json.Marshal(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal build statement: %w", err)
+	}
+	statement, err := builder.SignStatement(api.BuildStatementType, js)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signature: %w", err)
+	}
+
+	return json.Marshal(statement)
+}
+
+// readState returns the state persisted in the file, which is empty if there is no
+// file or no file has been written yet.
+func readState(path string) (state, error) {
+	var st state
+	if len(path) == 0 {
+		return st, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	} else if err != nil {
+		return st, fmt.Errorf("failed to read state file: %w", err)
+	}
+	if err := json.Unmarshal(b, &st); err != nil {
+		return st, fmt.Errorf("failed to parse state file: %w", err)
+	}
+	return st, nil
+}
+
+// writeState persists the state to the file, if there is one.
+func writeState(path string, st state) error {
+	if len(path) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+	// Write to a temporary file and rename, so that the state is never left half written.
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create state file: %w", err)
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
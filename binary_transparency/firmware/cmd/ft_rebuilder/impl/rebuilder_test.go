@@ -0,0 +1,83 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package impl
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/client"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
+)
+
+func TestProcessEntryUnverified(t *testing.T) {
+	for _, test := range []struct {
+		desc       string
+		unverified bool
+		wantFetch  bool
+		wantErr    bool
+	}{
+		{
+			desc:      "verified",
+			wantFetch: true,
+			// The source isn't a tarball, so the build fails after fetching it.
+			wantErr: true,
+		}, {
+			desc:       "unverified",
+			unverified: true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			fetched := false
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fetched = true
+				_, _ = w.Write([]byte("not a tarball"))
+			}))
+			defer ts.Close()
+
+			js, err := json.Marshal(api.FirmwareMetadata{
+				DeviceID:            "dummy",
+				FirmwareRevision:    1,
+				FirmwareImageSHA512: []byte("image hash"),
+				SourceURI:           ts.URL,
+			})
+			if err != nil {
+				t.Fatalf("json.Marshal(): %v", err)
+			}
+			stmt, err := crypto.Publisher.SignStatement(api.FirmwareMetadataType, js)
+			if err != nil {
+				t.Fatalf("SignStatement(): %v", err)
+			}
+			opts := RebuildOpts{
+				Source: Source{MaxSize: 1 << 20},
+				Recipe: Recipe{Command: []string{"true"}},
+			}
+			err = processEntry(context.Background(), client.LogEntry{Index: 3, Value: stmt, Unverified: test.unverified}, client.ReadonlyClient{}, opts)
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("Got unexpected error %q", err)
+			case err == nil && test.wantErr:
+				t.Fatal("Got no error, but wanted error")
+			}
+			if fetched != test.wantFetch {
+				t.Errorf("got source fetched %t, want %t", fetched, test.wantFetch)
+			}
+		})
+	}
+}
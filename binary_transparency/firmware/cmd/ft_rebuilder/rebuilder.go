@@ -0,0 +1,104 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This package is the entrypoint for the Firmware Transparency rebuilder.
+// The rebuilder follows the log, rebuilds each piece of firmware from the
+// source referenced in its metadata, and checks that the result matches the
+// logged image.
+//
+// Start the rebuilder using:
+// go run ./cmd/ft_rebuilder --logtostderr -v=1 --ftlog=http://localhost:8000 --build_command="sh build.sh" --state_file=/tmp/ftrebuild.state
+package main
+
+import (
+	"context"
+	"flag"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian-examples/binary_transparency/firmware/cmd/ft_rebuilder/impl"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
+)
+
+var (
+	ftLog        = flag.String("ftlog", "http://localhost:8000", "Base URL of FT Log server")
+	useTiles     = flag.Bool("use_tiles", false, "Read entries and proofs from the tiles published by the log rather than asking the log")
+	pollInterval = flag.Duration("poll_interval", 5*time.Second, "Duration to wait between polling for new entries")
+	buildCommand = flag.String("build_command", "", "Command line run in the root of the unpacked source, which must write the firmware image to $FT_OUTPUT")
+	buildTimeout = flag.Duration("build_timeout", 10*time.Minute, "Longest time a single build may take, or 0 for no limit")
+
+	sourceDirs          = flag.String("source_dirs", "", "Comma separated local directories which sources may be read from with file URLs or paths; if empty, only http(s) sources are fetched")
+	sourceMaxSize       = flag.Int64("source_max_size", 256<<20, "Largest source tarball in bytes that will be fetched, or 0 for no limit")
+	sourceMaxUnpackSize = flag.Int64("source_max_unpacked_size", 1<<30, "Largest total size in bytes of the files unpacked from a source tarball, or 0 for no limit")
+	annotate            = flag.Bool("annotate", false, "If true then this will add build annotations to the log in addition to local logging")
+	stateFile           = flag.String("state_file", "", "File path to persist the rebuilder's progress through the log to, or empty to start from the beginning each time")
+
+	claimantsConfig = flag.String("claimants_config", "", "Path to a JSON file listing the keys trusted to sign statements, or empty to trust only the TEST/DEMO keys")
+	builderKeyFile  = flag.String("builder_key_file", "", "Path to a PEM private key used to sign build annotations, or empty to use the TEST/DEMO key")
+	builderKeyID    = flag.String("builder_key_id", "", "ID of the key in --builder_key_file in the log's claimants config, included as a hint to verifiers")
+
+	logOrigin     = flag.String("log_origin", api.FTLogOrigin, "Origin line expected on checkpoints from the log")
+	logPublicKeys = flag.String("log_public_keys", crypto.TestFTPersonalityPub, "Comma separated note verifier keys for the log; checkpoints signed by any of them are accepted")
+)
+
+func main() {
+	flag.Parse()
+
+	logSigV, err := api.NewLogVerifier(*logOrigin, strings.Split(*logPublicKeys, ",")...)
+	if err != nil {
+		glog.Exitf("Failed to create log verifier: %v", err)
+	}
+
+	claimants := crypto.TestClaimantRegistry()
+	if len(*claimantsConfig) > 0 {
+		if claimants, err = crypto.LoadClaimantRegistry(*claimantsConfig); err != nil {
+			glog.Exitf("Failed to load claimants: %v", err)
+		}
+	}
+	builder := &crypto.AnnotatorBuild
+	if len(*builderKeyFile) > 0 {
+		if builder, err = crypto.LoadSigningClaimant(*builderKeyFile, *builderKeyID); err != nil {
+			glog.Exitf("Failed to load builder key: %v", err)
+		}
+	}
+
+	var localDirs []string
+	if len(*sourceDirs) > 0 {
+		localDirs = strings.Split(*sourceDirs, ",")
+	}
+
+	if err := impl.Main(context.Background(), impl.RebuildOpts{
+		LogURL:         *ftLog,
+		LogSigVerifier: logSigV,
+		UseTiles:       *useTiles,
+		PollInterval:   *pollInterval,
+		Source: impl.Source{
+			LocalDirs: localDirs,
+			MaxSize:   *sourceMaxSize,
+		},
+		Recipe: impl.Recipe{
+			Command:         strings.Fields(*buildCommand),
+			Timeout:         *buildTimeout,
+			MaxUnpackedSize: *sourceMaxUnpackSize,
+		},
+		Annotate:  *annotate,
+		StateFile: *stateFile,
+		Claimants: claimants,
+		Builder:   builder,
+	}); err != nil {
+		glog.Exit(err.Error())
+	}
+}
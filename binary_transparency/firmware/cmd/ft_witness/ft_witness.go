@@ -18,9 +18,13 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
 	"github.com/google/trillian-examples/binary_transparency/firmware/cmd/ft_witness/impl"
 	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
 	"golang.org/x/mod/sumdb/note"
@@ -28,24 +32,76 @@ import (
 
 var (
 	listenAddr   = flag.String("listen", ":8020", "address:port to listen for requests on")
-	wsFile       = flag.String("ws_db_file", "", "Path to a file to be used as simple file storage for checkpoint, e.g. /tmp/witness.db")
+	wsFile       = flag.String("ws_db_file", "", "Path to a sqlite database file to store the witness checkpoints in, e.g. /tmp/witness.db")
 	ftLogURL     = flag.String("ftlog", "http://localhost:8000", "Base URL of FT Log server")
+	evidenceDir  = flag.String("evidence_dir", "", "Directory to store evidence of split views in, which is also served by the witness; if empty, split views are only logged")
+	config       = flag.String("config", "", "Path to a JSON file listing the logs to witness; if empty, only the log configured by --ftlog, --log_origin and --log_public_keys is witnessed")
 	pollInterval = flag.Duration("poll_interval", 5*time.Second, "Duration to wait between polling FT Log for new entries")
+	keyFile      = flag.String("signing_key_file", "", "Path to a file containing the note signer key used to countersign checkpoints, or empty to use the TEST/DEMO key")
+
+	logOrigin     = flag.String("log_origin", api.FTLogOrigin, "Origin line expected on checkpoints from the log")
+	logPublicKeys = flag.String("log_public_keys", crypto.TestFTPersonalityPub, "Comma separated note verifier keys for the log; checkpoints signed by any of them are accepted")
 )
 
 func main() {
 	flag.Parse()
 
-	testVerifier, _ := note.NewVerifier(crypto.TestFTPersonalityPub)
+	logs, err := witnessedLogs()
+	if err != nil {
+		glog.Exitf("Failed to configure logs: %v", err)
+	}
+
+	signer, err := witnessSigner()
+	if err != nil {
+		glog.Exitf("Failed to create witness signer: %v", err)
+	}
 
 	ctx := context.Background()
+	if flag.Arg(0) == "compare" {
+		// Compare checkpoints from other parties, given as files or URLs, with the witness's.
+		if err := impl.Compare(ctx, impl.CompareOpts{
+			WSFile:      *wsFile,
+			Logs:        logs,
+			EvidenceDir: *evidenceDir,
+			Sources:     flag.Args()[1:],
+		}); err != nil {
+			glog.Exit(err.Error())
+		}
+		return
+	}
 	if err := impl.Main(ctx, impl.WitnessOpts{
-		ListenAddr:       *listenAddr,
-		WSFile:           *wsFile,
-		FtLogURL:         *ftLogURL,
-		FtLogSigVerifier: testVerifier,
-		PollInterval:     *pollInterval,
+		ListenAddr:   *listenAddr,
+		WSFile:       *wsFile,
+		Logs:         logs,
+		Signer:       signer,
+		PollInterval: *pollInterval,
+		EvidenceDir:  *evidenceDir,
 	}); err != nil {
 		glog.Exit(err.Error())
 	}
 }
+
+// witnessedLogs returns the logs listed in --config, or the single log configured by flags.
+func witnessedLogs() ([]impl.LogConfig, error) {
+	if len(*config) > 0 {
+		return impl.LoadConfig(*config)
+	}
+	lv, err := api.NewLogVerifier(*logOrigin, strings.Split(*logPublicKeys, ",")...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log verifier: %w", err)
+	}
+	return []impl.LogConfig{{Verifier: lv, URL: *ftLogURL}}, nil
+}
+
+// witnessSigner returns the signer for the key in --signing_key_file, or the TEST/DEMO key if none is provided.
+func witnessSigner() (note.Signer, error) {
+	if len(*keyFile) == 0 {
+		glog.Warning("No --signing_key_file provided; countersigning checkpoints with the TEST/DEMO key")
+		return note.NewSigner(crypto.TestWitnessPriv)
+	}
+	k, err := os.ReadFile(*keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	return note.NewSigner(strings.TrimSpace(string(k)))
+}
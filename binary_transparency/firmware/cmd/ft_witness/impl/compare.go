@@ -0,0 +1,124 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package impl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/golang/glog"
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/client"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/evidence"
+)
+
+// CompareOpts encapsulates options for comparing checkpoints from other parties
+// against those held by the witness.
+type CompareOpts struct {
+	WSFile      string
+	Logs        []LogConfig
+	EvidenceDir string
+	// Sources are files or URLs of checkpoints from other parties, e.g. other witnesses.
+	Sources []string
+}
+
+// Compare checks each checkpoint from the sources against the witness checkpoint for
+// the same log, and stores evidence of any split views. An error is returned if any
+// split view is found.
+func Compare(ctx context.Context, opts CompareOpts) error {
+	if len(opts.WSFile) == 0 {
+		return errors.New("Witness Store file is required")
+	}
+	ws, err := openStore(opts.WSFile, opts.Logs)
+	if err != nil {
+		return err
+	}
+	ev, err := openEvidence(opts.EvidenceDir)
+	if err != nil {
+		return err
+	}
+
+	var splits int
+	for _, src := range opts.Sources {
+		theirs, err := evidence.ReadCheckpoint(src)
+		if err != nil {
+			return fmt.Errorf("failed to read checkpoint from %q: %w", src, err)
+		}
+		l, err := logForCheckpoint(opts.Logs, theirs)
+		if err != nil {
+			return fmt.Errorf("checkpoint from %q: %w", src, err)
+		}
+		oursRaw, err := ws.RetrieveCP(l.logID())
+		if err != nil {
+			return fmt.Errorf("failed to read witness checkpoint: %w", err)
+		}
+		if len(oursRaw) == 0 {
+			glog.Warningf("No witness checkpoint for log %q to compare with %q", l.Verifier.Origin, src)
+			continue
+		}
+		cp, _, err := api.VerifyCheckpoint(oursRaw, l.Verifier)
+		if err != nil {
+			return fmt.Errorf("failed to open witness checkpoint: %w", err)
+		}
+		ours := api.LogCheckpoint{Checkpoint: *cp, Envelope: oursRaw}
+
+		sv, err := evidence.Compare(ours, theirs, l.Verifier, consistencyFunc(l))
+		if err != nil {
+			return fmt.Errorf("failed to compare with checkpoint from %q: %w", src, err)
+		}
+		if sv == nil {
+			glog.Infof("Checkpoint from %q is consistent with the witness", src)
+			continue
+		}
+		ev.Record(*sv)
+		splits++
+	}
+	if splits > 0 {
+		return fmt.Errorf("found %d split views", splits)
+	}
+	return nil
+}
+
+// logForCheckpoint returns the log which signed the checkpoint.
+func logForCheckpoint(logs []LogConfig, cpRaw []byte) (LogConfig, error) {
+	for _, l := range logs {
+		if _, _, err := api.VerifyCheckpoint(cpRaw, l.Verifier); err == nil {
+			return l, nil
+		}
+	}
+	return LogConfig{}, errors.New("not signed by any witnessed log")
+}
+
+// consistencyFunc returns a function to fetch consistency proofs from the log, or nil
+// if the witness doesn't know where the log is.
+func consistencyFunc(l LogConfig) evidence.ConsistencyProofFunc {
+	if len(l.URL) == 0 {
+		return nil
+	}
+	return func(from, to uint64) ([][]byte, error) {
+		u, err := url.Parse(l.URL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse FT log URL: %w", err)
+		}
+		c := client.ReadonlyClient{LogURL: u, LogSigVerifier: l.Verifier}
+		cp, err := c.GetConsistencyProof(api.GetConsistencyRequest{From: from, To: to})
+		if err != nil {
+			return nil, err
+		}
+		return cp.Proof, nil
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package impl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+)
+
+// Config is the serialized form of the logs followed by the witness.
+type Config struct {
+	Logs []ConfigLog
+}
+
+// ConfigLog describes a single log in the Config.
+type ConfigLog struct {
+	// ID identifies the log in the witness API, and defaults to api.WitnessLogID of Origin and the first of PublicKeys.
+	ID string
+	// Origin is the expected first line of checkpoints from the log.
+	Origin string
+	// PublicKeys are the note verifier keys of the log.
+	PublicKeys []string
+	// URL is the base URL of an FT log to poll. Other logs, e.g. the sumdb or serverless
+	// logs, can't be polled by the witness so must push checkpoints to it.
+	URL string
+}
+
+// LoadConfig reads a JSON encoded Config from the given file.
+func LoadConfig(path string) ([]LogConfig, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read witness config: %w", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(bs, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse witness config: %w", err)
+	}
+	if len(cfg.Logs) == 0 {
+		return nil, errors.New("no logs in witness config")
+	}
+	var logs []LogConfig
+	for i, l := range cfg.Logs {
+		if len(l.Origin) == 0 {
+			return nil, fmt.Errorf("log %d: Origin is required", i)
+		}
+		lv, err := api.NewLogVerifier(l.Origin, l.PublicKeys...)
+		if err != nil {
+			return nil, fmt.Errorf("log %d: %w", i, err)
+		}
+		logs = append(logs, LogConfig{ID: l.ID, Verifier: lv, URL: l.URL})
+	}
+	return logs, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package impl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
+)
+
+func TestLoadConfig(t *testing.T) {
+	for _, test := range []struct {
+		desc       string
+		config     string
+		wantOrigin []string
+		wantErr    bool
+	}{
+		{
+			desc:       "two logs",
+			config:     `{"Logs": [{"Origin": "` + api.FTLogOrigin + `", "PublicKeys": ["` + crypto.TestFTPersonalityPub + `"], "URL": "http://localhost:8000"}, {"ID": "other", "Origin": "other", "PublicKeys": ["` + crypto.TestWitnessPub + `"]}]}`,
+			wantOrigin: []string{api.FTLogOrigin, "other"},
+		}, {
+			desc:    "no logs",
+			config:  `{"Logs": []}`,
+			wantErr: true,
+		}, {
+			desc:    "no keys",
+			config:  `{"Logs": [{"Origin": "other"}]}`,
+			wantErr: true,
+		}, {
+			desc:    "no origin",
+			config:  `{"Logs": [{"PublicKeys": ["` + crypto.TestWitnessPub + `"]}]}`,
+			wantErr: true,
+		}, {
+			desc:    "garbage",
+			config:  `garbage`,
+			wantErr: true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(test.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			logs, err := LoadConfig(path)
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("unexpected error: %v", err)
+			case err == nil && test.wantErr:
+				t.Fatal("expected error, got none")
+			case err != nil && test.wantErr:
+				return
+			}
+			if len(logs) != len(test.wantOrigin) {
+				t.Fatalf("got %d logs, want %d", len(logs), len(test.wantOrigin))
+			}
+			for i, l := range logs {
+				if got, want := l.Verifier.Origin, test.wantOrigin[i]; got != want {
+					t.Errorf("log %d: got origin %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
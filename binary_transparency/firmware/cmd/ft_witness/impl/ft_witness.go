@@ -17,26 +17,128 @@
 package impl
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
 	ih "github.com/google/trillian-examples/binary_transparency/firmware/cmd/ft_witness/internal/http"
 	"github.com/google/trillian-examples/binary_transparency/firmware/cmd/ft_witness/internal/ws"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/evidence"
 	"github.com/gorilla/mux"
 	"golang.org/x/mod/sumdb/note"
+
+	_ "github.com/mattn/go-sqlite3" // Load drivers for sqlite3
 )
 
 // WitnessOpts encapsulates options for running an FT witness.
 type WitnessOpts struct {
-	ListenAddr       string
-	WSFile           string
-	FtLogURL         string
-	FtLogSigVerifier note.Verifier
-	PollInterval     time.Duration
+	ListenAddr string
+	// WSFile is the path of the sqlite database holding the witness checkpoints.
+	WSFile string
+	// Logs are the logs to witness. The first is served on the API paths without a log ID.
+	Logs []LogConfig
+	// Signer countersigns each checkpoint the witness accepts.
+	Signer       note.Signer
+	PollInterval time.Duration
+	// EvidenceDir is where evidence of split views is stored and served from.
+	// If empty, split views are only logged.
+	EvidenceDir string
+}
+
+// LogConfig describes a log followed by the witness.
+type LogConfig struct {
+	// ID identifies the log in the witness API. If empty, api.WitnessLogID of the origin and first key is used.
+	ID string
+	// Verifier checks the origin and signatures of the log's checkpoints.
+	Verifier api.LogVerifier
+	// URL is the base URL of an FT log to poll for new checkpoints.
+	// If empty, checkpoints must be pushed to the witness.
+	URL string
+}
+
+// logID returns the ID of the log in the witness API.
+func (l LogConfig) logID() string {
+	if len(l.ID) > 0 {
+		return l.ID
+	}
+	return api.WitnessLogID(l.Verifier.Origin, l.Verifier.Verifiers[0])
+}
+
+// sqliteHeader starts every non-empty sqlite database file.
+const sqliteHeader = "SQLite format 3\x00"
+
+// openStore opens the Witness Store in the sqlite database at the given path.
+// A store written by earlier versions of the witness, which was a plain file holding the
+// checkpoint of a single log, is migrated to a database holding it as the checkpoint of
+// the first of the logs.
+func openStore(path string, logs []LogConfig) (*ws.Storage, error) {
+	legacy, err := readLegacyStore(path)
+	if err != nil {
+		return nil, err
+	}
+	if legacy != nil {
+		if len(logs) == 0 {
+			return nil, fmt.Errorf("witness store %q is a legacy single log store, but no log is configured to migrate it to", path)
+		}
+		if _, _, err := api.VerifyCheckpoint(legacy, logs[0].Verifier); err != nil {
+			return nil, fmt.Errorf("witness store %q is a legacy single log store whose checkpoint isn't from log %q; move it aside to start afresh: %w", path, logs[0].Verifier.Origin, err)
+		}
+		backup := path + ".legacy"
+		if err := os.Rename(path, backup); err != nil {
+			return nil, fmt.Errorf("failed to move legacy witness store aside: %w", err)
+		}
+		glog.Infof("Migrating legacy witness store %q to log %q; the original is kept in %q", path, logs[0].logID(), backup)
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open witness store DB: %w", err)
+	}
+	s, err := ws.NewStorage(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect witness store: %w", err)
+	}
+	if legacy != nil {
+		if err := s.StoreCP(logs[0].logID(), legacy); err != nil {
+			return nil, fmt.Errorf("failed to migrate legacy witness store: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// readLegacyStore returns the checkpoint in the file at path if it is a legacy witness store,
+// or nil if the file is missing, empty, or a sqlite database.
+func readLegacyStore(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read witness store: %w", err)
+	case len(b) == 0, bytes.HasPrefix(b, []byte(sqliteHeader)):
+		return nil, nil
+	}
+	return b, nil
+}
+
+// openEvidence returns the store for evidence in dir, or nil if dir is empty.
+func openEvidence(dir string) (*evidence.Store, error) {
+	if len(dir) == 0 {
+		glog.Warning("No evidence directory configured; split views will only be logged")
+		return nil, nil
+	}
+	ev, err := evidence.NewStore(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open evidence store: %w", err)
+	}
+	return ev, nil
 }
 
 // Main kickstarts the witness
@@ -44,14 +146,29 @@ func Main(ctx context.Context, opts WitnessOpts) error {
 	if len(opts.WSFile) == 0 {
 		return errors.New("Witness Store file is required")
 	}
+	if opts.Signer == nil {
+		return errors.New("witness signer is required")
+	}
+	if len(opts.Logs) == 0 {
+		return errors.New("at least one log is required")
+	}
+	var logs []ih.Log
+	for _, l := range opts.Logs {
+		glog.Infof("Witnessing log %q with ID %s", l.Verifier.Origin, l.logID())
+		logs = append(logs, ih.Log{ID: l.logID(), Verifier: l.Verifier, URL: l.URL})
+	}
 
-	ws, err := ws.NewStorage(opts.WSFile)
+	ws, err := openStore(opts.WSFile, opts.Logs)
+	if err != nil {
+		return err
+	}
+	ev, err := openEvidence(opts.EvidenceDir)
 	if err != nil {
-		return fmt.Errorf("failed to connect witness store: %w", err)
+		return err
 	}
 
 	glog.Infof("Starting FT witness server...")
-	witness, err := ih.NewWitness(ws, opts.FtLogURL, opts.FtLogSigVerifier, opts.PollInterval)
+	witness, err := ih.NewWitness(ws, logs, opts.Signer, opts.PollInterval, ev)
 	if err != nil {
 		return fmt.Errorf("failed to create new witness: %w", err)
 	}
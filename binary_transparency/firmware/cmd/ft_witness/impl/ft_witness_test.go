@@ -0,0 +1,108 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package impl
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
+	"golang.org/x/mod/sumdb/note"
+)
+
+func TestOpenStoreMigratesLegacy(t *testing.T) {
+	s, err := note.NewSigner(crypto.TestFTPersonalityPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp, err := note.Sign(&note.Note{Text: api.FTLogOrigin + "\n5\nAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=\n"}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ftVerifier, err := api.NewLogVerifier(api.FTLogOrigin, crypto.TestFTPersonalityPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherVerifier, err := api.NewLogVerifier("other", crypto.TestWitnessPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		desc    string
+		legacy  []byte
+		logs    []LogConfig
+		wantCP  []byte
+		wantErr bool
+	}{
+		{
+			desc:   "no store",
+			logs:   []LogConfig{{Verifier: ftVerifier}},
+			wantCP: nil,
+		}, {
+			desc:   "legacy store",
+			legacy: cp,
+			logs:   []LogConfig{{Verifier: ftVerifier}, {Verifier: otherVerifier}},
+			wantCP: cp,
+		}, {
+			desc:    "legacy store for another log",
+			legacy:  cp,
+			logs:    []LogConfig{{Verifier: otherVerifier}, {Verifier: ftVerifier}},
+			wantErr: true,
+		}, {
+			desc:    "legacy store garbage",
+			legacy:  []byte("garbage"),
+			logs:    []LogConfig{{Verifier: ftVerifier}},
+			wantErr: true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ws.db")
+			if test.legacy != nil {
+				if err := os.WriteFile(path, test.legacy, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ws, err := openStore(path, test.logs)
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("Got unexpected error %q", err)
+			case err == nil && test.wantErr:
+				t.Fatal("Got no error, but wanted error")
+			case err != nil && test.wantErr:
+				// The legacy store must be left untouched.
+				if got, _ := os.ReadFile(path); !bytes.Equal(got, test.legacy) {
+					t.Errorf("legacy store modified: got %q, want %q", got, test.legacy)
+				}
+				return
+			}
+			got, err := ws.RetrieveCP(test.logs[0].logID())
+			if err != nil {
+				t.Fatalf("RetrieveCP(): %v", err)
+			}
+			if !bytes.Equal(got, test.wantCP) {
+				t.Errorf("got checkpoint %q, want %q", got, test.wantCP)
+			}
+
+			// Reopening the migrated store must not migrate again.
+			if _, err := openStore(path, test.logs); err != nil {
+				t.Fatalf("reopening store: %v", err)
+			}
+		})
+	}
+}
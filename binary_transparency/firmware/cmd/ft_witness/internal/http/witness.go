@@ -16,7 +16,10 @@
 package http
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -26,60 +29,254 @@ import (
 	"github.com/golang/glog"
 	"github.com/google/trillian-examples/binary_transparency/firmware/api"
 	"github.com/google/trillian-examples/binary_transparency/firmware/internal/client"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/evidence"
+	"github.com/google/trillian-examples/formats/checkpoints"
 	"github.com/gorilla/mux"
+	"github.com/transparency-dev/merkle/proof"
+	"github.com/transparency-dev/merkle/rfc6962"
 	"golang.org/x/mod/sumdb/note"
 )
 
 // WitnessStore is the interface to the  Witness Store, for storage of latest checkpoint
 type WitnessStore interface {
-	// Store puts the checkpoint into Witness Store
-	StoreCP([]byte) error
+	// Store puts the checkpoint for the log into Witness Store
+	StoreCP(logID string, cp []byte) error
 
-	// Retrieve gets the stored checkpoint.
-	RetrieveCP() ([]byte, error)
+	// Retrieve gets the stored checkpoint for the log, or nil if there is none.
+	RetrieveCP(logID string) ([]byte, error)
+}
+
+// Log is a log followed by the witness.
+type Log struct {
+	// ID identifies the log in the witness API and the Witness Store.
+	ID string
+	// Verifier checks the origin and signatures of the log's checkpoints.
+	Verifier api.LogVerifier
+	// URL is the base URL of an FT log to poll for new checkpoints.
+	// If empty, checkpoints must be pushed to the witness.
+	URL string
+}
+
+// logState is the witness's view of a single log.
+type logState struct {
+	Log
+	// gcp is the latest checkpoint accepted by the witness, and its Envelope
+	// is countersigned by the witness.
+	gcp api.LogCheckpoint
+	mu  sync.Mutex
 }
 
 // Witness is the core state & handler implementation of the FT Witness
 type Witness struct {
-	ws             WitnessStore
-	gcp            api.LogCheckpoint
-	logURL         string
-	logSigVerifier note.Verifier
-	pollInterval   time.Duration
-	witnessLock    sync.Mutex
+	ws   WitnessStore
+	logs map[string]*logState
+	// defaultLog is the log served on paths without a log ID, as they were before
+	// the witness supported multiple logs.
+	defaultLog   *logState
+	signer       note.Signer
+	pollInterval time.Duration
+	// evidence stores proof of any split views found, or is nil if they are only logged.
+	evidence *evidence.Store
 }
 
-// NewWitness creates a new Witness.
-func NewWitness(ws WitnessStore, logURL string, logSigVerifier note.Verifier, pollInterval time.Duration) (*Witness, error) {
-	gcpRaw, err := ws.RetrieveCP()
-	if err != nil {
-		return nil, fmt.Errorf("new witness failed due to storage retrieval: %w", err)
+// NewWitness creates a new Witness for the given logs, which countersigns the checkpoints
+// it accepts with signer. The first log is the default log. Evidence of split views is
+// kept in ev, which may be nil.
+func NewWitness(ws WitnessStore, logs []Log, signer note.Signer, pollInterval time.Duration, ev *evidence.Store) (*Witness, error) {
+	if len(logs) == 0 {
+		return nil, errors.New("at least one log is required")
 	}
-	gcp := api.LogCheckpoint{
-		Envelope: gcpRaw,
+	s := &Witness{
+		ws:           ws,
+		logs:         make(map[string]*logState),
+		signer:       signer,
+		pollInterval: pollInterval,
+		evidence:     ev,
 	}
-	if len(gcpRaw) > 0 {
-		cp, err := api.ParseCheckpoint(gcpRaw, logSigVerifier)
+	for _, l := range logs {
+		if _, ok := s.logs[l.ID]; ok {
+			return nil, fmt.Errorf("duplicate log ID %q", l.ID)
+		}
+		ls := &logState{Log: l}
+		gcpRaw, err := ws.RetrieveCP(l.ID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to open stored checkpoint: %w", err)
+			return nil, fmt.Errorf("new witness failed due to storage retrieval: %w", err)
+		}
+		if len(gcpRaw) > 0 {
+			cp, err := parseCheckpoint(gcpRaw, l.Verifier)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open stored checkpoint for log %q: %w", l.ID, err)
+			}
+			// Checkpoints stored before the witness countersigned them still need a signature.
+			if cp.Envelope, err = s.cosign(ls, cp.Envelope); err != nil {
+				return nil, fmt.Errorf("failed to countersign stored checkpoint for log %q: %w", l.ID, err)
+			}
+			ls.gcp = *cp
+		}
+		s.logs[l.ID] = ls
+		if s.defaultLog == nil {
+			s.defaultLog = ls
+		}
+	}
+	return s, nil
+}
+
+// parseCheckpoint verifies a checkpoint from the log. Only the fields common to all
+// logs are parsed, so the checkpoint's TimestampNanos is not set.
+func parseCheckpoint(cpRaw []byte, lv api.LogVerifier) (*api.LogCheckpoint, error) {
+	cp, _, err := api.VerifyCheckpoint(cpRaw, lv)
+	if err != nil {
+		return nil, err
+	}
+	return &api.LogCheckpoint{Checkpoint: *cp, Envelope: cpRaw}, nil
+}
+
+// cosign adds the witness signature to a checkpoint note which has been verified as
+// signed by the log. Only signatures from the log's keys and the witness are kept.
+//
+// checkpoints.Combine expects a single log key, but a log may sign with several while it
+// rotates them. The key that made the first signature is used as the log key, and the
+// others are passed to it with the witness key, so that their signatures are verified
+// and kept rather than dropped.
+func (s *Witness) cosign(l *logState, cpRaw []byte) ([]byte, error) {
+	n, err := note.Open(cpRaw, note.VerifierList(l.Verifier.Verifiers...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint: %w", err)
+	}
+	var logV note.Verifier
+	others := []note.Verifier{signerVerifier{s.signer}}
+	for _, v := range l.Verifier.Verifiers {
+		if logV == nil && v.KeyHash() == n.Sigs[0].Hash && v.Name() == n.Sigs[0].Name {
+			logV = v
+			continue
 		}
-		gcp = *cp
+		others = append(others, v)
+	}
+	// Signing n keeps the log's signatures on it, which checkpoints.Combine requires.
+	cosigned, err := note.Sign(n, s.signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign checkpoint: %w", err)
+	}
+	return checkpoints.Combine([][]byte{cpRaw, cosigned}, logV, note.VerifierList(others...))
+}
+
+// signerVerifier verifies signatures made by a deterministic signer, such as the Ed25519
+// signers returned by note.NewSigner, by signing the message again. This lets the witness
+// check its own signatures without being configured with its public key.
+type signerVerifier struct {
+	note.Signer
+}
+
+func (v signerVerifier) Verify(msg, sig []byte) bool {
+	want, err := v.Sign(msg)
+	return err == nil && bytes.Equal(sig, want)
+}
+
+// checkConsistency verifies that cp is consistent with the witness checkpoint for the log
+// using the consistency proof between them. The caller must hold l.mu.
+func (s *Witness) checkConsistency(l *logState, cp api.LogCheckpoint, consistency [][]byte) error {
+	if cp.Size < l.gcp.Size {
+		return fmt.Errorf("checkpoint size %d is smaller than witness checkpoint size %d", cp.Size, l.gcp.Size)
+	}
+	if l.gcp.Size == 0 {
+		// Nothing to be consistent with, so the first checkpoint is trusted.
+		return nil
+	}
+	return proof.VerifyConsistency(rfc6962.DefaultHasher, l.gcp.Size, cp.Size, consistency, l.gcp.Hash, cp.Hash)
+}
+
+// storeCheckpoint countersigns and stores cp as the witness checkpoint for the log, which
+// must already have been checked for consistency. The caller must hold l.mu.
+func (s *Witness) storeCheckpoint(l *logState, cp api.LogCheckpoint) error {
+	var err error
+	if cp.Envelope, err = s.cosign(l, cp.Envelope); err != nil {
+		return fmt.Errorf("failed to countersign checkpoint: %w", err)
+	}
+	if err := s.ws.StoreCP(l.ID, cp.Envelope); err != nil {
+		return fmt.Errorf("failed to save new logcheckpoint into store: %w", err)
 	}
+	l.gcp = cp
+	return nil
+}
 
-	return &Witness{
-		ws:             ws,
-		gcp:            gcp,
-		logURL:         logURL,
-		logSigVerifier: logSigVerifier,
-		pollInterval:   pollInterval,
-	}, nil
+// logForRequest returns the log identified in the request path, or the default log if
+// there is no log ID in the path. It writes an error response if the log is unknown.
+func (s *Witness) logForRequest(w http.ResponseWriter, r *http.Request) *logState {
+	id, ok := mux.Vars(r)["logid"]
+	if !ok {
+		return s.defaultLog
+	}
+	l, ok := s.logs[id]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown log %q", id), http.StatusNotFound)
+		return nil
+	}
+	return l
 }
 
-// getCheckpoint returns a checkpoint which is registered with witness
+// getCheckpoint returns the latest checkpoint accepted by the witness, countersigned by it.
 func (s *Witness) getCheckpoint(w http.ResponseWriter, r *http.Request) {
-	s.witnessLock.Lock()
+	l := s.logForRequest(w, r)
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	w.Header().Set("Content-Type", "text/plain")
-	if _, err := w.Write(s.gcp.Envelope); err != nil {
+	if _, err := w.Write(l.gcp.Envelope); err != nil {
+		glog.Errorf("w.Write(): %v", err)
+	}
+}
+
+// addCheckpoint accepts a checkpoint pushed to the witness by the log or a distributor,
+// and returns the witness checkpoint after it has been applied.
+func (s *Witness) addCheckpoint(w http.ResponseWriter, r *http.Request) {
+	l := s.logForRequest(w, r)
+	if l == nil {
+		return
+	}
+	var req api.AddCheckpointRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("failed to decode request: %v", err), http.StatusBadRequest)
+		return
+	}
+	cp, err := parseCheckpoint(req.Checkpoint, l.Verifier)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid checkpoint: %v", err), http.StatusForbidden)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain")
+	if req.OldSize != l.gcp.Size {
+		// The submitter has an out of date view of the witness, so tell it what we have.
+		w.WriteHeader(http.StatusConflict)
+		if _, err := w.Write(l.gcp.Envelope); err != nil {
+			glog.Errorf("w.Write(): %v", err)
+		}
+		return
+	}
+	if cp.Size < l.gcp.Size {
+		http.Error(w, fmt.Sprintf("checkpoint size %d is smaller than old size %d", cp.Size, req.OldSize), http.StatusBadRequest)
+		return
+	}
+	if err := s.checkConsistency(l, *cp, req.Proof); err != nil {
+		// The proof comes from the submitter rather than the log, so a failure is only
+		// evidence against the log if there can be no valid proof.
+		if cp.Size == l.gcp.Size {
+			s.evidence.Record(evidence.New(l.gcp, *cp, nil))
+		}
+		http.Error(w, fmt.Sprintf("failed to verify consistency proof: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+	if cp.Size > l.gcp.Size {
+		if err := s.storeCheckpoint(l, *cp); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if _, err := w.Write(l.gcp.Envelope); err != nil {
 		glog.Errorf("w.Write(): %v", err)
 	}
 }
@@ -87,41 +284,117 @@ func (s *Witness) getCheckpoint(w http.ResponseWriter, r *http.Request) {
 // RegisterHandlers registers HTTP handlers for firmware transparency endpoints.
 func (s *Witness) RegisterHandlers(r *mux.Router) {
 	r.HandleFunc(fmt.Sprintf("/%s", api.WitnessGetCheckpoint), s.getCheckpoint).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/%s/{logid}", api.WitnessGetCheckpoint), s.getCheckpoint).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/%s", api.WitnessAddCheckpoint), s.addCheckpoint).Methods("POST")
+	r.HandleFunc(fmt.Sprintf("/%s/{logid}", api.WitnessAddCheckpoint), s.addCheckpoint).Methods("POST")
+	if s.evidence != nil {
+		prefix := fmt.Sprintf("/%s", api.WitnessEvidence)
+		r.PathPrefix(prefix).Handler(http.StripPrefix(prefix, evidence.Handler(s.evidence))).Methods("GET")
+	}
 }
 
-// Poll periodically polls the FT log for updating the witness checkpoint.
-// It only returns on error (when it doesn't start its own polling thread)
+// Poll periodically polls the FT logs which have a URL for updating the witness checkpoints,
+// until the context is done. Each log is polled independently, so if polling one log fails,
+// e.g. because its URL is invalid or it has presented a split view, that is logged and the
+// other logs are still polled. Once polling has stopped, the failures are returned.
 func (s *Witness) Poll(ctx context.Context) error {
-	ftURL, err := url.Parse(s.logURL)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	for _, l := range s.logs {
+		if len(l.URL) == 0 {
+			continue
+		}
+		l := l
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.pollLog(ctx, l)
+			if err == nil || ctx.Err() != nil {
+				return
+			}
+			glog.Errorf("Stopped polling log %q: %v", l.ID, err)
+			mu.Lock()
+			defer mu.Unlock()
+			errs = append(errs, fmt.Errorf("log %q: %w", l.ID, err))
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return ctx.Err()
+}
+
+// pollLog follows a single FT log, updating its witness checkpoint.
+func (s *Witness) pollLog(ctx context.Context, l *logState) error {
+	ftURL, err := url.Parse(l.URL)
 	if err != nil {
 		return fmt.Errorf("failed to parse FT log URL: %w", err)
 	}
 	c := client.ReadonlyClient{
 		LogURL:         ftURL,
-		LogSigVerifier: s.logSigVerifier,
+		LogSigVerifier: l.Verifier,
 	}
-	follow := client.NewLogFollower(c)
+	// Only checkpoints are followed, so no claimants are needed to verify entries.
+	follow := client.NewLogFollower(c, nil)
 
 	glog.Infof("Polling FT log %q...", ftURL)
-	cpc, cperrc := follow.Checkpoints(ctx, s.pollInterval, s.gcp)
+	l.mu.Lock()
+	start := l.gcp
+	l.mu.Unlock()
+	cpc, cperrc := follow.Checkpoints(ctx, s.pollInterval, start)
 
 	for {
 		var cp api.LogCheckpoint
 		select {
 		case err = <-cperrc:
+			var cerr client.ErrConsistency
+			if errors.As(err, &cerr) {
+				s.evidence.Record(evidence.New(cerr.Golden, cerr.Latest, cerr.Proof))
+			}
 			return err
 		case <-ctx.Done():
 			return ctx.Err()
 		case cp = <-cpc:
 		}
 
-		s.witnessLock.Lock()
-		if err = s.ws.StoreCP(cp.Envelope); err != nil {
-			glog.Warningf("Failed to save new logcheckpoint into store: %q", err)
-			s.witnessLock.Unlock()
+		// Checkpoints may also have been pushed to the witness since the follower's last
+		// one, so check consistency against the witness checkpoint rather than relying on
+		// the follower.
+		l.mu.Lock()
+		from := l.gcp.Size
+		l.mu.Unlock()
+		if cp.Size <= from {
 			continue
 		}
-		s.gcp = cp
-		s.witnessLock.Unlock()
+		var consistency [][]byte
+		if from > 0 {
+			cpr, err := c.GetConsistencyProof(api.GetConsistencyRequest{From: from, To: cp.Size})
+			if err != nil {
+				glog.Warningf("Failed to fetch consistency proof: %q", err)
+				continue
+			}
+			consistency = cpr.Proof
+		}
+		if err := s.updateFromPoll(l, cp, from, consistency); err != nil {
+			glog.Warningf("Failed to update witness checkpoint for log %q: %q", l.ID, err)
+		}
+	}
+}
+
+// updateFromPoll stores a checkpoint from the log if the witness checkpoint is still at size
+// from, and the checkpoint is consistent with it.
+func (s *Witness) updateFromPoll(l *logState, cp api.LogCheckpoint, from uint64, consistency [][]byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.gcp.Size != from {
+		return fmt.Errorf("witness checkpoint moved from size %d to %d while polling", from, l.gcp.Size)
+	}
+	if err := s.checkConsistency(l, cp, consistency); err != nil {
+		// Both the checkpoint and the proof came from the log.
+		s.evidence.Record(evidence.New(l.gcp, cp, consistency))
+		return fmt.Errorf("checkpoint is not consistent with witness checkpoint: %w", err)
 	}
+	return s.storeCheckpoint(l, cp)
 }
@@ -15,13 +15,25 @@
 package http
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
 	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/evidence"
+	"github.com/gorilla/mux"
+	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/merkle/testonly"
 	"golang.org/x/mod/sumdb/note"
 )
 
@@ -33,6 +45,9 @@ const (
 func TestGetWitnessCheckpoint(t *testing.T) {
 	testSigner, _ := note.NewSigner(crypto.TestFTPersonalityPriv)
 	testVerifier, _ := note.NewVerifier(crypto.TestFTPersonalityPub)
+	logVerifier := api.LogVerifier{Origin: api.FTLogOrigin, Verifiers: []note.Verifier{testVerifier}}
+	witnessSigner, _ := note.NewSigner(crypto.TestWitnessPriv)
+	witnessVerifier, _ := note.NewVerifier(crypto.TestWitnessPub)
 	for _, test := range []struct {
 		desc     string
 		wantBody string
@@ -50,7 +65,7 @@ func TestGetWitnessCheckpoint(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Failed to sign checkpoint: %v", err)
 			}
-			witness, err := NewWitness(&FakeStore{ns, true}, dummyURL, testVerifier, dummyPollInterval)
+			witness, err := NewWitness(&FakeStore{map[string][]byte{"ft": ns}, true}, []Log{{ID: "ft", Verifier: logVerifier, URL: dummyURL}}, witnessSigner, dummyPollInterval, nil)
 			if err != nil {
 				t.Fatalf("error creating witness: %v", err)
 			}
@@ -69,19 +84,25 @@ func TestGetWitnessCheckpoint(t *testing.T) {
 			if err != nil {
 				t.Errorf("failed to read body: %v", err)
 			}
-			got, err := note.Open(body, note.VerifierList(testVerifier))
+			got, err := note.Open(body, note.VerifierList(testVerifier, witnessVerifier))
 			if err != nil {
 				t.Fatalf("Failed to open returned body: %v :\n%s", err, body)
 			}
 			if got.Text != test.wantBody {
 				t.Errorf("got '%s' want '%s'", got.Text, test.wantBody)
 			}
+			// The checkpoint should be signed by both the log and the witness.
+			if len(got.Sigs) != 2 {
+				t.Errorf("got %d signatures, want 2", len(got.Sigs))
+			}
 		})
 	}
 }
 
 func TestFailedWitnessCreation(t *testing.T) {
 	testVerifier, _ := note.NewVerifier(crypto.TestFTPersonalityPub)
+	logVerifier := api.LogVerifier{Origin: api.FTLogOrigin, Verifiers: []note.Verifier{testVerifier}}
+	witnessSigner, _ := note.NewSigner(crypto.TestWitnessPriv)
 	for _, test := range []struct {
 		desc      string
 		wantError string
@@ -92,7 +113,7 @@ func TestFailedWitnessCreation(t *testing.T) {
 		},
 	} {
 		t.Run(test.desc, func(t *testing.T) {
-			_, err := NewWitness(&FakeStore{[]byte{}, false}, dummyURL, testVerifier, dummyPollInterval)
+			_, err := NewWitness(&FakeStore{nil, false}, []Log{{ID: "ft", Verifier: logVerifier, URL: dummyURL}}, witnessSigner, dummyPollInterval, nil)
 			if err == nil {
 				t.Errorf("error witness creation happened smoothly: %v", err)
 			}
@@ -104,22 +125,444 @@ func TestFailedWitnessCreation(t *testing.T) {
 	}
 }
 
+func TestAddCheckpoint(t *testing.T) {
+	logSigner, _ := note.NewSigner(crypto.TestFTPersonalityPriv)
+	logVerifier, _ := note.NewVerifier(crypto.TestFTPersonalityPub)
+	lv := api.LogVerifier{Origin: api.FTLogOrigin, Verifiers: []note.Verifier{logVerifier}}
+	witnessSigner, _ := note.NewSigner(crypto.TestWitnessPriv)
+	witnessVerifier, _ := note.NewVerifier(crypto.TestWitnessPub)
+
+	tree := testonly.New(rfc6962.DefaultHasher)
+	for i := 0; i < 10; i++ {
+		tree.AppendData([]byte(fmt.Sprintf("leaf %d", i)))
+	}
+	checkpoint := func(size uint64, s note.Signer) []byte {
+		t.Helper()
+		text := fmt.Sprintf("%s\n%d\n%s\n123\n", api.FTLogOrigin, size, base64.StdEncoding.EncodeToString(tree.HashAt(size)))
+		n, err := note.Sign(&note.Note{Text: text}, s)
+		if err != nil {
+			t.Fatalf("Failed to sign checkpoint: %v", err)
+		}
+		return n
+	}
+	consistency := func(from, to uint64) [][]byte {
+		t.Helper()
+		p, err := tree.ConsistencyProof(from, to)
+		if err != nil {
+			t.Fatalf("Failed to build consistency proof: %v", err)
+		}
+		return p
+	}
+
+	witness, err := NewWitness(&FakeStore{map[string][]byte{}, true}, []Log{{ID: "ft", Verifier: lv}}, witnessSigner, dummyPollInterval, nil)
+	if err != nil {
+		t.Fatalf("error creating witness: %v", err)
+	}
+	r := mux.NewRouter()
+	witness.RegisterHandlers(r)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// Each step builds on the state left by the previous one.
+	for _, test := range []struct {
+		desc     string
+		body     []byte
+		req      api.AddCheckpointRequest
+		wantCode int
+		wantSize uint64
+	}{
+		{
+			desc:     "first checkpoint",
+			req:      api.AddCheckpointRequest{OldSize: 0, Checkpoint: checkpoint(3, logSigner)},
+			wantCode: http.StatusOK,
+			wantSize: 3,
+		}, {
+			desc:     "stale old size",
+			req:      api.AddCheckpointRequest{OldSize: 0, Checkpoint: checkpoint(5, logSigner)},
+			wantCode: http.StatusConflict,
+			wantSize: 3,
+		}, {
+			desc:     "bad proof",
+			req:      api.AddCheckpointRequest{OldSize: 3, Checkpoint: checkpoint(5, logSigner), Proof: consistency(2, 5)},
+			wantCode: http.StatusUnprocessableEntity,
+		}, {
+			desc:     "consistent",
+			req:      api.AddCheckpointRequest{OldSize: 3, Checkpoint: checkpoint(5, logSigner), Proof: consistency(3, 5)},
+			wantCode: http.StatusOK,
+			wantSize: 5,
+		}, {
+			desc:     "same checkpoint again",
+			req:      api.AddCheckpointRequest{OldSize: 5, Checkpoint: checkpoint(5, logSigner)},
+			wantCode: http.StatusOK,
+			wantSize: 5,
+		}, {
+			desc:     "smaller checkpoint",
+			req:      api.AddCheckpointRequest{OldSize: 5, Checkpoint: checkpoint(4, logSigner)},
+			wantCode: http.StatusBadRequest,
+		}, {
+			desc:     "not signed by log",
+			req:      api.AddCheckpointRequest{OldSize: 5, Checkpoint: checkpoint(10, witnessSigner), Proof: consistency(5, 10)},
+			wantCode: http.StatusForbidden,
+		}, {
+			desc:     "garbage",
+			body:     []byte("garbage"),
+			wantCode: http.StatusBadRequest,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			body := test.body
+			if body == nil {
+				var err error
+				if body, err = json.Marshal(test.req); err != nil {
+					t.Fatalf("json.Marshal(): %v", err)
+				}
+			}
+			resp, err := http.Post(fmt.Sprintf("%s/%s", ts.URL, api.WitnessAddCheckpoint), "application/json", bytes.NewReader(body))
+			if err != nil {
+				t.Fatalf("error response: %v", err)
+			}
+			defer resp.Body.Close()
+			if got, want := resp.StatusCode, test.wantCode; got != want {
+				t.Fatalf("got status %d, want %d", got, want)
+			}
+			if test.wantSize == 0 {
+				return
+			}
+			// Successful and conflicting requests return the witness checkpoint.
+			b, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("failed to read body: %v", err)
+			}
+			n, err := note.Open(b, note.VerifierList(logVerifier, witnessVerifier))
+			if err != nil {
+				t.Fatalf("Failed to open returned body: %v :\n%s", err, b)
+			}
+			if len(n.Sigs) != 2 {
+				t.Errorf("got %d signatures, want 2", len(n.Sigs))
+			}
+			cp, err := api.ParseCheckpoint(b, lv)
+			if err != nil {
+				t.Fatalf("Failed to parse returned checkpoint: %v", err)
+			}
+			if cp.Size != test.wantSize {
+				t.Errorf("got checkpoint size %d, want %d", cp.Size, test.wantSize)
+			}
+		})
+	}
+}
+
+func TestCosign(t *testing.T) {
+	witnessSigner, _ := note.NewSigner(crypto.TestWitnessPriv)
+	witnessVerifier, _ := note.NewVerifier(crypto.TestWitnessPub)
+	var signers []note.Signer
+	var verifiers []note.Verifier
+	for _, name := range []string{"old_log_key", "new_log_key", "junk"} {
+		skey, vkey, err := note.GenerateKey(rand.Reader, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, _ := note.NewSigner(skey)
+		v, _ := note.NewVerifier(vkey)
+		signers = append(signers, s)
+		verifiers = append(verifiers, v)
+	}
+	// The log is rotating from the old key to the new one, so accepts both.
+	lv := api.LogVerifier{Origin: api.FTLogOrigin, Verifiers: verifiers[:2]}
+	witness, err := NewWitness(&FakeStore{map[string][]byte{}, true}, []Log{{ID: "ft", Verifier: lv}}, witnessSigner, dummyPollInterval, nil)
+	if err != nil {
+		t.Fatalf("error creating witness: %v", err)
+	}
+	all := note.VerifierList(append([]note.Verifier{witnessVerifier}, verifiers...)...)
+
+	for _, test := range []struct {
+		desc     string
+		signers  []note.Signer
+		wantSigs int
+	}{
+		{
+			desc:     "old key",
+			signers:  signers[:1],
+			wantSigs: 2,
+		}, {
+			desc:     "both keys",
+			signers:  []note.Signer{signers[1], signers[0]},
+			wantSigs: 3,
+		}, {
+			desc:     "junk signature dropped",
+			signers:  []note.Signer{signers[2], signers[1]},
+			wantSigs: 2,
+		}, {
+			desc:     "already cosigned",
+			signers:  []note.Signer{signers[0], witnessSigner},
+			wantSigs: 2,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			cp, err := note.Sign(&note.Note{Text: "Firmware Transparency Log\n1\nEjQ=\n123\n"}, test.signers...)
+			if err != nil {
+				t.Fatalf("Failed to sign checkpoint: %v", err)
+			}
+			got, err := witness.cosign(witness.logs["ft"], cp)
+			if err != nil {
+				t.Fatalf("cosign(): %v", err)
+			}
+			n, err := note.Open(got, all)
+			if err != nil {
+				t.Fatalf("Failed to open cosigned checkpoint: %v :\n%s", err, got)
+			}
+			if len(n.Sigs) != test.wantSigs || len(n.UnverifiedSigs) != 0 {
+				t.Errorf("got %d verified and %d unverified signatures, want %d verified", len(n.Sigs), len(n.UnverifiedSigs), test.wantSigs)
+			}
+			if _, err := note.Open(got, note.VerifierList(witnessVerifier)); err != nil {
+				t.Errorf("cosigned checkpoint isn't signed by the witness: %v", err)
+			}
+		})
+	}
+}
+
+func TestMultipleLogs(t *testing.T) {
+	ftSigner, _ := note.NewSigner(crypto.TestFTPersonalityPriv)
+	ftVerifier, _ := note.NewVerifier(crypto.TestFTPersonalityPub)
+	ftLV := api.LogVerifier{Origin: api.FTLogOrigin, Verifiers: []note.Verifier{ftVerifier}}
+	skey, vkey, err := note.GenerateKey(rand.Reader, "serverless")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSigner, _ := note.NewSigner(skey)
+	otherVerifier, _ := note.NewVerifier(vkey)
+	otherLV := api.LogVerifier{Origin: "example.com/serverless", Verifiers: []note.Verifier{otherVerifier}}
+	witnessSigner, _ := note.NewSigner(crypto.TestWitnessPriv)
+
+	sign := func(text string, s note.Signer) []byte {
+		t.Helper()
+		n, err := note.Sign(&note.Note{Text: text}, s)
+		if err != nil {
+			t.Fatalf("Failed to sign checkpoint: %v", err)
+		}
+		return n
+	}
+	ftCP := sign(api.FTLogOrigin+"\n1\nEjQ=\n123\n", ftSigner)
+	// Checkpoints from logs other than FT logs don't have a timestamp.
+	otherCP := sign("example.com/serverless\n2\nNBI=\n", otherSigner)
+
+	witness, err := NewWitness(&FakeStore{map[string][]byte{"ft": ftCP}, true}, []Log{{ID: "ft", Verifier: ftLV}, {ID: "other", Verifier: otherLV}}, witnessSigner, dummyPollInterval, nil)
+	if err != nil {
+		t.Fatalf("error creating witness: %v", err)
+	}
+	r := mux.NewRouter()
+	witness.RegisterHandlers(r)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for _, test := range []struct {
+		desc     string
+		path     string
+		add      []byte
+		wantCode int
+		wantText string
+	}{
+		{
+			desc:     "default log",
+			path:     api.WitnessGetCheckpoint,
+			wantCode: http.StatusOK,
+			wantText: api.FTLogOrigin + "\n1\nEjQ=\n123\n",
+		}, {
+			desc:     "ft log",
+			path:     api.WitnessGetCheckpoint + "/ft",
+			wantCode: http.StatusOK,
+			wantText: api.FTLogOrigin + "\n1\nEjQ=\n123\n",
+		}, {
+			desc:     "add to other log",
+			path:     api.WitnessAddCheckpoint + "/other",
+			add:      otherCP,
+			wantCode: http.StatusOK,
+			wantText: "example.com/serverless\n2\nNBI=\n",
+		}, {
+			desc:     "other log",
+			path:     api.WitnessGetCheckpoint + "/other",
+			wantCode: http.StatusOK,
+			wantText: "example.com/serverless\n2\nNBI=\n",
+		}, {
+			desc:     "add to wrong log",
+			path:     api.WitnessAddCheckpoint + "/ft",
+			add:      otherCP,
+			wantCode: http.StatusForbidden,
+		}, {
+			desc:     "unknown log",
+			path:     api.WitnessGetCheckpoint + "/unknown",
+			wantCode: http.StatusNotFound,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			u := fmt.Sprintf("%s/%s", ts.URL, test.path)
+			var resp *http.Response
+			var err error
+			if test.add != nil {
+				body, _ := json.Marshal(api.AddCheckpointRequest{OldSize: 0, Checkpoint: test.add})
+				resp, err = http.Post(u, "application/json", bytes.NewReader(body))
+			} else {
+				resp, err = http.Get(u)
+			}
+			if err != nil {
+				t.Fatalf("error response: %v", err)
+			}
+			defer resp.Body.Close()
+			if got, want := resp.StatusCode, test.wantCode; got != want {
+				t.Fatalf("got status %d, want %d", got, want)
+			}
+			if test.wantCode != http.StatusOK {
+				return
+			}
+			b, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("failed to read body: %v", err)
+			}
+			n, err := note.Open(b, note.VerifierList(ftVerifier, otherVerifier))
+			if err != nil {
+				t.Fatalf("Failed to open returned body: %v :\n%s", err, b)
+			}
+			if n.Text != test.wantText {
+				t.Errorf("got %q want %q", n.Text, test.wantText)
+			}
+		})
+	}
+}
+
+func TestPollKeepsHealthyLogs(t *testing.T) {
+	logSigner, _ := note.NewSigner(crypto.TestFTPersonalityPriv)
+	logVerifier, _ := note.NewVerifier(crypto.TestFTPersonalityPub)
+	lv := api.LogVerifier{Origin: api.FTLogOrigin, Verifiers: []note.Verifier{logVerifier}}
+	witnessSigner, _ := note.NewSigner(crypto.TestWitnessPriv)
+	cp, err := note.Sign(&note.Note{Text: api.FTLogOrigin + "\n1\nEjQ=\n123\n"}, logSigner)
+	if err != nil {
+		t.Fatalf("Failed to sign checkpoint: %v", err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+api.HTTPGetRoot {
+			http.NotFound(w, r)
+			return
+		}
+		if _, err := w.Write(cp); err != nil {
+			t.Errorf("w.Write: %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	logs := []Log{
+		{ID: "bad", Verifier: lv, URL: "://not a url"},
+		{ID: "healthy", Verifier: lv, URL: ts.URL + "/"},
+	}
+	witness, err := NewWitness(&FakeStore{map[string][]byte{}, true}, logs, witnessSigner, dummyPollInterval, nil)
+	if err != nil {
+		t.Fatalf("error creating witness: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- witness.Poll(ctx)
+	}()
+
+	// The healthy log is still witnessed after polling the bad one has failed.
+	healthy := witness.logs["healthy"]
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		healthy.mu.Lock()
+		size := healthy.gcp.Size
+		healthy.mu.Unlock()
+		if size == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the healthy log to be witnessed")
+		}
+	}
+	cancel()
+	err = <-errc
+	if err == nil || !strings.Contains(err.Error(), `log "bad"`) {
+		t.Errorf("Poll() got error %v, want error for log %q", err, "bad")
+	}
+	if strings.Contains(err.Error(), `log "healthy"`) {
+		t.Errorf("Poll() got error %v for healthy log", err)
+	}
+}
+
+func TestSplitViewEvidence(t *testing.T) {
+	logSigner, _ := note.NewSigner(crypto.TestFTPersonalityPriv)
+	logVerifier, _ := note.NewVerifier(crypto.TestFTPersonalityPub)
+	lv := api.LogVerifier{Origin: api.FTLogOrigin, Verifiers: []note.Verifier{logVerifier}}
+	witnessSigner, _ := note.NewSigner(crypto.TestWitnessPriv)
+	sign := func(text string) []byte {
+		t.Helper()
+		n, err := note.Sign(&note.Note{Text: text}, logSigner)
+		if err != nil {
+			t.Fatalf("Failed to sign checkpoint: %v", err)
+		}
+		return n
+	}
+
+	ev, err := evidence.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("evidence.NewStore(): %v", err)
+	}
+	store := &FakeStore{map[string][]byte{"ft": sign(api.FTLogOrigin + "\n1\nEjQ=\n123\n")}, true}
+	witness, err := NewWitness(store, []Log{{ID: "ft", Verifier: lv}}, witnessSigner, dummyPollInterval, ev)
+	if err != nil {
+		t.Fatalf("error creating witness: %v", err)
+	}
+	r := mux.NewRouter()
+	witness.RegisterHandlers(r)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// The log has signed a different checkpoint of the same size.
+	forked := sign(api.FTLogOrigin + "\n1\nNBI=\n124\n")
+	body, _ := json.Marshal(api.AddCheckpointRequest{OldSize: 1, Checkpoint: forked})
+	resp, err := http.Post(fmt.Sprintf("%s/%s", ts.URL, api.WitnessAddCheckpoint), "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("error response: %v", err)
+	}
+	resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusUnprocessableEntity; got != want {
+		t.Fatalf("got status %d, want %d", got, want)
+	}
+
+	resp, err = http.Get(fmt.Sprintf("%s/%s", ts.URL, api.WitnessEvidence))
+	if err != nil {
+		t.Fatalf("error response: %v", err)
+	}
+	var names []string
+	if err := json.NewDecoder(resp.Body).Decode(&names); err != nil {
+		t.Fatalf("failed to decode evidence list: %v", err)
+	}
+	resp.Body.Close()
+	if len(names) != 1 {
+		t.Fatalf("got %d pieces of evidence, want 1", len(names))
+	}
+	sv, err := ev.Get(names[0])
+	if err != nil {
+		t.Fatalf("failed to get evidence: %v", err)
+	}
+	if err := sv.Verify(lv, nil); err != nil {
+		t.Errorf("evidence failed to verify: %v", err)
+	}
+}
+
 type FakeStore struct {
-	scp         []byte
+	scp         map[string][]byte
 	storeaccess bool
 }
 
-func (f *FakeStore) StoreCP(wcp []byte) error {
+func (f *FakeStore) StoreCP(logID string, wcp []byte) error {
 	if !f.storeaccess {
 		return fmt.Errorf("unable to access store")
 	}
-	f.scp = wcp
+	f.scp[logID] = wcp
 	return nil
 }
 
-func (f *FakeStore) RetrieveCP() ([]byte, error) {
+func (f *FakeStore) RetrieveCP(logID string) ([]byte, error) {
 	if !f.storeaccess {
 		return nil, fmt.Errorf("unable to access store")
 	}
-	return f.scp, nil
+	return f.scp[logID], nil
 }
@@ -12,59 +12,49 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Package ws contains a Witness Store backed by a file.
+// Package ws contains a Witness Store backed by a SQL database.
 package ws
 
 import (
+	"database/sql"
 	"fmt"
-	"os"
-	"sync"
-)
-
-const (
-	fileMask = 0755
 )
 
 // Storage is a Witness Storage intended for storing witness checkpoints.
-// Currently a simple file is used as a storage mechanism
+// The latest checkpoint for each witnessed log is kept, keyed by log ID.
 type Storage struct {
-	fp        string
-	storeLock sync.Mutex
+	db *sql.DB
 }
 
-// NewStorage creates a new WS that uses the given file as DB backend.
+// NewStorage creates a new WS that uses the given DB as a backend.
 // The DB will be initialized if needed.
-func NewStorage(fp string) (*Storage, error) {
+func NewStorage(db *sql.DB) (*Storage, error) {
 	ws := &Storage{
-		fp: fp,
+		db: db,
 	}
 	return ws, ws.init()
 }
 
-// init creates the file storage
+// init creates the database tables if needed.
 func (ws *Storage) init() error {
-	// Check if the file exists, if not create one
-	f, err := os.OpenFile(ws.fp, os.O_RDWR|os.O_CREATE, os.ModePerm)
-	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
-	}
-	if err := f.Close(); err != nil {
-		return fmt.Errorf("failed to close file: %w", err)
-	}
-	return nil
+	_, err := ws.db.Exec("CREATE TABLE IF NOT EXISTS checkpoints (logID TEXT PRIMARY KEY, checkpoint BLOB)")
+	return err
 }
 
-// StoreCP saves the given checkpoint into DB.
-func (ws *Storage) StoreCP(wcp []byte) error {
-	ws.storeLock.Lock()
-	defer ws.storeLock.Unlock()
-
-	return os.WriteFile(ws.fp, wcp, fileMask)
+// StoreCP saves the given checkpoint for the log into DB, replacing any previous one.
+func (ws *Storage) StoreCP(logID string, wcp []byte) error {
+	if _, err := ws.db.Exec("INSERT OR REPLACE INTO checkpoints (logID, checkpoint) VALUES (?, ?)", logID, wcp); err != nil {
+		return fmt.Errorf("failed to store checkpoint for log %q: %w", logID, err)
+	}
+	return nil
 }
 
-// RetrieveCP gets the checkpoint previously stored.
-func (ws *Storage) RetrieveCP() ([]byte, error) {
-	ws.storeLock.Lock()
-	defer ws.storeLock.Unlock()
-	return os.ReadFile(ws.fp)
+// RetrieveCP gets the checkpoint previously stored for the log, or nil if there is none.
+func (ws *Storage) RetrieveCP(logID string) ([]byte, error) {
+	var wcp []byte
+	err := ws.db.QueryRow("SELECT checkpoint FROM checkpoints WHERE logID=?", logID).Scan(&wcp)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return wcp, err
 }
@@ -16,66 +16,61 @@ package ws
 
 import (
 	"bytes"
-	"fmt"
-	"path/filepath"
+	"database/sql"
 	"testing"
-)
 
-const undefFp = ""
+	_ "github.com/mattn/go-sqlite3" // Load drivers for sqlite3
+)
 
 func TestRoundTrip(t *testing.T) {
 	for _, test := range []struct {
-		desc string
-		data []byte
+		desc  string
+		store map[string][]string
+		want  map[string][]byte
 	}{
 		{
-			desc: "initial checkpoint test",
-			data: []byte("some checkpoint"),
+			desc:  "initial checkpoint test",
+			store: map[string][]string{"log": {"some checkpoint"}},
+			want:  map[string][]byte{"log": []byte("some checkpoint")},
 		}, {
-			desc: "check over-write of checkpoint",
-			data: []byte("some more checkpoint"),
+			desc:  "check over-write of checkpoint",
+			store: map[string][]string{"log": {"some checkpoint", "some more checkpoint"}},
+			want:  map[string][]byte{"log": []byte("some more checkpoint")},
+		}, {
+			desc:  "multiple logs",
+			store: map[string][]string{"log1": {"checkpoint 1"}, "log2": {"checkpoint 2", "checkpoint 3"}},
+			want:  map[string][]byte{"log1": []byte("checkpoint 1"), "log2": []byte("checkpoint 3"), "log3": nil},
 		},
 	} {
-		dbFP := filepath.Join(t.TempDir(), "db")
 		t.Run(test.desc, func(t *testing.T) {
-			store, err := NewStorage(dbFP)
+			db, err := sql.Open("sqlite3", ":memory:")
 			if err != nil {
-				t.Error("failed to create storage", err)
-			}
-			want := test.data
-			if err := store.StoreCP(test.data); err != nil {
-				t.Error("failed to store into Witness Store", err)
+				t.Fatal("failed to open temporary in-memory DB", err)
 			}
-			got, err := store.RetrieveCP()
+			defer func() {
+				if err := db.Close(); err != nil {
+					t.Errorf("db.Close(): %v", err)
+				}
+			}()
+			store, err := NewStorage(db)
 			if err != nil {
-				t.Error("failed to retrieve from Witness Store", err)
+				t.Fatal("failed to create storage", err)
 			}
-			if !bytes.Equal(got, want) {
-				t.Errorf("got '%s' want '%s'", got, want)
-			}
-
-		})
-	}
-}
-
-func TestFailedStorage(t *testing.T) {
-	for _, test := range []struct {
-		desc      string
-		wantError string
-	}{
-		{
-			desc:      "Handle Storage failure",
-			wantError: "failed to open file: open : no such file or directory",
-		},
-	} {
-		t.Run(test.desc, func(t *testing.T) {
-			_, err := NewStorage(undefFp)
-			if err == nil {
-				t.Error("Unexpected success in storage creation", err)
+			for id, cps := range test.store {
+				for _, cp := range cps {
+					if err := store.StoreCP(id, []byte(cp)); err != nil {
+						t.Fatal("failed to store into Witness Store", err)
+					}
+				}
 			}
-			fmt.Printf("Received Error = %s", err.Error())
-			if err.Error() != test.wantError {
-				t.Error("Unexpected error message received", err)
+			for id, want := range test.want {
+				got, err := store.RetrieveCP(id)
+				if err != nil {
+					t.Fatal("failed to retrieve from Witness Store", err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("log %q: got '%s' want '%s'", id, got, want)
+				}
 			}
 		})
 	}
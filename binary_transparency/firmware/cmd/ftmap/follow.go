@@ -0,0 +1,211 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/golang/glog"
+	"github.com/google/trillian/types"
+	"golang.org/x/mod/sumdb/note"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/client"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/ftmap"
+)
+
+var (
+	// minRetryBackoff and maxRetryBackoff bound the time that followLog waits before
+	// retrying after a transient failure.
+	minRetryBackoff = time.Second
+	maxRetryBackoff = 5 * time.Minute
+)
+
+// followLog follows the log, and builds a new revision of the map each time --build_entries
+// new entries have been verified, or every --build_interval if there are any new entries,
+// until the context is done. The first revision is built from scratch if there are none in
+// the map DB, and each later revision updates the one before it.
+//
+// Failures to reach the log, or to build a revision, are retried with backoff. Statements
+// whose signature can't be verified, e.g. because the signer isn't in the claimants, are
+// skipped: they take up their index in the input log, but contribute nothing to the map.
+// Evidence that the log is misbehaving, such as an inconsistent checkpoint or an entry
+// which isn't included in it, stops following with an error.
+func followLog(ctx context.Context, mapDB *ftmap.MapDB, c client.ReadonlyClient, claimants *crypto.ClaimantRegistry, signer note.Signer) error {
+	var golden api.LogCheckpoint
+	var next int64
+	incremental := false
+	rev, logRoot, count, err := mapDB.LatestRevision()
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		glog.Warning("No map revisions found; building from the start of the log, and the first log checkpoint will be trusted implicitly")
+	case err != nil:
+		return err
+	default:
+		if err := mapDB.CheckUpdatable(rev); err != nil {
+			return err
+		}
+		// The log checkpoint the revision was built from is the starting point for consistency proofs.
+		// The signed version is needed because entries before it is superseded are verified against it.
+		var cp *api.LogCheckpoint
+		if err := retry(ctx, fmt.Sprintf("get signed log checkpoint at size %d", logRoot.TreeSize), func() (err error) {
+			cp, err = c.GetCheckpointAtSize(logRoot.TreeSize)
+			return err
+		}); err != nil {
+			return err
+		}
+		if !bytes.Equal(cp.Hash, logRoot.RootHash) {
+			return fmt.Errorf("log checkpoint at size %d has root %x, but revision %d was built from root %x", logRoot.TreeSize, cp.Hash, rev, logRoot.RootHash)
+		}
+		golden = *cp
+		next = count
+		incremental = true
+	}
+	follow := client.NewLogFollower(c, claimants)
+	follow.AllowUnverified = true
+
+	glog.Infof("Following FT log (%q) from index %d", *logURL, next)
+	cpc, cperrc := follow.Checkpoints(ctx, *pollInterval, golden)
+	ec, eerrc := follow.Entries(ctx, cpc, uint64(next))
+
+	ticker := time.NewTicker(*buildInterval)
+	defer ticker.Stop()
+	pending := &followedLog{start: next}
+	for {
+		select {
+		case err = <-cperrc:
+			return err
+		case err = <-eerrc:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		case entry, ok := <-ec:
+			if !ok {
+				// The follower has stopped, and will report why on its error channels.
+				ec = nil
+				continue
+			}
+			if err := pending.add(entry); err != nil {
+				return err
+			}
+			if len(pending.leaves) < *buildEntries {
+				continue
+			}
+		case <-ticker.C:
+			if len(pending.leaves) == 0 {
+				continue
+			}
+		}
+
+		var rev int
+		var metadata ftmap.InputLogMetadata
+		if err := retry(ctx, "build map revision", func() error {
+			// A failed build may leave a partial revision behind, which readers ignore as it is
+			// never finalized. The retry writes a new revision after it.
+			var err error
+			if rev, metadata, err = buildRevision(ctx, mapDB, pending, incremental, -1); err != nil {
+				return err
+			}
+			return finalizeRevision(mapDB, rev, metadata, pending.checkpoint.Envelope, signer)
+		}); err != nil {
+			return err
+		}
+		glog.Infof("Map revision %d generated successfully from %d log entries", rev, metadata.Entries)
+		pending = &followedLog{start: metadata.Entries}
+		incremental = true
+	}
+}
+
+// followedLog is an ftmap.InputLog of the entries followed from the log since the last
+// revision of the map was built. These are held in memory until the next revision is built.
+type followedLog struct {
+	// start is the index in the log of the first of the leaves.
+	start  int64
+	leaves []ftmap.InputLogLeaf
+	// checkpoint is the log checkpoint that the leaves have been verified to be included in.
+	checkpoint api.LogCheckpoint
+}
+
+// retry calls f until it succeeds, waiting with exponential backoff between attempts,
+// or the context is done.
+func retry(ctx context.Context, what string, f func() error) error {
+	backoff := minRetryBackoff
+	for {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		glog.Warningf("Failed to %s, will retry after %v: %v", what, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("failed to %s: %v", what, err)
+		}
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// add appends the entry to the leaves, which must be the next entry in the log.
+// An unverified entry is replaced with an empty statement, which the map ignores.
+func (l *followedLog) add(e client.LogEntry) error {
+	if want := l.start + int64(len(l.leaves)); int64(e.Index) != want {
+		return fmt.Errorf("got entry at index %d, but expected index %d", e.Index, want)
+	}
+	stmt := e.Value
+	if e.Unverified {
+		glog.Warningf("Skipping statement of type %v at index %d, as its signature couldn't be verified", e.Value.Type, e.Index)
+		stmt = api.SignedStatement{}
+	}
+	bs, err := json.Marshal(stmt)
+	if err != nil {
+		return fmt.Errorf("failed to marshal statement at index %d: %v", e.Index, err)
+	}
+	l.leaves = append(l.leaves, ftmap.InputLogLeaf{
+		Seq:  int64(e.Index),
+		Data: bs,
+	})
+	l.checkpoint = e.Root
+	return nil
+}
+
+// Head returns the checkpoint the leaves were verified against as a serialized LogRootV1,
+// and the number of entries in the log up to and including the last leaf.
+func (l *followedLog) Head() ([]byte, int64, error) {
+	cp, err := (&types.LogRootV1{
+		RootHash:       l.checkpoint.Hash,
+		TimestampNanos: l.checkpoint.TimestampNanos,
+		TreeSize:       l.checkpoint.Size,
+	}).MarshalBinary()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to marshal LogRoot: %w", err)
+	}
+	return cp, l.start + int64(len(l.leaves)), nil
+}
+
+// Entries returns a PCollection of InputLogLeaf, containing entries in range [start, end).
+// Only the entries since the last revision are available, so start must be at least l.start.
+func (l *followedLog) Entries(s beam.Scope, start, end int64) beam.PCollection {
+	return beam.CreateList(s, l.leaves[start-l.start:end-l.start])
+}
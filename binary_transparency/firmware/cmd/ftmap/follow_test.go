@@ -0,0 +1,306 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/sha512"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/client"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/ftmap"
+	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/merkle/testonly"
+	"golang.org/x/mod/sumdb/note"
+)
+
+func TestFollowLog(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		buildEntries  int
+		buildInterval time.Duration
+		// runs are the firmware revisions appended to the log before each run of followLog.
+		runs [][]uint64
+		// failCheckpoints is the number of requests for checkpoints at a given size that fail.
+		failCheckpoints int
+
+		wantRevision  int
+		wantCount     int64
+		wantRevisions []uint64
+	}{
+		{
+			name:          "build entries reached",
+			buildEntries:  3,
+			buildInterval: time.Hour,
+			runs:          [][]uint64{{1, 2, 3}},
+			wantRevision:  0,
+			wantCount:     3,
+			wantRevisions: []uint64{1, 2, 3},
+		},
+		{
+			name:          "build interval elapsed",
+			buildEntries:  1000,
+			buildInterval: 100 * time.Millisecond,
+			runs:          [][]uint64{{1, 2}},
+			wantRevision:  0,
+			wantCount:     2,
+			wantRevisions: []uint64{1, 2},
+		},
+		{
+			name:            "restart from existing revision",
+			buildEntries:    2,
+			buildInterval:   time.Hour,
+			runs:            [][]uint64{{1, 2}, {3, 4}},
+			failCheckpoints: 2,
+			wantRevision:    1,
+			wantCount:       4,
+			wantRevisions:   []uint64{1, 2, 3, 4},
+		},
+		{
+			name:          "unknown signer skipped",
+			buildEntries:  3,
+			buildInterval: time.Hour,
+			// Revision 0 is signed by a claimant that isn't trusted to publish firmware.
+			runs:          [][]uint64{{1, 0, 2}},
+			wantRevision:  0,
+			wantCount:     3,
+			wantRevisions: []uint64{1, 2},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			setFollowFlags(t, filepath.Join(t.TempDir(), "map.db"), test.buildEntries, test.buildInterval)
+			mapDB, err := ftmap.NewMapDB(*mapDBString)
+			if err != nil {
+				t.Fatalf("NewMapDB(): %v", err)
+			}
+			signer, err := note.NewSigner(crypto.TestMapPriv)
+			if err != nil {
+				t.Fatalf("NewSigner(): %v", err)
+			}
+			l := newFakeLog(t)
+			l.failCheckpoints = test.failCheckpoints
+			c := l.client(t)
+
+			for i, revisions := range test.runs {
+				for _, r := range revisions {
+					l.append(t, r)
+				}
+				ctx, cancel := context.WithCancel(context.Background())
+				errc := make(chan error, 1)
+				go func() {
+					errc <- followLog(ctx, mapDB, c, crypto.TestClaimantRegistry(), signer)
+				}()
+				waitForRevision(t, mapDB, i, errc)
+				cancel()
+				if err := <-errc; !errors.Is(err, context.Canceled) {
+					t.Fatalf("followLog(): %v", err)
+				}
+			}
+
+			rev, _, count, err := mapDB.LatestRevision()
+			if err != nil {
+				t.Fatalf("LatestRevision(): %v", err)
+			}
+			if rev != test.wantRevision || count != test.wantCount {
+				t.Errorf("got revision %d with %d entries, want revision %d with %d entries", rev, count, test.wantRevision, test.wantCount)
+			}
+			drl, err := mapDB.DeviceReleaseLog(rev, "dummy")
+			if err != nil {
+				t.Fatalf("DeviceReleaseLog(): %v", err)
+			}
+			if !reflect.DeepEqual(drl.Revisions, test.wantRevisions) {
+				t.Errorf("got firmware revisions %v, want %v", drl.Revisions, test.wantRevisions)
+			}
+			if l.failCheckpoints != 0 {
+				t.Errorf("log had %d failures left, want 0", l.failCheckpoints)
+			}
+		})
+	}
+}
+
+// setFollowFlags sets the flags used by followLog for the duration of the test.
+func setFollowFlags(t *testing.T, db string, entries int, interval time.Duration) {
+	t.Helper()
+	oldDB, oldEntries, oldBuild, oldPoll := *mapDBString, *buildEntries, *buildInterval, *pollInterval
+	oldMin, oldMax := minRetryBackoff, maxRetryBackoff
+	t.Cleanup(func() {
+		*mapDBString, *buildEntries, *buildInterval, *pollInterval = oldDB, oldEntries, oldBuild, oldPoll
+		minRetryBackoff, maxRetryBackoff = oldMin, oldMax
+	})
+	*mapDBString, *buildEntries, *buildInterval, *pollInterval = db, entries, interval, 10*time.Millisecond
+	minRetryBackoff, maxRetryBackoff = time.Millisecond, time.Millisecond
+	if err := flag.Set("runner", "direct"); err != nil {
+		t.Fatalf("failed to set runner: %v", err)
+	}
+}
+
+// waitForRevision waits until the map has the given revision, failing if followLog returns first.
+func waitForRevision(t *testing.T, mapDB *ftmap.MapDB, want int, errc <-chan error) {
+	t.Helper()
+	timeout := time.After(time.Minute)
+	for {
+		rev, _, _, err := mapDB.LatestRevision()
+		if err == nil && rev >= want {
+			return
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			t.Logf("LatestRevision(): %v", err)
+		}
+		select {
+		case err := <-errc:
+			t.Fatalf("followLog() returned before revision %d was built: %v", want, err)
+		case <-timeout:
+			t.Fatalf("timed out waiting for revision %d", want)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// fakeLog serves the parts of the FT log API used to follow it.
+type fakeLog struct {
+	mu     sync.Mutex
+	tree   *testonly.Tree
+	leaves [][]byte
+	signer note.Signer
+	// failCheckpoints is the number of requests for checkpoints at a given size to fail.
+	failCheckpoints int
+}
+
+func newFakeLog(t *testing.T) *fakeLog {
+	t.Helper()
+	s, err := note.NewSigner(crypto.TestFTPersonalityPriv)
+	if err != nil {
+		t.Fatalf("NewSigner(): %v", err)
+	}
+	return &fakeLog{tree: testonly.New(rfc6962.DefaultHasher), signer: s}
+}
+
+// append adds a firmware statement for the given revision to the log. Revision 0 is
+// signed by a claimant which isn't trusted to publish firmware.
+func (l *fakeLog) append(t *testing.T, revision uint64) {
+	t.Helper()
+	image := fmt.Sprintf("this image is the firmware at revision %d for device dummy.", revision)
+	imageHash := sha512.Sum512([]byte(image))
+	fw, err := json.Marshal(api.FirmwareMetadata{
+		DeviceID:            "dummy",
+		FirmwareRevision:    revision,
+		FirmwareImageSHA512: imageHash[:],
+	})
+	if err != nil {
+		t.Fatalf("json.Marshal(): %v", err)
+	}
+	claimant := crypto.Publisher
+	if revision == 0 {
+		claimant = crypto.Revoker
+	}
+	stmt, err := claimant.SignStatement(api.FirmwareMetadataType, fw)
+	if err != nil {
+		t.Fatalf("SignStatement(): %v", err)
+	}
+	leaf, err := json.Marshal(stmt)
+	if err != nil {
+		t.Fatalf("json.Marshal(): %v", err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tree.AppendData(leaf)
+	l.leaves = append(l.leaves, leaf)
+}
+
+// client starts serving the log, and returns a client for it.
+func (l *fakeLog) client(t *testing.T) client.ReadonlyClient {
+	t.Helper()
+	ts := httptest.NewServer(l)
+	t.Cleanup(ts.Close)
+	u, err := url.Parse(ts.URL + "/")
+	if err != nil {
+		t.Fatalf("url.Parse(): %v", err)
+	}
+	lv, err := api.NewLogVerifier(api.FTLogOrigin, crypto.TestFTPersonalityPub)
+	if err != nil {
+		t.Fatalf("NewLogVerifier(): %v", err)
+	}
+	return client.ReadonlyClient{LogURL: u, LogSigVerifier: lv}
+}
+
+func (l *fakeLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	size := uint64(len(l.leaves))
+	var from, to, index uint64
+	switch {
+	case r.URL.Path == "/"+api.HTTPGetRoot:
+		l.writeCheckpoint(w, size)
+	case scan(r.URL.Path, "/"+api.HTTPGetRoot+"/at-size/%d", &to):
+		if l.failCheckpoints > 0 {
+			l.failCheckpoints--
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		l.writeCheckpoint(w, to)
+	case scan(r.URL.Path, "/"+api.HTTPGetConsistency+"/from/%d/to/%d", &from, &to):
+		p, err := l.tree.ConsistencyProof(from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(api.ConsistencyProof{Proof: p})
+	case scan(r.URL.Path, "/"+api.HTTPGetManifestEntryAndProof+"/at/%d/in-tree-of/%d", &index, &to):
+		p, err := l.tree.InclusionProof(index, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(api.InclusionProof{Value: l.leaves[index], LeafIndex: index, Proof: p})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// writeCheckpoint writes the signed checkpoint for the log at the given size.
+func (l *fakeLog) writeCheckpoint(w http.ResponseWriter, size uint64) {
+	if size > uint64(len(l.leaves)) {
+		http.Error(w, "too large", http.StatusBadRequest)
+		return
+	}
+	text := fmt.Sprintf("%s\n%d\n%s\n%d\n", api.FTLogOrigin, size, base64.StdEncoding.EncodeToString(l.tree.HashAt(size)), size)
+	cp, err := note.Sign(&note.Note{Text: text}, l.signer)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write(cp)
+}
+
+// scan reports whether the path matches the format, parsing the values into args.
+func scan(path, format string, args ...interface{}) bool {
+	n, err := fmt.Sscanf(path, format, args...)
+	return err == nil && n == len(args)
+}
@@ -16,12 +16,17 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"net/url"
+	"os"
 	"reflect"
+	"strings"
+	"time"
 
 	"github.com/apache/beam/sdks/v2/go/pkg/beam"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/databaseio"
@@ -34,48 +39,129 @@ import (
 	"github.com/google/trillian/types"
 
 	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/client"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
 	"github.com/google/trillian-examples/binary_transparency/firmware/internal/ftmap"
+	"golang.org/x/mod/sumdb/note"
 
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 var (
-	trillianMySQL = flag.String("trillian_mysql", "", "The connection string to the Trillian MySQL database.")
+	trillianMySQL = flag.String("trillian_mysql", "", "The connection string to the Trillian MySQL database. Not used with --follow.")
 	mapDBString   = flag.String("map_db", "", "Connection path for output database where the map tiles will be written.")
 	count         = flag.Int64("count", -1, "The total number of entries starting from the beginning of the log to use, or -1 to use all. This can be used to independently create maps of the same size.")
 	batchSize     = flag.Int("write_batch_size", 250, "Number of tiles to write per batch")
+
+	incrementalUpdate = flag.Bool("incremental_update", false, "If set the last revision of the map will be updated with the log entries since it was built, otherwise this will build the map from scratch each time.")
+	keepRevisions     = flag.Int("keep_revisions", 0, "The number of the latest map revisions to keep after writing a new one, or 0 to keep all revisions.")
+
+	follow          = flag.Bool("follow", false, "If set then rather than building a single revision from the Trillian DB, this will follow the log at log_url and keep building new revisions as entries are added to it.")
+	pollInterval    = flag.Duration("poll_interval", 5*time.Second, "Duration to wait between polling the log for new entries when following")
+	buildInterval   = flag.Duration("build_interval", time.Minute, "Longest duration to wait before building a new revision from the entries followed since the last revision")
+	buildEntries    = flag.Int("build_entries", 1000, "Number of new entries followed which triggers building a new revision without waiting for build_interval")
+	claimantsConfig = flag.String("claimants_config", "", "Path to a JSON file listing the keys trusted to sign statements when following, or empty to trust only the TEST/DEMO keys")
+
+	logURL        = flag.String("log_url", "http://localhost:8000", "Base URL of the FT Log server, used to fetch the signed checkpoint the map is built from")
+	logOrigin     = flag.String("log_origin", api.FTLogOrigin, "Origin line expected on checkpoints from the log")
+	logPublicKeys = flag.String("log_public_keys", crypto.TestFTPersonalityPub, "Comma separated note verifier keys for the log; checkpoints signed by any of them are accepted")
+	keyFile       = flag.String("map_signing_key_file", "", "Path to a file containing the note signer key used to sign map checkpoints, or empty to use the TEST/DEMO key")
 )
 
 func init() {
 	beam.RegisterType(reflect.TypeOf((*tileToDBRowFn)(nil)).Elem())
 	beam.RegisterType(reflect.TypeOf((*logToDBRowFn)(nil)).Elem())
 	beam.RegisterType(reflect.TypeOf((*aggToDBRowFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*commitToDBRowFn)(nil)).Elem())
+	beam.RegisterFunction(tileFromDBRowFn)
+	beam.RegisterFunction(logFromDBRowFn)
+	beam.RegisterFunction(aggFromDBRowFn)
+	beam.RegisterFunction(commitFromDBRowFn)
 }
 
 func main() {
 	flag.Parse()
 	beam.Init()
 
-	// Connect to where we will read from and write to.
+	// Connect to where we will write to.
+	mapDB, err := sinkFromFlags()
+	if err != nil {
+		glog.Exitf("Failed to initialize Map DB: %v", err)
+	}
+
+	signer, err := mapSigner()
+	if err != nil {
+		glog.Exitf("Failed to create map signer: %v", err)
+	}
+	logClient, err := logClientFromFlags()
+	if err != nil {
+		glog.Exitf("Failed to create log client: %v", err)
+	}
+	beamlog.SetLogger(&BeamGLogger{InfoLogAtVerbosity: 2})
+
+	if *follow {
+		claimants := crypto.TestClaimantRegistry()
+		if len(*claimantsConfig) > 0 {
+			if claimants, err = crypto.LoadClaimantRegistry(*claimantsConfig); err != nil {
+				glog.Exitf("Failed to load claimants: %v", err)
+			}
+		}
+		if err := followLog(context.Background(), mapDB, logClient, claimants, signer); err != nil {
+			glog.Exit(err.Error())
+		}
+		return
+	}
+
+	// Connect to where we will read from.
 	trillianDB, err := newTrillianDBFromFlags()
 	if err != nil {
 		glog.Exitf("Failed to initialize Trillian connection: %v", err)
 	}
-	mapDB, rev, err := sinkFromFlags()
+	rev, metadata, err := buildRevision(context.Background(), mapDB, trillianDB, *incrementalUpdate, *count)
 	if err != nil {
-		glog.Exitf("Failed to initialize Map DB: %v", err)
+		glog.Exit(err.Error())
+	}
+	// The map commits to the log checkpoint it was built from, so find the one the log signed for this root.
+	logCheckpoint, err := signedLogCheckpoint(logClient, metadata.Checkpoint)
+	if err != nil {
+		glog.Exitf("Failed to get signed log checkpoint: %v", err)
+	}
+	if err := finalizeRevision(mapDB, rev, metadata, logCheckpoint.Envelope, signer); err != nil {
+		glog.Exit(err.Error())
+	}
+	glog.Infof("Map revision %d generated successfully from %d log entries", rev, metadata.Entries)
+}
+
+// buildRevision runs a pipeline to write the next revision of the map from the first
+// `size` entries in the source log, and returns the revision and the provenance of the
+// entries it was built from. If incremental is set then this updates the last revision
+// of the map, otherwise the map is built from scratch.
+// The revision isn't complete until it has been passed to finalizeRevision.
+func buildRevision(ctx context.Context, mapDB *ftmap.MapDB, source ftmap.InputLog, incremental bool, size int64) (int, ftmap.InputLogMetadata, error) {
+	rev, err := mapDB.NextWriteRevision()
+	if err != nil {
+		return 0, ftmap.InputLogMetadata{}, fmt.Errorf("failed to query for next write revision: %v", err)
 	}
 
 	// The tree & strata config is part of the API for clients. If we make this configurable then
-	// there needs to be some dynamic way to get this to clients (e.g. in a MapCheckpoint).
-	pb := ftmap.NewMapBuilder(trillianDB, api.MapTreeID, api.MapPrefixStrata)
+	// there needs to be some way to get this to clients (e.g. in the signed MapCheckpoint).
+	pb := ftmap.NewMapBuilder(source, api.MapTreeID, api.MapPrefixStrata)
 
-	beamlog.SetLogger(&BeamGLogger{InfoLogAtVerbosity: 2})
 	p, s := beam.NewPipelineWithRoot()
-	result, err := pb.Create(s, *count)
-	if err != nil {
-		glog.Exitf("Failed to build Create pipeline: %v", err)
+	var result *ftmap.PipelineResult
+	if incremental {
+		last, err := lastRevision(s, mapDB)
+		if err != nil {
+			return 0, ftmap.InputLogMetadata{}, fmt.Errorf("failed to read last map revision: %v", err)
+		}
+		if result, err = pb.Update(s, last, size); err != nil {
+			return 0, ftmap.InputLogMetadata{}, fmt.Errorf("failed to build Update pipeline: %v", err)
+		}
+	} else {
+		if result, err = pb.Create(s, size); err != nil {
+			return 0, ftmap.InputLogMetadata{}, fmt.Errorf("failed to build Create pipeline: %v", err)
+		}
 	}
 
 	tileRows := beam.ParDo(s.Scope("convertTiles"), &tileToDBRowFn{Revision: rev}, result.MapTiles)
@@ -84,34 +170,125 @@ func main() {
 	databaseio.WriteWithBatchSize(s.Scope("sinkAgg"), *batchSize, "sqlite3", *mapDBString, "aggregations", []string{}, aggRows)
 	logRows := beam.ParDo(s, &logToDBRowFn{rev}, result.DeviceLogs)
 	databaseio.WriteWithBatchSize(s.Scope("sinkLogs"), *batchSize, "sqlite3", *mapDBString, "logs", []string{}, logRows)
+	commitRows := beam.ParDo(s.Scope("convertCommits"), &commitToDBRowFn{Revision: rev}, result.CommitFirmware)
+	databaseio.WriteWithBatchSize(s.Scope("sinkCommits"), *batchSize, "sqlite3", *mapDBString, "sourceCommits", []string{}, commitRows)
 
 	// All of the above constructs the pipeline but doesn't run it. Now we run it.
-	if err := beamx.Run(context.Background(), p); err != nil {
-		glog.Exitf("Failed to execute job: %q", err)
+	if err := beamx.Run(ctx, p); err != nil {
+		return 0, ftmap.InputLogMetadata{}, fmt.Errorf("failed to execute job: %q", err)
+	}
+	return rev, result.Metadata, nil
+}
+
+// finalizeRevision signs the map checkpoint for a revision written by buildRevision, which
+// commits to the signed log checkpoint, and writes the revision metadata to complete it.
+// Old revisions are then pruned according to --keep_revisions.
+func finalizeRevision(mapDB *ftmap.MapDB, rev int, metadata ftmap.InputLogMetadata, logCheckpoint []byte, signer note.Signer) error {
+	root, err := mapDB.Tile(rev, []byte{})
+	if err != nil {
+		return fmt.Errorf("failed to read root tile for revision %d: %v", rev, err)
+	}
+	mcp, err := note.Sign(&note.Note{Text: string(api.MapCheckpoint{
+		LogCheckpoint: logCheckpoint,
+		LogSize:       uint64(metadata.Entries),
+		RootHash:      root.RootHash,
+		Revision:      uint64(rev),
+	}.Marshal())}, signer)
+	if err != nil {
+		return fmt.Errorf("failed to sign map checkpoint: %v", err)
+	}
+	if err := mapDB.WriteRevision(rev, metadata.Checkpoint, metadata.Entries, mcp); err != nil {
+		return fmt.Errorf("failed to finalize map revison %d: %v", rev, err)
+	}
+	if *keepRevisions > 0 {
+		if err := mapDB.PruneRevisions(*keepRevisions); err != nil {
+			return fmt.Errorf("failed to prune map revisions: %v", err)
+		}
+	}
+	return nil
+}
+
+// mapSigner returns the signer for the key in --map_signing_key_file, or the TEST/DEMO key if none is provided.
+func mapSigner() (note.Signer, error) {
+	if len(*keyFile) == 0 {
+		glog.Warning("No --map_signing_key_file provided; signing map checkpoints with the TEST/DEMO key")
+		return note.NewSigner(crypto.TestMapPriv)
+	}
+	k, err := os.ReadFile(*keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	return note.NewSigner(strings.TrimSpace(string(k)))
+}
+
+func logClientFromFlags() (client.ReadonlyClient, error) {
+	u, err := url.Parse(*logURL)
+	if err != nil {
+		return client.ReadonlyClient{}, fmt.Errorf("failed to parse log_url: %w", err)
 	}
+	v, err := api.NewLogVerifier(*logOrigin, strings.Split(*logPublicKeys, ",")...)
+	if err != nil {
+		return client.ReadonlyClient{}, fmt.Errorf("failed to create log verifier: %w", err)
+	}
+	return client.ReadonlyClient{LogURL: u, LogSigVerifier: v}, nil
+}
 
-	// Now write the revision metadata to finalize this map construction.
-	if err := mapDB.WriteRevision(rev, result.Metadata.Checkpoint, result.Metadata.Entries); err != nil {
-		glog.Exitf("Failed to finalize map revison %d: %v", rev, err)
+// signedLogCheckpoint returns the checkpoint signed by the log for the given Trillian log root.
+func signedLogCheckpoint(c client.ReadonlyClient, logRoot []byte) (*api.LogCheckpoint, error) {
+	var lr types.LogRootV1
+	if err := lr.UnmarshalBinary(logRoot); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal log root: %w", err)
+	}
+	cp, err := c.GetCheckpointAtSize(lr.TreeSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get checkpoint at size %d: %w", lr.TreeSize, err)
+	}
+	if !bytes.Equal(cp.Hash, lr.RootHash) {
+		return nil, fmt.Errorf("log checkpoint at size %d has root %x, but Trillian has root %x", lr.TreeSize, cp.Hash, lr.RootHash)
 	}
+	return cp, nil
 }
 
-func sinkFromFlags() (*ftmap.MapDB, int, error) {
+func sinkFromFlags() (*ftmap.MapDB, error) {
 	if len(*mapDBString) == 0 {
-		return nil, 0, fmt.Errorf("missing flag: map_db")
+		return nil, fmt.Errorf("missing flag: map_db")
 	}
 
 	mapDB, err := ftmap.NewMapDB(*mapDBString)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to open map DB at %q: %v", *mapDBString, err)
+		return nil, fmt.Errorf("failed to open map DB at %q: %v", *mapDBString, err)
 	}
+	return mapDB, nil
+}
 
-	var rev int
-	if rev, err = mapDB.NextWriteRevision(); err != nil {
-		return nil, 0, fmt.Errorf("failed to query for next write revision: %v", err)
+// lastRevision reads the contents of the last revision written to the map DB, so that it can be updated.
+func lastRevision(s beam.Scope, mapDB *ftmap.MapDB) (*ftmap.PipelineResult, error) {
+	rev, logRoot, count, err := mapDB.LatestRevision()
+	if err != nil {
+		return nil, err
+	}
+	if err := mapDB.CheckUpdatable(rev); err != nil {
+		return nil, err
+	}
+	golden, err := logRoot.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal log root: %v", err)
+	}
+	glog.Infof("Updating map revision %d, built from %d log entries", rev, count)
 
+	query := func(table string, t reflect.Type) beam.PCollection {
+		return databaseio.Query(s.Scope("last-"+table), "sqlite3", *mapDBString, fmt.Sprintf("SELECT * FROM %s WHERE revision=%d", table, rev), t)
 	}
-	return mapDB, rev, nil
+	return &ftmap.PipelineResult{
+		MapTiles:           beam.ParDo(s, tileFromDBRowFn, query("tiles", reflect.TypeOf(MapTile{}))),
+		DeviceLogs:         beam.ParDo(s, logFromDBRowFn, query("logs", reflect.TypeOf(LogDBRow{}))),
+		AggregatedFirmware: beam.ParDo(s, aggFromDBRowFn, query("aggregations", reflect.TypeOf(AggregatedFirmwareDBRow{}))),
+		CommitFirmware:     beam.ParDo(s, commitFromDBRowFn, query("sourceCommits", reflect.TypeOf(CommitDBRow{}))),
+		Metadata: ftmap.InputLogMetadata{
+			Checkpoint: golden,
+			Entries:    count,
+		},
+	}, nil
 }
 
 // LogDBRow adapts DeviceReleaseLog to the schema format of the Map database to allow for databaseio writing.
@@ -137,6 +314,48 @@ func (fn *logToDBRowFn) ProcessElement(ctx context.Context, l *api.DeviceRelease
 	}, nil
 }
 
+func logFromDBRowFn(r LogDBRow) (*api.DeviceReleaseLog, error) {
+	l := &api.DeviceReleaseLog{DeviceID: r.DeviceID}
+	if err := json.Unmarshal(r.Leaves, &l.Revisions); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// CommitDBRow adapts CommitFirmware to the schema format of the Map database to allow for databaseio writing.
+type CommitDBRow struct {
+	SourceRepository string
+	SourceCommit     string
+	Revision         int
+	// FWLogIndices is the JSON encoded list of firmware log indices built from the commit.
+	FWLogIndices []byte
+}
+
+type commitToDBRowFn struct {
+	Revision int
+}
+
+func (fn *commitToDBRowFn) ProcessElement(ctx context.Context, c *api.CommitFirmware) (CommitDBRow, error) {
+	bs, err := json.Marshal(c.FirmwareLogIndices)
+	if err != nil {
+		return CommitDBRow{}, err
+	}
+	return CommitDBRow{
+		SourceRepository: c.Repository,
+		SourceCommit:     c.Commit,
+		Revision:         fn.Revision,
+		FWLogIndices:     bs,
+	}, nil
+}
+
+func commitFromDBRowFn(r CommitDBRow) (*api.CommitFirmware, error) {
+	c := &api.CommitFirmware{Repository: r.SourceRepository, Commit: r.SourceCommit}
+	if err := json.Unmarshal(r.FWLogIndices, &c.FirmwareLogIndices); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
 // MapTile is the schema format of the Map database to allow for databaseio writing.
 type MapTile struct {
 	Revision int
@@ -160,31 +379,100 @@ func (fn *tileToDBRowFn) ProcessElement(ctx context.Context, t *batchmap.Tile) (
 	}, nil
 }
 
+func tileFromDBRowFn(t MapTile) (*batchmap.Tile, error) {
+	var res batchmap.Tile
+	if err := json.Unmarshal(t.Tile, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
 // AggregatedFirmwareDBRow adapts AggregatedFirmware to the schema format of the Map database to allow for databaseio writing.
 type AggregatedFirmwareDBRow struct {
 	// The keys are the index of the FW Log Metadata that was aggregated, and map Revision number.
 	FWLogIndex uint64
 	Revision   int
 
-	// The value is the summary of the aggregated information. Thus far, a bool for whether it's considered good.
+	// The value is the summary of the aggregated information. Thus far, a bool for whether it's considered good,
+	// a bool for whether it has been revoked, bools for whether it has been rebuilt and reproducibly built, and
+	// the JSON encoded SBOM hashes and vulnerability IDs, or nil if there are none.
 	// Clients will have the other information about the FW so no need to duplicate it here.
-	Good int
+	Good            int
+	Revoked         int
+	Rebuilt         int
+	Reproducible    int
+	SBOMs           []byte
+	Vulnerabilities []byte
+	// FirmwareSHA512 isn't committed to by the map, but is needed to check annotations in later revisions.
+	FirmwareSHA512 []byte
 }
 
 type aggToDBRowFn struct {
 	Revision int
 }
 
-func (fn *aggToDBRowFn) ProcessElement(ctx context.Context, t *api.AggregatedFirmware) AggregatedFirmwareDBRow {
-	goodInt := 0
+func (fn *aggToDBRowFn) ProcessElement(ctx context.Context, t *api.AggregatedFirmware) (AggregatedFirmwareDBRow, error) {
+	goodInt, revokedInt, rebuiltInt, reproducibleInt := 0, 0, 0, 0
 	if t.Good {
 		goodInt = 1
 	}
+	if t.Revoked {
+		revokedInt = 1
+	}
+	if t.Rebuilt {
+		rebuiltInt = 1
+	}
+	if t.Reproducible {
+		reproducibleInt = 1
+	}
+	var sboms, vulns []byte
+	if len(t.SBOMs) > 0 {
+		bs, err := json.Marshal(t.SBOMs)
+		if err != nil {
+			return AggregatedFirmwareDBRow{}, err
+		}
+		sboms = bs
+	}
+	if len(t.Vulnerabilities) > 0 {
+		bs, err := json.Marshal(t.Vulnerabilities)
+		if err != nil {
+			return AggregatedFirmwareDBRow{}, err
+		}
+		vulns = bs
+	}
 	return AggregatedFirmwareDBRow{
-		FWLogIndex: t.Index,
-		Revision:   fn.Revision,
-		Good:       goodInt,
+		FWLogIndex:      t.Index,
+		Revision:        fn.Revision,
+		Good:            goodInt,
+		Revoked:         revokedInt,
+		Rebuilt:         rebuiltInt,
+		Reproducible:    reproducibleInt,
+		SBOMs:           sboms,
+		Vulnerabilities: vulns,
+		FirmwareSHA512:  t.FirmwareImageSHA512,
+	}, nil
+}
+
+func aggFromDBRowFn(r AggregatedFirmwareDBRow) (*api.AggregatedFirmware, error) {
+	agg := &api.AggregatedFirmware{
+		Index:               r.FWLogIndex,
+		Good:                r.Good > 0,
+		Revoked:             r.Revoked > 0,
+		Rebuilt:             r.Rebuilt > 0,
+		Reproducible:        r.Reproducible > 0,
+		FirmwareImageSHA512: r.FirmwareSHA512,
+	}
+	if len(r.SBOMs) > 0 {
+		if err := json.Unmarshal(r.SBOMs, &agg.SBOMs); err != nil {
+			return nil, err
+		}
+	}
+	if len(r.Vulnerabilities) > 0 {
+		if err := json.Unmarshal(r.Vulnerabilities, &agg.Vulnerabilities); err != nil {
+			return nil, err
+		}
 	}
+	return agg, nil
 }
 
 // TODO(mhutchinson): This only works if the Trillian DB has a single tree.
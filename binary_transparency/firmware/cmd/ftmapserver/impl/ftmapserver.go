@@ -17,6 +17,8 @@ package impl
 
 import (
 	"context"
+	"crypto/sha512"
+	"database/sql"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -28,9 +30,8 @@ import (
 	"github.com/golang/glog"
 	"github.com/google/trillian-examples/binary_transparency/firmware/api"
 	"github.com/google/trillian-examples/binary_transparency/firmware/internal/ftmap"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/mapproof"
 	"github.com/google/trillian/experimental/batchmap"
-	"github.com/google/trillian/types"
-	"github.com/transparency-dev/formats/log"
 
 	"github.com/gorilla/mux"
 
@@ -39,14 +40,20 @@ import (
 
 // MapReader is an interface that allows a map to be read from storage.
 type MapReader interface {
-	// LatestRevision gets the metadata for the last completed write.
-	LatestRevision() (rev int, logroot types.LogRootV1, count int64, err error)
+	// LatestCheckpoint gets the signed MapCheckpoint for the last completed write.
+	LatestCheckpoint() ([]byte, error)
 
 	// Tile gets the tile at the given path in the given revision of the map.
 	Tile(revision int, path []byte) (*batchmap.Tile, error)
 
 	// Aggregation gets the aggregation for the firmware at the given log index.
 	Aggregation(revision int, fwLogIndex uint64) (api.AggregatedFirmware, error)
+
+	// CommitFirmware gets the firmware built from the given source commit in the given repository.
+	CommitFirmware(revision int, repository, commit string) (api.CommitFirmware, error)
+
+	// DeviceReleaseLog gets the log of firmware revisions released for the given device.
+	DeviceReleaseLog(revision int, deviceID string) (api.DeviceReleaseLog, error)
 }
 
 // MapServerOpts encapsulates options for running an FT map server.
@@ -91,41 +98,15 @@ type Server struct {
 	db MapReader
 }
 
-// getCheckpoint returns a recent MapCheckpoint.
+// getCheckpoint returns the MapCheckpoint for the latest revision, signed by the map operator.
 func (s *Server) getCheckpoint(w http.ResponseWriter, r *http.Request) {
-	rev, logRootV1, count, err := s.db.LatestRevision()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	glog.V(1).Infof("Latest revision: %d %+v", rev, logRootV1)
-	tile, err := s.db.Tile(rev, []byte{})
+	cp, err := s.db.LatestCheckpoint()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	lcp := api.LogCheckpoint{
-		Checkpoint: log.Checkpoint{
-			Origin: api.FTLogOrigin,
-			Size:   logRootV1.TreeSize,
-			Hash:   logRootV1.RootHash,
-		},
-		TimestampNanos: logRootV1.TimestampNanos,
-	}
-	checkpoint := api.MapCheckpoint{
-		LogCheckpoint: lcp.Marshal(),
-		LogSize:       uint64(count),
-		Revision:      uint64(rev),
-		RootHash:      tile.RootHash,
-	}
-	js, err := json.Marshal(checkpoint)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-
-	}
-	w.Header().Set("Content-Type", "application/json")
-	if _, err := w.Write(js); err != nil {
+	w.Header().Set("Content-Type", "text/plain")
+	if _, err := w.Write(cp); err != nil {
 		glog.Errorf("w.Write(): %v", err)
 	}
 }
@@ -152,6 +133,19 @@ func (s *Server) getTile(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	js, err := json.Marshal(toAPITile(bmt))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(js); err != nil {
+		glog.Errorf("w.Write(): %v", err)
+	}
+}
+
+// toAPITile converts a tile from storage into the format served to clients.
+func toAPITile(bmt *batchmap.Tile) api.MapTile {
 	leaves := make([]api.MapTileLeaf, len(bmt.Leaves))
 	for i, l := range bmt.Leaves {
 		leaves[i] = api.MapTileLeaf{
@@ -159,11 +153,36 @@ func (s *Server) getTile(w http.ResponseWriter, r *http.Request) {
 			Hash: l.Hash,
 		}
 	}
-	tile := api.MapTile{
+	return api.MapTile{
 		Path:   bmt.Path,
 		Leaves: leaves,
 	}
-	js, err := json.Marshal(tile)
+}
+
+// getAggregation returns the aggregation for the firware at the given log index.
+func (s *Server) getAggregation(w http.ResponseWriter, r *http.Request) {
+	rev, err := parseUintParam(r, "revision")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rev > math.MaxInt {
+		// TODO(mhutchinson): Revision probably ought to be uint64 as negative revisions are weird.
+		http.Error(w, "revision is too large", http.StatusBadRequest)
+		return
+	}
+	fwIndex, err := parseUintParam(r, "fwIndex")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	agg, err := s.db.Aggregation(int(rev), fwIndex)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	js, err := json.Marshal(agg)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -174,8 +193,9 @@ func (s *Server) getTile(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// getAggregation returns the aggregation for the firware at the given log index.
-func (s *Server) getAggregation(w http.ResponseWriter, r *http.Request) {
+// getCommitFirmware returns the firmware built from the given source commit in the
+// repository given by the "repository" query parameter.
+func (s *Server) getCommitFirmware(w http.ResponseWriter, r *http.Request) {
 	rev, err := parseUintParam(r, "revision")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -186,18 +206,107 @@ func (s *Server) getAggregation(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "revision is too large", http.StatusBadRequest)
 		return
 	}
-	fwIndex, err := parseUintParam(r, "fwIndex")
+	commit := mux.Vars(r)["commit"]
+	repository := r.URL.Query().Get("repository")
+	if len(repository) == 0 {
+		http.Error(w, "repository is required", http.StatusBadRequest)
+		return
+	}
+
+	cf, err := s.db.CommitFirmware(int(rev), repository, commit)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, fmt.Sprintf("no firmware found for commit %q in %q", commit, repository), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	js, err := json.Marshal(cf)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(js); err != nil {
+		glog.Errorf("w.Write(): %v", err)
+	}
+}
+
+// getDeviceLog returns the release log for the given device, with the inclusion proof
+// for its root hash in the map.
+func (s *Server) getDeviceLog(w http.ResponseWriter, r *http.Request) {
+	rev, err := parseUintParam(r, "revision")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if rev > math.MaxInt {
+		// TODO(mhutchinson): Revision probably ought to be uint64 as negative revisions are weird.
+		http.Error(w, "revision is too large", http.StatusBadRequest)
+		return
+	}
+	deviceID := mux.Vars(r)["deviceID"]
 
-	agg, err := s.db.Aggregation(int(rev), fwIndex)
+	l, err := s.db.DeviceReleaseLog(int(rev), deviceID)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, fmt.Sprintf("no releases found for device %q", deviceID), http.StatusNotFound)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	js, err := json.Marshal(agg)
+	kbs := sha512.Sum512_256([]byte(deviceID))
+	proof, err := s.proof(int(rev), kbs[:])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	js, err := json.Marshal(api.DeviceReleaseLogProof{Log: l, Proof: proof})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(js); err != nil {
+		glog.Errorf("w.Write(): %v", err)
+	}
+}
+
+// getProof returns the inclusion proof for the given key, or a non-inclusion proof if the
+// key is not in the map.
+func (s *Server) getProof(w http.ResponseWriter, r *http.Request) {
+	rev, err := parseUintParam(r, "revision")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rev > math.MaxInt {
+		// TODO(mhutchinson): Revision probably ought to be uint64 as negative revisions are weird.
+		http.Error(w, "revision is too large", http.StatusBadRequest)
+		return
+	}
+	key, err := parseBase64Param(r, "key")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(key) != sha512.Size256 {
+		http.Error(w, fmt.Sprintf("key should be %d bytes, got %d", sha512.Size256, len(key)), http.StatusBadRequest)
+		return
+	}
+
+	proof, err := s.proof(int(rev), key)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, fmt.Sprintf("revision %d not found", rev), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	js, err := json.Marshal(proof)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -208,6 +317,23 @@ func (s *Server) getAggregation(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// proof builds the proof for the key from the tiles along its path in the given revision.
+// Tiles are only written for non-empty subtrees, so the path stops at the first missing tile
+// below the root.
+func (s *Server) proof(rev int, key []byte) (api.MapInclusionProof, error) {
+	tiles := make([]api.MapTile, 0, api.MapPrefixStrata+1)
+	for i := 0; i <= api.MapPrefixStrata; i++ {
+		t, err := s.db.Tile(rev, key[:i])
+		if errors.Is(err, sql.ErrNoRows) && i > 0 {
+			break
+		} else if err != nil {
+			return api.MapInclusionProof{}, fmt.Errorf("failed to read tile %x: %w", key[:i], err)
+		}
+		tiles = append(tiles, toAPITile(t))
+	}
+	return mapproof.Build(key, tiles)
+}
+
 // RegisterHandlers registers HTTP handlers for the endpoints.
 func (s *Server) RegisterHandlers(r *mux.Router) {
 	r.HandleFunc(fmt.Sprintf("/%s", api.MapHTTPGetCheckpoint), s.getCheckpoint).Methods("GET")
@@ -215,6 +341,9 @@ func (s *Server) RegisterHandlers(r *mux.Router) {
 	r.HandleFunc(fmt.Sprintf("/%s/in-revision/{revision:[0-9]+}/at-path/", api.MapHTTPGetTile), s.getTile).Methods("GET")
 	r.HandleFunc(fmt.Sprintf("/%s/in-revision/{revision:[0-9]+}/at-path/{path}", api.MapHTTPGetTile), s.getTile).Methods("GET")
 	r.HandleFunc(fmt.Sprintf("/%s/in-revision/{revision:[0-9]+}/for-firmware-at-index/{fwIndex:[0-9]+}", api.MapHTTPGetAggregation), s.getAggregation).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/%s/in-revision/{revision:[0-9]+}/for-commit/{commit:[0-9a-f]+}", api.MapHTTPGetCommitFirmware), s.getCommitFirmware).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/%s/in-revision/{revision:[0-9]+}/for-device/{deviceID}", api.MapHTTPGetDeviceLog), s.getDeviceLog).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/%s/in-revision/{revision:[0-9]+}/for-key/{key}", api.MapHTTPGetProof), s.getProof).Methods("GET")
 }
 
 func parseBase64Param(r *http.Request, name string) ([]byte, error) {
@@ -15,46 +15,43 @@
 package impl
 
 import (
+	"bytes"
+	"crypto/sha512"
+	"database/sql"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 
 	gomock "github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
 	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/verify"
 	"github.com/google/trillian/experimental/batchmap"
-	"github.com/google/trillian/types"
 	"github.com/gorilla/mux"
 )
 
 func TestRoot(t *testing.T) {
 	for _, test := range []struct {
-		desc     string
-		rev      int
-		logroot  types.LogRootV1
-		count    int64
-		rootHash []byte
-		wantBody string
+		desc       string
+		checkpoint []byte
+		err        error
+		wantCode   int
 	}{
 		{
-			desc: "valid 1",
-			rev:  42,
-			logroot: types.LogRootV1{
-				TreeSize:       42,
-				RootHash:       []byte{0x12, 0x34},
-				Revision:       2,
-				TimestampNanos: 12345,
-			},
-			count:    111,
-			rootHash: []byte{0x34, 0x12},
-			// LogCheckpoint below is base64 of:
-			// Firmware Transparency Log
-			// 42
-			// EjQ=
-			// 12345
-			wantBody: `{"LogCheckpoint":"RmlybXdhcmUgVHJhbnNwYXJlbmN5IExvZwo0MgpFalE9CjEyMzQ1Cg==","LogSize":111,"RootHash":"NBI=","Revision":42}`,
+			desc:       "valid",
+			checkpoint: []byte("Firmware Transparency Map\n42\n111\nNBI=\nRmlybXdhcmU=\n\n\u2014 ft_map sig\n"),
+			wantCode:   http.StatusOK,
+		}, {
+			desc:     "no signed revision",
+			err:      errors.New("latest revision 3 has no signed checkpoint"),
+			wantCode: http.StatusInternalServerError,
 		},
 	} {
 		t.Run(test.desc, func(t *testing.T) {
@@ -62,8 +59,7 @@ func TestRoot(t *testing.T) {
 			mmr := NewMockMapReader(ctrl)
 			server := Server{db: mmr}
 
-			mmr.EXPECT().LatestRevision().Return(test.rev, test.logroot, test.count, nil /* err */)
-			mmr.EXPECT().Tile(test.rev, []byte{}).Return(&batchmap.Tile{RootHash: test.rootHash}, nil /* err */)
+			mmr.EXPECT().LatestCheckpoint().Return(test.checkpoint, test.err)
 
 			ts := httptest.NewServer(http.HandlerFunc(server.getCheckpoint))
 			defer ts.Close()
@@ -71,17 +67,20 @@ func TestRoot(t *testing.T) {
 			client := ts.Client()
 			resp, err := client.Get(ts.URL)
 			if err != nil {
-				t.Errorf("error response: %v", err)
+				t.Fatalf("error response: %v", err)
 			}
-			if resp.StatusCode != http.StatusOK {
-				t.Errorf("status code not OK: %v", resp.StatusCode)
+			if got, want := resp.StatusCode, test.wantCode; got != want {
+				t.Errorf("got status code %d, want %d", got, want)
+			}
+			if test.wantCode != http.StatusOK {
+				return
 			}
 			body, err := io.ReadAll(resp.Body)
 			if err != nil {
 				t.Errorf("failed to read body: %v", err)
 			}
-			if string(body) != test.wantBody {
-				t.Errorf("got '%s' want '%s'", string(body), test.wantBody)
+			if !bytes.Equal(body, test.checkpoint) {
+				t.Errorf("got '%s' want '%s'", body, test.checkpoint)
 			}
 		})
 	}
@@ -199,3 +198,241 @@ func TestAggregation(t *testing.T) {
 		})
 	}
 }
+
+func TestCommitFirmware(t *testing.T) {
+	commit := "0123456789abcdef0123456789abcdef01234567"
+	repo := "https://github.com/example/firmware"
+	for _, test := range []struct {
+		desc       string
+		rev        int
+		repo       string
+		cf         api.CommitFirmware
+		err        error
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			desc:       "found",
+			rev:        42,
+			repo:       repo,
+			cf:         api.CommitFirmware{Repository: repo, Commit: commit, FirmwareLogIndices: []uint64{1, 4}},
+			wantStatus: http.StatusOK,
+			wantBody:   `{"Repository":"https://github.com/example/firmware","Commit":"0123456789abcdef0123456789abcdef01234567","FirmwareLogIndices":[1,4]}`,
+		},
+		{
+			desc:       "not found",
+			rev:        42,
+			repo:       repo,
+			err:        sql.ErrNoRows,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			desc:       "no repository",
+			rev:        42,
+			wantStatus: http.StatusBadRequest,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mmr := NewMockMapReader(ctrl)
+			server := Server{db: mmr}
+
+			if len(test.repo) > 0 {
+				mmr.EXPECT().CommitFirmware(test.rev, test.repo, commit).Return(test.cf, test.err)
+			}
+
+			r := mux.NewRouter()
+			server.RegisterHandlers(r)
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+			url := fmt.Sprintf("%s/%s/in-revision/%d/for-commit/%s?repository=%s", ts.URL, api.MapHTTPGetCommitFirmware, test.rev, commit, url.QueryEscape(test.repo))
+
+			client := ts.Client()
+			resp, err := client.Get(url)
+			if err != nil {
+				t.Fatalf("error response: %v", err)
+			}
+			if resp.StatusCode != test.wantStatus {
+				t.Errorf("got status code %v, want %v (%s)", resp.StatusCode, test.wantStatus, url)
+			}
+			if test.wantStatus != http.StatusOK {
+				return
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Errorf("failed to read body: %v", err)
+			}
+			if string(body) != test.wantBody {
+				t.Errorf("got '%s' want '%s'", string(body), test.wantBody)
+			}
+		})
+	}
+}
+
+func TestDeviceLog(t *testing.T) {
+	deviceID := "dummy"
+	kbs := sha512.Sum512_256([]byte(deviceID))
+	leafHash := []byte{0xab, 0xcd}
+	for _, test := range []struct {
+		desc       string
+		rev        int
+		log        api.DeviceReleaseLog
+		err        error
+		tileErr    error
+		wantStatus int
+	}{
+		{
+			desc:       "found",
+			rev:        42,
+			log:        api.DeviceReleaseLog{DeviceID: deviceID, Revisions: []uint64{1, 2, 5}},
+			wantStatus: http.StatusOK,
+		},
+		{
+			desc:       "not found",
+			rev:        42,
+			err:        sql.ErrNoRows,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			desc:       "missing tile",
+			rev:        42,
+			log:        api.DeviceReleaseLog{DeviceID: deviceID, Revisions: []uint64{1}},
+			tileErr:    sql.ErrNoRows,
+			wantStatus: http.StatusInternalServerError,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mmr := NewMockMapReader(ctrl)
+			server := Server{db: mmr}
+
+			mmr.EXPECT().DeviceReleaseLog(test.rev, deviceID).Return(test.log, test.err)
+			if test.err == nil {
+				mmr.EXPECT().Tile(test.rev, kbs[:0]).Return(&batchmap.Tile{Leaves: []*batchmap.TileLeaf{{Path: kbs[:1], Hash: []byte{0x12}}}}, nil).AnyTimes()
+				mmr.EXPECT().Tile(test.rev, kbs[:1]).Return(&batchmap.Tile{Path: kbs[:1], Leaves: []*batchmap.TileLeaf{{Path: kbs[1:], Hash: leafHash}}}, test.tileErr).AnyTimes()
+			}
+
+			r := mux.NewRouter()
+			server.RegisterHandlers(r)
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+			url := fmt.Sprintf("%s/%s/in-revision/%d/for-device/%s", ts.URL, api.MapHTTPGetDeviceLog, test.rev, deviceID)
+
+			client := ts.Client()
+			resp, err := client.Get(url)
+			if err != nil {
+				t.Fatalf("error response: %v", err)
+			}
+			if resp.StatusCode != test.wantStatus {
+				t.Errorf("got status code %v, want %v (%s)", resp.StatusCode, test.wantStatus, url)
+			}
+			if test.wantStatus != http.StatusOK {
+				return
+			}
+			var got api.DeviceReleaseLogProof
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode body: %v", err)
+			}
+			if d := cmp.Diff(got.Log, test.log); len(d) != 0 {
+				t.Errorf("got log with diff: %s", d)
+			}
+			if !bytes.Equal(got.Proof.Key, kbs[:]) || !bytes.Equal(got.Proof.Value, leafHash) {
+				t.Errorf("got proof for %x => %x, want %x => %x", got.Proof.Key, got.Proof.Value, kbs[:], leafHash)
+			}
+		})
+	}
+}
+
+func TestProof(t *testing.T) {
+	// The tiles below are from a map containing the aggregations for two pieces of firmware.
+	mustTile := func(js string) *batchmap.Tile {
+		t.Helper()
+		var tile batchmap.Tile
+		if err := json.Unmarshal([]byte(js), &tile); err != nil {
+			t.Fatal(err)
+		}
+		return &tile
+	}
+	rootTile := mustTile(`{"Path":"","Leaves":[{"Path":"Rg==","Hash":"M7DmUN5R2auo88WMjg+EcijUzfX085QdHuTzx7Rrwgs="},{"Path":"7A==","Hash":"fK4jTvvbd90D29jYrsBrmWNG83416K1WhgS5T5qYpEI="}]}`)
+	leafTile := mustTile(`{"Path":"Rg==","Leaves":[{"Path":"IRCmyCYwSorPfJ/NXqlkZdVYvs4KKtRYuV27zLJjCg==","Hash":"sE0GFv87tf0j7YciRBVFk4pFKExwVRhwykxdPz70Dxw="}]}`)
+	root, _ := hex.DecodeString("0f3d3f78f57ed66fed386538d96c92a47ce83ec3fdfd687b989d02a3f0bfa5a8")
+	present := sha512.Sum512_256([]byte("summary:0"))
+	// absent is a key whose first byte is 0x00, where the map has no entries.
+	var absent [32]byte
+
+	for _, test := range []struct {
+		desc       string
+		key        []byte
+		tiles      map[string]*batchmap.Tile
+		value      []byte
+		wantStatus int
+	}{
+		{
+			desc:       "inclusion",
+			key:        present[:],
+			tiles:      map[string]*batchmap.Tile{"": rootTile, "46": leafTile},
+			value:      []byte(`{"Index":0,"Good":true}`),
+			wantStatus: http.StatusOK,
+		},
+		{
+			desc:       "non-inclusion",
+			key:        absent[:],
+			tiles:      map[string]*batchmap.Tile{"": rootTile},
+			wantStatus: http.StatusOK,
+		},
+		{
+			desc:       "short key",
+			key:        present[:31],
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			desc:       "no revision",
+			key:        present[:],
+			tiles:      map[string]*batchmap.Tile{},
+			wantStatus: http.StatusNotFound,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mmr := NewMockMapReader(ctrl)
+			server := Server{db: mmr}
+
+			mmr.EXPECT().Tile(42, gomock.Any()).DoAndReturn(func(_ int, path []byte) (*batchmap.Tile, error) {
+				if tile, ok := test.tiles[fmt.Sprintf("%x", path)]; ok {
+					return tile, nil
+				}
+				return nil, sql.ErrNoRows
+			}).AnyTimes()
+
+			r := mux.NewRouter()
+			server.RegisterHandlers(r)
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+			url := fmt.Sprintf("%s/%s/in-revision/42/for-key/%s", ts.URL, api.MapHTTPGetProof, base64.URLEncoding.EncodeToString(test.key))
+
+			client := ts.Client()
+			resp, err := client.Get(url)
+			if err != nil {
+				t.Fatalf("error response: %v", err)
+			}
+			if resp.StatusCode != test.wantStatus {
+				t.Errorf("got status code %v, want %v (%s)", resp.StatusCode, test.wantStatus, url)
+			}
+			if test.wantStatus != http.StatusOK {
+				return
+			}
+			var proof api.MapInclusionProof
+			if err := json.NewDecoder(resp.Body).Decode(&proof); err != nil {
+				t.Fatalf("failed to decode body: %v", err)
+			}
+			if test.value == nil {
+				err = verify.MapNonInclusion(root, test.key, proof)
+			} else {
+				err = verify.MapInclusion(root, test.key, test.value, proof)
+			}
+			if err != nil {
+				t.Errorf("proof failed to verify: %v", err)
+			}
+		})
+	}
+}
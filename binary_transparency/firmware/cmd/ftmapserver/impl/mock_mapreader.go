@@ -9,7 +9,6 @@ import (
 	gomock "github.com/golang/mock/gomock"
 	api "github.com/google/trillian-examples/binary_transparency/firmware/api"
 	batchmap "github.com/google/trillian/experimental/batchmap"
-	types "github.com/google/trillian/types"
 )
 
 // MockMapReader is a mock of MapReader interface.
@@ -50,21 +49,49 @@ func (mr *MockMapReaderMockRecorder) Aggregation(arg0, arg1 interface{}) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Aggregation", reflect.TypeOf((*MockMapReader)(nil).Aggregation), arg0, arg1)
 }
 
-// LatestRevision mocks base method.
-func (m *MockMapReader) LatestRevision() (int, types.LogRootV1, int64, error) {
+// CommitFirmware mocks base method.
+func (m *MockMapReader) CommitFirmware(arg0 int, arg1, arg2 string) (api.CommitFirmware, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "LatestRevision")
-	ret0, _ := ret[0].(int)
-	ret1, _ := ret[1].(types.LogRootV1)
-	ret2, _ := ret[2].(int64)
-	ret3, _ := ret[3].(error)
-	return ret0, ret1, ret2, ret3
+	ret := m.ctrl.Call(m, "CommitFirmware", arg0, arg1, arg2)
+	ret0, _ := ret[0].(api.CommitFirmware)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CommitFirmware indicates an expected call of CommitFirmware.
+func (mr *MockMapReaderMockRecorder) CommitFirmware(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CommitFirmware", reflect.TypeOf((*MockMapReader)(nil).CommitFirmware), arg0, arg1, arg2)
+}
+
+// DeviceReleaseLog mocks base method.
+func (m *MockMapReader) DeviceReleaseLog(arg0 int, arg1 string) (api.DeviceReleaseLog, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeviceReleaseLog", arg0, arg1)
+	ret0, _ := ret[0].(api.DeviceReleaseLog)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeviceReleaseLog indicates an expected call of DeviceReleaseLog.
+func (mr *MockMapReaderMockRecorder) DeviceReleaseLog(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeviceReleaseLog", reflect.TypeOf((*MockMapReader)(nil).DeviceReleaseLog), arg0, arg1)
+}
+
+// LatestCheckpoint mocks base method.
+func (m *MockMapReader) LatestCheckpoint() ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LatestCheckpoint")
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
-// LatestRevision indicates an expected call of LatestRevision.
-func (mr *MockMapReaderMockRecorder) LatestRevision() *gomock.Call {
+// LatestCheckpoint indicates an expected call of LatestCheckpoint.
+func (mr *MockMapReaderMockRecorder) LatestCheckpoint() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LatestRevision", reflect.TypeOf((*MockMapReader)(nil).LatestRevision))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LatestCheckpoint", reflect.TypeOf((*MockMapReader)(nil).LatestCheckpoint))
 }
 
 // Tile mocks base method.
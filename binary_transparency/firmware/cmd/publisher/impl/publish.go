@@ -17,6 +17,7 @@ package impl
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/json"
 	"errors"
@@ -31,18 +32,31 @@ import (
 	"github.com/google/trillian-examples/binary_transparency/firmware/devices/usbarmory"
 	"github.com/google/trillian-examples/binary_transparency/firmware/internal/client"
 	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
-	"golang.org/x/mod/sumdb/note"
 )
 
 // PublishOpts encapsulates parameters for the publish Main below.
 type PublishOpts struct {
 	LogURL         string
-	LogSigVerifier note.Verifier
+	LogSigVerifier api.LogVerifier
 	DeviceID       string
 	Revision       uint64
 	BinaryPath     string
 	Timestamp      string
 	OutputPath     string
+	// SourceURI locates a tarball of the source the firmware was built from, if available.
+	SourceURI string
+	// SourceRepository and SourceCommit identify the source the firmware was built from, if known.
+	SourceRepository string
+	SourceCommit     string
+	// ToolchainSHA256 is the digest of the toolchain used for the build, if known.
+	ToolchainSHA256 []byte
+	// BuilderID identifies who or what built the firmware, if known.
+	BuilderID string
+	// ProvenancePath is the path of an in-toto or SLSA provenance document for the
+	// build, whose digest is included in the metadata, if available.
+	ProvenancePath string
+	// Signer signs the firmware metadata statement.
+	Signer *crypto.Claimant
 }
 
 // Main is the entrypoint for the implementation of the publisher.
@@ -51,6 +65,9 @@ func Main(ctx context.Context, opts PublishOpts) error {
 	if err != nil {
 		return fmt.Errorf("LogURL is invalid: %w", err)
 	}
+	if opts.Signer == nil {
+		return errors.New("signer is required")
+	}
 
 	metadata, fw, err := createManifest(opts)
 	if err != nil {
@@ -59,7 +76,7 @@ func Main(ctx context.Context, opts PublishOpts) error {
 
 	glog.Infof("Measurement: %x", metadata.ExpectedFirmwareMeasurement)
 
-	js, err := createStatementJSON(metadata)
+	js, err := createStatementJSON(metadata, opts.Signer)
 	if err != nil {
 		return fmt.Errorf("failed to marshal statement: %w", err)
 	}
@@ -161,26 +178,36 @@ func createManifest(opts PublishOpts) (api.FirmwareMetadata, []byte, error) {
 		FirmwareImageSHA512:         h[:],
 		ExpectedFirmwareMeasurement: m,
 		BuildTimestamp:              buildTime,
+		SourceURI:                   opts.SourceURI,
+		SourceRepository:            opts.SourceRepository,
+		SourceCommit:                opts.SourceCommit,
+		ToolchainSHA256:             opts.ToolchainSHA256,
+		BuilderID:                   opts.BuilderID,
+	}
+	if len(opts.ProvenancePath) > 0 {
+		p, err := os.ReadFile(opts.ProvenancePath)
+		if err != nil {
+			return api.FirmwareMetadata{}, nil, fmt.Errorf("failed to read provenance %q: %w", opts.ProvenancePath, err)
+		}
+		ph := sha256.Sum256(p)
+		metadata.ProvenanceSHA256 = ph[:]
+	}
+	if err := metadata.Validate(); err != nil {
+		return api.FirmwareMetadata{}, nil, fmt.Errorf("invalid metadata: %w", err)
 	}
 
 	return metadata, fw, nil
 }
 
-func createStatementJSON(m api.FirmwareMetadata) ([]byte, error) {
+func createStatementJSON(m api.FirmwareMetadata, signer *crypto.Claimant) ([]byte, error) {
 	js, err := json.Marshal(m)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal metadata: %w", err)
 	}
-	sig, err := crypto.Publisher.SignMessage(api.FirmwareMetadataType, js)
+	statement, err := signer.SignStatement(api.FirmwareMetadataType, js)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate signature: %w", err)
 	}
 
-	statement := api.SignedStatement{
-		Type:      api.FirmwareMetadataType,
-		Statement: js,
-		Signature: sig,
-	}
-
 	return json.Marshal(statement)
 }
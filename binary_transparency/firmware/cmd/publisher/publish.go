@@ -17,13 +17,15 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
 	"flag"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
 	"github.com/google/trillian-examples/binary_transparency/firmware/cmd/publisher/impl"
 	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
-	"golang.org/x/mod/sumdb/note"
 )
 
 var (
@@ -35,6 +37,18 @@ var (
 	timestamp  = flag.String("timestamp", "", "timestamp formatted as RFC3339, or empty to use current time")
 	timeout    = flag.Duration("timeout", 5*time.Minute, "Duration to wait for inclusion of submitted metadata")
 	outputPath = flag.String("output_path", "/tmp/update.ota", "File path to write the update package file to. This file is intended to be consumed by the flash_tool only.")
+	keyFile    = flag.String("key_file", "", "Path to a PEM private key used to sign the firmware metadata, or empty to use the TEST/DEMO key")
+	keyID      = flag.String("key_id", "", "ID of the key in --key_file in the log's claimants config, included as a hint to verifiers")
+	sourceURI  = flag.String("source_uri", "", "URL or file path of a tarball of the source the firmware was built from, or empty if it's not available")
+
+	sourceRepo      = flag.String("source_repo", "", "URL of the repository the firmware was built from, or empty if unknown")
+	sourceCommit    = flag.String("source_commit", "", "Lowercase hex commit hash in --source_repo the firmware was built from, or empty if unknown")
+	toolchainSHA256 = flag.String("toolchain_sha256", "", "Hex SHA256 digest of the toolchain used for the build, e.g. the builder container image, or empty if unknown")
+	builderID       = flag.String("builder_id", "", "Identity of the builder, e.g. the URI of the CI system, or empty if unknown")
+	provenanceFile  = flag.String("provenance_file", "", "Path to an in-toto or SLSA provenance document for the build, whose digest is included in the metadata")
+
+	logOrigin     = flag.String("log_origin", api.FTLogOrigin, "Origin line expected on checkpoints from the log")
+	logPublicKeys = flag.String("log_public_keys", crypto.TestFTPersonalityPub, "Comma separated note verifier keys for the log; checkpoints signed by any of them are accepted")
 )
 
 func main() {
@@ -42,16 +56,39 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
 	defer cancel()
 
-	testLogSigV, _ := note.NewVerifier(crypto.TestFTPersonalityPub)
+	logSigV, err := api.NewLogVerifier(*logOrigin, strings.Split(*logPublicKeys, ",")...)
+	if err != nil {
+		glog.Exitf("Failed to create log verifier: %v", err)
+	}
+
+	signer := &crypto.Publisher
+	if len(*keyFile) > 0 {
+		var err error
+		if signer, err = crypto.LoadSigningClaimant(*keyFile, *keyID); err != nil {
+			glog.Exitf("Failed to load signing key: %v", err)
+		}
+	}
+
+	toolchain, err := hex.DecodeString(*toolchainSHA256)
+	if err != nil {
+		glog.Exitf("Invalid toolchain_sha256: %v", err)
+	}
 
 	if err := impl.Main(ctx, impl.PublishOpts{
-		LogURL:         *logURL,
-		DeviceID:       *deviceID,
-		Revision:       *revision,
-		BinaryPath:     *binaryPath,
-		Timestamp:      *timestamp,
-		OutputPath:     *outputPath,
-		LogSigVerifier: testLogSigV,
+		LogURL:           *logURL,
+		DeviceID:         *deviceID,
+		Revision:         *revision,
+		BinaryPath:       *binaryPath,
+		Timestamp:        *timestamp,
+		SourceURI:        *sourceURI,
+		SourceRepository: *sourceRepo,
+		SourceCommit:     *sourceCommit,
+		ToolchainSHA256:  toolchain,
+		BuilderID:        *builderID,
+		ProvenancePath:   *provenanceFile,
+		OutputPath:       *outputPath,
+		LogSigVerifier:   logSigV,
+		Signer:           signer,
 	}); err != nil {
 		glog.Exitf(err.Error())
 	}
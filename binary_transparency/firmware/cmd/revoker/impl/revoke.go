@@ -0,0 +1,124 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package impl is the implementation of a tool to revoke firmware in the log.
+package impl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/golang/glog"
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/client"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
+	"github.com/transparency-dev/merkle/proof"
+	"github.com/transparency-dev/merkle/rfc6962"
+)
+
+// RevokeOpts encapsulates parameters for the revoke Main below.
+type RevokeOpts struct {
+	LogURL         string
+	LogSigVerifier api.LogVerifier
+	// Index is the log index of the firmware metadata to revoke.
+	Index uint64
+	// Signer signs the revocation statement.
+	Signer *crypto.Claimant
+}
+
+// Main looks up the firmware at the given index, and logs a revocation for it.
+func Main(ctx context.Context, opts RevokeOpts) error {
+	logURL, err := url.Parse(opts.LogURL)
+	if err != nil {
+		return fmt.Errorf("LogURL is invalid: %w", err)
+	}
+	if opts.Signer == nil {
+		return errors.New("signer is required")
+	}
+
+	c := &client.SubmitClient{
+		ReadonlyClient: &client.ReadonlyClient{
+			LogURL:         logURL,
+			LogSigVerifier: opts.LogSigVerifier,
+		},
+	}
+
+	initialCP, err := c.GetCheckpoint()
+	if err != nil {
+		return fmt.Errorf("failed to get a pre-submission checkpoint from log: %w", err)
+	}
+	if opts.Index >= initialCP.Size {
+		return fmt.Errorf("index %d is beyond the log size %d", opts.Index, initialCP.Size)
+	}
+
+	// Fetch the firmware being revoked, and make sure it's really in the log.
+	ip, err := c.GetManifestEntryAndProof(api.GetFirmwareManifestRequest{Index: opts.Index, TreeSize: initialCP.Size})
+	if err != nil {
+		return fmt.Errorf("failed to get firmware at index %d: %w", opts.Index, err)
+	}
+	h := rfc6962.DefaultHasher
+	if err := proof.VerifyInclusion(h, opts.Index, initialCP.Size, h.HashLeaf(ip.Value), ip.Proof, initialCP.Hash); err != nil {
+		return fmt.Errorf("invalid inclusion proof for firmware: %w", err)
+	}
+	var fwStmt api.SignedStatement
+	if err := json.Unmarshal(ip.Value, &fwStmt); err != nil {
+		return fmt.Errorf("failed to unmarshal statement: %w", err)
+	}
+	if fwStmt.Type != api.FirmwareMetadataType {
+		return fmt.Errorf("statement at index %d is not firmware", opts.Index)
+	}
+	var meta api.FirmwareMetadata
+	if err := json.Unmarshal(fwStmt.Statement, &meta); err != nil {
+		return fmt.Errorf("failed to unmarshal firmware metadata: %w", err)
+	}
+	glog.Infof("Revoking firmware at index %d: %s", opts.Index, meta)
+
+	js, err := createStatementJSON(api.RevocationStatement{
+		FirmwareID: api.FirmwareID{
+			LogIndex:            opts.Index,
+			FirmwareImageSHA512: meta.FirmwareImageSHA512,
+		},
+	}, opts.Signer)
+	if err != nil {
+		return fmt.Errorf("failed to create revocation: %w", err)
+	}
+
+	glog.Info("Submitting revocation...")
+	if err := c.PublishRevocation(js); err != nil {
+		return fmt.Errorf("couldn't submit revocation: %w", err)
+	}
+
+	glog.Info("Successfully submitted revocation, waiting for inclusion...")
+	if _, _, _, err := client.AwaitInclusion(ctx, c.ReadonlyClient, *initialCP, js); err != nil {
+		return fmt.Errorf("failed while waiting for inclusion: %w", err)
+	}
+	glog.Infof("Successfully logged %s", js)
+	return nil
+}
+
+func createStatementJSON(r api.RevocationStatement, signer *crypto.Claimant) ([]byte, error) {
+	js, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal revocation: %w", err)
+	}
+	statement, err := signer.SignStatement(api.RevocationStatementType, js)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signature: %w", err)
+	}
+
+	return json.Marshal(statement)
+}
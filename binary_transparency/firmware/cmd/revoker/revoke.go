@@ -0,0 +1,67 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// revoke is a demo tool to log the revocation of firmware already in the log.
+package main
+
+import (
+	"context"
+	"flag"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian-examples/binary_transparency/firmware/cmd/revoker/impl"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
+)
+
+var (
+	logURL  = flag.String("log_url", "http://localhost:8000", "Base URL of the log HTTP API")
+	index   = flag.Uint64("index", 0, "The log index of the firmware metadata to revoke")
+	keyFile = flag.String("key_file", "", "Path to a PEM private key used to sign the revocation, or empty to use the TEST/DEMO key")
+	keyID   = flag.String("key_id", "", "ID of the key in --key_file in the log's claimants config, included as a hint to verifiers")
+	timeout = flag.Duration("timeout", 5*time.Minute, "Duration to wait for inclusion of submitted revocation")
+
+	logOrigin     = flag.String("log_origin", api.FTLogOrigin, "Origin line expected on checkpoints from the log")
+	logPublicKeys = flag.String("log_public_keys", crypto.TestFTPersonalityPub, "Comma separated note verifier keys for the log; checkpoints signed by any of them are accepted")
+)
+
+func main() {
+	flag.Parse()
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	logSigV, err := api.NewLogVerifier(*logOrigin, strings.Split(*logPublicKeys, ",")...)
+	if err != nil {
+		glog.Exitf("Failed to create log verifier: %v", err)
+	}
+
+	signer := &crypto.Revoker
+	if len(*keyFile) > 0 {
+		var err error
+		if signer, err = crypto.LoadSigningClaimant(*keyFile, *keyID); err != nil {
+			glog.Exitf("Failed to load signing key: %v", err)
+		}
+	}
+
+	if err := impl.Main(ctx, impl.RevokeOpts{
+		LogURL:         *logURL,
+		LogSigVerifier: logSigV,
+		Index:          *index,
+		Signer:         signer,
+	}); err != nil {
+		glog.Exit(err.Error())
+	}
+}
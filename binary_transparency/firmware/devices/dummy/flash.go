@@ -76,6 +76,11 @@ func (d Device) DeviceCheckpoint() ([]byte, error) {
 	return d.bundle.Checkpoint, nil
 }
 
+// DeviceManifest returns the firmware manifest statement for the installed firmware.
+func (d Device) DeviceManifest() ([]byte, error) {
+	return d.bundle.ManifestStatement, nil
+}
+
 // ApplyUpdate applies the firmware update to the dummy device.
 // The firmware image is stored in the dummy state directory in the firmware.bin file,
 // and the rest of the update bundle is stored in the bundle.json file.
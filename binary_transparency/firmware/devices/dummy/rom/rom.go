@@ -20,11 +20,10 @@ import (
 	"path/filepath"
 
 	"github.com/golang/glog"
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
 	"github.com/google/trillian-examples/binary_transparency/firmware/devices/dummy/common"
 	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
 	"github.com/google/trillian-examples/binary_transparency/firmware/internal/verify"
-
-	"golang.org/x/mod/sumdb/note"
 )
 
 const (
@@ -45,8 +44,12 @@ type Chain func() error
 // Other, real-world, devices with secure elements may be able to optimise this
 // process by checking once and leveraging properties of the hardware.
 //
+// The bundle's checkpoint must be signed by one of the log keys in logSigVerifier, which
+// stands in for the keys burned into the ROM; listing several keys lets devices survive a
+// rotation of the log's key.
+//
 // Returns the first link in the boot chain as a func.
-func Reset(storagePath string) (Chain, error) {
+func Reset(storagePath string, logSigVerifier api.LogVerifier) (Chain, error) {
 	glog.Info("----RESET----")
 	glog.Info("Powering up bananas, configuring Romulans, feeding the watchdogs")
 
@@ -71,11 +74,7 @@ func Reset(storagePath string) (Chain, error) {
 	}
 
 	// validate bundle
-	v, err := note.NewVerifier(crypto.TestFTPersonalityPub)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create sig verifier: %w", err)
-	}
-	if err := verify.BundleForBoot(bundleRaw, fwMeasurement[:], v); err != nil {
+	if err := verify.BundleForBoot(bundleRaw, fwMeasurement[:], logSigVerifier, crypto.TestClaimantRegistry()); err != nil {
 		return nil, fmt.Errorf("failed to verify bundle: %w", err)
 	}
 
@@ -7,12 +7,13 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"strings"
 	"time"
 
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
 	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
 	"github.com/google/trillian-examples/binary_transparency/firmware/internal/verify"
 	"github.com/usbarmory/tamago/soc/nxp/imx6ul"
-	"golang.org/x/mod/sumdb/note"
 )
 
 const (
@@ -44,9 +45,19 @@ func verifyIntegrity(proof, firmware *Partition) error {
 		return fmt.Errorf("failed to hash firmware partition: %w\n", err)
 	}
 	fmt.Printf("firmware partition hash: 0x%x\n", h)
-	logSigVerifier, err := note.NewVerifier(crypto.TestFTPersonalityPub)
+	origin, keys := api.FTLogOrigin, crypto.TestFTPersonalityPub
+	if len(LogOrigin) > 0 {
+		origin = LogOrigin
+	}
+	if len(LogPublicKeys) > 0 {
+		keys = LogPublicKeys
+	}
+	logVerifier, err := api.NewLogVerifier(origin, strings.Split(keys, ",")...)
+	if err != nil {
+		return fmt.Errorf("failed to create log verifier: %w", err)
+	}
 
-	if err := verify.BundleForBoot(rawBundle, h, logSigVerifier); err != nil {
+	if err := verify.BundleForBoot(rawBundle, h, logVerifier, crypto.TestClaimantRegistry()); err != nil {
 		return fmt.Errorf("failed to verify bundle: %w", err)
 	}
 	return nil
@@ -30,6 +30,12 @@ var StartProof string
 
 var PublicKeyStr string
 
+// LogOrigin and LogPublicKeys identify the log which proof bundles must come from, and
+// default to the TEST/DEMO log. LogPublicKeys is a comma separated list of note verifier
+// keys, any one of which may have signed the bundle's checkpoint.
+var LogOrigin string
+var LogPublicKeys string
+
 var (
 	partition      *Partition
 	proofPartition *Partition
@@ -88,6 +88,11 @@ func (d Device) DeviceCheckpoint() ([]byte, error) {
 	return d.bundle.Checkpoint, nil
 }
 
+// DeviceManifest returns the firmware manifest statement for the installed firmware.
+func (d Device) DeviceManifest() ([]byte, error) {
+	return d.bundle.ManifestStatement, nil
+}
+
 // ApplyUpdate applies the firmware update to the armory SD Card device.
 // The firmware image is written directly to the unikernel partition of the device
 // (the raw block device is specified by the --armory_unikernel_dev flag),
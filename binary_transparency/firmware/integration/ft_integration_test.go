@@ -51,15 +51,24 @@ var (
 	trillianAddr = flag.String("trillian", "", "Host:port of Trillian Log RPC server")
 )
 
-func mustGetLogSigVerifier(t *testing.T) note.Verifier {
+func mustGetLogSigVerifier(t *testing.T) api.LogVerifier {
 	t.Helper()
-	v, err := note.NewVerifier(crypto.TestFTPersonalityPub)
+	v, err := api.NewLogVerifier(api.FTLogOrigin, crypto.TestFTPersonalityPub)
 	if err != nil {
 		t.Fatalf("Failed to create CP verifier: %q", err)
 	}
 	return v
 }
 
+func mustGetWitnessVerifier(t *testing.T) note.Verifier {
+	t.Helper()
+	v, err := note.NewVerifier(crypto.TestWitnessPub)
+	if err != nil {
+		t.Fatalf("Failed to create witness verifier: %q", err)
+	}
+	return v
+}
+
 func TestFTIntegration(t *testing.T) {
 	if len(*trillianAddr) == 0 {
 		t.Skip("--trillian flag unset, skipping test")
@@ -101,6 +110,7 @@ func TestFTIntegration(t *testing.T) {
 				return i_publish.Main(ctx, i_publish.PublishOpts{
 					LogURL:         pAddr,
 					LogSigVerifier: logSigVerifier,
+					Signer:         &crypto.Publisher,
 					DeviceID:       "dummy",
 					BinaryPath:     GoodFirmware,
 					Timestamp:      PublishTimestamp1,
@@ -114,7 +124,7 @@ func TestFTIntegration(t *testing.T) {
 				return i_flash.Main(ctx, i_flash.FlashOpts{
 					LogURL:         pAddr,
 					LogSigVerifier: logSigVerifier,
-					WitnessURL:     "",
+					Claimants:      crypto.TestClaimantRegistry(),
 					DeviceID:       "dummy",
 					UpdateFile:     updatePath,
 					DeviceStorage:  devStoragePath,
@@ -134,6 +144,7 @@ func TestFTIntegration(t *testing.T) {
 				return i_publish.Main(ctx, i_publish.PublishOpts{
 					LogURL:         pAddr,
 					LogSigVerifier: logSigVerifier,
+					Signer:         &crypto.Publisher,
 					DeviceID:       "dummy",
 					BinaryPath:     GoodFirmware,
 					Timestamp:      PublishTimestamp2,
@@ -147,7 +158,7 @@ func TestFTIntegration(t *testing.T) {
 				return i_flash.Main(ctx, i_flash.FlashOpts{
 					LogURL:         pAddr,
 					LogSigVerifier: logSigVerifier,
-					WitnessURL:     "",
+					Claimants:      crypto.TestClaimantRegistry(),
 					DeviceID:       "dummy",
 					UpdateFile:     updatePath,
 					DeviceStorage:  devStoragePath,
@@ -240,10 +251,11 @@ func TestFTIntegration(t *testing.T) {
 				if err := i_publish.Main(ctx, i_publish.PublishOpts{
 					LogURL:         pAddr,
 					LogSigVerifier: logSigVerifier,
+					Signer:         &crypto.Publisher,
 					DeviceID:       "dummy",
 					BinaryPath:     HackedFirmware,
 					Timestamp:      PublishMalwareTimestamp,
-					Revision:       1,
+					Revision:       3,
 					OutputPath:     updatePath,
 				}); err != nil {
 					t.Fatalf("Failed to log malware: %q", err)
@@ -255,7 +267,7 @@ func TestFTIntegration(t *testing.T) {
 				if err := i_flash.Main(ctx, i_flash.FlashOpts{
 					LogURL:         pAddr,
 					LogSigVerifier: logSigVerifier,
-					WitnessURL:     "",
+					Claimants:      crypto.TestClaimantRegistry(),
 					DeviceID:       "dummy",
 					UpdateFile:     updatePath,
 					DeviceStorage:  devStoragePath,
@@ -301,10 +313,11 @@ func TestFTIntegration(t *testing.T) {
 				if err := i_publish.Main(ctx, i_publish.PublishOpts{
 					LogURL:         pAddr,
 					LogSigVerifier: logSigVerifier,
+					Signer:         &crypto.Publisher,
 					DeviceID:       "dummy",
 					BinaryPath:     GoodFirmware,
 					Timestamp:      PublishTimestamp3,
-					Revision:       3,
+					Revision:       4,
 					OutputPath:     updatePath,
 				}); err != nil {
 					t.Fatalf("Failed to publish new bundle: %q", err)
@@ -316,7 +329,8 @@ func TestFTIntegration(t *testing.T) {
 				if err := i_flash.Main(ctx, i_flash.FlashOpts{
 					LogURL:         pAddr,
 					LogSigVerifier: logSigVerifier,
-					WitnessURL:     wAddr,
+					Claimants:      crypto.TestClaimantRegistry(),
+					Witnesses:      []i_flash.Witness{{URL: wAddr, Verifier: mustGetWitnessVerifier(t)}},
 					DeviceID:       "dummy",
 					UpdateFile:     updatePath,
 					DeviceStorage:  devStoragePath,
@@ -375,23 +389,30 @@ func runPersonality(ctx context.Context, t *testing.T, serverAddr string) error
 		TrillianAddr:   *trillianAddr,
 		ConnectTimeout: 10 * time.Second,
 		STHRefresh:     time.Second,
-		Signer:         signer,
+		TilesDir:       filepath.Join(r, "tiles"),
+		Origin:         api.FTLogOrigin,
+		Signers:        []note.Signer{signer},
+		Claimants:      crypto.TestClaimantRegistry(),
 	}); err != http.ErrServerClosed {
 		return err
 	}
 	return nil
 }
 
-func runWitness(ctx context.Context, t *testing.T, persAddr, serverAddr string, logSigVerifier note.Verifier) error {
+func runWitness(ctx context.Context, t *testing.T, persAddr, serverAddr string, logSigVerifier api.LogVerifier) error {
 	t.Helper()
 	r := t.TempDir()
+	signer, err := note.NewSigner(crypto.TestWitnessPriv)
+	if err != nil {
+		return err
+	}
 
-	err := i_witness.Main(ctx, i_witness.WitnessOpts{
-		ListenAddr:       serverAddr,
-		WSFile:           filepath.Join(r, "ft-witness.db"),
-		FtLogURL:         persAddr,
-		FtLogSigVerifier: logSigVerifier,
-		PollInterval:     5 * time.Second,
+	err = i_witness.Main(ctx, i_witness.WitnessOpts{
+		ListenAddr:   serverAddr,
+		WSFile:       filepath.Join(r, "ft-witness.db"),
+		Logs:         []i_witness.LogConfig{{Verifier: logSigVerifier, URL: persAddr}},
+		Signer:       signer,
+		PollInterval: 5 * time.Second,
 	})
 	if err != http.ErrServerClosed {
 		return err
@@ -399,7 +420,7 @@ func runWitness(ctx context.Context, t *testing.T, persAddr, serverAddr string,
 	return nil
 }
 
-func runMonitor(ctx context.Context, t *testing.T, serverAddr string, pattern string, logSigVerifier note.Verifier, matched i_monitor.MatchFunc) error {
+func runMonitor(ctx context.Context, t *testing.T, serverAddr string, pattern string, logSigVerifier api.LogVerifier, matched i_monitor.MatchFunc) error {
 	t.Helper()
 
 	r := t.TempDir()
@@ -407,10 +428,12 @@ func runMonitor(ctx context.Context, t *testing.T, serverAddr string, pattern st
 	err := i_monitor.Main(ctx, i_monitor.MonitorOpts{
 		LogURL:         serverAddr,
 		LogSigVerifier: logSigVerifier,
+		UseTiles:       true,
 		PollInterval:   1 * time.Second,
 		Keyword:        "H4x0r3d",
 		Matched:        matched,
-		StateFile:      filepath.Join(r, "ft-monitor.state"),
+		StateFile:      filepath.Join(r, "ft-monitor.db"),
+		Claimants:      crypto.TestClaimantRegistry(),
 	})
 	if err != http.ErrServerClosed {
 		return err
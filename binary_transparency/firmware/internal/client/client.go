@@ -16,6 +16,7 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -28,7 +29,6 @@ import (
 	"github.com/golang/glog"
 	"github.com/google/trillian-examples/binary_transparency/firmware/api"
 	"github.com/transparency-dev/merkle/rfc6962"
-	"golang.org/x/mod/sumdb/note"
 	"google.golang.org/grpc/status"
 )
 
@@ -39,7 +39,12 @@ type ReadonlyClient struct {
 	// LogURL is the base URL for the FT log.
 	LogURL *url.URL
 
-	LogSigVerifier note.Verifier
+	LogSigVerifier api.LogVerifier
+
+	// UseTiles fetches checkpoints and builds proofs from the tile-based view of the log
+	// published under api.HTTPTiles, rather than asking the log for them. Tiles are static
+	// files, so can be served from caches close to the client.
+	UseTiles bool
 }
 
 // SubmitClient extends ReadonlyClient to also know how to submit entries
@@ -108,7 +113,32 @@ func (c SubmitClient) PublishFirmware(manifest, image []byte) error {
 
 // PublishAnnotationMalware publishes the serialized annotation to the log.
 func (c SubmitClient) PublishAnnotationMalware(stmt []byte) error {
-	u, err := c.LogURL.Parse(api.HTTPAddAnnotationMalware)
+	return c.publishStatement(api.HTTPAddAnnotationMalware, stmt)
+}
+
+// PublishRevocation publishes the serialized revocation statement to the log.
+func (c SubmitClient) PublishRevocation(stmt []byte) error {
+	return c.publishStatement(api.HTTPAddRevocation, stmt)
+}
+
+// PublishAnnotationBuild publishes the serialized build annotation to the log.
+func (c SubmitClient) PublishAnnotationBuild(stmt []byte) error {
+	return c.publishStatement(api.HTTPAddAnnotationBuild, stmt)
+}
+
+// PublishAnnotationSBOM publishes the serialized SBOM annotation to the log.
+func (c SubmitClient) PublishAnnotationSBOM(stmt []byte) error {
+	return c.publishStatement(api.HTTPAddAnnotationSBOM, stmt)
+}
+
+// PublishAnnotationVulnerability publishes the serialized vulnerability annotation to the log.
+func (c SubmitClient) PublishAnnotationVulnerability(stmt []byte) error {
+	return c.publishStatement(api.HTTPAddAnnotationVulnerability, stmt)
+}
+
+// publishStatement posts a serialized SignedStatement to the given log path.
+func (c SubmitClient) publishStatement(path string, stmt []byte) error {
+	u, err := c.LogURL.Parse(path)
 	if err != nil {
 		return err
 	}
@@ -125,7 +155,29 @@ func (c SubmitClient) PublishAnnotationMalware(stmt []byte) error {
 
 // GetCheckpoint returns a new LogCheckPoint from the server.
 func (c ReadonlyClient) GetCheckpoint() (*api.LogCheckpoint, error) {
-	u, err := c.LogURL.Parse(api.HTTPGetRoot)
+	if c.UseTiles {
+		return c.getTileCheckpoint(context.Background())
+	}
+	return c.fetchCheckpoint(api.HTTPGetRoot)
+}
+
+// GetCheckpointAtSize returns the LogCheckpoint for the given tree size, if the
+// server signed one recently. Returns an error if the server returns a checkpoint
+// for a different size.
+func (c ReadonlyClient) GetCheckpointAtSize(size uint64) (*api.LogCheckpoint, error) {
+	cp, err := c.fetchCheckpoint(fmt.Sprintf("%s/at-size/%d", api.HTTPGetRoot, size))
+	if err != nil {
+		return nil, err
+	}
+	if cp.Size != size {
+		return nil, fmt.Errorf("asked for checkpoint at size %d, but got size %d", size, cp.Size)
+	}
+	return cp, nil
+}
+
+// fetchCheckpoint fetches and verifies a checkpoint from the given log path.
+func (c ReadonlyClient) fetchCheckpoint(path string) (*api.LogCheckpoint, error) {
+	u, err := c.LogURL.Parse(path)
 	if err != nil {
 		return nil, err
 	}
@@ -153,6 +205,9 @@ func (c ReadonlyClient) GetCheckpoint() (*api.LogCheckpoint, error) {
 // GetInclusion returns an inclusion proof for the statement under the given checkpoint.
 func (c ReadonlyClient) GetInclusion(statement []byte, cp api.LogCheckpoint) (api.InclusionProof, error) {
 	hash := rfc6962.DefaultHasher.HashLeaf(statement)
+	if c.UseTiles {
+		return c.getTileInclusion(context.Background(), hash, cp)
+	}
 	u, err := c.LogURL.Parse(fmt.Sprintf("%s/for-leaf-hash/%s/in-tree-of/%d", api.HTTPGetInclusion, base64.URLEncoding.EncodeToString(hash), cp.Size))
 	if err != nil {
 		return api.InclusionProof{}, err
@@ -174,6 +229,9 @@ func (c ReadonlyClient) GetInclusion(statement []byte, cp api.LogCheckpoint) (ap
 // GetManifestEntryAndProof returns the manifest and proof from the server, for given Index and TreeSize
 // TODO(mhutchinson): Rename this as leaf values can also be annotations.
 func (c ReadonlyClient) GetManifestEntryAndProof(request api.GetFirmwareManifestRequest) (*api.InclusionProof, error) {
+	if c.UseTiles {
+		return c.getTileEntryAndProof(context.Background(), request)
+	}
 	url := fmt.Sprintf("%s/at/%d/in-tree-of/%d", api.HTTPGetManifestEntryAndProof, request.Index, request.TreeSize)
 
 	u, err := c.LogURL.Parse(url)
@@ -199,6 +257,9 @@ func (c ReadonlyClient) GetManifestEntryAndProof(request api.GetFirmwareManifest
 
 // GetConsistencyProof returns the Consistency Proof from the server, for the two given snapshots
 func (c ReadonlyClient) GetConsistencyProof(request api.GetConsistencyRequest) (*api.ConsistencyProof, error) {
+	if c.UseTiles {
+		return c.getTileConsistencyProof(context.Background(), request)
+	}
 	url := fmt.Sprintf("%s/from/%d/to/%d", api.HTTPGetConsistency, request.From, request.To)
 	u, err := c.LogURL.Parse(url)
 	if err != nil {
@@ -223,27 +284,42 @@ func (c ReadonlyClient) GetConsistencyProof(request api.GetConsistencyRequest) (
 
 // GetFirmwareImage returns the firmware image with the corresponding hash from the personality CAS.
 func (c ReadonlyClient) GetFirmwareImage(hash []byte) ([]byte, error) {
+	var b bytes.Buffer
+	if _, err := c.StreamFirmwareImage(hash, &b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// StreamFirmwareImage writes the firmware image with the corresponding hash from the
+// personality CAS to w as it is downloaded, without holding the whole image in memory.
+// Returns the number of bytes written.
+func (c ReadonlyClient) StreamFirmwareImage(hash []byte, w io.Writer) (int64, error) {
 	url := fmt.Sprintf("%s/with-hash/%s", api.HTTPGetFirmwareImage, base64.URLEncoding.EncodeToString(hash))
 
 	u, err := c.LogURL.Parse(url)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
 	r, err := http.Get(u.String())
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			glog.Errorf("r.Body.Close(): %v", err)
+		}
+	}()
 	if r.StatusCode != 200 {
-		return nil, errFromResponse("failed to fetch firmware image", r)
+		return 0, errFromResponse("failed to fetch firmware image", r)
 	}
 
-	b, err := io.ReadAll(r.Body)
+	n, err := io.Copy(w, r.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read firmware image from response: %w", err)
+		return n, fmt.Errorf("failed to read firmware image from response: %w", err)
 	}
-
-	return b, nil
+	return n, nil
 }
 
 func errFromResponse(m string, r *http.Response) error {
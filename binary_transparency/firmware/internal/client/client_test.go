@@ -23,6 +23,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -47,9 +49,9 @@ func mustSignCPNote(t *testing.T, b string) []byte {
 	return n
 }
 
-func mustGetLogSigVerifier(t *testing.T) note.Verifier {
+func mustGetLogSigVerifier(t *testing.T) api.LogVerifier {
 	t.Helper()
-	v, err := note.NewVerifier(crypto.TestFTPersonalityPub)
+	v, err := api.NewLogVerifier(api.FTLogOrigin, crypto.TestFTPersonalityPub)
 	if err != nil {
 		t.Fatalf("failed to create verifier: %q", err)
 	}
@@ -115,6 +117,56 @@ func TestPublish(t *testing.T) {
 	}
 }
 
+func TestPublishRevocation(t *testing.T) {
+	for _, test := range []struct {
+		desc    string
+		stmt    []byte
+		wantErr bool
+	}{
+		{
+			desc: "valid",
+			stmt: []byte("Revoked!"),
+		}, {
+			desc:    "log server fails",
+			stmt:    []byte("Revoked!"),
+			wantErr: true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got, want := r.URL.Path[1:], api.HTTPAddRevocation; got != want {
+					t.Fatalf("Got unexpected HTTP request on %q", r.URL.Path)
+				}
+				if test.wantErr {
+					http.Error(w, "BOOM", http.StatusInternalServerError)
+					return
+				}
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Fatalf("Failed to read body: %v", err)
+				}
+				if diff := cmp.Diff(body, test.stmt); len(diff) != 0 {
+					t.Errorf("POSTed body with unexpected diff: %v", diff)
+				}
+			}))
+			defer ts.Close()
+
+			tsURL, err := url.Parse((ts.URL))
+			if err != nil {
+				t.Fatalf("Failed to parse test server URL: %v", err)
+			}
+			c := client.SubmitClient{ReadonlyClient: &client.ReadonlyClient{LogURL: tsURL}}
+			err = c.PublishRevocation(test.stmt)
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("Got unexpected error %q", err)
+			case err == nil && test.wantErr:
+				t.Fatal("Got no error, but wanted error")
+			}
+		})
+	}
+}
+
 // parseAddFirmwareRequest returns the bytes for the SignedStatement, and the firmware image respectively.
 // TODO(mhutchinson): For now this is a copy of the server code. de-dupe this.
 func parseAddFirmwareRequest(r *http.Request) ([]byte, []byte, error) {
@@ -231,6 +283,73 @@ func TestGetCheckpoint(t *testing.T) {
 	}
 }
 
+func TestGetCheckpointAtSize(t *testing.T) {
+	for _, test := range []struct {
+		desc     string
+		size     uint64
+		notFound bool
+		wantPath string
+		wantSize uint64
+		wantErr  bool
+	}{
+		{
+			desc:     "in history",
+			size:     10,
+			wantPath: "/ft/v0/get-root/at-size/10",
+			wantSize: 10,
+		}, {
+			desc:     "not in history",
+			size:     5,
+			notFound: true,
+			wantPath: "/ft/v0/get-root/at-size/5",
+			wantErr:  true,
+		}, {
+			desc:     "wrong size returned",
+			size:     7,
+			wantPath: "/ft/v0/get-root/at-size/7",
+			wantErr:  true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got, want := r.URL.Path, test.wantPath; got != want {
+					t.Fatalf("Got HTTP request on %q, want %q", got, want)
+				}
+				if test.notFound {
+					http.Error(w, "no recent checkpoint", http.StatusNotFound)
+					return
+				}
+				if _, err := w.Write(mustSignCPNote(t, "Firmware Transparency Log\n10\nNBI=\n1230\n")); err != nil {
+					t.Errorf("w.Write: %v", err)
+				}
+			}))
+			defer ts.Close()
+
+			tsURL, err := url.Parse((ts.URL))
+			if err != nil {
+				t.Fatalf("Failed to parse test server URL: %v", err)
+			}
+			c := client.ReadonlyClient{
+				LogURL:         tsURL,
+				LogSigVerifier: mustGetLogSigVerifier(t),
+			}
+			cp, err := c.GetCheckpointAtSize(test.size)
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("Got unexpected error %q", err)
+			case err == nil && test.wantErr:
+				t.Fatal("Got no error, but wanted error")
+			case err != nil && test.wantErr:
+				// expected error
+			default:
+				if got, want := cp.Size, test.wantSize; got != want {
+					t.Errorf("Got checkpoint of size %d, want %d", got, want)
+				}
+			}
+		})
+	}
+}
+
 func TestGetInclusion(t *testing.T) {
 	cp := api.LogCheckpoint{
 		Checkpoint: log.Checkpoint{
@@ -474,3 +593,45 @@ func TestGetFirmwareImage(t *testing.T) {
 		})
 	}
 }
+
+func TestStreamFirmwareImage(t *testing.T) {
+	image := []byte("a firmware image which is streamed to disk")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path[1:], api.HTTPGetFirmwareImage) {
+			t.Fatalf("Got unexpected HTTP request on %q", r.URL.Path)
+		}
+		if _, err := w.Write(image); err != nil {
+			t.Errorf("w.Write(): %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	tsURL, err := url.Parse((ts.URL))
+	if err != nil {
+		t.Fatalf("Failed to parse test server URL: %v", err)
+	}
+	c := client.ReadonlyClient{LogURL: tsURL}
+
+	path := filepath.Join(t.TempDir(), "firmware.bin")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Create(): %v", err)
+	}
+	n, err := c.StreamFirmwareImage([]byte("hash"), f)
+	if err != nil {
+		t.Fatalf("StreamFirmwareImage(): %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close(): %v", err)
+	}
+	if got, want := n, int64(len(image)); got != want {
+		t.Errorf("got %d bytes written, want %d", got, want)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(): %v", err)
+	}
+	if !bytes.Equal(got, image) {
+		t.Errorf("got file contents %q, want %q", got, image)
+	}
+}
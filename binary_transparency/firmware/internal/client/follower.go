@@ -34,6 +34,8 @@ import (
 // if needed.
 type ErrConsistency struct {
 	Golden, Latest api.LogCheckpoint
+	// Proof is the consistency proof returned by the log, which failed to verify.
+	Proof [][]byte
 }
 
 func (e ErrConsistency) Error() string {
@@ -51,24 +53,43 @@ func (e ErrInclusion) Error() string {
 	return fmt.Sprintf("failed to verify inclusion proof %s in root %s", e.Proof, e.Checkpoint)
 }
 
+var (
+	// minFetchBackoff and maxFetchBackoff bound the time that Entries waits before
+	// fetching an entry again after a failure.
+	minFetchBackoff = time.Second
+	maxFetchBackoff = time.Minute
+)
+
 // LogEntry wraps up a leaf value with its position in the log.
 type LogEntry struct {
 	Root  api.LogCheckpoint
 	Index uint64
 	Value api.SignedStatement
+	// Unverified is set if the signature on Value couldn't be verified by the claimants.
+	// Such entries are only output if the LogFollower allows them.
+	Unverified bool
 }
 
 // LogFollower follows a log for new data becoming available.
 type LogFollower struct {
-	c ReadonlyClient
-	h merkle.LogHasher
+	c         ReadonlyClient
+	h         merkle.LogHasher
+	claimants *crypto.ClaimantRegistry
+
+	// AllowUnverified makes Entries output statements whose signature can't be verified
+	// by the claimants, e.g. because they are from a signer that isn't trusted, with
+	// Unverified set. Otherwise, Entries fails when it finds such a statement.
+	AllowUnverified bool
 }
 
 // NewLogFollower creates a LogFollower that uses the given client.
-func NewLogFollower(c ReadonlyClient) LogFollower {
+// The signatures on entries returned by Entries are checked against the claimants,
+// which may be nil if only Checkpoints will be used.
+func NewLogFollower(c ReadonlyClient, claimants *crypto.ClaimantRegistry) LogFollower {
 	return LogFollower{
-		c: c,
-		h: rfc6962.DefaultHasher,
+		c:         c,
+		h:         rfc6962.DefaultHasher,
+		claimants: claimants,
 	}
 }
 
@@ -123,6 +144,7 @@ func (f *LogFollower) Checkpoints(ctx context.Context, pollInterval time.Duratio
 					errc <- ErrConsistency{
 						Golden: golden,
 						Latest: *cp,
+						Proof:  consistency.Proof,
 					}
 					return
 				}
@@ -138,6 +160,8 @@ func (f *LogFollower) Checkpoints(ctx context.Context, pollInterval time.Duratio
 // Entries follows the log to output all of the leaves starting from the head index provided.
 // This is intended to be set up to consume the output of #Checkpoints(), and will output new
 // entries each time a Checkpoint becomes available which is larger than the current head.
+// Entries are output in order without gaps: an entry which can't be fetched is retried,
+// with backoff, until it can be or the context is canceled.
 // The input channel should be closed in order to clean up the resources used by this method.
 func (f *LogFollower) Entries(ctx context.Context, cpc <-chan api.LogCheckpoint, head uint64) (<-chan LogEntry, <-chan error) {
 	outc := make(chan LogEntry, 1)
@@ -147,10 +171,10 @@ func (f *LogFollower) Entries(ctx context.Context, cpc <-chan api.LogCheckpoint,
 		defer close(outc)
 		for cp := range cpc {
 			for ; head < cp.Size; head++ {
-				ep, err := f.c.GetManifestEntryAndProof(api.GetFirmwareManifestRequest{Index: head, TreeSize: cp.Size})
+				ep, err := f.fetchEntry(ctx, head, cp.Size)
 				if err != nil {
-					glog.Warningf("Failed to fetch the Manifest: %q", err)
-					continue
+					errc <- err
+					return
 				}
 				lh := f.h.HashLeaf(ep.Value)
 				if err := proof.VerifyInclusion(f.h, ep.LeafIndex, cp.Size, lh, ep.Proof, cp.Hash); err != nil {
@@ -169,23 +193,44 @@ func (f *LogFollower) Entries(ctx context.Context, cpc <-chan api.LogCheckpoint,
 					return
 				}
 
-				claimant, err := crypto.ClaimantForType(stmt.Type)
-				if err != nil {
-					errc <- err
-					return
-				}
 				// Verify the signature:
-				if err := claimant.VerifySignature(stmt.Type, stmt.Statement, stmt.Signature); err != nil {
-					errc <- fmt.Errorf("failed to verify signature: %q", err)
-					return
+				unverified := false
+				if _, err := f.claimants.VerifyStatement(stmt); err != nil {
+					if !f.AllowUnverified {
+						errc <- fmt.Errorf("failed to verify signature: %q", err)
+						return
+					}
+					unverified = true
 				}
 				outc <- LogEntry{
-					Root:  cp,
-					Index: head,
-					Value: stmt,
+					Root:       cp,
+					Index:      head,
+					Value:      stmt,
+					Unverified: unverified,
 				}
 			}
 		}
 	}()
 	return outc, errc
 }
+
+// fetchEntry fetches the entry at index from the tree of the given size, retrying with
+// backoff until it succeeds or the context is canceled.
+func (f *LogFollower) fetchEntry(ctx context.Context, index, size uint64) (*api.InclusionProof, error) {
+	backoff := minFetchBackoff
+	for {
+		ep, err := f.c.GetManifestEntryAndProof(api.GetFirmwareManifestRequest{Index: index, TreeSize: size})
+		if err == nil {
+			return ep, nil
+		}
+		glog.Warningf("Failed to fetch the Manifest at index %d, will retry after %v: %q", index, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if backoff *= 2; backoff > maxFetchBackoff {
+			backoff = maxFetchBackoff
+		}
+	}
+}
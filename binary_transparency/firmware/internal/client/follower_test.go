@@ -0,0 +1,104 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
+	"github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/merkle/testonly"
+)
+
+func TestEntriesRetriesFailedFetch(t *testing.T) {
+	oldMin, oldMax := minFetchBackoff, maxFetchBackoff
+	minFetchBackoff, maxFetchBackoff = time.Millisecond, time.Millisecond
+	t.Cleanup(func() { minFetchBackoff, maxFetchBackoff = oldMin, oldMax })
+	tree := testonly.New(rfc6962.DefaultHasher)
+	var leaves [][]byte
+	for i := 0; i < 3; i++ {
+		stmt, err := crypto.Publisher.SignStatement(api.FirmwareMetadataType, []byte(fmt.Sprintf(`{"FirmwareRevision": %d}`, i)))
+		if err != nil {
+			t.Fatalf("SignStatement(): %v", err)
+		}
+		leaf, err := json.Marshal(stmt)
+		if err != nil {
+			t.Fatalf("json.Marshal(): %v", err)
+		}
+		tree.AppendData(leaf)
+		leaves = append(leaves, leaf)
+	}
+	size := uint64(len(leaves))
+
+	// The log fails the first two requests for the entry at index 1.
+	failures := 2
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var index, treeSize uint64
+		if _, err := fmt.Sscanf(r.URL.Path, "/"+api.HTTPGetManifestEntryAndProof+"/at/%d/in-tree-of/%d", &index, &treeSize); err != nil {
+			t.Errorf("Got unexpected HTTP request on %q", r.URL.Path)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if index == 1 && failures > 0 {
+			failures--
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		p, err := tree.InclusionProof(index, treeSize)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(api.InclusionProof{Value: leaves[index], LeafIndex: index, Proof: p})
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(): %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	f := NewLogFollower(ReadonlyClient{LogURL: u}, crypto.TestClaimantRegistry())
+	cpc := make(chan api.LogCheckpoint, 1)
+	cpc <- api.LogCheckpoint{Checkpoint: log.Checkpoint{Size: size, Hash: tree.HashAt(size)}}
+	close(cpc)
+	ec, errc := f.Entries(ctx, cpc, 0)
+
+	var got []uint64
+	for e := range ec {
+		got = append(got, e.Index)
+	}
+	select {
+	case err := <-errc:
+		t.Fatalf("Entries(): %v", err)
+	default:
+	}
+	if want := []uint64{0, 1, 2}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got entries %v, want %v", got, want)
+	}
+	if failures != 0 {
+		t.Errorf("log had %d failures left, want 0", failures)
+	}
+}
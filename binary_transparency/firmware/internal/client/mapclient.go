@@ -15,6 +15,7 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha512"
 	"encoding/base64"
@@ -23,86 +24,141 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"sync"
 
 	"github.com/golang/glog"
 	"github.com/google/trillian-examples/binary_transparency/firmware/api"
-	"github.com/google/trillian/merkle/coniks"
-	"github.com/google/trillian/merkle/smt"
-	"github.com/google/trillian/merkle/smt/node"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/verify"
+	"golang.org/x/mod/sumdb/note"
 	"golang.org/x/sync/errgroup"
 )
 
 // MapClient is a client that exposes the operations on a remote map.
 type MapClient struct {
-	mapURL *url.URL
+	mapURL      *url.URL
+	mapVerifier note.Verifier
+
+	mu sync.Mutex
+	// roots holds the root hash of each revision with a verified checkpoint.
+	roots map[uint64][]byte
 }
 
-// NewMapClient creates a MapClient for a map hosted at the given URL.
-func NewMapClient(mapURL string) (*MapClient, error) {
+// NewMapClient creates a MapClient for a map hosted at the given URL, whose
+// checkpoints are signed by the map operator key that v verifies.
+func NewMapClient(mapURL string, v note.Verifier) (*MapClient, error) {
 	u, err := url.Parse(mapURL)
 	if err != nil {
 		return nil, err
 	}
 	return &MapClient{
-		mapURL: u,
+		mapURL:      u,
+		mapVerifier: v,
+		roots:       make(map[uint64][]byte),
 	}, nil
 }
 
-// MapCheckpoint returns the Checkpoint for the latest map revision.
+// MapCheckpoint returns the Checkpoint for the latest map revision, once the
+// signature from the map operator has been verified. Values can only be read
+// from revisions for which a checkpoint has been returned.
 // This map root needs to be taken on trust that it isn't forked etc.
 // To remove this trust, the map roots should be stored in a log, and
 // this would further return:
 // * A Log Checkpoint for the MapCheckpointLog
 // * An inclusion proof for this checkpoint within it
 func (c *MapClient) MapCheckpoint() (api.MapCheckpoint, error) {
-	mcp := api.MapCheckpoint{}
-	u, err := c.mapURL.Parse(api.MapHTTPGetCheckpoint)
+	b, err := c.fetch(api.MapHTTPGetCheckpoint)
 	if err != nil {
-		return mcp, err
+		return api.MapCheckpoint{}, err
 	}
-	r, err := http.Get(u.String())
+	mcp, err := api.ParseMapCheckpoint(b, c.mapVerifier)
 	if err != nil {
-		return mcp, err
-	}
-	if r.StatusCode != http.StatusOK {
-		return mcp, errFromResponse("failed to fetch checkpoint", r)
+		return api.MapCheckpoint{}, err
 	}
-
-	if err := json.NewDecoder(r.Body).Decode(&mcp); err != nil {
-		return mcp, err
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if root, ok := c.roots[mcp.Revision]; ok && !bytes.Equal(root, mcp.RootHash) {
+		return api.MapCheckpoint{}, fmt.Errorf("map revision %d has root %x, but previously had %x", mcp.Revision, mcp.RootHash, root)
 	}
-	// TODO(mhutchinson): Check signature
-	return mcp, nil
+	c.roots[mcp.Revision] = mcp.RootHash
+	return *mcp, nil
 }
 
 // Aggregation returns the value committed to by the map under the given key,
 // with an inclusion proof.
 func (c *MapClient) Aggregation(ctx context.Context, rev uint64, fwIndex uint64) ([]byte, api.MapInclusionProof, error) {
+	key := fmt.Sprintf("summary:%d", fwIndex)
+	return c.value(ctx, rev, key, fmt.Sprintf("%s/in-revision/%d/for-firmware-at-index/%d", api.MapHTTPGetAggregation, rev, fwIndex))
+}
+
+// CommitFirmware returns the JSON encoded api.CommitFirmware committed to by the map
+// for the given source commit in the repository, with an inclusion proof.
+func (c *MapClient) CommitFirmware(ctx context.Context, rev uint64, repository, commit string) ([]byte, api.MapInclusionProof, error) {
+	key := api.CommitMapKey(repository, commit)
+	return c.value(ctx, rev, key, fmt.Sprintf("%s/in-revision/%d/for-commit/%s?repository=%s", api.MapHTTPGetCommitFirmware, rev, url.PathEscape(commit), url.QueryEscape(repository)))
+}
+
+// DeviceReleaseLog returns the log of firmware revisions released for the device, once
+// the proof that the map commits to the log in the given revision has been verified.
+func (c *MapClient) DeviceReleaseLog(ctx context.Context, rev uint64, deviceID string) (api.DeviceReleaseLog, api.MapInclusionProof, error) {
+	root, err := c.root(rev)
+	if err != nil {
+		return api.DeviceReleaseLog{}, api.MapInclusionProof{}, err
+	}
+	bs, err := c.fetch(fmt.Sprintf("%s/in-revision/%d/for-device/%s", api.MapHTTPGetDeviceLog, rev, url.PathEscape(deviceID)))
+	if err != nil {
+		return api.DeviceReleaseLog{}, api.MapInclusionProof{}, err
+	}
+	var lp api.DeviceReleaseLogProof
+	if err := json.Unmarshal(bs, &lp); err != nil {
+		return api.DeviceReleaseLog{}, api.MapInclusionProof{}, fmt.Errorf("failed to parse device log: %w", err)
+	}
+	if lp.Log.DeviceID != deviceID {
+		return api.DeviceReleaseLog{}, api.MapInclusionProof{}, fmt.Errorf("got log for device %q, want %q", lp.Log.DeviceID, deviceID)
+	}
+	logRoot, err := lp.Log.LogRoot()
+	if err != nil {
+		return api.DeviceReleaseLog{}, api.MapInclusionProof{}, err
+	}
+	kbs := sha512.Sum512_256([]byte(deviceID))
+	if err := verify.MapInclusion(root, kbs[:], logRoot, lp.Proof); err != nil {
+		return api.DeviceReleaseLog{}, api.MapInclusionProof{}, fmt.Errorf("log for device %q does not match map revision %d: %w", deviceID, rev, err)
+	}
+	return lp.Log, lp.Proof, nil
+}
+
+// root returns the root hash from the verified checkpoint for the revision.
+func (c *MapClient) root(rev uint64) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	root, ok := c.roots[rev]
+	if !ok {
+		return nil, fmt.Errorf("no verified checkpoint for map revision %d", rev)
+	}
+	return root, nil
+}
+
+// value fetches the value at valuePath, and the inclusion proof for the map key in the
+// given revision. The proof is checked against the root hash from
+// the verified checkpoint for the revision before the value is returned.
+func (c *MapClient) value(ctx context.Context, rev uint64, key, valuePath string) ([]byte, api.MapInclusionProof, error) {
+	root, err := c.root(rev)
+	if err != nil {
+		return nil, api.MapInclusionProof{}, err
+	}
+
 	errs, _ := errgroup.WithContext(ctx)
-	// Simultaneously fetch all tiles:
-	tiles := make([]api.MapTile, api.MapPrefixStrata+1)
-	kbs := sha512.Sum512_256([]byte(fmt.Sprintf("summary:%d", fwIndex)))
-	for i := range tiles {
-		i := i
-		errs.Go(func() error {
-			path := kbs[:i]
-			var t api.MapTile
-			tbs, err := c.fetch(fmt.Sprintf("%s/in-revision/%d/at-path/%s", api.MapHTTPGetTile, rev, base64.URLEncoding.EncodeToString(path)))
-			if err != nil {
-				return err
-			}
-			if err := json.Unmarshal(tbs, &t); err != nil {
-				return err
-			}
-			tiles[i] = t
-			return nil
-		})
-	}
-
-	var agg []byte
+	kbs := sha512.Sum512_256([]byte(key))
+	// Simultaneously fetch the value and the proof:
+	var proof api.MapInclusionProof
 	errs.Go(func() error {
 		var err error
-		agg, err = c.fetch(fmt.Sprintf("%s/in-revision/%d/for-firmware-at-index/%d", api.MapHTTPGetAggregation, rev, fwIndex))
+		proof, err = c.proof(rev, kbs[:])
+		return err
+	})
+	var val []byte
+	errs.Go(func() error {
+		var err error
+		val, err = c.fetch(valuePath)
 		return err
 	})
 
@@ -110,27 +166,41 @@ func (c *MapClient) Aggregation(ctx context.Context, rev uint64, fwIndex uint64)
 		return nil, api.MapInclusionProof{}, err
 	}
 
-	ipt := newInclusionProofTree(api.MapTreeID, coniks.Default, kbs[:])
-	for i := api.MapPrefixStrata; i >= 0; i-- {
-		tile := tiles[i]
-		nodes := make([]smt.Node, len(tile.Leaves))
-		for j, l := range tile.Leaves {
-			nodes[j] = toNode(tile.Path, l)
-		}
-		hs, err := smt.NewHStar3(nodes, ipt.hasher.HashChildren,
-			uint(len(tile.Path)+len(tile.Leaves[0].Path))*8, uint(len(tile.Path))*8)
-		if err != nil {
-			return agg, api.MapInclusionProof{}, fmt.Errorf("failed to create HStar3 for tile %x: %v", tile.Path, err)
-		}
-		res, err := hs.Update(ipt)
-		if err != nil {
-			return agg, api.MapInclusionProof{}, fmt.Errorf("failed to hash tile %x: %v", tile.Path, err)
-		} else if got, want := len(res), 1; got != want {
-			return agg, api.MapInclusionProof{}, fmt.Errorf("wrong number of roots for tile %x: got %v, want %v", tile.Path, got, want)
-		}
+	if err := verify.MapInclusion(root, kbs[:], val, proof); err != nil {
+		return nil, api.MapInclusionProof{}, fmt.Errorf("value for key %q does not match map revision %d: %w", key, rev, err)
 	}
+	return val, proof, nil
+}
 
-	return agg, *ipt.proof, nil
+// ProveAbsent checks that the map has no value under the given key in the revision,
+// and returns the non-inclusion proof.
+func (c *MapClient) ProveAbsent(ctx context.Context, rev uint64, key string) (api.MapInclusionProof, error) {
+	root, err := c.root(rev)
+	if err != nil {
+		return api.MapInclusionProof{}, err
+	}
+	kbs := sha512.Sum512_256([]byte(key))
+	proof, err := c.proof(rev, kbs[:])
+	if err != nil {
+		return api.MapInclusionProof{}, err
+	}
+	if err := verify.MapNonInclusion(root, kbs[:], proof); err != nil {
+		return api.MapInclusionProof{}, fmt.Errorf("key %q is not absent from map revision %d: %w", key, rev, err)
+	}
+	return proof, nil
+}
+
+// proof fetches the proof for the hashed key from the map server. The proof is not verified.
+func (c *MapClient) proof(rev uint64, kbs []byte) (api.MapInclusionProof, error) {
+	bs, err := c.fetch(fmt.Sprintf("%s/in-revision/%d/for-key/%s", api.MapHTTPGetProof, rev, base64.URLEncoding.EncodeToString(kbs)))
+	if err != nil {
+		return api.MapInclusionProof{}, err
+	}
+	var proof api.MapInclusionProof
+	if err := json.Unmarshal(bs, &proof); err != nil {
+		return api.MapInclusionProof{}, fmt.Errorf("failed to parse proof: %w", err)
+	}
+	return proof, nil
 }
 
 // fetch gets the body from the given path.
@@ -154,52 +224,3 @@ func (c *MapClient) fetch(path string) ([]byte, error) {
 	}()
 	return io.ReadAll(body)
 }
-
-// toNode converts a MapTileLeaf into the equivalent Node for HStar3.
-func toNode(prefix []byte, l api.MapTileLeaf) smt.Node {
-	path := make([]byte, 0, len(prefix)+len(l.Path))
-	path = append(append(path, prefix...), l.Path...)
-	return smt.Node{
-		ID:   node.NewID(string(path), uint(len(path))*8),
-		Hash: l.Hash,
-	}
-}
-
-// inclusionProofTree is a NodeAccessor for an empty tree with the given ID.
-// As values are set on the tree, an inclusion proof is generated containing
-// the siblings computed.
-type inclusionProofTree struct {
-	treeID int64
-	hasher *coniks.Hasher
-	target node.ID
-	proof  *api.MapInclusionProof
-}
-
-func newInclusionProofTree(treeID int64, hasher *coniks.Hasher, target []byte) inclusionProofTree {
-	return inclusionProofTree{
-		treeID: treeID,
-		hasher: hasher,
-		target: node.NewID(string(target), 256),
-		proof: &api.MapInclusionProof{
-			Key:   target,
-			Proof: make([][]byte, 256),
-		},
-	}
-}
-
-func (e inclusionProofTree) Get(id node.ID) ([]byte, error) {
-	return e.hasher.HashEmpty(e.treeID, id), nil
-}
-
-func (e inclusionProofTree) Set(id node.ID, hash []byte) {
-	if id == e.target {
-		e.proof.Value = hash
-		glog.V(2).Infof("inclusionProofTree: set value for target: %x", hash)
-		return
-	}
-	stem := e.target.Prefix(id.BitLen())
-	if stem == id.Sibling() {
-		e.proof.Proof[id.BitLen()-1] = hash
-		glog.V(2).Infof("inclusionProofTree: set sibling at depth %d: %x", id.BitLen(), hash)
-	}
-}
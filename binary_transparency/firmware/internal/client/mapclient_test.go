@@ -17,6 +17,9 @@ package client_test
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -26,26 +29,88 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/trillian-examples/binary_transparency/firmware/api"
 	"github.com/google/trillian-examples/binary_transparency/firmware/internal/client"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/mapproof"
+	"golang.org/x/mod/sumdb/note"
 )
 
+// testMapRoot is the root hash of the map made from the tiles in TestAggregation.
+var testMapRoot = mustDecode("Dz0/ePV+1m/tOGU42WySpHzoPsP9/Wh7mJ0Co/C/pag=")
+
+func mustDecode(s string) []byte {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func mustSignMapNote(t *testing.T, text string, s note.Signer) []byte {
+	t.Helper()
+	n, err := note.Sign(&note.Note{Text: text}, s)
+	if err != nil {
+		t.Fatalf("failed to sign note: %q", err)
+	}
+	return n
+}
+
+func mustGetMapSigner(t *testing.T) note.Signer {
+	t.Helper()
+	s, err := note.NewSigner(crypto.TestMapPriv)
+	if err != nil {
+		t.Fatalf("failed to create signer: %q", err)
+	}
+	return s
+}
+
+func mustGetMapVerifier(t *testing.T) note.Verifier {
+	t.Helper()
+	v, err := note.NewVerifier(crypto.TestMapPub)
+	if err != nil {
+		t.Fatalf("failed to create verifier: %q", err)
+	}
+	return v
+}
+
 func TestMapCheckpoint(t *testing.T) {
+	mapSigner := mustGetMapSigner(t)
+	skey, _, err := note.GenerateKey(rand.Reader, "ft_map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSigner, err := note.NewSigner(skey)
+	if err != nil {
+		t.Fatal(err)
+	}
 	for _, test := range []struct {
 		desc    string
-		body    string
+		body    []byte
 		want    api.MapCheckpoint
 		wantErr bool
 	}{
 		{
 			desc: "valid 1",
-			body: `{ "Revision": 42, "LogSize": 1, "LogCheckpoint": "QyE=", "RootHash": "EjQ="}`,
+			body: mustSignMapNote(t, "Firmware Transparency Map\n42\n1\nEjQ=\nQyE=\n", mapSigner),
 			want: api.MapCheckpoint{Revision: 42, LogSize: 1, LogCheckpoint: []byte{0x43, 0x21}, RootHash: []byte{0x12, 0x34}},
 		}, {
 			desc: "valid 2",
-			body: `{ "Revision": 42, "LogSize": 10, "LogCheckpoint": "/u1C", "RootHash": "NBI="}`,
+			body: mustSignMapNote(t, "Firmware Transparency Map\n42\n10\nNBI=\n/u1C\n", mapSigner),
 			want: api.MapCheckpoint{Revision: 42, LogSize: 10, LogCheckpoint: []byte{0xfe, 0xed, 0x42}, RootHash: []byte{0x34, 0x12}},
+		}, {
+			desc:    "wrong key",
+			body:    mustSignMapNote(t, "Firmware Transparency Map\n42\n1\nEjQ=\nQyE=\n", otherSigner),
+			wantErr: true,
+		}, {
+			desc:    "wrong origin",
+			body:    mustSignMapNote(t, "Firmware Transparency Log\n42\n1\nEjQ=\nQyE=\n", mapSigner),
+			wantErr: true,
+		}, {
+			desc:    "unsigned",
+			body:    []byte(`{ "Revision": 42, "LogSize": 1, "LogCheckpoint": "QyE=", "RootHash": "EjQ="}`),
+			wantErr: true,
 		}, {
 			desc:    "garbage",
-			body:    `garbage`,
+			body:    []byte(`garbage`),
 			wantErr: true,
 		},
 	} {
@@ -54,13 +119,13 @@ func TestMapCheckpoint(t *testing.T) {
 				if !strings.HasSuffix(r.URL.Path, api.MapHTTPGetCheckpoint) {
 					t.Fatalf("Got unexpected HTTP request on %q", r.URL.Path)
 				}
-				if _, err := fmt.Fprintln(w, test.body); err != nil {
-					t.Errorf("fmt.Fprintln: %v", err)
+				if _, err := w.Write(test.body); err != nil {
+					t.Errorf("w.Write: %v", err)
 				}
 			}))
 			defer ts.Close()
 
-			c, err := client.NewMapClient(ts.URL)
+			c, err := client.NewMapClient(ts.URL, mustGetMapVerifier(t))
 			if err != nil {
 				t.Fatalf("Failed to create client: %q", err)
 			}
@@ -73,6 +138,7 @@ func TestMapCheckpoint(t *testing.T) {
 			case err != nil && test.wantErr:
 				// expected error
 			default:
+				test.want.Envelope = test.body
 				if d := cmp.Diff(cp, test.want); len(d) != 0 {
 					t.Fatalf("Got checkpoint with diff: %s", d)
 				}
@@ -81,18 +147,52 @@ func TestMapCheckpoint(t *testing.T) {
 	}
 }
 
+// serveProof plays the part of the map server, building the proof for the key from
+// the tiles in the bodies, keyed by the URL path they would be served at.
+func serveProof(t *testing.T, w http.ResponseWriter, bodies map[string]string, rev uint64, key string) {
+	t.Helper()
+	kbs, err := base64.URLEncoding.DecodeString(key)
+	if err != nil {
+		t.Fatalf("Failed to decode key %q: %v", key, err)
+	}
+	var tiles []api.MapTile
+	for i := 0; i <= api.MapPrefixStrata; i++ {
+		body, ok := bodies[fmt.Sprintf("/%s/in-revision/%d/at-path/%s", api.MapHTTPGetTile, rev, base64.URLEncoding.EncodeToString(kbs[:i]))]
+		if !ok {
+			break
+		}
+		var tile api.MapTile
+		if err := json.Unmarshal([]byte(body), &tile); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tiles = append(tiles, tile)
+	}
+	proof, err := mapproof.Build(kbs, tiles)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(proof); err != nil {
+		t.Errorf("Encode: %v", err)
+	}
+}
+
 func TestAggregation(t *testing.T) {
 	for _, test := range []struct {
 		desc       string
 		rev, index uint64
-		bodies     map[string]string
-		wantAgg    []byte
-		wantErr    bool
+		// root is the root hash in the signed checkpoint for rev, or nil if there isn't one.
+		root    []byte
+		bodies  map[string]string
+		wantAgg []byte
+		wantErr bool
 	}{
 		{
 			desc:  "valid 1",
 			rev:   1,
 			index: 0,
+			root:  testMapRoot,
 			bodies: map[string]string{
 				"/ftmap/v0/tile/in-revision/1/at-path/":                       `{"Path":"","Leaves":[{"Path":"Rg==","Hash":"M7DmUN5R2auo88WMjg+EcijUzfX085QdHuTzx7Rrwgs="},{"Path":"7A==","Hash":"fK4jTvvbd90D29jYrsBrmWNG83416K1WhgS5T5qYpEI="}]}`,
 				"/ftmap/v0/tile/in-revision/1/at-path/Rg==":                   `{"Path":"Rg==","Leaves":[{"Path":"IRCmyCYwSorPfJ/NXqlkZdVYvs4KKtRYuV27zLJjCg==","Hash":"sE0GFv87tf0j7YciRBVFk4pFKExwVRhwykxdPz70Dxw="}]}`,
@@ -103,16 +203,45 @@ func TestAggregation(t *testing.T) {
 			desc:  "valid 2",
 			rev:   2,
 			index: 1,
+			root:  testMapRoot,
 			bodies: map[string]string{
 				"/ftmap/v0/tile/in-revision/2/at-path/":                       `{"Path":"","Leaves":[{"Path":"Rg==","Hash":"M7DmUN5R2auo88WMjg+EcijUzfX085QdHuTzx7Rrwgs="},{"Path":"7A==","Hash":"fK4jTvvbd90D29jYrsBrmWNG83416K1WhgS5T5qYpEI="}]}`,
 				"/ftmap/v0/tile/in-revision/2/at-path/7A==":                   `{"Path":"7A==","Leaves":[{"Path":"Kk19hKuMqnXxlJGG0LQ5y8LbzyPhmCCDMxRmPAowFw==","Hash":"+d6n+Cubqrkvx6vwQg0f2M3ZPub3a8jf/HICam0T3sM="},{"Path":"z+HuPYEme3qpfllqffSoL8jKc8VLtf3njh/nVoksCA==","Hash":"rxQDwfN/PhVD+lF2FtVkzUb9ha1G+4OHE7ZaIvSow9Y="}]}`,
 				"/ftmap/v0/aggregation/in-revision/2/for-firmware-at-index/1": `{"Index":1,"Good":false}`,
 			},
 			wantAgg: []byte(`{"Index":1,"Good":false}`),
+		}, {
+			desc:  "value not committed to",
+			rev:   1,
+			index: 0,
+			root:  testMapRoot,
+			bodies: map[string]string{
+				"/ftmap/v0/tile/in-revision/1/at-path/":                       `{"Path":"","Leaves":[{"Path":"Rg==","Hash":"M7DmUN5R2auo88WMjg+EcijUzfX085QdHuTzx7Rrwgs="},{"Path":"7A==","Hash":"fK4jTvvbd90D29jYrsBrmWNG83416K1WhgS5T5qYpEI="}]}`,
+				"/ftmap/v0/tile/in-revision/1/at-path/Rg==":                   `{"Path":"Rg==","Leaves":[{"Path":"IRCmyCYwSorPfJ/NXqlkZdVYvs4KKtRYuV27zLJjCg==","Hash":"sE0GFv87tf0j7YciRBVFk4pFKExwVRhwykxdPz70Dxw="}]}`,
+				"/ftmap/v0/aggregation/in-revision/1/for-firmware-at-index/0": `{"Index":0,"Good":false}`,
+			},
+			wantErr: true,
+		}, {
+			desc:  "wrong root",
+			rev:   1,
+			index: 0,
+			root:  []byte{0x12, 0x34},
+			bodies: map[string]string{
+				"/ftmap/v0/tile/in-revision/1/at-path/":                       `{"Path":"","Leaves":[{"Path":"Rg==","Hash":"M7DmUN5R2auo88WMjg+EcijUzfX085QdHuTzx7Rrwgs="},{"Path":"7A==","Hash":"fK4jTvvbd90D29jYrsBrmWNG83416K1WhgS5T5qYpEI="}]}`,
+				"/ftmap/v0/tile/in-revision/1/at-path/Rg==":                   `{"Path":"Rg==","Leaves":[{"Path":"IRCmyCYwSorPfJ/NXqlkZdVYvs4KKtRYuV27zLJjCg==","Hash":"sE0GFv87tf0j7YciRBVFk4pFKExwVRhwykxdPz70Dxw="}]}`,
+				"/ftmap/v0/aggregation/in-revision/1/for-firmware-at-index/0": `{"Index":0,"Good":true}`,
+			},
+			wantErr: true,
+		}, {
+			desc:    "unverified revision",
+			rev:     1,
+			index:   0,
+			wantErr: true,
 		}, {
 			desc:  "garbage",
 			rev:   42,
 			index: 22,
+			root:  testMapRoot,
 			bodies: map[string]string{
 				"/ftmap/v0/tile/in-revision/42/at-path/":                        `moose`,
 				"/ftmap/v0/tile/in-revision/42/at-path/RA==":                    `loose`,
@@ -122,8 +251,16 @@ func TestAggregation(t *testing.T) {
 		},
 	} {
 		t.Run(test.desc, func(t *testing.T) {
+			cp := mustSignMapNote(t, string(api.MapCheckpoint{Revision: test.rev, LogSize: 1, LogCheckpoint: []byte("log"), RootHash: test.root}.Marshal()), mustGetMapSigner(t))
 			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				if body, ok := test.bodies[r.URL.Path]; ok {
+				proofPrefix := fmt.Sprintf("/%s/in-revision/%d/for-key/", api.MapHTTPGetProof, test.rev)
+				if strings.HasSuffix(r.URL.Path, api.MapHTTPGetCheckpoint) {
+					if _, err := w.Write(cp); err != nil {
+						t.Errorf("w.Write: %v", err)
+					}
+				} else if strings.HasPrefix(r.URL.Path, proofPrefix) {
+					serveProof(t, w, test.bodies, test.rev, strings.TrimPrefix(r.URL.Path, proofPrefix))
+				} else if body, ok := test.bodies[r.URL.Path]; ok {
 					if _, err := fmt.Fprint(w, body); err != nil {
 						t.Errorf("fmt.Fprint: %v", err)
 					}
@@ -133,10 +270,15 @@ func TestAggregation(t *testing.T) {
 			}))
 			defer ts.Close()
 
-			c, err := client.NewMapClient(ts.URL)
+			c, err := client.NewMapClient(ts.URL, mustGetMapVerifier(t))
 			if err != nil {
 				t.Fatalf("Failed to create client: %q", err)
 			}
+			if test.root != nil {
+				if _, err := c.MapCheckpoint(); err != nil {
+					t.Fatalf("MapCheckpoint(): %v", err)
+				}
+			}
 			agg, proof, err := c.Aggregation(context.Background(), test.rev, test.index)
 			switch {
 			case err != nil && !test.wantErr:
@@ -149,7 +291,6 @@ func TestAggregation(t *testing.T) {
 				if !bytes.Equal(agg, test.wantAgg) {
 					t.Errorf("Got wrong aggregation (%x != %x)", agg, test.wantAgg)
 				}
-				// TODO(mhutchinson): more fully test the generated inclusion proof.
 				if proof.Key == nil || proof.Value == nil || len(proof.Proof) != 256 {
 					t.Errorf("Generated proof is malformed: %+v", proof)
 				}
@@ -157,3 +298,247 @@ func TestAggregation(t *testing.T) {
 		})
 	}
 }
+
+func TestDeviceReleaseLog(t *testing.T) {
+	// deviceRoot is the root hash of a map containing only the release log for the dummy device,
+	// which has the value deviceValue and an inclusion proof with only empty siblings.
+	deviceRoot := mustDecode("UcsliT71+98NIGYkt5tiNObg8sK/TiBsu/GTCkL0Mwc=")
+	deviceProof := fmt.Sprintf(`{"Key":"7CpNfYSrjKp18ZSRhtC0OcvC288j4ZgggzMUZjwKMBc=","Value":"S8P4vccyZuiHX0eBx4SmugvyFSmR0ahhc71PRgQCQeM=","Proof":[%s]}`, strings.TrimSuffix(strings.Repeat("null,", 256), ","))
+	for _, test := range []struct {
+		desc     string
+		deviceID string
+		root     []byte
+		body     string
+		want     api.DeviceReleaseLog
+		wantErr  bool
+	}{
+		{
+			desc:     "valid",
+			deviceID: "dummy",
+			root:     deviceRoot,
+			body:     fmt.Sprintf(`{"Log":{"DeviceID":"dummy","Revisions":[1,2,5]},"Proof":%s}`, deviceProof),
+			want:     api.DeviceReleaseLog{DeviceID: "dummy", Revisions: []uint64{1, 2, 5}},
+		}, {
+			desc:     "release missing",
+			deviceID: "dummy",
+			root:     deviceRoot,
+			body:     fmt.Sprintf(`{"Log":{"DeviceID":"dummy","Revisions":[1,2]},"Proof":%s}`, deviceProof),
+			wantErr:  true,
+		}, {
+			desc:     "wrong device",
+			deviceID: "armory",
+			root:     deviceRoot,
+			body:     fmt.Sprintf(`{"Log":{"DeviceID":"dummy","Revisions":[1,2,5]},"Proof":%s}`, deviceProof),
+			wantErr:  true,
+		}, {
+			desc:     "wrong root",
+			deviceID: "dummy",
+			root:     testMapRoot,
+			body:     fmt.Sprintf(`{"Log":{"DeviceID":"dummy","Revisions":[1,2,5]},"Proof":%s}`, deviceProof),
+			wantErr:  true,
+		}, {
+			desc:     "unverified revision",
+			deviceID: "dummy",
+			wantErr:  true,
+		}, {
+			desc:     "garbage",
+			deviceID: "dummy",
+			root:     deviceRoot,
+			body:     `moose`,
+			wantErr:  true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			const rev = 3
+			cp := mustSignMapNote(t, string(api.MapCheckpoint{Revision: rev, LogSize: 1, LogCheckpoint: []byte("log"), RootHash: test.root}.Marshal()), mustGetMapSigner(t))
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body []byte
+				switch r.URL.Path {
+				case "/" + api.MapHTTPGetCheckpoint:
+					body = cp
+				case fmt.Sprintf("/%s/in-revision/%d/for-device/%s", api.MapHTTPGetDeviceLog, rev, test.deviceID):
+					body = []byte(test.body)
+				default:
+					t.Fatalf("Got unexpected HTTP request on %q", r.URL.Path)
+				}
+				if _, err := w.Write(body); err != nil {
+					t.Errorf("w.Write: %v", err)
+				}
+			}))
+			defer ts.Close()
+
+			c, err := client.NewMapClient(ts.URL, mustGetMapVerifier(t))
+			if err != nil {
+				t.Fatalf("Failed to create client: %q", err)
+			}
+			if test.root != nil {
+				if _, err := c.MapCheckpoint(); err != nil {
+					t.Fatalf("MapCheckpoint(): %v", err)
+				}
+			}
+			got, _, err := c.DeviceReleaseLog(context.Background(), rev, test.deviceID)
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("Got unexpected error %q", err)
+			case err == nil && test.wantErr:
+				t.Fatal("Got no error, but wanted error")
+			case err != nil && test.wantErr:
+				// expected error
+			default:
+				if d := cmp.Diff(got, test.want); len(d) != 0 {
+					t.Errorf("Got log with diff: %s", d)
+				}
+			}
+		})
+	}
+}
+
+func TestCommitFirmware(t *testing.T) {
+	const (
+		repo   = "https://github.com/example/firmware"
+		commit = "0123456789abcdef0123456789abcdef01234567"
+	)
+	// commitRoot is the root hash of a map containing only the firmware built from commit in repo,
+	// which has the value commitValue and an inclusion proof with only empty siblings.
+	commitRoot := mustDecode("W8osplXbE1/7jYFjq9KMABMPlTzt7hvalYIgar2MQm8=")
+	commitValue := fmt.Sprintf(`{"Repository":%q,"Commit":%q,"FirmwareLogIndices":[1,4]}`, repo, commit)
+	commitProof := fmt.Sprintf(`{"Key":"ollY2xxuVkzVoqKliwy1fXaogEk7yOZjE4dz2eBlkVQ=","Value":"PDGqRLGX/xnemx+YDwHOopjNfyDAwSGXphKRTurCXj8=","Proof":[%s]}`, strings.TrimSuffix(strings.Repeat("null,", 256), ","))
+	for _, test := range []struct {
+		desc       string
+		repository string
+		root       []byte
+		body       string
+		want       string
+		wantErr    bool
+	}{
+		{
+			desc:       "valid",
+			repository: repo,
+			root:       commitRoot,
+			body:       commitValue,
+			want:       commitValue,
+		}, {
+			desc:       "other repository",
+			repository: "https://github.com/evil/firmware",
+			root:       commitRoot,
+			body:       commitValue,
+			wantErr:    true,
+		}, {
+			desc:       "firmware missing",
+			repository: repo,
+			root:       commitRoot,
+			body:       fmt.Sprintf(`{"Repository":%q,"Commit":%q,"FirmwareLogIndices":[1]}`, repo, commit),
+			wantErr:    true,
+		}, {
+			desc:       "unverified revision",
+			repository: repo,
+			wantErr:    true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			const rev = 3
+			cp := mustSignMapNote(t, string(api.MapCheckpoint{Revision: rev, LogSize: 1, LogCheckpoint: []byte("log"), RootHash: test.root}.Marshal()), mustGetMapSigner(t))
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body []byte
+				switch {
+				case r.URL.Path == "/"+api.MapHTTPGetCheckpoint:
+					body = cp
+				case r.URL.Path == fmt.Sprintf("/%s/in-revision/%d/for-commit/%s", api.MapHTTPGetCommitFirmware, rev, commit):
+					if got := r.URL.Query().Get("repository"); got != test.repository {
+						http.Error(w, fmt.Sprintf("got repository %q, want %q", got, test.repository), http.StatusBadRequest)
+						return
+					}
+					body = []byte(test.body)
+				case strings.HasPrefix(r.URL.Path, fmt.Sprintf("/%s/in-revision/%d/for-key/", api.MapHTTPGetProof, rev)):
+					body = []byte(commitProof)
+				default:
+					t.Fatalf("Got unexpected HTTP request on %q", r.URL.Path)
+				}
+				if _, err := w.Write(body); err != nil {
+					t.Errorf("w.Write: %v", err)
+				}
+			}))
+			defer ts.Close()
+
+			c, err := client.NewMapClient(ts.URL, mustGetMapVerifier(t))
+			if err != nil {
+				t.Fatalf("Failed to create client: %q", err)
+			}
+			if test.root != nil {
+				if _, err := c.MapCheckpoint(); err != nil {
+					t.Fatalf("MapCheckpoint(): %v", err)
+				}
+			}
+			got, _, err := c.CommitFirmware(context.Background(), rev, test.repository, commit)
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("Got unexpected error %q", err)
+			case err == nil && test.wantErr:
+				t.Fatal("Got no error, but wanted error")
+			case err != nil && test.wantErr:
+				// expected error
+			default:
+				if string(got) != test.want {
+					t.Errorf("Got value %s, want %s", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestProveAbsent(t *testing.T) {
+	bodies := map[string]string{
+		"/ftmap/v0/tile/in-revision/1/at-path/":     `{"Path":"","Leaves":[{"Path":"Rg==","Hash":"M7DmUN5R2auo88WMjg+EcijUzfX085QdHuTzx7Rrwgs="},{"Path":"7A==","Hash":"fK4jTvvbd90D29jYrsBrmWNG83416K1WhgS5T5qYpEI="}]}`,
+		"/ftmap/v0/tile/in-revision/1/at-path/Rg==": `{"Path":"Rg==","Leaves":[{"Path":"IRCmyCYwSorPfJ/NXqlkZdVYvs4KKtRYuV27zLJjCg==","Hash":"sE0GFv87tf0j7YciRBVFk4pFKExwVRhwykxdPz70Dxw="}]}`,
+		"/ftmap/v0/tile/in-revision/1/at-path/7A==": `{"Path":"7A==","Leaves":[{"Path":"Kk19hKuMqnXxlJGG0LQ5y8LbzyPhmCCDMxRmPAowFw==","Hash":"+d6n+Cubqrkvx6vwQg0f2M3ZPub3a8jf/HICam0T3sM="},{"Path":"z+HuPYEme3qpfllqffSoL8jKc8VLtf3njh/nVoksCA==","Hash":"rxQDwfN/PhVD+lF2FtVkzUb9ha1G+4OHE7ZaIvSow9Y="}]}`,
+	}
+	for _, test := range []struct {
+		desc    string
+		key     string
+		wantErr bool
+	}{
+		{
+			desc: "absent",
+			key:  "summary:42",
+		}, {
+			desc:    "present",
+			key:     "summary:0",
+			wantErr: true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			cp := mustSignMapNote(t, string(api.MapCheckpoint{Revision: 1, LogSize: 1, LogCheckpoint: []byte("log"), RootHash: testMapRoot}.Marshal()), mustGetMapSigner(t))
+			proofPrefix := fmt.Sprintf("/%s/in-revision/1/for-key/", api.MapHTTPGetProof)
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Path == "/"+api.MapHTTPGetCheckpoint:
+					if _, err := w.Write(cp); err != nil {
+						t.Errorf("w.Write: %v", err)
+					}
+				case strings.HasPrefix(r.URL.Path, proofPrefix):
+					serveProof(t, w, bodies, 1, strings.TrimPrefix(r.URL.Path, proofPrefix))
+				default:
+					t.Fatalf("Got unexpected HTTP request on %q", r.URL.Path)
+				}
+			}))
+			defer ts.Close()
+
+			c, err := client.NewMapClient(ts.URL, mustGetMapVerifier(t))
+			if err != nil {
+				t.Fatalf("Failed to create client: %q", err)
+			}
+			if _, err := c.MapCheckpoint(); err != nil {
+				t.Fatalf("MapCheckpoint(): %v", err)
+			}
+			proof, err := c.ProveAbsent(context.Background(), 1, test.key)
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("Got unexpected error %q", err)
+			case err == nil && test.wantErr:
+				t.Fatal("Got no error, but wanted error")
+			case err == nil && proof.Value != nil:
+				t.Errorf("Got proof with value %x, want empty leaf", proof.Value)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/glog"
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/merkle/compact"
+	"github.com/transparency-dev/merkle/proof"
+	"github.com/transparency-dev/merkle/rfc6962"
+	slapi "github.com/transparency-dev/serverless-log/api"
+	"github.com/transparency-dev/serverless-log/api/layout"
+	slclient "github.com/transparency-dev/serverless-log/client"
+)
+
+// fetchTileFile fetches a file from the tile-based view of the log.
+// It implements the serverless client's Fetcher, so returns os.ErrNotExist for missing files.
+func (c ReadonlyClient) fetchTileFile(_ context.Context, p string) ([]byte, error) {
+	u, err := c.LogURL.Parse(fmt.Sprintf("%s/%s", api.HTTPTiles, filepath.ToSlash(p)))
+	if err != nil {
+		return nil, err
+	}
+	glog.V(2).Infof("Fetching %q", u.String())
+	r, err := http.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			glog.Errorf("r.Body.Close(): %v", err)
+		}
+	}()
+	switch r.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(r.Body)
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%s: %w", u, os.ErrNotExist)
+	default:
+		return nil, errFromResponse("failed to fetch tile data", r)
+	}
+}
+
+// tileProofBuilder returns a builder for proofs in the tree of the trusted checkpoint, using
+// tiles. Returns an error if the root hash computed from the tiles doesn't match the checkpoint.
+func (c ReadonlyClient) tileProofBuilder(ctx context.Context, cp api.LogCheckpoint) (*slclient.ProofBuilder, error) {
+	pb, err := slclient.NewProofBuilder(ctx, log.Checkpoint{Size: cp.Size, Hash: cp.Hash}, rfc6962.DefaultHasher.HashChildren, c.fetchTileFile)
+	if err != nil {
+		return nil, fmt.Errorf("tiles for tree size %d don't match the checkpoint: %w", cp.Size, err)
+	}
+	return pb, nil
+}
+
+// tileCheckpointAtSize returns a checkpoint for the tree of the given size, which may be
+// smaller than the checkpoint published with the tiles. In that case its root hash is computed
+// from the tiles, and verified to be consistent with the published checkpoint.
+func (c ReadonlyClient) tileCheckpointAtSize(ctx context.Context, size uint64) (*api.LogCheckpoint, error) {
+	latest, err := c.getTileCheckpoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case size == latest.Size:
+		return latest, nil
+	case size > latest.Size:
+		return nil, fmt.Errorf("tree size %d is larger than the published checkpoint size %d", size, latest.Size)
+	}
+	root, err := c.tileRootHash(ctx, size)
+	if err != nil {
+		return nil, err
+	}
+	pb, err := c.tileProofBuilder(ctx, *latest)
+	if err != nil {
+		return nil, err
+	}
+	p, err := pb.ConsistencyProof(ctx, size, latest.Size)
+	if err != nil {
+		return nil, err
+	}
+	if err := proof.VerifyConsistency(rfc6962.DefaultHasher, size, latest.Size, p, root, latest.Hash); err != nil {
+		return nil, fmt.Errorf("tiles for tree size %d are inconsistent with the published checkpoint: %w", size, err)
+	}
+	return &api.LogCheckpoint{Checkpoint: log.Checkpoint{Origin: latest.Origin, Size: size, Hash: root}}, nil
+}
+
+// tileRootHash computes the root hash of the tree of the given size from tiles.
+// The result is untrusted until it's checked against a signed checkpoint.
+func (c ReadonlyClient) tileRootHash(ctx context.Context, size uint64) ([]byte, error) {
+	getTile := func(ctx context.Context, level, index uint64) (*slapi.Tile, error) {
+		b, err := c.fetchTileFile(ctx, filepath.Join(layout.TilePath("", level, index, layout.PartialTileSize(level, index, size))))
+		if err != nil {
+			return nil, err
+		}
+		var tile slapi.Tile
+		if err := tile.UnmarshalText(b); err != nil {
+			return nil, fmt.Errorf("failed to parse tile: %w", err)
+		}
+		return &tile, nil
+	}
+	hashes, err := slclient.FetchRangeNodes(ctx, size, getTile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tiles for tree size %d: %w", size, err)
+	}
+	r, err := (&compact.RangeFactory{Hash: rfc6962.DefaultHasher.HashChildren}).NewRange(0, size, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return r.GetRootHash(nil)
+}
+
+// getTileCheckpoint returns the checkpoint published alongside the tiles.
+func (c ReadonlyClient) getTileCheckpoint(ctx context.Context) (*api.LogCheckpoint, error) {
+	b, err := c.fetchTileFile(ctx, layout.CheckpointPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch checkpoint: %w", err)
+	}
+	return api.ParseCheckpoint(b, c.LogSigVerifier)
+}
+
+// getTileInclusion builds an inclusion proof for the leaf with the given hash from tiles,
+// in the tree of the given checkpoint.
+func (c ReadonlyClient) getTileInclusion(ctx context.Context, hash []byte, cp api.LogCheckpoint) (api.InclusionProof, error) {
+	index, err := slclient.LookupIndex(ctx, c.fetchTileFile, hash)
+	if err != nil {
+		return api.InclusionProof{}, err
+	}
+	if index >= cp.Size {
+		return api.InclusionProof{}, fmt.Errorf("leaf %d is not in tree of size %d", index, cp.Size)
+	}
+	pb, err := c.tileProofBuilder(ctx, cp)
+	if err != nil {
+		return api.InclusionProof{}, err
+	}
+	p, err := pb.InclusionProof(ctx, index)
+	if err != nil {
+		return api.InclusionProof{}, err
+	}
+	return api.InclusionProof{LeafIndex: index, Proof: p}, nil
+}
+
+// getTileEntryAndProof fetches a log entry and builds its inclusion proof from tiles.
+func (c ReadonlyClient) getTileEntryAndProof(ctx context.Context, request api.GetFirmwareManifestRequest) (*api.InclusionProof, error) {
+	if request.Index >= request.TreeSize {
+		return nil, fmt.Errorf("leaf %d is not in tree of size %d", request.Index, request.TreeSize)
+	}
+	value, err := slclient.GetLeaf(ctx, c.fetchTileFile, request.Index)
+	if err != nil {
+		return nil, err
+	}
+	cp, err := c.tileCheckpointAtSize(ctx, request.TreeSize)
+	if err != nil {
+		return nil, err
+	}
+	pb, err := c.tileProofBuilder(ctx, *cp)
+	if err != nil {
+		return nil, err
+	}
+	p, err := pb.InclusionProof(ctx, request.Index)
+	if err != nil {
+		return nil, err
+	}
+	return &api.InclusionProof{Value: value, LeafIndex: request.Index, Proof: p}, nil
+}
+
+// getTileConsistencyProof builds a consistency proof between two tree sizes from tiles.
+func (c ReadonlyClient) getTileConsistencyProof(ctx context.Context, request api.GetConsistencyRequest) (*api.ConsistencyProof, error) {
+	cp, err := c.tileCheckpointAtSize(ctx, request.To)
+	if err != nil {
+		return nil, err
+	}
+	pb, err := c.tileProofBuilder(ctx, *cp)
+	if err != nil {
+		return nil, err
+	}
+	p, err := pb.ConsistencyProof(ctx, request.From, request.To)
+	if err != nil {
+		return nil, err
+	}
+	return &api.ConsistencyProof{Proof: p}, nil
+}
@@ -0,0 +1,196 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/client"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/tiles"
+	"github.com/transparency-dev/merkle/compact"
+	"github.com/transparency-dev/merkle/proof"
+	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/serverless-log/api/layout"
+)
+
+type fakeLog [][]byte
+
+func (l fakeLog) LeavesByRange(_ context.Context, start, count uint64) ([][]byte, error) {
+	return l[start : start+count], nil
+}
+
+// rootAt returns the root hash of the first size leaves of the log.
+func (l fakeLog) rootAt(t *testing.T, size uint64) []byte {
+	t.Helper()
+	r := (&compact.RangeFactory{Hash: rfc6962.DefaultHasher.HashChildren}).NewEmptyRange(0)
+	for _, leaf := range l[:size] {
+		if err := r.Append(rfc6962.DefaultHasher.HashLeaf(leaf), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, err := r.GetRootHash(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// mustPublishTiles publishes the log in the tile-based layout in a new directory, with a
+// checkpoint for its root signed by the test key, and returns the directory.
+func mustPublishTiles(t *testing.T, l fakeLog) string {
+	t.Helper()
+	dir := t.TempDir()
+	st, err := tiles.NewStorage(dir)
+	if err != nil {
+		t.Fatalf("NewStorage(): %v", err)
+	}
+	p, err := tiles.NewPublisher(l, st)
+	if err != nil {
+		t.Fatalf("NewPublisher(): %v", err)
+	}
+	size := uint64(len(l))
+	cpNote := mustSignCPNote(t, fmt.Sprintf("%s\n%d\n%s\n123\n", api.FTLogOrigin, size, base64.StdEncoding.EncodeToString(l.rootAt(t, size))))
+	if err := p.Publish(context.Background(), cpNote); err != nil {
+		t.Fatalf("Publish(): %v", err)
+	}
+	return dir
+}
+
+// mustServeTiles serves the tiles in dir, and returns a client which uses them.
+func mustServeTiles(t *testing.T, dir string) client.ReadonlyClient {
+	t.Helper()
+	prefix := "/" + api.HTTPTiles
+	mux := http.NewServeMux()
+	mux.Handle(prefix+"/", http.StripPrefix(prefix, tiles.Handler(dir)))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Got unexpected non-tile request on %q", r.URL.Path)
+		http.NotFound(w, r)
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	tsURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse test server URL: %v", err)
+	}
+	return client.ReadonlyClient{
+		LogURL:         tsURL,
+		LogSigVerifier: mustGetLogSigVerifier(t),
+		UseTiles:       true,
+	}
+}
+
+func TestTiles(t *testing.T) {
+	const size = 300
+	l := make(fakeLog, size)
+	for i := range l {
+		l[i] = []byte(fmt.Sprintf("statement %d", i))
+	}
+	root := l.rootAt(t, size)
+	c := mustServeTiles(t, mustPublishTiles(t, l))
+
+	cp, err := c.GetCheckpoint()
+	if err != nil {
+		t.Fatalf("GetCheckpoint(): %v", err)
+	}
+	if cp.Size != size || !bytes.Equal(cp.Hash, root) {
+		t.Fatalf("Got checkpoint %+v, want size %d with root %x", cp, size, root)
+	}
+
+	for _, index := range []uint64{0, 7, 255, 256, size - 1} {
+		ip, err := c.GetManifestEntryAndProof(api.GetFirmwareManifestRequest{Index: index, TreeSize: size})
+		if err != nil {
+			t.Fatalf("GetManifestEntryAndProof(%d): %v", index, err)
+		}
+		if !bytes.Equal(ip.Value, l[index]) {
+			t.Errorf("Got value %q at %d, want %q", ip.Value, index, l[index])
+		}
+		if err := proof.VerifyInclusion(rfc6962.DefaultHasher, index, size, rfc6962.DefaultHasher.HashLeaf(ip.Value), ip.Proof, root); err != nil {
+			t.Errorf("Inclusion proof for %d failed to verify: %v", index, err)
+		}
+
+		byHash, err := c.GetInclusion(l[index], *cp)
+		if err != nil {
+			t.Fatalf("GetInclusion(%d): %v", index, err)
+		}
+		if byHash.LeafIndex != index {
+			t.Errorf("Got leaf index %d, want %d", byHash.LeafIndex, index)
+		}
+		if err := proof.VerifyInclusion(rfc6962.DefaultHasher, index, size, rfc6962.DefaultHasher.HashLeaf(l[index]), byHash.Proof, root); err != nil {
+			t.Errorf("Inclusion proof by hash for %d failed to verify: %v", index, err)
+		}
+	}
+
+	// Proofs can only be built to tree sizes which have been published, but from any smaller size.
+	for _, from := range []uint64{1, 10, 256, size} {
+		cp, err := c.GetConsistencyProof(api.GetConsistencyRequest{From: from, To: size})
+		if err != nil {
+			t.Fatalf("GetConsistencyProof(%d, %d): %v", from, size, err)
+		}
+		if err := proof.VerifyConsistency(rfc6962.DefaultHasher, from, size, cp.Proof, l.rootAt(t, from), root); err != nil {
+			t.Errorf("Consistency proof from %d to %d failed to verify: %v", from, size, err)
+		}
+	}
+
+	if _, err := c.GetInclusion([]byte("not logged"), *cp); err == nil {
+		t.Error("GetInclusion() for unknown statement succeeded")
+	}
+}
+
+func TestTamperedTiles(t *testing.T) {
+	const size = 300
+	l, other := make(fakeLog, size), make(fakeLog, size)
+	for i := range l {
+		l[i] = []byte(fmt.Sprintf("statement %d", i))
+		other[i] = []byte(fmt.Sprintf("other statement %d", i))
+	}
+	dir, otherDir := mustPublishTiles(t, l), mustPublishTiles(t, other)
+
+	// Replace the partial tile holding the node for the first 256 leaves, from which the root
+	// is computed, with one from another log. It is well formed but has the wrong hashes. The
+	// signed checkpoint is left alone.
+	tileDir, tileFile := layout.TilePath("", 1, 0, layout.PartialTileSize(1, 0, size))
+	tile, err := os.ReadFile(filepath.Join(otherDir, tileDir, tileFile))
+	if err != nil {
+		t.Fatalf("ReadFile(): %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, tileDir, tileFile), tile, 0o644); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	c := mustServeTiles(t, dir)
+
+	cp, err := c.GetCheckpoint()
+	if err != nil {
+		t.Fatalf("GetCheckpoint(): %v", err)
+	}
+	if _, err := c.GetManifestEntryAndProof(api.GetFirmwareManifestRequest{Index: 7, TreeSize: size}); err == nil {
+		t.Error("GetManifestEntryAndProof() succeeded with tampered tiles")
+	}
+	if _, err := c.GetInclusion(l[7], *cp); err == nil {
+		t.Error("GetInclusion() succeeded with tampered tiles")
+	}
+	if _, err := c.GetConsistencyProof(api.GetConsistencyRequest{From: 10, To: size}); err == nil {
+		t.Error("GetConsistencyProof() succeeded with tampered tiles")
+	}
+}
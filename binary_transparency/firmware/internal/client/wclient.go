@@ -15,6 +15,7 @@
 package client
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -30,12 +31,22 @@ import (
 type WitnessClient struct {
 	// URL is the base URL for the FT witness.
 	URL            *url.URL
-	LogSigVerifier note.Verifier
+	LogSigVerifier api.LogVerifier
+	// WitnessVerifier checks the witness's countersignature on checkpoints.
+	WitnessVerifier note.Verifier
+	// LogID selects the log on a witness which follows several logs.
+	// If empty, the witness's default log is used.
+	LogID string
 }
 
-// GetWitnessCheckpoint returns a checkpoint from witness server
+// GetWitnessCheckpoint returns a checkpoint from witness server, which must be signed by both
+// the log and the witness.
 func (c WitnessClient) GetWitnessCheckpoint() (*api.LogCheckpoint, error) {
-	u, err := c.URL.Parse(api.WitnessGetCheckpoint)
+	path := api.WitnessGetCheckpoint
+	if len(c.LogID) > 0 {
+		path = fmt.Sprintf("%s/%s", path, url.PathEscape(c.LogID))
+	}
+	u, err := c.URL.Parse(path)
 	if err != nil {
 		return nil, err
 	}
@@ -56,7 +67,17 @@ func (c WitnessClient) GetWitnessCheckpoint() (*api.LogCheckpoint, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read body: %w", err)
 	}
-	return api.ParseCheckpoint(b, c.LogSigVerifier)
+	if c.WitnessVerifier == nil {
+		return nil, errors.New("no witness verifier configured")
+	}
+	cp, err := api.ParseCheckpoint(b, c.LogSigVerifier, c.WitnessVerifier)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := note.Open(b, note.VerifierList(c.WitnessVerifier)); err != nil {
+		return nil, fmt.Errorf("checkpoint is not signed by the witness: %w", err)
+	}
+	return cp, nil
 }
 
 func errFromRsp(m string, r *http.Response) error {
@@ -25,19 +25,44 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/trillian-examples/binary_transparency/firmware/api"
 	"github.com/google/trillian-examples/binary_transparency/firmware/internal/client"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
 	"github.com/transparency-dev/formats/log"
+	"golang.org/x/mod/sumdb/note"
 )
 
+// mustCosignCPNote returns a note with the body signed by the log and the test witness.
+func mustCosignCPNote(t *testing.T, b string) []byte {
+	t.Helper()
+	ls, err := note.NewSigner(crypto.TestFTPersonalityPriv)
+	if err != nil {
+		t.Fatalf("failed to create log signer: %q", err)
+	}
+	ws, err := note.NewSigner(crypto.TestWitnessPriv)
+	if err != nil {
+		t.Fatalf("failed to create witness signer: %q", err)
+	}
+	n, err := note.Sign(&note.Note{Text: b}, ls, ws)
+	if err != nil {
+		t.Fatalf("failed to sign note: %q", err)
+	}
+	return n
+}
+
 func TestGetWitnessCheckpoint(t *testing.T) {
+	wv, err := note.NewVerifier(crypto.TestWitnessPub)
+	if err != nil {
+		t.Fatalf("failed to create witness verifier: %q", err)
+	}
 	for _, test := range []struct {
 		desc    string
 		body    []byte
 		want    api.LogCheckpoint
+		logID   string
 		wantErr bool
 	}{
 		{
 			desc: "valid 1",
-			body: mustSignCPNote(t, "Firmware Transparency Log\n1\nEjQ=\n123\n"),
+			body: mustCosignCPNote(t, "Firmware Transparency Log\n1\nEjQ=\n123\n"),
 			want: api.LogCheckpoint{
 				Checkpoint: log.Checkpoint{
 					Origin: "Firmware Transparency Log",
@@ -48,7 +73,7 @@ func TestGetWitnessCheckpoint(t *testing.T) {
 			},
 		}, {
 			desc: "valid 2",
-			body: mustSignCPNote(t, "Firmware Transparency Log\n10\nNBI=\n1230\n"),
+			body: mustCosignCPNote(t, "Firmware Transparency Log\n10\nNBI=\n1230\n"),
 			want: api.LogCheckpoint{
 				Checkpoint: log.Checkpoint{
 					Origin: "Firmware Transparency Log",
@@ -57,6 +82,22 @@ func TestGetWitnessCheckpoint(t *testing.T) {
 				},
 				TimestampNanos: 1230,
 			},
+		}, {
+			desc:  "log ID",
+			body:  mustCosignCPNote(t, "Firmware Transparency Log\n1\nEjQ=\n123\n"),
+			logID: "ft",
+			want: api.LogCheckpoint{
+				Checkpoint: log.Checkpoint{
+					Origin: "Firmware Transparency Log",
+					Size:   1,
+					Hash:   []byte{0x12, 0x34},
+				},
+				TimestampNanos: 123,
+			},
+		}, {
+			desc:    "not countersigned",
+			body:    mustSignCPNote(t, "Firmware Transparency Log\n1\nEjQ=\n123\n"),
+			wantErr: true,
 		}, {
 			desc:    "garbage",
 			body:    []byte("garbage"),
@@ -65,7 +106,11 @@ func TestGetWitnessCheckpoint(t *testing.T) {
 	} {
 		t.Run(test.desc, func(t *testing.T) {
 			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				if !strings.HasSuffix(r.URL.Path, api.WitnessGetCheckpoint) {
+				wantPath := api.WitnessGetCheckpoint
+				if len(test.logID) > 0 {
+					wantPath += "/" + test.logID
+				}
+				if !strings.HasSuffix(r.URL.Path, wantPath) {
 					t.Fatalf("Got unexpected HTTP request on %q", r.URL.Path)
 				}
 				if _, err := fmt.Fprint(w, string(test.body)); err != nil {
@@ -79,8 +124,10 @@ func TestGetWitnessCheckpoint(t *testing.T) {
 				t.Fatalf("Failed to parse test server URL: %v", err)
 			}
 			wc := client.WitnessClient{
-				URL:            tsURL,
-				LogSigVerifier: mustGetLogSigVerifier(t),
+				URL:             tsURL,
+				LogSigVerifier:  mustGetLogSigVerifier(t),
+				WitnessVerifier: wv,
+				LogID:           test.logID,
 			}
 			cp, err := wc.GetWitnessCheckpoint()
 			switch {
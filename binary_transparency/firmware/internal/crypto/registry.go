@@ -0,0 +1,269 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypto
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+)
+
+// RegistryConfig is the serialized form of a ClaimantRegistry.
+type RegistryConfig struct {
+	Claimants []ClaimantConfig
+	// Devices restricts which publishers may make firmware statements for each device.
+	// If empty, any trusted publisher may make statements for any device.
+	Devices []DevicePolicyConfig
+}
+
+// ClaimantConfig describes a single key which is trusted to make statements
+// of a given type.
+type ClaimantConfig struct {
+	// ID is a human readable identifier for this key, e.g. "vendor-2021".
+	ID string
+	// Type is the single character StatementType the key may sign, e.g. "f".
+	Type string
+	// PublicKey is the PEM encoded public key.
+	// Exactly one of PublicKey and PublicKeyFile must be set.
+	PublicKey string
+	// PublicKeyFile is a path to a PEM encoded public key. Relative paths are
+	// resolved against the directory containing the config file.
+	PublicKeyFile string
+}
+
+// DevicePolicyConfig binds a device, or family of devices, to the publishers
+// which are allowed to make firmware statements for it.
+type DevicePolicyConfig struct {
+	// DeviceID matches a single device exactly.
+	// Exactly one of DeviceID and DeviceIDPrefix must be set.
+	DeviceID string
+	// DeviceIDPrefix matches all devices with IDs starting with this prefix.
+	DeviceIDPrefix string
+	// Publishers are the IDs of the firmware claimants allowed to publish for matching devices.
+	Publishers []string
+}
+
+// ClaimantRegistry is the set of keys which are trusted to make each type of statement.
+type ClaimantRegistry struct {
+	claimants map[api.StatementType][]*Claimant
+	devices   []DevicePolicyConfig
+}
+
+// NewClaimantRegistry returns a registry containing the claimants in the given config.
+// Relative key file paths are resolved against baseDir.
+func NewClaimantRegistry(cfg RegistryConfig, baseDir string) (*ClaimantRegistry, error) {
+	r := &ClaimantRegistry{claimants: make(map[api.StatementType][]*Claimant)}
+	for i, cc := range cfg.Claimants {
+		if len(cc.Type) != 1 {
+			return nil, fmt.Errorf("claimant %d (%q): invalid statement type %q", i, cc.ID, cc.Type)
+		}
+		pub := cc.PublicKey
+		switch {
+		case pub != "" && cc.PublicKeyFile != "":
+			return nil, fmt.Errorf("claimant %d (%q): only one of PublicKey and PublicKeyFile may be set", i, cc.ID)
+		case cc.PublicKeyFile != "":
+			path := cc.PublicKeyFile
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(baseDir, path)
+			}
+			bs, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("claimant %d (%q): failed to read public key: %w", i, cc.ID, err)
+			}
+			pub = string(bs)
+		case pub == "":
+			return nil, fmt.Errorf("claimant %d (%q): no public key provided", i, cc.ID)
+		}
+		if err := r.Add(api.StatementType(cc.Type[0]), &Claimant{ID: cc.ID, pub: pub}); err != nil {
+			return nil, fmt.Errorf("claimant %d (%q): %w", i, cc.ID, err)
+		}
+	}
+	publishers := make(map[string]bool)
+	for _, c := range r.claimants[api.FirmwareMetadataType] {
+		publishers[c.ID] = true
+	}
+	for i, dc := range cfg.Devices {
+		if (len(dc.DeviceID) > 0) == (len(dc.DeviceIDPrefix) > 0) {
+			return nil, fmt.Errorf("device policy %d: exactly one of DeviceID and DeviceIDPrefix must be set", i)
+		}
+		for _, p := range dc.Publishers {
+			if !publishers[p] {
+				return nil, fmt.Errorf("device policy %d: unknown publisher %q", i, p)
+			}
+		}
+		r.devices = append(r.devices, dc)
+	}
+	return r, nil
+}
+
+// LoadClaimantRegistry reads a JSON encoded RegistryConfig from the given file.
+func LoadClaimantRegistry(path string) (*ClaimantRegistry, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read claimant config: %w", err)
+	}
+	var cfg RegistryConfig
+	if err := json.Unmarshal(bs, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse claimant config: %w", err)
+	}
+	return NewClaimantRegistry(cfg, filepath.Dir(path))
+}
+
+// TestClaimantRegistry returns a registry which trusts only the TEST/DEMO keys.
+func TestClaimantRegistry() *ClaimantRegistry {
+	r := &ClaimantRegistry{claimants: make(map[api.StatementType][]*Claimant)}
+	for _, e := range []struct {
+		t api.StatementType
+		c Claimant
+	}{
+		{t: api.FirmwareMetadataType, c: Publisher},
+		{t: api.MalwareStatementType, c: AnnotatorMalware},
+		{t: api.RevocationStatementType, c: Revoker},
+		{t: api.BuildStatementType, c: AnnotatorBuild},
+		{t: api.SBOMStatementType, c: AnnotatorSBOM},
+		{t: api.VulnerabilityStatementType, c: AnnotatorVulnerability},
+	} {
+		c := e.c
+		if err := r.Add(e.t, &c); err != nil {
+			panic(fmt.Sprintf("invalid test claimant %q: %v", c.ID, err))
+		}
+	}
+	return r
+}
+
+// Add registers the claimant as trusted to make statements of the given type.
+// Claimant IDs must be unique for each statement type, as key ID hints and publisher
+// policies refer to claimants by ID.
+func (r *ClaimantRegistry) Add(t api.StatementType, c *Claimant) error {
+	if _, err := c.getPublicKey(); err != nil {
+		return fmt.Errorf("invalid public key: %w", err)
+	}
+	for _, o := range r.claimants[t] {
+		if o.ID == c.ID {
+			return fmt.Errorf("duplicate claimant ID %q for statement type %q", c.ID, t)
+		}
+	}
+	r.claimants[t] = append(r.claimants[t], c)
+	return nil
+}
+
+// ClaimantsForType returns all of the claimants trusted to make statements of the given type.
+func (r *ClaimantRegistry) ClaimantsForType(t api.StatementType) []*Claimant {
+	if r == nil {
+		return nil
+	}
+	return r.claimants[t]
+}
+
+// VerifySignature checks that the signature was made over the statement by one of
+// the claimants trusted for the statement type, and returns that claimant.
+func (r *ClaimantRegistry) VerifySignature(stype api.StatementType, stmt []byte, signature []byte) (*Claimant, error) {
+	return r.VerifyStatement(api.SignedStatement{Type: stype, Statement: stmt, Signature: signature})
+}
+
+// VerifyStatement checks that the SignedStatement was signed by one of the claimants
+// trusted for its type, and returns that claimant. The KeyID is only a hint, as signers
+// don't necessarily know the ID that verifiers have given their key: the claimant with
+// that ID is tried first, followed by the others. If the statement names an Algorithm
+// then only claimants with keys for it are considered.
+func (r *ClaimantRegistry) VerifyStatement(s api.SignedStatement) (*Claimant, error) {
+	cs := r.ClaimantsForType(s.Type)
+	if len(cs) == 0 {
+		return nil, fmt.Errorf("no claimants trusted for statement type %q", s.Type)
+	}
+	ordered := make([]*Claimant, 0, len(cs))
+	for _, c := range cs {
+		if c.ID == s.KeyID {
+			ordered = append(ordered, c)
+		}
+	}
+	for _, c := range cs {
+		if c.ID != s.KeyID {
+			ordered = append(ordered, c)
+		}
+	}
+	for _, c := range ordered {
+		if len(s.Algorithm) > 0 {
+			if alg, err := c.Algorithm(); err != nil || alg != s.Algorithm {
+				continue
+			}
+		}
+		if err := c.VerifySignature(s.Type, s.Statement, s.Signature); err == nil {
+			return c, nil
+		}
+	}
+	if len(s.KeyID) > 0 {
+		return nil, fmt.Errorf("signature (key ID %q) not made by any trusted claimant", s.KeyID)
+	}
+	return nil, errors.New("signature not made by any trusted claimant")
+}
+
+// AuthorizeFirmware checks that the claimant is allowed to make firmware statements
+// for the given device. An exact DeviceID policy takes precedence over prefix policies,
+// and the longest matching prefix wins. If device policies are configured then
+// firmware for devices matching none of them is rejected.
+func (r *ClaimantRegistry) AuthorizeFirmware(deviceID string, c *Claimant) error {
+	if r == nil || len(r.devices) == 0 {
+		return nil
+	}
+	var match *DevicePolicyConfig
+	for i, d := range r.devices {
+		if len(d.DeviceID) > 0 && d.DeviceID == deviceID {
+			match = &r.devices[i]
+			break
+		}
+		if len(d.DeviceIDPrefix) > 0 && strings.HasPrefix(deviceID, d.DeviceIDPrefix) {
+			if match == nil || len(d.DeviceIDPrefix) > len(match.DeviceIDPrefix) {
+				match = &r.devices[i]
+			}
+		}
+	}
+	if match == nil {
+		return fmt.Errorf("no publishers are authorized for device %q", deviceID)
+	}
+	for _, p := range match.Publishers {
+		if p == c.ID {
+			return nil
+		}
+	}
+	return fmt.Errorf("publisher %q is not authorized for device %q", c.ID, deviceID)
+}
+
+// LoadSigningClaimant returns a Claimant which signs with the PEM encoded private
+// key in the given file. The id is included in signed statements as a hint to
+// verifiers, and may be empty.
+func LoadSigningClaimant(path, id string) (*Claimant, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+	return NewSigningClaimant(string(bs), id)
+}
+
+// NewSigningClaimant returns a Claimant which signs with the given PEM encoded private key.
+func NewSigningClaimant(priv, id string) (*Claimant, error) {
+	c := &Claimant{ID: id, priv: priv}
+	pub, err := c.derivePublicKey()
+	if err != nil {
+		return nil, err
+	}
+	c.pub = pub
+	return c, nil
+}
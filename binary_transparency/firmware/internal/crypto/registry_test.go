@@ -0,0 +1,312 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package crypto
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+)
+
+func TestLoadClaimantRegistry(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "annotator.pem"), []byte(TestAnnotationPub), 0o644); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+
+	for _, test := range []struct {
+		desc    string
+		cfg     RegistryConfig
+		wantErr bool
+	}{
+		{
+			desc: "inline and file keys",
+			cfg: RegistryConfig{Claimants: []ClaimantConfig{
+				{ID: "vendor", Type: "f", PublicKey: TestVendorRSAPub},
+				{ID: "annotator", Type: "m", PublicKeyFile: "annotator.pem"},
+			}},
+		}, {
+			desc: "missing key",
+			cfg: RegistryConfig{Claimants: []ClaimantConfig{
+				{ID: "vendor", Type: "f"},
+			}},
+			wantErr: true,
+		}, {
+			desc: "both keys",
+			cfg: RegistryConfig{Claimants: []ClaimantConfig{
+				{ID: "vendor", Type: "f", PublicKey: TestVendorRSAPub, PublicKeyFile: "annotator.pem"},
+			}},
+			wantErr: true,
+		}, {
+			desc: "bad type",
+			cfg: RegistryConfig{Claimants: []ClaimantConfig{
+				{ID: "vendor", Type: "firmware", PublicKey: TestVendorRSAPub},
+			}},
+			wantErr: true,
+		}, {
+			desc: "bad key",
+			cfg: RegistryConfig{Claimants: []ClaimantConfig{
+				{ID: "vendor", Type: "f", PublicKey: "not a key"},
+			}},
+			wantErr: true,
+		}, {
+			desc: "duplicate ID",
+			cfg: RegistryConfig{Claimants: []ClaimantConfig{
+				{ID: "vendor", Type: "f", PublicKey: TestVendorRSAPub},
+				{ID: "vendor", Type: "f", PublicKeyFile: "annotator.pem"},
+			}},
+			wantErr: true,
+		}, {
+			desc: "same ID for different types",
+			cfg: RegistryConfig{Claimants: []ClaimantConfig{
+				{ID: "vendor", Type: "f", PublicKey: TestVendorRSAPub},
+				{ID: "vendor", Type: "r", PublicKey: TestVendorRSAPub},
+			}},
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			bs, err := json.Marshal(test.cfg)
+			if err != nil {
+				t.Fatalf("Marshal(): %v", err)
+			}
+			path := filepath.Join(dir, "claimants.json")
+			if err := os.WriteFile(path, bs, 0o644); err != nil {
+				t.Fatalf("WriteFile(): %v", err)
+			}
+			_, err = LoadClaimantRegistry(path)
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("Got unexpected error %q", err)
+			case err == nil && test.wantErr:
+				t.Fatal("Got no error, but wanted error")
+			}
+		})
+	}
+}
+
+func TestRegistryVerifySignature(t *testing.T) {
+	r := TestClaimantRegistry()
+	vendor, err := NewSigningClaimant(TestVendorRSAPriv, "vendor")
+	if err != nil {
+		t.Fatalf("NewSigningClaimant(): %v", err)
+	}
+	msg := []byte("My Test Message")
+
+	for _, test := range []struct {
+		desc     string
+		claimant *Claimant
+		stype    api.StatementType
+		wantID   string
+		wantErr  bool
+	}{
+		{
+			desc:     "vendor firmware",
+			claimant: vendor,
+			stype:    api.FirmwareMetadataType,
+			wantID:   Publisher.ID,
+		}, {
+			desc:     "annotator malware",
+			claimant: &AnnotatorMalware,
+			stype:    api.MalwareStatementType,
+			wantID:   AnnotatorMalware.ID,
+		}, {
+			desc:     "annotator not trusted for firmware",
+			claimant: &AnnotatorMalware,
+			stype:    api.FirmwareMetadataType,
+			wantErr:  true,
+		}, {
+			desc:     "unknown type",
+			claimant: vendor,
+			stype:    api.StatementType('x'),
+			wantErr:  true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			sig, err := test.claimant.SignMessage(test.stype, msg)
+			if err != nil {
+				t.Fatalf("SignMessage(): %v", err)
+			}
+			c, err := r.VerifySignature(test.stype, msg, sig)
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("Got unexpected error %q", err)
+			case err == nil && test.wantErr:
+				t.Fatal("Got no error, but wanted error")
+			case err == nil && c.ID != test.wantID:
+				t.Errorf("got claimant %q, want %q", c.ID, test.wantID)
+			}
+		})
+	}
+}
+
+func TestAuthorizeFirmware(t *testing.T) {
+	r, err := NewClaimantRegistry(RegistryConfig{
+		Claimants: []ClaimantConfig{
+			{ID: "vendor-a", Type: "f", PublicKey: TestVendorRSAPub},
+			{ID: "vendor-b", Type: "f", PublicKey: TestAnnotationPub},
+		},
+		Devices: []DevicePolicyConfig{
+			{DeviceIDPrefix: "acme-", Publishers: []string{"vendor-a"}},
+			{DeviceIDPrefix: "acme-toaster-", Publishers: []string{"vendor-b"}},
+			{DeviceID: "acme-toaster-9000", Publishers: []string{"vendor-a", "vendor-b"}},
+		},
+	}, "")
+	if err != nil {
+		t.Fatalf("NewClaimantRegistry(): %v", err)
+	}
+	a, b := &Claimant{ID: "vendor-a"}, &Claimant{ID: "vendor-b"}
+
+	for _, test := range []struct {
+		desc     string
+		deviceID string
+		claimant *Claimant
+		wantErr  bool
+	}{
+		{desc: "prefix match", deviceID: "acme-kettle", claimant: a},
+		{desc: "prefix mismatch", deviceID: "acme-kettle", claimant: b, wantErr: true},
+		{desc: "longest prefix wins", deviceID: "acme-toaster-1", claimant: b},
+		{desc: "longest prefix excludes shorter", deviceID: "acme-toaster-1", claimant: a, wantErr: true},
+		{desc: "exact match a", deviceID: "acme-toaster-9000", claimant: a},
+		{desc: "exact match b", deviceID: "acme-toaster-9000", claimant: b},
+		{desc: "no policy", deviceID: "other", claimant: a, wantErr: true},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			err := r.AuthorizeFirmware(test.deviceID, test.claimant)
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("Got unexpected error %q", err)
+			case err == nil && test.wantErr:
+				t.Fatal("Got no error, but wanted error")
+			}
+		})
+	}
+
+	if err := TestClaimantRegistry().AuthorizeFirmware("anything", &Publisher); err != nil {
+		t.Errorf("registry without device policies rejected firmware: %v", err)
+	}
+	if _, err := NewClaimantRegistry(RegistryConfig{
+		Devices: []DevicePolicyConfig{{DeviceID: "d", Publishers: []string{"nobody"}}},
+	}, ""); err == nil {
+		t.Error("policy with unknown publisher was accepted")
+	}
+}
+
+func TestRegistryVerifyStatementHints(t *testing.T) {
+	r := TestClaimantRegistry()
+	msg := []byte("My Test Message")
+	good, err := Publisher.SignStatement(api.FirmwareMetadataType, msg)
+	if err != nil {
+		t.Fatalf("SignStatement(): %v", err)
+	}
+
+	for _, test := range []struct {
+		desc    string
+		modify  func(s *api.SignedStatement)
+		wantErr bool
+	}{
+		{
+			desc:   "all hints",
+			modify: func(s *api.SignedStatement) {},
+		}, {
+			desc: "no hints",
+			modify: func(s *api.SignedStatement) {
+				s.KeyID, s.Algorithm = "", ""
+			},
+		}, {
+			desc: "unknown key ID",
+			modify: func(s *api.SignedStatement) {
+				s.KeyID = "someone-else"
+			},
+		}, {
+			desc: "key ID of another claimant",
+			modify: func(s *api.SignedStatement) {
+				s.KeyID = AnnotatorMalware.ID
+			},
+		}, {
+			desc: "unknown key ID and bad signature",
+			modify: func(s *api.SignedStatement) {
+				s.KeyID = "someone-else"
+				s.Signature = []byte("not a signature")
+			},
+			wantErr: true,
+		}, {
+			desc: "wrong algorithm",
+			modify: func(s *api.SignedStatement) {
+				s.Algorithm = api.Ed25519
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			s := good
+			test.modify(&s)
+			_, err := r.VerifyStatement(s)
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("Got unexpected error %q", err)
+			case err == nil && test.wantErr:
+				t.Fatal("Got no error, but wanted error")
+			}
+		})
+	}
+}
+
+func TestLoadedKeyVerifiesAgainstLoadedConfig(t *testing.T) {
+	dir := t.TempDir()
+	privPath := filepath.Join(dir, "vendor.key")
+	if err := os.WriteFile(privPath, []byte(TestVendorRSAPriv), 0o600); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "vendor.pem"), []byte(TestVendorRSAPub), 0o644); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	cfg, err := json.Marshal(RegistryConfig{Claimants: []ClaimantConfig{
+		{ID: "annotator", Type: "f", PublicKey: TestAnnotationPub},
+		{ID: "vendor-2021", Type: "f", PublicKeyFile: "vendor.pem"},
+	}})
+	if err != nil {
+		t.Fatalf("Marshal(): %v", err)
+	}
+	cfgPath := filepath.Join(dir, "claimants.json")
+	if err := os.WriteFile(cfgPath, cfg, 0o644); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	r, err := LoadClaimantRegistry(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadClaimantRegistry(): %v", err)
+	}
+
+	for _, id := range []string{"", "vendor-2021", privPath} {
+		t.Run(id, func(t *testing.T) {
+			signer, err := LoadSigningClaimant(privPath, id)
+			if err != nil {
+				t.Fatalf("LoadSigningClaimant(): %v", err)
+			}
+			s, err := signer.SignStatement(api.FirmwareMetadataType, []byte("firmware"))
+			if err != nil {
+				t.Fatalf("SignStatement(): %v", err)
+			}
+			c, err := r.VerifyStatement(s)
+			if err != nil {
+				t.Fatalf("VerifyStatement(): %v", err)
+			}
+			if got, want := c.ID, "vendor-2021"; got != want {
+				t.Errorf("got claimant %q, want %q", got, want)
+			}
+		})
+	}
+}
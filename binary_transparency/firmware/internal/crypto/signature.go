@@ -16,15 +16,17 @@ package crypto
 
 import (
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/sha512"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
 	"fmt"
-	"io"
-	"strings"
 
 	"github.com/google/trillian-examples/binary_transparency/firmware/api"
 )
@@ -32,73 +34,182 @@ import (
 var (
 	// Publisher makes statements containing the firmware metadata.
 	Publisher = Claimant{
+		ID:   "test-vendor",
 		priv: TestVendorRSAPriv,
 		pub:  TestVendorRSAPub,
 	}
 
 	// AnnotatorMalware makes annotation statements about malware in firmware.
 	AnnotatorMalware = Claimant{
+		ID:   "test-annotator-malware",
 		priv: TestAnnotationPriv,
 		pub:  TestAnnotationPub,
 	}
+
+	// Revoker makes statements revoking firmware.
+	Revoker = Claimant{
+		ID:   "test-revoker",
+		priv: TestRevokerPriv,
+		pub:  TestRevokerPub,
+	}
+
+	// AnnotatorBuild makes annotation statements about rebuilding firmware from source.
+	AnnotatorBuild = Claimant{
+		ID:   "test-annotator-build",
+		priv: TestBuilderPriv,
+		pub:  TestBuilderPub,
+	}
+
+	// AnnotatorSBOM makes annotation statements about the software bill of materials for firmware.
+	AnnotatorSBOM = Claimant{
+		ID:   "test-annotator-sbom",
+		priv: TestSBOMPriv,
+		pub:  TestSBOMPub,
+	}
+
+	// AnnotatorVulnerability makes annotation statements about vulnerabilities in firmware.
+	AnnotatorVulnerability = Claimant{
+		ID:   "test-annotator-vulnerability",
+		priv: TestVulnerabilityPriv,
+		pub:  TestVulnerabilityPub,
+	}
 )
 
 // Claimant is someone that makes statements, and can sign and verify them.
+// Keys may be RSA (signing with RSA-PSS over SHA-512), Ed25519, or ECDSA P-256
+// (signing over SHA-256), and are PEM encoded.
 type Claimant struct {
+	// ID identifies the claimant's key in logs and configuration.
+	ID string
+
 	// Note that outside of a demo the private key should never be used like this!
 	priv, pub string
 }
 
-func (c *Claimant) getPrivateKey() (*rsa.PrivateKey, error) {
-	mKey := strings.NewReader(c.priv)
-	priv, err := io.ReadAll(mKey)
-	if err != nil {
-		return nil, fmt.Errorf("read failed! %s", err)
+func decodePEM(k string) (*pem.Block, error) {
+	p, rest := pem.Decode([]byte(k))
+	if p == nil {
+		return nil, fmt.Errorf("pem decoded to nil")
 	}
-
-	privPem, rest := pem.Decode(priv)
 	if len(rest) != 0 {
 		return nil, fmt.Errorf("extraneous data: %v", rest)
 	}
-	if privPem == nil {
-		return nil, fmt.Errorf("pem decoded to nil")
+	return p, nil
+}
+
+func (c *Claimant) getPrivateKey() (crypto.Signer, error) {
+	privPem, err := decodePEM(c.priv)
+	if err != nil {
+		return nil, err
 	}
-	if privPem.Type != "RSA PRIVATE KEY" {
-		return nil, fmt.Errorf("RSA private key is of the wrong type %s", privPem.Type)
+
+	switch privPem.Type {
+	case "RSA PRIVATE KEY":
+		k, err := x509.ParsePKCS1PrivateKey(privPem.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse RSA private key %v", err)
+		}
+		return k, nil
+	case "EC PRIVATE KEY":
+		k, err := x509.ParseECPrivateKey(privPem.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse EC private key %v", err)
+		}
+		return k, nil
+	case "PRIVATE KEY":
+		k, err := x509.ParsePKCS8PrivateKey(privPem.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse PKCS#8 private key %v", err)
+		}
+		s, ok := k.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", k)
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("private key is of the wrong type %s", privPem.Type)
 	}
+}
 
-	var privateKey *rsa.PrivateKey
-	if privateKey, err = x509.ParsePKCS1PrivateKey(privPem.Bytes); err != nil {
-		return nil, fmt.Errorf("unable to parse RSA private key %v", err)
+func (c *Claimant) getPublicKey() (crypto.PublicKey, error) {
+	pubPem, err := decodePEM(c.pub)
+	if err != nil {
+		return nil, err
 	}
 
-	return privateKey, nil
+	var pubKey crypto.PublicKey
+	switch pubPem.Type {
+	case "RSA PUBLIC KEY":
+		if pubKey, err = x509.ParsePKCS1PublicKey(pubPem.Bytes); err != nil {
+			return nil, fmt.Errorf("unable to parse RSA public key %v", err)
+		}
+	case "PUBLIC KEY":
+		if pubKey, err = x509.ParsePKIXPublicKey(pubPem.Bytes); err != nil {
+			return nil, fmt.Errorf("unable to parse public key %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("public key is of the wrong type %s", pubPem.Type)
+	}
+	if _, err := algorithmForKey(pubKey); err != nil {
+		return nil, err
+	}
+	return pubKey, nil
 }
 
-func (c *Claimant) getPublicKey() (*rsa.PublicKey, error) {
-	mKey := strings.NewReader(c.pub)
-	pub, err := io.ReadAll(mKey)
+// derivePublicKey returns the PEM encoded public key corresponding to the private key.
+func (c *Claimant) derivePublicKey() (string, error) {
+	key, err := c.getPrivateKey()
 	if err != nil {
-		return nil, fmt.Errorf("public key read failed! %s", err)
+		return "", err
 	}
-
-	pubPem, rest := pem.Decode(pub)
-	if len(rest) != 0 {
-		return nil, fmt.Errorf("extraneous data: %v", rest)
+	if k, ok := key.Public().(*rsa.PublicKey); ok {
+		return string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PUBLIC KEY",
+			Bytes: x509.MarshalPKCS1PublicKey(k),
+		})), nil
 	}
-	if pubPem == nil {
-		return nil, fmt.Errorf("pem decoded to nil")
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal public key %v", err)
 	}
-	if pubPem.Type != "RSA PUBLIC KEY" {
-		return nil, fmt.Errorf("RSA public key is of the wrong type %s", pubPem.Type)
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: der,
+	})), nil
+}
+
+// algorithmForKey returns the signature algorithm used with the given public key.
+func algorithmForKey(k crypto.PublicKey) (api.SignatureAlgorithm, error) {
+	switch k := k.(type) {
+	case *rsa.PublicKey:
+		return api.RSAPSSSHA512, nil
+	case ed25519.PublicKey:
+		return api.Ed25519, nil
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return "", fmt.Errorf("unsupported ECDSA curve %s", k.Curve.Params().Name)
+		}
+		return api.ECDSAP256SHA256, nil
+	default:
+		return "", fmt.Errorf("unsupported public key type %T", k)
 	}
+}
 
-	var pubKey *rsa.PublicKey
-	if pubKey, err = x509.ParsePKCS1PublicKey(pubPem.Bytes); err != nil {
-		return nil, fmt.Errorf("unable to parse RSA public key %v", err)
+// Algorithm returns the signature algorithm used by this claimant's key.
+func (c *Claimant) Algorithm() (api.SignatureAlgorithm, error) {
+	key, err := c.getPublicKey()
+	if err != nil {
+		return "", err
 	}
+	return algorithmForKey(key)
+}
 
-	return pubKey, nil
+// signedBytes returns the bytes which are signed for a statement, i.e. (Type || Statement).
+func signedBytes(stype api.StatementType, msg []byte) []byte {
+	bs := make([]byte, len(msg)+1)
+	bs[0] = byte(stype)
+	copy(bs[1:], msg)
+	return bs
 }
 
 // SignMessage is used to sign the Statement
@@ -106,27 +217,57 @@ func (c *Claimant) SignMessage(stype api.StatementType, msg []byte) ([]byte, err
 	if len(msg) > 64*1024*1024 {
 		return nil, errors.New("msg too large")
 	}
-	bs := make([]byte, len(msg)+1)
-	bs[0] = byte(stype)
-	copy(bs[1:], msg)
-
-	// Before signing, we need to hash the message
-	// The hash is what we actually sign
-	h := sha512.Sum512(bs)
+	bs := signedBytes(stype, msg)
 
 	// Get the required key for signing
 	key, err := c.getPrivateKey()
 	if err != nil {
 		return nil, fmt.Errorf("private key fetch failed %v", err)
 	}
-	// use PSS over PKCS#1 v1.5 for enhanced security
-	signature, err := rsa.SignPSS(rand.Reader, key, crypto.SHA512, h[:], nil)
+
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		// Before signing, we need to hash the message
+		// The hash is what we actually sign
+		h := sha512.Sum512(bs)
+		// use PSS over PKCS#1 v1.5 for enhanced security
+		signature, err = rsa.SignPSS(rand.Reader, key, crypto.SHA512, h[:], nil)
+	case ed25519.PrivateKey:
+		// Ed25519 hashes the message internally.
+		signature = ed25519.Sign(key, bs)
+	case *ecdsa.PrivateKey:
+		h := sha256.Sum256(bs)
+		signature, err = ecdsa.SignASN1(rand.Reader, key, h[:])
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to sign statement %v", err)
 	}
 	return signature, nil
 }
 
+// SignStatement signs the message and returns it as a SignedStatement, including
+// the ID and algorithm of the key used.
+func (c *Claimant) SignStatement(stype api.StatementType, msg []byte) (api.SignedStatement, error) {
+	alg, err := c.Algorithm()
+	if err != nil {
+		return api.SignedStatement{}, err
+	}
+	sig, err := c.SignMessage(stype, msg)
+	if err != nil {
+		return api.SignedStatement{}, err
+	}
+	return api.SignedStatement{
+		Type:      stype,
+		Statement: msg,
+		Signature: sig,
+		KeyID:     c.ID,
+		Algorithm: alg,
+	}, nil
+}
+
 // VerifySignature is used to verify the incoming message
 func (c *Claimant) VerifySignature(stype api.StatementType, stmt []byte, signature []byte) error {
 	// Get the required key for signing
@@ -134,30 +275,29 @@ func (c *Claimant) VerifySignature(stype api.StatementType, stmt []byte, signatu
 	if err != nil {
 		return fmt.Errorf("public key fetch failed %v", err)
 	}
-	bs := make([]byte, len(stmt)+1)
-	bs[0] = byte(stype)
-	copy(bs[1:], stmt)
-
-	// Before verify, we need to hash the message
-	// The hash is what we actually verify
-	h := sha512.Sum512(bs)
+	bs := signedBytes(stype, stmt)
 
-	if err = rsa.VerifyPSS(key, crypto.SHA512, h[:], signature, nil); err != nil {
-		return fmt.Errorf("failed to verify signature %v", err)
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		// Before verify, we need to hash the message
+		// The hash is what we actually verify
+		h := sha512.Sum512(bs)
+		if err = rsa.VerifyPSS(key, crypto.SHA512, h[:], signature, nil); err != nil {
+			return fmt.Errorf("failed to verify signature %v", err)
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, bs, signature) {
+			return errors.New("failed to verify signature")
+		}
+	case *ecdsa.PublicKey:
+		h := sha256.Sum256(bs)
+		if !ecdsa.VerifyASN1(key, h[:], signature) {
+			return errors.New("failed to verify signature")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
 	}
-	// If we don't get any error from the `VerifyPSS` method, implies our
+	// If we don't get any error from verification, this implies our
 	// signature is valid
 	return nil
 }
-
-// ClaimantForType returns the relevant Claimant for the given Statement type.
-func ClaimantForType(t api.StatementType) (*Claimant, error) {
-	switch t {
-	case api.FirmwareMetadataType:
-		return &Publisher, nil
-	case api.MalwareStatementType:
-		return &AnnotatorMalware, nil
-	default:
-		return nil, fmt.Errorf("Unknown Claimant type %v", t)
-	}
-}
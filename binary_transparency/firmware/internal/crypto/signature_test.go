@@ -14,6 +14,12 @@
 package crypto
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
 	"testing"
 
 	"github.com/google/trillian-examples/binary_transparency/firmware/api"
@@ -75,3 +81,97 @@ func TestSignatureRoundTrip(t *testing.T) {
 		})
 	}
 }
+
+func mustPKCS8Claimant(t *testing.T, id string, k interface{}) *Claimant {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(k)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey(): %v", err)
+	}
+	c, err := NewSigningClaimant(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), id)
+	if err != nil {
+		t.Fatalf("NewSigningClaimant(): %v", err)
+	}
+	return c
+}
+
+func TestSignStatementAlgorithms(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey(): %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey(): %v", err)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey(): %v", err)
+	}
+	sec1, err := NewSigningClaimant(string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER})), "sec1")
+	if err != nil {
+		t.Fatalf("NewSigningClaimant(): %v", err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey(): %v", err)
+	}
+
+	for _, test := range []struct {
+		desc     string
+		claimant *Claimant
+		wantAlg  api.SignatureAlgorithm
+	}{
+		{
+			desc:     "RSA",
+			claimant: &Publisher,
+			wantAlg:  api.RSAPSSSHA512,
+		}, {
+			desc:     "Ed25519",
+			claimant: mustPKCS8Claimant(t, "ed", edKey),
+			wantAlg:  api.Ed25519,
+		}, {
+			desc:     "ECDSA PKCS8",
+			claimant: mustPKCS8Claimant(t, "ec", ecKey),
+			wantAlg:  api.ECDSAP256SHA256,
+		}, {
+			desc:     "ECDSA SEC1",
+			claimant: sec1,
+			wantAlg:  api.ECDSAP256SHA256,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			msg := []byte("My Test Message")
+			ss, err := test.claimant.SignStatement(api.FirmwareMetadataType, msg)
+			if err != nil {
+				t.Fatalf("SignStatement(): %v", err)
+			}
+			if got, want := ss.Algorithm, test.wantAlg; got != want {
+				t.Errorf("got algorithm %q, want %q", got, want)
+			}
+			if got, want := ss.KeyID, test.claimant.ID; got != want {
+				t.Errorf("got key ID %q, want %q", got, want)
+			}
+			if err := test.claimant.VerifySignature(ss.Type, ss.Statement, ss.Signature); err != nil {
+				t.Errorf("VerifySignature(): %v", err)
+			}
+			if err := test.claimant.VerifySignature(ss.Type, []byte("My Test1 Message"), ss.Signature); err == nil {
+				t.Error("VerifySignature() of modified message succeeded")
+			}
+		})
+	}
+
+	t.Run("unsupported curve", func(t *testing.T) {
+		der, err := x509.MarshalPKCS8PrivateKey(p384Key)
+		if err != nil {
+			t.Fatalf("MarshalPKCS8PrivateKey(): %v", err)
+		}
+		c, err := NewSigningClaimant(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), "p384")
+		if err != nil {
+			t.Fatalf("NewSigningClaimant(): %v", err)
+		}
+		if _, err := c.SignStatement(api.FirmwareMetadataType, []byte("msg")); err == nil {
+			t.Error("SignStatement() with P-384 key succeeded")
+		}
+	})
+}
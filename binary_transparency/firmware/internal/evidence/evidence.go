@@ -0,0 +1,159 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package evidence records proof that a log has presented split views of itself,
+// in a form that can be checked by third parties.
+package evidence
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/transparency-dev/merkle/proof"
+	"github.com/transparency-dev/merkle/rfc6962"
+)
+
+// SplitView is evidence that a log has signed two checkpoints which can't both be
+// views of the same append-only log.
+//
+// If both checkpoints have the same size then they are conclusive evidence on their
+// own. Otherwise, the evidence is that the log can't produce a consistency proof
+// between them: Proof is what the log returned when asked, but anyone verifying the
+// evidence must ask the log for a proof themselves.
+type SplitView struct {
+	// Older and Newer are the conflicting checkpoint notes signed by the log.
+	// Older is no larger than Newer.
+	Older, Newer []byte
+	// Proof is the consistency proof from Older to Newer which failed to verify, if any.
+	Proof [][]byte
+	// Detected is when the split view was found.
+	Detected time.Time
+}
+
+// New returns evidence of a split view between the two checkpoints.
+func New(a, b api.LogCheckpoint, consistency [][]byte) SplitView {
+	if a.Size > b.Size {
+		a, b = b, a
+	}
+	return SplitView{
+		Older:    a.Envelope,
+		Newer:    b.Envelope,
+		Proof:    consistency,
+		Detected: time.Now().UTC(),
+	}
+}
+
+// Verify checks that the evidence shows a split view of the log: both checkpoints
+// are signed by the log, and they are not consistent.
+// Checkpoints of different sizes are checked against a consistency proof fetched from
+// the log with cpFunc, as the recorded Proof may not have come from the log; if cpFunc
+// is nil, only evidence with checkpoints of the same size can be verified.
+func (sv SplitView) Verify(lv api.LogVerifier, cpFunc ConsistencyProofFunc) error {
+	older, _, err := api.VerifyCheckpoint(sv.Older, lv)
+	if err != nil {
+		return fmt.Errorf("invalid older checkpoint: %w", err)
+	}
+	newer, _, err := api.VerifyCheckpoint(sv.Newer, lv)
+	if err != nil {
+		return fmt.Errorf("invalid newer checkpoint: %w", err)
+	}
+	if older.Size > newer.Size {
+		return fmt.Errorf("older checkpoint size %d is larger than newer checkpoint size %d", older.Size, newer.Size)
+	}
+	if older.Size == newer.Size {
+		if bytes.Equal(older.Hash, newer.Hash) {
+			return errors.New("checkpoints have the same root hash")
+		}
+		return nil
+	}
+	if older.Size == 0 {
+		return errors.New("every tree is consistent with the empty tree")
+	}
+	if cpFunc == nil {
+		return fmt.Errorf("can't verify checkpoints of sizes %d and %d without a consistency proof from the log", older.Size, newer.Size)
+	}
+	consistency, err := cpFunc(older.Size, newer.Size)
+	if err != nil {
+		return fmt.Errorf("failed to get consistency proof from %d to %d: %w", older.Size, newer.Size, err)
+	}
+	if err := proof.VerifyConsistency(rfc6962.DefaultHasher, older.Size, newer.Size, consistency, older.Hash, newer.Hash); err == nil {
+		return errors.New("checkpoints are consistent")
+	}
+	return nil
+}
+
+// ConsistencyProofFunc returns a consistency proof from the log between two tree sizes.
+type ConsistencyProofFunc func(from, to uint64) ([][]byte, error)
+
+// Compare checks a checkpoint from another party against our own view of the log.
+// It returns evidence if they show a split view, or nil if they are consistent.
+// cpFunc may be nil if no log is available to ask for proofs, in which case only
+// checkpoints of the same size can be compared.
+func Compare(ours api.LogCheckpoint, theirsRaw []byte, lv api.LogVerifier, cpFunc ConsistencyProofFunc) (*SplitView, error) {
+	tcp, _, err := api.VerifyCheckpoint(theirsRaw, lv)
+	if err != nil {
+		return nil, fmt.Errorf("invalid checkpoint: %w", err)
+	}
+	theirs := api.LogCheckpoint{Checkpoint: *tcp, Envelope: theirsRaw}
+	if ours.Size == theirs.Size {
+		if bytes.Equal(ours.Hash, theirs.Hash) {
+			return nil, nil
+		}
+		sv := New(ours, theirs, nil)
+		return &sv, nil
+	}
+	smaller, larger := ours, theirs
+	if smaller.Size > larger.Size {
+		smaller, larger = larger, smaller
+	}
+	if smaller.Size == 0 {
+		return nil, nil
+	}
+	if cpFunc == nil {
+		return nil, fmt.Errorf("can't compare checkpoints of sizes %d and %d without a consistency proof", ours.Size, theirs.Size)
+	}
+	consistency, err := cpFunc(smaller.Size, larger.Size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get consistency proof from %d to %d: %w", smaller.Size, larger.Size, err)
+	}
+	if err := proof.VerifyConsistency(rfc6962.DefaultHasher, smaller.Size, larger.Size, consistency, smaller.Hash, larger.Hash); err != nil {
+		sv := New(smaller, larger, consistency)
+		return &sv, nil
+	}
+	return nil, nil
+}
+
+// ReadCheckpoint reads a checkpoint note from another party, which may be a URL
+// (e.g. of another witness) or a local file.
+func ReadCheckpoint(src string) ([]byte, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return os.ReadFile(src)
+	}
+	r, err := http.Get(src)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %q: %s", src, r.Status)
+	}
+	return io.ReadAll(r.Body)
+}
@@ -0,0 +1,207 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evidence
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
+	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/merkle/testonly"
+	"golang.org/x/mod/sumdb/note"
+)
+
+// testLog returns two trees which share the first 3 leaves but then fork.
+func testLog() (*testonly.Tree, *testonly.Tree) {
+	a, b := testonly.New(rfc6962.DefaultHasher), testonly.New(rfc6962.DefaultHasher)
+	for i := 0; i < 10; i++ {
+		a.AppendData([]byte(fmt.Sprintf("leaf %d", i)))
+		if i < 3 {
+			b.AppendData([]byte(fmt.Sprintf("leaf %d", i)))
+		} else {
+			b.AppendData([]byte(fmt.Sprintf("evil leaf %d", i)))
+		}
+	}
+	return a, b
+}
+
+func mustSignCP(t *testing.T, tree *testonly.Tree, size uint64) api.LogCheckpoint {
+	t.Helper()
+	s, err := note.NewSigner(crypto.TestFTPersonalityPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := fmt.Sprintf("%s\n%d\n%s\n123\n", api.FTLogOrigin, size, base64.StdEncoding.EncodeToString(tree.HashAt(size)))
+	n, err := note.Sign(&note.Note{Text: text}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := api.NewLogVerifier(api.FTLogOrigin, crypto.TestFTPersonalityPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp, err := api.ParseCheckpoint(n, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *cp
+}
+
+func TestCompareAndVerify(t *testing.T) {
+	lv, err := api.NewLogVerifier(api.FTLogOrigin, crypto.TestFTPersonalityPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	good, evil := testLog()
+	// The log serves proofs from the good tree.
+	cpFunc := func(from, to uint64) ([][]byte, error) { return good.ConsistencyProof(from, to) }
+
+	for _, test := range []struct {
+		desc      string
+		ours      api.LogCheckpoint
+		theirs    []byte
+		cpFunc    ConsistencyProofFunc
+		wantSplit bool
+		wantErr   bool
+	}{
+		{
+			desc:   "same",
+			ours:   mustSignCP(t, good, 5),
+			theirs: mustSignCP(t, good, 5).Envelope,
+		}, {
+			desc:   "theirs larger",
+			ours:   mustSignCP(t, good, 5),
+			theirs: mustSignCP(t, good, 8).Envelope,
+			cpFunc: cpFunc,
+		}, {
+			desc:   "theirs smaller",
+			ours:   mustSignCP(t, good, 8),
+			theirs: mustSignCP(t, good, 5).Envelope,
+			cpFunc: cpFunc,
+		}, {
+			desc:      "fork at same size",
+			ours:      mustSignCP(t, good, 5),
+			theirs:    mustSignCP(t, evil, 5).Envelope,
+			wantSplit: true,
+		}, {
+			desc:      "fork at different sizes",
+			ours:      mustSignCP(t, good, 5),
+			theirs:    mustSignCP(t, evil, 8).Envelope,
+			cpFunc:    cpFunc,
+			wantSplit: true,
+		}, {
+			desc:   "shared prefix",
+			ours:   mustSignCP(t, good, 3),
+			theirs: mustSignCP(t, evil, 8).Envelope,
+			cpFunc: func(from, to uint64) ([][]byte, error) { return evil.ConsistencyProof(from, to) },
+		}, {
+			desc:    "no proofs available",
+			ours:    mustSignCP(t, good, 5),
+			theirs:  mustSignCP(t, evil, 8).Envelope,
+			wantErr: true,
+		}, {
+			desc:    "log unavailable",
+			ours:    mustSignCP(t, good, 5),
+			theirs:  mustSignCP(t, evil, 8).Envelope,
+			cpFunc:  func(from, to uint64) ([][]byte, error) { return nil, errors.New("unavailable") },
+			wantErr: true,
+		}, {
+			desc:    "not signed by log",
+			ours:    mustSignCP(t, good, 5),
+			theirs:  []byte("garbage"),
+			wantErr: true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			sv, err := Compare(test.ours, test.theirs, lv, test.cpFunc)
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("unexpected error: %v", err)
+			case err == nil && test.wantErr:
+				t.Fatal("expected error, got none")
+			case err != nil && test.wantErr:
+				return
+			}
+			if got, want := sv != nil, test.wantSplit; got != want {
+				t.Fatalf("got split view %t, want %t", got, want)
+			}
+			if sv == nil {
+				return
+			}
+			if err := sv.Verify(lv, test.cpFunc); err != nil {
+				t.Errorf("Verify(): %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	lv, err := api.NewLogVerifier(api.FTLogOrigin, crypto.TestFTPersonalityPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	good, evil := testLog()
+	p, err := good.ConsistencyProof(5, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A proof which doesn't verify between the good checkpoints, as a submitter could make up.
+	bogus, err := evil.ConsistencyProof(5, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpFunc := func(from, to uint64) ([][]byte, error) { return good.ConsistencyProof(from, to) }
+	for _, test := range []struct {
+		desc   string
+		sv     SplitView
+		cpFunc ConsistencyProofFunc
+	}{
+		{
+			desc: "same checkpoint",
+			sv:   New(mustSignCP(t, good, 5), mustSignCP(t, good, 5), nil),
+		}, {
+			desc:   "consistent",
+			sv:     New(mustSignCP(t, good, 5), mustSignCP(t, good, 8), p),
+			cpFunc: cpFunc,
+		}, {
+			desc:   "consistent with bogus proof",
+			sv:     New(mustSignCP(t, good, 5), mustSignCP(t, good, 8), bogus),
+			cpFunc: cpFunc,
+		}, {
+			desc: "different sizes without log",
+			sv:   New(mustSignCP(t, good, 5), mustSignCP(t, evil, 8), bogus),
+		}, {
+			desc:   "log unavailable",
+			sv:     New(mustSignCP(t, good, 5), mustSignCP(t, evil, 8), bogus),
+			cpFunc: func(from, to uint64) ([][]byte, error) { return nil, errors.New("unavailable") },
+		}, {
+			desc: "unsigned",
+			sv:   SplitView{Older: []byte("garbage"), Newer: mustSignCP(t, good, 5).Envelope},
+		}, {
+			desc: "misordered",
+			sv:   SplitView{Older: mustSignCP(t, good, 8).Envelope, Newer: mustSignCP(t, good, 5).Envelope},
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			if err := test.sv.Verify(lv, test.cpFunc); err == nil {
+				t.Error("Verify() succeeded for invalid evidence")
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evidence
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+const fileSuffix = ".json"
+
+// nameRE matches the names of evidence files in a Store.
+var nameRE = regexp.MustCompile("^[0-9a-f]{64}" + regexp.QuoteMeta(fileSuffix) + "$")
+
+// Store keeps evidence as files in a directory, so that it can be copied and shared easily.
+type Store struct {
+	dir string
+}
+
+// NewStore returns a Store which keeps evidence in dir, creating it if needed.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create evidence directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Add writes the evidence to the store and returns the name of its file.
+// Evidence for the same pair of checkpoints is only stored once.
+func (s *Store) Add(sv SplitView) (string, error) {
+	h := sha256.New()
+	for _, cp := range [][]byte{sv.Older, sv.Newer} {
+		hh := sha256.Sum256(cp)
+		h.Write(hh[:])
+	}
+	name := hex.EncodeToString(h.Sum(nil)) + fileSuffix
+	p := filepath.Join(s.dir, name)
+	if _, err := os.Stat(p); err == nil {
+		return name, nil
+	}
+	bs, err := json.MarshalIndent(sv, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal evidence: %w", err)
+	}
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		// Remove is a no-op failure once the file has been renamed into place.
+		_ = os.Remove(f.Name())
+	}()
+	if _, err := f.Write(bs); err != nil {
+		_ = f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(f.Name(), p); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// List returns the names of all of the evidence in the store.
+func (s *Store) List() ([]string, error) {
+	es, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, e := range es {
+		if nameRE.MatchString(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Get returns the evidence with the given name.
+func (s *Store) Get(name string) (*SplitView, error) {
+	if !nameRE.MatchString(name) {
+		return nil, fmt.Errorf("invalid evidence name %q: %w", name, os.ErrNotExist)
+	}
+	bs, err := os.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	var sv SplitView
+	if err := json.Unmarshal(bs, &sv); err != nil {
+		return nil, fmt.Errorf("failed to parse evidence %q: %w", name, err)
+	}
+	return &sv, nil
+}
+
+// Record stores the evidence and logs where it can be found.
+func (s *Store) Record(sv SplitView) {
+	glog.Errorf("Found split view of log between checkpoints:\n%s\nand\n%s", sv.Older, sv.Newer)
+	if s == nil {
+		return
+	}
+	name, err := s.Add(sv)
+	if err != nil {
+		glog.Errorf("Failed to store evidence: %v", err)
+		return
+	}
+	glog.Errorf("Evidence of split view stored in %s", filepath.Join(s.dir, name))
+}
+
+// Handler serves the evidence in the store. The root path lists the names of all of
+// the evidence as a JSON array, and each piece of evidence is served under its name.
+func Handler(s *Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if len(name) == 0 {
+			names, err := s.List()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("failed to list evidence: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(names); err != nil {
+				glog.Errorf("Failed to write evidence list: %v", err)
+			}
+			return
+		}
+		sv, err := s.Get(name)
+		if errors.Is(err, os.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(sv); err != nil {
+			glog.Errorf("Failed to write evidence: %v", err)
+		}
+	})
+}
@@ -0,0 +1,79 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evidence
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	good, evil := testLog()
+	st, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore(): %v", err)
+	}
+	sv := New(mustSignCP(t, good, 5), mustSignCP(t, evil, 5), nil)
+	name, err := st.Add(sv)
+	if err != nil {
+		t.Fatalf("Add(): %v", err)
+	}
+	// Adding the same evidence again doesn't create another file.
+	if again, err := st.Add(New(mustSignCP(t, good, 5), mustSignCP(t, evil, 5), nil)); err != nil || again != name {
+		t.Errorf("Add() again = %q, %v; want %q", again, err, name)
+	}
+
+	ts := httptest.NewServer(Handler(st))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	if err := json.NewDecoder(resp.Body).Decode(&names); err != nil {
+		t.Fatalf("failed to decode evidence list: %v", err)
+	}
+	resp.Body.Close()
+	if len(names) != 1 || names[0] != name {
+		t.Fatalf("got evidence list %q, want [%q]", names, name)
+	}
+
+	resp, err = http.Get(ts.URL + "/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got SplitView
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode evidence: %v", err)
+	}
+	resp.Body.Close()
+	if string(got.Older) != string(sv.Older) || string(got.Newer) != string(sv.Newer) {
+		t.Errorf("got evidence %+v, want %+v", got, sv)
+	}
+
+	for _, p := range []string{"/unknown.json", "/" + name[1:], "/sub/" + name} {
+		resp, err := http.Get(ts.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %q: got status %d, want %d", p, resp.StatusCode, http.StatusNotFound)
+		}
+	}
+}
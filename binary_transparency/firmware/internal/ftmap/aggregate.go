@@ -15,12 +15,15 @@
 package ftmap
 
 import (
+	"bytes"
 	"crypto/sha512"
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
 
 	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/golang/glog"
 
 	"github.com/google/trillian-examples/binary_transparency/firmware/api"
 	"github.com/google/trillian/experimental/batchmap"
@@ -30,7 +33,13 @@ import (
 
 func init() {
 	beam.RegisterFunction(aggregationFn)
+	beam.RegisterFunction(updateAggregationFn)
+	beam.RegisterFunction(aggregationIndexFn)
 	beam.RegisterFunction(annotationLogIndexFn)
+	beam.RegisterFunction(revocationLogIndexFn)
+	beam.RegisterFunction(buildLogIndexFn)
+	beam.RegisterFunction(sbomLogIndexFn)
+	beam.RegisterFunction(vulnerabilityLogIndexFn)
 	beam.RegisterFunction(logEntryIndexFn)
 	beam.RegisterType(reflect.TypeOf((*api.AggregatedFirmware)(nil)).Elem())
 	beam.RegisterType(reflect.TypeOf((*aggregatedFirmwareHashFn)(nil)).Elem())
@@ -41,37 +50,221 @@ func init() {
 // rules are:
 //   - AnnotationMalware: `Good` is true providing there are no malware annotations that claim the
 //     firmware is bad.
-func Aggregate(s beam.Scope, treeID int64, fws, annotationMalwares beam.PCollection) (beam.PCollection, beam.PCollection) {
+//   - Revocation: `Revoked` is true if there are any revocations for the firmware. Revocations
+//     with a FirmwareImageSHA512 that doesn't match the firmware at the log index are ignored.
+//   - Build: `Rebuilt` is true if there are any build annotations for the firmware, and
+//     `Reproducible` is true if at least one of them rebuilt the same image, and none rebuilt a
+//     different image.
+//   - SBOM: `SBOMs` lists the hashes of all SBOMs annotated for the firmware.
+//   - Vulnerability: `Vulnerabilities` lists the IDs of all vulnerabilities annotated for the firmware.
+func Aggregate(s beam.Scope, treeID int64, fws beam.PCollection, anns Annotations) (beam.PCollection, beam.PCollection) {
 	keyedFws := beam.ParDo(s, logEntryIndexFn, fws)
-	keyedAnns := beam.ParDo(s, annotationLogIndexFn, annotationMalwares)
-	annotations := beam.ParDo(s, aggregationFn, beam.CoGroupByKey(s, keyedFws, keyedAnns))
+	keyedAnns := beam.ParDo(s, annotationLogIndexFn, anns.Malware)
+	keyedRevs := beam.ParDo(s, revocationLogIndexFn, anns.Revocations)
+	keyedBuilds := beam.ParDo(s, buildLogIndexFn, anns.Builds)
+	keyedSBOMs := beam.ParDo(s, sbomLogIndexFn, anns.SBOMs)
+	keyedVulns := beam.ParDo(s, vulnerabilityLogIndexFn, anns.Vulnerabilities)
+	annotations := beam.ParDo(s, aggregationFn, beam.CoGroupByKey(s, keyedFws, keyedAnns, keyedRevs, keyedBuilds, keyedSBOMs, keyedVulns))
 	return beam.ParDo(s, &aggregatedFirmwareHashFn{treeID}, annotations), annotations
 }
 
+// UpdateAggregations applies the rules of Aggregate to the firmware and annotations logged since
+// the previous revision of the map, whose AggregatedFirmware are in lastAggs. Annotations may be
+// for firmware logged in any revision. The PCollections returned are as for Aggregate, except
+// that the entries are only for the aggregations which have changed. The second PCollection
+// contains every aggregation, so that the new revision is complete.
+func UpdateAggregations(s beam.Scope, treeID int64, lastAggs, fws beam.PCollection, anns Annotations) (beam.PCollection, beam.PCollection) {
+	keyedLast := beam.ParDo(s, aggregationIndexFn, lastAggs)
+	keyedFws := beam.ParDo(s, logEntryIndexFn, fws)
+	keyedAnns := beam.ParDo(s, annotationLogIndexFn, anns.Malware)
+	keyedRevs := beam.ParDo(s, revocationLogIndexFn, anns.Revocations)
+	keyedBuilds := beam.ParDo(s, buildLogIndexFn, anns.Builds)
+	keyedSBOMs := beam.ParDo(s, sbomLogIndexFn, anns.SBOMs)
+	keyedVulns := beam.ParDo(s, vulnerabilityLogIndexFn, anns.Vulnerabilities)
+	aggs, changed := beam.ParDo2(s, updateAggregationFn, beam.CoGroupByKey(s, keyedLast, keyedFws, keyedAnns, keyedRevs, keyedBuilds, keyedSBOMs, keyedVulns))
+	return beam.ParDo(s, &aggregatedFirmwareHashFn{treeID}, changed), aggs
+}
+
+// Annotations are the parsed annotations from the log, for aggregating with the firmware they annotate.
+type Annotations struct {
+	// Malware is a PCollection of *annotationMalwareLogEntry.
+	Malware beam.PCollection
+	// Revocations is a PCollection of *revocationLogEntry.
+	Revocations beam.PCollection
+	// Builds is a PCollection of *buildLogEntry.
+	Builds beam.PCollection
+	// SBOMs is a PCollection of *sbomLogEntry.
+	SBOMs beam.PCollection
+	// Vulnerabilities is a PCollection of *vulnerabilityLogEntry.
+	Vulnerabilities beam.PCollection
+}
+
+func aggregationIndexFn(a *api.AggregatedFirmware) (uint64, *api.AggregatedFirmware) {
+	return a.Index, a
+}
+
 func logEntryIndexFn(l *firmwareLogEntry) (uint64, *firmwareLogEntry) { return uint64(l.Index), l }
 
 func annotationLogIndexFn(a *annotationMalwareLogEntry) (uint64, *annotationMalwareLogEntry) {
 	return a.Annotation.FirmwareID.LogIndex, a
 }
 
-func aggregationFn(fwIndex uint64, fwit func(**firmwareLogEntry) bool, amit func(**annotationMalwareLogEntry) bool) (*api.AggregatedFirmware, error) {
+func revocationLogIndexFn(r *revocationLogEntry) (uint64, *revocationLogEntry) {
+	return r.Revocation.FirmwareID.LogIndex, r
+}
+
+func buildLogIndexFn(b *buildLogEntry) (uint64, *buildLogEntry) {
+	return b.Build.FirmwareID.LogIndex, b
+}
+
+func sbomLogIndexFn(b *sbomLogEntry) (uint64, *sbomLogEntry) {
+	return b.SBOM.FirmwareID.LogIndex, b
+}
+
+func vulnerabilityLogIndexFn(v *vulnerabilityLogEntry) (uint64, *vulnerabilityLogEntry) {
+	return v.Vulnerability.FirmwareID.LogIndex, v
+}
+
+func aggregationFn(fwIndex uint64, fwit func(**firmwareLogEntry) bool, amit func(**annotationMalwareLogEntry) bool, rvit func(**revocationLogEntry) bool,
+	bit func(**buildLogEntry) bool, sit func(**sbomLogEntry) bool, vit func(**vulnerabilityLogEntry) bool) (*api.AggregatedFirmware, error) {
 	// There will be exactly one firmware entry for the log index.
 	var fwle *firmwareLogEntry
 	if !fwit(&fwle) {
 		return nil, fmt.Errorf("aggregationFn for %d found no firmware", fwIndex)
 	}
 
-	// And 0-many annotations. The FW is good as long as no annotations say that it is not.
-	good := true
+	agg := &api.AggregatedFirmware{
+		Index:               fwIndex,
+		Good:                true,
+		FirmwareImageSHA512: fwle.Firmware.FirmwareImageSHA512,
+	}
+	annotate(agg, amit, rvit, bit, sit, vit)
+	return agg, nil
+}
+
+// updateAggregationFn outputs the aggregation for a firmware index to emitAll, and also to
+// emitChanged if it differs from the aggregation in the previous revision.
+func updateAggregationFn(fwIndex uint64, ait func(**api.AggregatedFirmware) bool, fwit func(**firmwareLogEntry) bool, amit func(**annotationMalwareLogEntry) bool,
+	rvit func(**revocationLogEntry) bool, bit func(**buildLogEntry) bool, sit func(**sbomLogEntry) bool, vit func(**vulnerabilityLogEntry) bool,
+	emitAll, emitChanged func(*api.AggregatedFirmware)) error {
+	// The firmware is either in the previous revision, or was logged since then, but not both.
+	var last *api.AggregatedFirmware
+	var fwle *firmwareLogEntry
+	hasLast, hasFW := ait(&last), fwit(&fwle)
+	var agg *api.AggregatedFirmware
+	switch {
+	case hasLast && hasFW:
+		return fmt.Errorf("updateAggregationFn for %d found firmware already in the previous revision", fwIndex)
+	case hasLast:
+		// Copy so that the element from the previous revision isn't modified.
+		a := *last
+		agg = &a
+	case hasFW:
+		agg = &api.AggregatedFirmware{
+			Index:               fwIndex,
+			Good:                true,
+			FirmwareImageSHA512: fwle.Firmware.FirmwareImageSHA512,
+		}
+	default:
+		return fmt.Errorf("updateAggregationFn for %d found no firmware", fwIndex)
+	}
+
+	changed := annotate(agg, amit, rvit, bit, sit, vit) || hasFW
+	emitAll(agg)
+	if changed {
+		emitChanged(agg)
+	}
+	return nil
+}
+
+// annotates returns whether the annotation at index names the image of the aggregated firmware.
+// The personality rejects annotations for the wrong image, but the map doesn't trust the log to
+// have done so, as otherwise an annotation could claim anything about an image that was never logged.
+func annotates(agg *api.AggregatedFirmware, kind string, index int64, id api.FirmwareID) bool {
+	if !bytes.Equal(id.FirmwareImageSHA512, agg.FirmwareImageSHA512) {
+		glog.Warningf("Ignoring %s at index %d: image hash %x doesn't match firmware at index %d", kind, index, id.FirmwareImageSHA512, agg.Index)
+		return false
+	}
+	return true
+}
+
+// annotate applies the annotations to the aggregation, and returns whether there were any.
+// Annotations which don't name the image of the firmware are ignored.
+func annotate(agg *api.AggregatedFirmware, amit func(**annotationMalwareLogEntry) bool, rvit func(**revocationLogEntry) bool,
+	bit func(**buildLogEntry) bool, sit func(**sbomLogEntry) bool, vit func(**vulnerabilityLogEntry) bool) bool {
+	found := false
+
+	// The FW is good as long as no annotations say that it is not.
 	var amle *annotationMalwareLogEntry
 	for amit(&amle) {
-		good = good && amle.Annotation.Good
+		if !annotates(agg, "malware annotation", amle.Index, amle.Annotation.FirmwareID) {
+			continue
+		}
+		found = true
+		agg.Good = agg.Good && amle.Annotation.Good
 	}
 
-	return &api.AggregatedFirmware{
-		Index: fwIndex,
-		Good:  good,
-	}, nil
+	// There is no way to unrevoke firmware, so a single revocation is enough.
+	var rvle *revocationLogEntry
+	for rvit(&rvle) {
+		if !annotates(agg, "revocation", rvle.Index, rvle.Revocation.FirmwareID) {
+			continue
+		}
+		found = true
+		agg.Revoked = true
+	}
+
+	// A single build that doesn't reproduce the image is enough to cast doubt on it.
+	// The rebuilt image is compared with the logged firmware, not the hash the build claims.
+	var ble *buildLogEntry
+	for bit(&ble) {
+		if !annotates(agg, "build", ble.Index, ble.Build.FirmwareID) {
+			continue
+		}
+		found = true
+		agg.Reproducible = (agg.Reproducible || !agg.Rebuilt) && bytes.Equal(ble.Build.RebuiltImageSHA512, agg.FirmwareImageSHA512)
+		agg.Rebuilt = true
+	}
+
+	// The SBOMs and vulnerabilities are sorted so that the value committed to by the map is
+	// independent of the order that the annotations were grouped in.
+	sboms := append([][]byte{}, agg.SBOMs...)
+	var sle *sbomLogEntry
+	for sit(&sle) {
+		if !annotates(agg, "SBOM", sle.Index, sle.SBOM.FirmwareID) {
+			continue
+		}
+		found = true
+		sboms = append(sboms, sle.SBOM.SBOMSHA512)
+	}
+	sort.Slice(sboms, func(i, j int) bool { return bytes.Compare(sboms[i], sboms[j]) < 0 })
+	var uniqueSBOMs [][]byte
+	for i, h := range sboms {
+		if i == 0 || !bytes.Equal(h, sboms[i-1]) {
+			uniqueSBOMs = append(uniqueSBOMs, h)
+		}
+	}
+	agg.SBOMs = uniqueSBOMs
+
+	vulns := append([]string{}, agg.Vulnerabilities...)
+	var vle *vulnerabilityLogEntry
+	for vit(&vle) {
+		if !annotates(agg, "vulnerability", vle.Index, vle.Vulnerability.FirmwareID) {
+			continue
+		}
+		found = true
+		vulns = append(vulns, vle.Vulnerability.ID)
+	}
+	sort.Strings(vulns)
+	var uniqueVulns []string
+	for i, id := range vulns {
+		if i == 0 || id != vulns[i-1] {
+			uniqueVulns = append(uniqueVulns, id)
+		}
+	}
+	agg.Vulnerabilities = uniqueVulns
+
+	return found
 }
 
 type aggregatedFirmwareHashFn struct {
@@ -27,6 +27,8 @@ import (
 
 func init() {
 	register.Function1x1(testAggregationToStringFn)
+	register.Function1x1(testRevokedToStringFn)
+	register.Function1x1(testAnnotationsToStringFn)
 }
 
 func TestMain(m *testing.M) {
@@ -39,25 +41,98 @@ func TestAggregate(t *testing.T) {
 		{Index: 1, Firmware: createFW("dummy", 401)},
 	}
 	logHead := int64(len(fwEntries) - 1)
-	createAnnotationMalware := func(fwIndex int, good bool) *annotationMalwareLogEntry {
+	createAnnotationMalwareWithHash := func(fwIndex int, hash []byte, good bool) *annotationMalwareLogEntry {
 		logHead++
 		return &annotationMalwareLogEntry{
 			Index: logHead,
 			Annotation: api.MalwareStatement{
 				FirmwareID: api.FirmwareID{
 					LogIndex:            uint64(fwIndex),
-					FirmwareImageSHA512: fwEntries[fwIndex].Firmware.FirmwareImageSHA512,
+					FirmwareImageSHA512: hash,
 				},
 				Good: good,
 			},
 		}
 	}
+	createAnnotationMalware := func(fwIndex int, good bool) *annotationMalwareLogEntry {
+		return createAnnotationMalwareWithHash(fwIndex, fwEntries[fwIndex].Firmware.FirmwareImageSHA512, good)
+	}
+	createRevocationWithHash := func(fwIndex int, hash []byte) *revocationLogEntry {
+		logHead++
+		return &revocationLogEntry{
+			Index: logHead,
+			Revocation: api.RevocationStatement{
+				FirmwareID: api.FirmwareID{
+					LogIndex:            uint64(fwIndex),
+					FirmwareImageSHA512: hash,
+				},
+			},
+		}
+	}
+	createRevocation := func(fwIndex int) *revocationLogEntry {
+		return createRevocationWithHash(fwIndex, fwEntries[fwIndex].Firmware.FirmwareImageSHA512)
+	}
+	createBuildWithHash := func(fwIndex int, hash, rebuilt []byte) *buildLogEntry {
+		logHead++
+		return &buildLogEntry{
+			Index: logHead,
+			Build: api.BuildStatement{
+				FirmwareID: api.FirmwareID{
+					LogIndex:            uint64(fwIndex),
+					FirmwareImageSHA512: hash,
+				},
+				RebuiltImageSHA512: rebuilt,
+			},
+		}
+	}
+	createBuild := func(fwIndex int, rebuilt []byte) *buildLogEntry {
+		return createBuildWithHash(fwIndex, fwEntries[fwIndex].Firmware.FirmwareImageSHA512, rebuilt)
+	}
+	createSBOMWithHash := func(fwIndex int, hash []byte, sbom string) *sbomLogEntry {
+		logHead++
+		return &sbomLogEntry{
+			Index: logHead,
+			SBOM: api.SBOMStatement{
+				FirmwareID: api.FirmwareID{
+					LogIndex:            uint64(fwIndex),
+					FirmwareImageSHA512: hash,
+				},
+				Format:     "SPDX-2.2",
+				SBOMSHA512: []byte(sbom),
+			},
+		}
+	}
+	createSBOM := func(fwIndex int, sbom string) *sbomLogEntry {
+		return createSBOMWithHash(fwIndex, fwEntries[fwIndex].Firmware.FirmwareImageSHA512, sbom)
+	}
+	createVulnerabilityWithHash := func(fwIndex int, hash []byte, id string) *vulnerabilityLogEntry {
+		logHead++
+		return &vulnerabilityLogEntry{
+			Index: logHead,
+			Vulnerability: api.VulnerabilityStatement{
+				FirmwareID: api.FirmwareID{
+					LogIndex:            uint64(fwIndex),
+					FirmwareImageSHA512: hash,
+				},
+				ID: id,
+			},
+		}
+	}
+	createVulnerability := func(fwIndex int, id string) *vulnerabilityLogEntry {
+		return createVulnerabilityWithHash(fwIndex, fwEntries[fwIndex].Firmware.FirmwareImageSHA512, id)
+	}
 	tests := []struct {
 		name               string
 		treeID             int64
 		annotationMalwares []*annotationMalwareLogEntry
+		revocations        []*revocationLogEntry
+		builds             []*buildLogEntry
+		sboms              []*sbomLogEntry
+		vulnerabilities    []*vulnerabilityLogEntry
 
-		wantGood []string
+		wantGood        []string
+		wantRevoked     []string
+		wantAnnotations []string
 	}{
 		{
 			name:   "No annotations",
@@ -98,6 +173,139 @@ func TestAggregate(t *testing.T) {
 
 			wantGood: []string{"0: false", "1: true"},
 		},
+		{
+			name:   "Revoked but good",
+			treeID: 12345,
+
+			revocations: []*revocationLogEntry{
+				createRevocation(1),
+			},
+
+			wantGood:    []string{"0: true", "1: true"},
+			wantRevoked: []string{"0: false", "1: true"},
+		},
+		{
+			name:   "Revoked many times and bad",
+			treeID: 12345,
+
+			annotationMalwares: []*annotationMalwareLogEntry{
+				createAnnotationMalware(0, false),
+			},
+			revocations: []*revocationLogEntry{
+				createRevocation(0),
+				createRevocation(0),
+			},
+
+			wantGood:    []string{"0: false", "1: true"},
+			wantRevoked: []string{"0: true", "1: false"},
+		},
+		{
+			name:   "Revocations with wrong image hash",
+			treeID: 12345,
+
+			revocations: []*revocationLogEntry{
+				createRevocationWithHash(0, fwEntries[1].Firmware.FirmwareImageSHA512),
+				createRevocationWithHash(1, []byte("not the firmware")),
+				createRevocationWithHash(1, nil),
+			},
+
+			wantGood:    []string{"0: true", "1: true"},
+			wantRevoked: []string{"0: false", "1: false"},
+		},
+		{
+			name:   "Revoked with one wrong image hash",
+			treeID: 12345,
+
+			revocations: []*revocationLogEntry{
+				createRevocationWithHash(1, fwEntries[0].Firmware.FirmwareImageSHA512),
+				createRevocation(1),
+			},
+
+			wantGood:    []string{"0: true", "1: true"},
+			wantRevoked: []string{"0: false", "1: true"},
+		},
+		{
+			name:   "Reproducible builds",
+			treeID: 12345,
+
+			builds: []*buildLogEntry{
+				createBuild(0, fwEntries[0].Firmware.FirmwareImageSHA512),
+				createBuild(0, fwEntries[0].Firmware.FirmwareImageSHA512),
+				createBuild(1, fwEntries[1].Firmware.FirmwareImageSHA512),
+				createBuild(1, []byte("a different image")),
+			},
+
+			wantGood:        []string{"0: true", "1: true"},
+			wantAnnotations: []string{"0: reproducible true, sboms [], vulnerabilities []", "1: reproducible false, sboms [], vulnerabilities []"},
+		},
+		{
+			name:   "SBOMs and vulnerabilities",
+			treeID: 12345,
+
+			sboms: []*sbomLogEntry{
+				createSBOM(0, "sbom b"),
+				createSBOM(0, "sbom a"),
+				createSBOM(0, "sbom b"),
+			},
+			vulnerabilities: []*vulnerabilityLogEntry{
+				createVulnerability(1, "CVE-2021-0002"),
+				createVulnerability(1, "CVE-2021-0001"),
+				createVulnerability(1, "CVE-2021-0002"),
+			},
+
+			wantGood:        []string{"0: true", "1: true"},
+			wantAnnotations: []string{"0: reproducible false, sboms [sbom a sbom b], vulnerabilities []", "1: reproducible false, sboms [], vulnerabilities [CVE-2021-0001 CVE-2021-0002]"},
+		},
+		{
+			name:   "Malware annotations with wrong image hash",
+			treeID: 12345,
+
+			annotationMalwares: []*annotationMalwareLogEntry{
+				createAnnotationMalwareWithHash(0, fwEntries[1].Firmware.FirmwareImageSHA512, false),
+				createAnnotationMalwareWithHash(1, nil, false),
+			},
+
+			wantGood: []string{"0: true", "1: true"},
+		},
+		{
+			name:   "Builds with wrong image hash",
+			treeID: 12345,
+
+			builds: []*buildLogEntry{
+				// Claiming the same arbitrary hash for the firmware and the rebuilt image doesn't make it reproducible.
+				createBuildWithHash(0, []byte("not the firmware"), []byte("not the firmware")),
+				createBuildWithHash(1, fwEntries[0].Firmware.FirmwareImageSHA512, fwEntries[0].Firmware.FirmwareImageSHA512),
+			},
+
+			wantGood: []string{"0: true", "1: true"},
+		},
+		{
+			name:   "Reproducible with one wrong image hash",
+			treeID: 12345,
+
+			builds: []*buildLogEntry{
+				createBuildWithHash(0, []byte("not the firmware"), []byte("a different image")),
+				createBuild(0, fwEntries[0].Firmware.FirmwareImageSHA512),
+			},
+
+			wantGood:        []string{"0: true", "1: true"},
+			wantAnnotations: []string{"0: reproducible true, sboms [], vulnerabilities []", "1: reproducible false, sboms [], vulnerabilities []"},
+		},
+		{
+			name:   "SBOMs and vulnerabilities with wrong image hash",
+			treeID: 12345,
+
+			sboms: []*sbomLogEntry{
+				createSBOMWithHash(0, fwEntries[1].Firmware.FirmwareImageSHA512, "sbom a"),
+				createSBOM(0, "sbom b"),
+			},
+			vulnerabilities: []*vulnerabilityLogEntry{
+				createVulnerabilityWithHash(1, []byte("not the firmware"), "CVE-2021-0001"),
+			},
+
+			wantGood:        []string{"0: true", "1: true"},
+			wantAnnotations: []string{"0: reproducible false, sboms [sbom b], vulnerabilities []", "1: reproducible false, sboms [], vulnerabilities []"},
+		},
 	}
 
 	for _, test := range tests {
@@ -106,14 +314,30 @@ func TestAggregate(t *testing.T) {
 			p, s := beam.NewPipelineWithRoot()
 
 			fws := beam.CreateList(s, fwEntries)
-			annotationMalwares := beam.CreateList(s, test.annotationMalwares)
+			anns := Annotations{
+				Malware:         beam.CreateList(s, test.annotationMalwares),
+				Revocations:     beam.CreateList(s, test.revocations),
+				Builds:          beam.CreateList(s, test.builds),
+				SBOMs:           beam.CreateList(s, test.sboms),
+				Vulnerabilities: beam.CreateList(s, test.vulnerabilities),
+			}
 
-			entries, aggs := Aggregate(s, test.treeID, fws, annotationMalwares)
+			entries, aggs := Aggregate(s, test.treeID, fws, anns)
 
 			passert.Count(s, entries, "entries", len(fwEntries))
 			passert.Count(s, aggs, "aggs", len(fwEntries))
 
 			passert.Equals(s, beam.ParDo(s, testAggregationToStringFn, aggs), beam.CreateList(s, test.wantGood))
+			wantRevoked := test.wantRevoked
+			if wantRevoked == nil {
+				wantRevoked = []string{"0: false", "1: false"}
+			}
+			passert.Equals(s, beam.ParDo(s, testRevokedToStringFn, aggs), beam.CreateList(s, wantRevoked))
+			wantAnnotations := test.wantAnnotations
+			if wantAnnotations == nil {
+				wantAnnotations = []string{"0: reproducible false, sboms [], vulnerabilities []", "1: reproducible false, sboms [], vulnerabilities []"}
+			}
+			passert.Equals(s, beam.ParDo(s, testAnnotationsToStringFn, aggs), beam.CreateList(s, wantAnnotations))
 
 			err := ptest.Run(p)
 			if err != nil {
@@ -126,3 +350,11 @@ func TestAggregate(t *testing.T) {
 func testAggregationToStringFn(a *api.AggregatedFirmware) string {
 	return fmt.Sprintf("%d: %t", a.Index, a.Good)
 }
+
+func testRevokedToStringFn(a *api.AggregatedFirmware) string {
+	return fmt.Sprintf("%d: %t", a.Index, a.Revoked)
+}
+
+func testAnnotationsToStringFn(a *api.AggregatedFirmware) string {
+	return fmt.Sprintf("%d: reproducible %t, sboms %s, vulnerabilities %v", a.Index, a.Reproducible, a.SBOMs, a.Vulnerabilities)
+}
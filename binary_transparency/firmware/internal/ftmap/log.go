@@ -16,9 +16,6 @@ package ftmap
 
 import (
 	"crypto"
-	"crypto/sha256"
-	"encoding/binary"
-	"fmt"
 	"reflect"
 	"sort"
 
@@ -28,12 +25,13 @@ import (
 	"github.com/google/trillian/experimental/batchmap"
 	"github.com/google/trillian/merkle/coniks"
 	"github.com/google/trillian/merkle/smt/node"
-	"github.com/transparency-dev/merkle/compact"
 )
 
 func init() {
 	beam.RegisterFunction(logEntryDeviceIDFn)
 	beam.RegisterFunction(makeDeviceReleaseLogFn)
+	beam.RegisterFunction(updateDeviceReleaseLogFn)
+	beam.RegisterFunction(deviceReleaseLogIDFn)
 	beam.RegisterType(reflect.TypeOf((*moduleLogHashFn)(nil)).Elem())
 	beam.RegisterType(reflect.TypeOf((*api.DeviceReleaseLog)(nil)).Elem())
 }
@@ -50,35 +48,33 @@ func MakeReleaseLogs(s beam.Scope, treeID int64, logEntries beam.PCollection) (b
 	return beam.ParDo(s, &moduleLogHashFn{TreeID: treeID}, logs), logs
 }
 
+// UpdateReleaseLogs appends the firmware logged since the previous revision of the map to
+// the release logs from that revision, which are in lastLogs. The PCollections returned are
+// as for MakeReleaseLogs, except that the entries are only for the logs which have changed.
+// The second PCollection contains every log, so that the new revision is complete.
+func UpdateReleaseLogs(s beam.Scope, treeID int64, lastLogs, logEntries beam.PCollection) (beam.PCollection, beam.PCollection) {
+	keyedLast := beam.ParDo(s, deviceReleaseLogIDFn, lastLogs)
+	keyed := beam.ParDo(s, logEntryDeviceIDFn, logEntries)
+	logs, changed := beam.ParDo2(s, updateDeviceReleaseLogFn, beam.CoGroupByKey(s, keyedLast, keyed))
+	return beam.ParDo(s, &moduleLogHashFn{TreeID: treeID}, changed), logs
+}
+
+func deviceReleaseLogIDFn(l *api.DeviceReleaseLog) (string, *api.DeviceReleaseLog) {
+	return l.DeviceID, l
+}
+
 func logEntryDeviceIDFn(l *firmwareLogEntry) (string, *firmwareLogEntry) {
 	return l.Firmware.DeviceID, l
 }
 
 type moduleLogHashFn struct {
 	TreeID int64
-
-	rf *compact.RangeFactory
-}
-
-func (fn *moduleLogHashFn) Setup() {
-	fn.rf = &compact.RangeFactory{
-		Hash: NodeHash,
-	}
 }
 
 func (fn *moduleLogHashFn) ProcessElement(log *api.DeviceReleaseLog) (*batchmap.Entry, error) {
-	logRange := fn.rf.NewEmptyRange(0)
-	for _, v := range log.Revisions {
-		bs := make([]byte, 8)
-		binary.LittleEndian.PutUint64(bs, v)
-		h := RecordHash(bs)
-		if err := logRange.Append(h[:], nil); err != nil {
-			return nil, err
-		}
-	}
-	logRoot, err := logRange.GetRootHash(nil)
+	logRoot, err := log.LogRoot()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create log for %q: %v", log.DeviceID, err)
+		return nil, err
 	}
 	h := crypto.SHA512_256.New()
 	h.Write([]byte(log.DeviceID))
@@ -92,6 +88,34 @@ func (fn *moduleLogHashFn) ProcessElement(log *api.DeviceReleaseLog) (*batchmap.
 }
 
 func makeDeviceReleaseLogFn(deviceID string, lit func(**firmwareLogEntry) bool) (*api.DeviceReleaseLog, error) {
+	return &api.DeviceReleaseLog{
+		DeviceID:  deviceID,
+		Revisions: sortedRevisions(lit),
+	}, nil
+}
+
+// updateDeviceReleaseLogFn outputs the release log for a device to emitAll, and also to
+// emitChanged if any firmware has been logged for it since the previous revision.
+func updateDeviceReleaseLogFn(deviceID string, lastit func(**api.DeviceReleaseLog) bool, lit func(**firmwareLogEntry) bool, emitAll, emitChanged func(*api.DeviceReleaseLog)) {
+	var revisions []uint64
+	var last *api.DeviceReleaseLog
+	if lastit(&last) {
+		revisions = append(revisions, last.Revisions...)
+	}
+	// The new entries are all later in the log than those in the previous revision.
+	added := sortedRevisions(lit)
+	l := &api.DeviceReleaseLog{
+		DeviceID:  deviceID,
+		Revisions: append(revisions, added...),
+	}
+	emitAll(l)
+	if len(added) > 0 {
+		emitChanged(l)
+	}
+}
+
+// sortedRevisions returns the firmware revisions of the entries, ordered by their index in the log.
+func sortedRevisions(lit func(**firmwareLogEntry) bool) []uint64 {
 	// We need to ensure ordering by sequence ID in the original log for stability.
 
 	// First consume the iterator into an in-memory list.
@@ -108,31 +132,5 @@ func makeDeviceReleaseLogFn(deviceID string, lit func(**firmwareLogEntry) bool)
 	for i := range entries {
 		revisions[i] = entries[i].Firmware.FirmwareRevision
 	}
-
-	return &api.DeviceReleaseLog{
-		DeviceID:  deviceID,
-		Revisions: revisions,
-	}, nil
-}
-
-var zeroPrefix = []byte{0x00}
-var onePrefix = []byte{0x01}
-
-// RecordHash returns the content hash for the given record data.
-func RecordHash(data []byte) []byte {
-	// SHA256(0x00 || data)
-	h := sha256.New()
-	h.Write(zeroPrefix)
-	h.Write(data)
-	return h.Sum(nil)
-}
-
-// NodeHash returns the hash for an interior tree node with the given left and right hashes.
-func NodeHash(left, right []byte) []byte {
-	// SHA256(0x01 || left || right)
-	h := sha256.New()
-	h.Write(onePrefix)
-	h.Write(left)
-	h.Write(right)
-	return h.Sum(nil)
+	return revisions
 }
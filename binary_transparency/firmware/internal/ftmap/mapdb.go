@@ -49,7 +49,11 @@ func NewMapDB(location string) (*MapDB, error) {
 
 // Init creates the database tables if needed.
 func (d *MapDB) Init() error {
-	if _, err := d.db.Exec("CREATE TABLE IF NOT EXISTS revisions (revision INTEGER PRIMARY KEY, datetime TIMESTAMP, logroot BLOB, count INTEGER)"); err != nil {
+	if _, err := d.db.Exec("CREATE TABLE IF NOT EXISTS revisions (revision INTEGER PRIMARY KEY, datetime TIMESTAMP, logroot BLOB, count INTEGER, checkpoint BLOB)"); err != nil {
+		return err
+	}
+	// Databases created before map checkpoints were signed need the column adding.
+	if err := d.addColumnIfMissing("revisions", "checkpoint", "BLOB"); err != nil {
 		return err
 	}
 	if _, err := d.db.Exec("CREATE TABLE IF NOT EXISTS tiles (revision INTEGER, path BLOB, tile BLOB, PRIMARY KEY (revision, path))"); err != nil {
@@ -58,24 +62,72 @@ func (d *MapDB) Init() error {
 	if _, err := d.db.Exec("CREATE TABLE IF NOT EXISTS logs (deviceID BLOB, revision INTEGER, leaves BLOB, PRIMARY KEY (deviceID, revision))"); err != nil {
 		return err
 	}
+	// The commits table is from before commits were keyed by their repository. It is only
+	// kept so that CheckUpdatable can refuse to update revisions which used it.
+	if _, err := d.db.Exec("CREATE TABLE IF NOT EXISTS commits (sourceCommit TEXT, revision INTEGER, fwLogIndices BLOB, PRIMARY KEY (sourceCommit, revision))"); err != nil {
+		return err
+	}
+	if _, err := d.db.Exec("CREATE TABLE IF NOT EXISTS sourceCommits (sourceRepository TEXT, sourceCommit TEXT, revision INTEGER, fwLogIndices BLOB, PRIMARY KEY (sourceRepository, sourceCommit, revision))"); err != nil {
+		return err
+	}
 	// We use an INTEGER for a boolean to make life easy across multiple DB implementations.
-	if _, err := d.db.Exec("CREATE TABLE IF NOT EXISTS aggregations (fwLogIndex INTEGER, revision INTEGER, good INTEGER, PRIMARY KEY (fwLogIndex, revision))"); err != nil {
+	if _, err := d.db.Exec("CREATE TABLE IF NOT EXISTS aggregations (fwLogIndex INTEGER, revision INTEGER, good INTEGER, revoked INTEGER DEFAULT 0, reproducible INTEGER DEFAULT 0, sboms BLOB, vulnerabilities BLOB, rebuilt INTEGER, firmwareSHA512 BLOB, PRIMARY KEY (fwLogIndex, revision))"); err != nil {
 		return err
 	}
+	// Databases created before revocations and the richer annotations were supported need the columns adding.
+	for _, c := range []struct{ name, decl string }{
+		{"revoked", "INTEGER DEFAULT 0"},
+		{"reproducible", "INTEGER DEFAULT 0"},
+		{"sboms", "BLOB"},
+		{"vulnerabilities", "BLOB"},
+		// These are left NULL for existing rows, which can't be updated incrementally; see CheckUpdatable.
+		{"rebuilt", "INTEGER"},
+		{"firmwareSHA512", "BLOB"},
+	} {
+		if err := d.addColumnIfMissing("aggregations", c.name, c.decl); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// addColumnIfMissing adds the column to the table if it isn't already present.
+func (d *MapDB) addColumnIfMissing(table, column, decl string) error {
+	rows, err := d.db.Query(fmt.Sprintf("SELECT * FROM %s LIMIT 0", table))
+	if err != nil {
+		return err
+	}
+	cols, err := rows.Columns()
+	if cerr := rows.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	for _, c := range cols {
+		if c == column {
+			return nil
+		}
+	}
+	_, err = d.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl))
+	return err
+}
+
 // NextWriteRevision gets the revision that the next generation of the map should be written at.
+// This is after any revision with rows in any table, so that a write which failed part way
+// through doesn't collide with the next one.
 func (d *MapDB) NextWriteRevision() (int, error) {
-	var rev sql.NullInt32
-	// TODO(mhutchinson): This should be updated to include the max of "tiles" or "logs".
-	if err := d.db.QueryRow("SELECT MAX(revision) FROM tiles").Scan(&rev); err != nil {
-		return 0, fmt.Errorf("failed to get max revision: %v", err)
-	}
-	if rev.Valid {
-		return int(rev.Int32) + 1, nil
+	next := 0
+	for _, table := range []string{"revisions", "tiles", "logs", "commits", "sourceCommits", "aggregations"} {
+		var rev sql.NullInt32
+		if err := d.db.QueryRow(fmt.Sprintf("SELECT MAX(revision) FROM %s", table)).Scan(&rev); err != nil {
+			return 0, fmt.Errorf("failed to get max revision from %s: %v", table, err)
+		}
+		if rev.Valid && int(rev.Int32) >= next {
+			next = int(rev.Int32) + 1
+		}
 	}
-	return 0, nil
+	return next, nil
 }
 
 // LatestRevision gets the metadata for the last completed write.
@@ -83,7 +135,7 @@ func (d *MapDB) LatestRevision() (rev int, logroot types.LogRootV1, count int64,
 	var sqlRev sql.NullInt32
 	var lcpRaw []byte
 	if err := d.db.QueryRow("SELECT revision, logroot, count FROM revisions ORDER BY revision DESC LIMIT 1").Scan(&sqlRev, &lcpRaw, &count); err != nil {
-		return 0, types.LogRootV1{}, 0, fmt.Errorf("failed to get latest revision: %v", err)
+		return 0, types.LogRootV1{}, 0, fmt.Errorf("failed to get latest revision: %w", err)
 	}
 	if sqlRev.Valid {
 		if err := logroot.UnmarshalBinary(lcpRaw); err != nil {
@@ -94,6 +146,43 @@ func (d *MapDB) LatestRevision() (rev int, logroot types.LogRootV1, count int64,
 	return 0, types.LogRootV1{}, 0, NoRevisionsFound(errors.New("no revisions found"))
 }
 
+// CheckUpdatable returns an error if the given revision can't be used as the base for an
+// incremental build. This is the case for revisions written before the aggregations recorded
+// whether firmware had been rebuilt and the firmware image hash, as these are needed to apply
+// new build annotations and revocations.
+func (d *MapDB) CheckUpdatable(revision int) error {
+	var legacy int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM aggregations WHERE revision=? AND (rebuilt IS NULL OR firmwareSHA512 IS NULL)", revision).Scan(&legacy); err != nil {
+		return fmt.Errorf("failed to query aggregations: %v", err)
+	}
+	if legacy > 0 {
+		return fmt.Errorf("revision %d has %d aggregations without build state or image hash; the map must be built from scratch", revision, legacy)
+	}
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM commits WHERE revision=?", revision).Scan(&legacy); err != nil {
+		return fmt.Errorf("failed to query commits: %v", err)
+	}
+	if legacy > 0 {
+		return fmt.Errorf("revision %d has %d commits without a repository; the map must be built from scratch", revision, legacy)
+	}
+	return nil
+}
+
+// LatestCheckpoint gets the signed map checkpoint for the last completed write.
+func (d *MapDB) LatestCheckpoint() ([]byte, error) {
+	var rev int
+	var cp []byte
+	if err := d.db.QueryRow("SELECT revision, checkpoint FROM revisions ORDER BY revision DESC LIMIT 1").Scan(&rev, &cp); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, NoRevisionsFound(errors.New("no revisions found"))
+		}
+		return nil, fmt.Errorf("failed to get latest checkpoint: %v", err)
+	}
+	if len(cp) == 0 {
+		return nil, fmt.Errorf("latest revision %d has no signed checkpoint", rev)
+	}
+	return cp, nil
+}
+
 // Tile gets the tile at the given path in the given revision of the map.
 func (d *MapDB) Tile(revision int, path []byte) (*batchmap.Tile, error) {
 	var bs []byte
@@ -109,37 +198,96 @@ func (d *MapDB) Tile(revision int, path []byte) (*batchmap.Tile, error) {
 
 // Aggregation gets the aggregation for the firmware at the given log index.
 func (d *MapDB) Aggregation(revision int, fwLogIndex uint64) (api.AggregatedFirmware, error) {
-	var good int
-	if err := d.db.QueryRow("SELECT good FROM aggregations WHERE fwLogIndex=? AND revision=?", fwLogIndex, revision).Scan(&good); err != nil {
+	var good, revoked, reproducible int
+	// Rebuilt is NULL for aggregations written before it was recorded, which committed to it being false.
+	var rebuilt sql.NullInt64
+	var sboms, vulns []byte
+	if err := d.db.QueryRow("SELECT good, revoked, rebuilt, reproducible, sboms, vulnerabilities FROM aggregations WHERE fwLogIndex=? AND revision=?", fwLogIndex, revision).Scan(&good, &revoked, &rebuilt, &reproducible, &sboms, &vulns); err != nil {
 		return api.AggregatedFirmware{}, err
 	}
-	return api.AggregatedFirmware{
-		Index: fwLogIndex,
-		Good:  good > 0,
-	}, nil
+	agg := api.AggregatedFirmware{
+		Index:        fwLogIndex,
+		Good:         good > 0,
+		Revoked:      revoked > 0,
+		Rebuilt:      rebuilt.Valid && rebuilt.Int64 > 0,
+		Reproducible: reproducible > 0,
+	}
+	if len(sboms) > 0 {
+		if err := json.Unmarshal(sboms, &agg.SBOMs); err != nil {
+			return api.AggregatedFirmware{}, fmt.Errorf("failed to parse SBOMs for firmware %d at revision=%d: %v", fwLogIndex, revision, err)
+		}
+	}
+	if len(vulns) > 0 {
+		if err := json.Unmarshal(vulns, &agg.Vulnerabilities); err != nil {
+			return api.AggregatedFirmware{}, fmt.Errorf("failed to parse vulnerabilities for firmware %d at revision=%d: %v", fwLogIndex, revision, err)
+		}
+	}
+	return agg, nil
+}
+
+// CommitFirmware gets the firmware built from the given source commit in the given repository,
+// in the given revision of the map.
+func (d *MapDB) CommitFirmware(revision int, repository, commit string) (api.CommitFirmware, error) {
+	var bs []byte
+	if err := d.db.QueryRow("SELECT fwLogIndices FROM sourceCommits WHERE sourceRepository=? AND sourceCommit=? AND revision=?", repository, commit, revision).Scan(&bs); err != nil {
+		return api.CommitFirmware{}, err
+	}
+	cf := api.CommitFirmware{Repository: repository, Commit: commit}
+	if err := json.Unmarshal(bs, &cf.FirmwareLogIndices); err != nil {
+		return api.CommitFirmware{}, fmt.Errorf("failed to parse firmware for commit %q at revision=%d: %v", commit, revision, err)
+	}
+	return cf, nil
 }
 
 // WriteRevision writes the metadata for a completed run into the database.
 // If this method isn't called then the tiles may be written but this revision will be
 // skipped by sensible readers because the provenance information isn't available.
-func (d *MapDB) WriteRevision(rev int, logCheckpoint []byte, count int64) error {
+// mapCheckpoint is the signed api.MapCheckpoint committing to this revision.
+func (d *MapDB) WriteRevision(rev int, logCheckpoint []byte, count int64, mapCheckpoint []byte) error {
 	now := time.Now()
-	_, err := d.db.Exec("INSERT INTO revisions (revision, datetime, logroot, count) VALUES (?, ?, ?, ?)", rev, now, logCheckpoint, count)
+	_, err := d.db.Exec("INSERT INTO revisions (revision, datetime, logroot, count, checkpoint) VALUES (?, ?, ?, ?, ?)", rev, now, logCheckpoint, count, mapCheckpoint)
 	if err != nil {
 		return fmt.Errorf("failed to write revision: %w", err)
 	}
 	return nil
 }
 
-// Versions gets the log of versions for the given module in the given map revision.
-func (d *MapDB) Versions(revision int, module string) ([]string, error) {
+// PruneRevisions deletes all but the latest `keep` completed revisions of the map,
+// along with any incomplete revisions older than these.
+func (d *MapDB) PruneRevisions(keep int) error {
+	if keep < 1 {
+		return fmt.Errorf("must keep at least 1 revision, got %d", keep)
+	}
+	var oldest int
+	if err := d.db.QueryRow("SELECT revision FROM revisions ORDER BY revision DESC LIMIT 1 OFFSET ?", keep-1).Scan(&oldest); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// There are no more than `keep` revisions, so there is nothing to prune.
+			return nil
+		}
+		return fmt.Errorf("failed to find oldest revision to keep: %v", err)
+	}
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	for _, table := range []string{"tiles", "logs", "aggregations", "commits", "sourceCommits", "revisions"} {
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE revision < ?", table), oldest); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("failed to prune %s: %v", table, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// DeviceReleaseLog gets the log of firmware revisions for the given device in the given map revision.
+func (d *MapDB) DeviceReleaseLog(revision int, deviceID string) (api.DeviceReleaseLog, error) {
 	var bs []byte
-	if err := d.db.QueryRow("SELECT leaves FROM logs WHERE revision=? AND module=?", revision, module).Scan(&bs); err != nil {
-		return nil, err
+	if err := d.db.QueryRow("SELECT leaves FROM logs WHERE revision=? AND deviceID=?", revision, deviceID).Scan(&bs); err != nil {
+		return api.DeviceReleaseLog{}, err
 	}
-	var versions []string
-	if err := json.Unmarshal(bs, &versions); err != nil {
-		return nil, fmt.Errorf("failed to parse tile at revision=%d, path=%x: %v", revision, module, err)
+	l := api.DeviceReleaseLog{DeviceID: deviceID}
+	if err := json.Unmarshal(bs, &l.Revisions); err != nil {
+		return api.DeviceReleaseLog{}, fmt.Errorf("failed to parse log for device %q at revision=%d: %v", deviceID, revision, err)
 	}
-	return versions, nil
+	return l, nil
 }
@@ -0,0 +1,174 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftmap
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestPruneRevisions(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		keep    int
+		wantErr bool
+
+		wantRevisions []int
+		wantTiles     []int
+	}{
+		{
+			name:          "keep two",
+			keep:          2,
+			wantRevisions: []int{2, 3},
+			// Revision 4 is incomplete but newer than those kept, so may still be being written.
+			wantTiles: []int{2, 3, 4},
+		},
+		{
+			name:          "keep all",
+			keep:          10,
+			wantRevisions: []int{0, 1, 2, 3},
+			wantTiles:     []int{0, 1, 2, 3, 4},
+		},
+		{
+			name:    "keep none",
+			keep:    0,
+			wantErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			d, err := NewMapDB(filepath.Join(t.TempDir(), "map.db"))
+			if err != nil {
+				t.Fatalf("NewMapDB(): %v", err)
+			}
+			for rev := 0; rev < 5; rev++ {
+				if _, err := d.db.Exec("INSERT INTO tiles (revision, path, tile) VALUES (?, ?, ?)", rev, []byte{}, []byte("{}")); err != nil {
+					t.Fatalf("failed to write tile: %v", err)
+				}
+				// The last revision is left incomplete.
+				if rev < 4 {
+					if err := d.WriteRevision(rev, []byte("logroot"), int64(rev), []byte("checkpoint")); err != nil {
+						t.Fatalf("WriteRevision(): %v", err)
+					}
+				}
+			}
+
+			err = d.PruneRevisions(test.keep)
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("unexpected error: %v", err)
+			case err == nil && test.wantErr:
+				t.Fatal("expected error but got none")
+			case err != nil:
+				return
+			}
+
+			for _, table := range []struct {
+				name string
+				want []int
+			}{
+				{"revisions", test.wantRevisions},
+				{"tiles", test.wantTiles},
+			} {
+				rows, err := d.db.Query("SELECT revision FROM " + table.name + " ORDER BY revision")
+				if err != nil {
+					t.Fatalf("failed to query %s: %v", table.name, err)
+				}
+				var got []int
+				for rows.Next() {
+					var rev int
+					if err := rows.Scan(&rev); err != nil {
+						t.Fatalf("failed to scan %s: %v", table.name, err)
+					}
+					got = append(got, rev)
+				}
+				rows.Close()
+				if !reflect.DeepEqual(got, table.want) {
+					t.Errorf("%s revisions: got %v, want %v", table.name, got, table.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCommitFirmware(t *testing.T) {
+	d, err := NewMapDB(filepath.Join(t.TempDir(), "map.db"))
+	if err != nil {
+		t.Fatalf("NewMapDB(): %v", err)
+	}
+	commit := "0123456789abcdef0123456789abcdef01234567"
+	for _, r := range []struct {
+		repo    string
+		indices string
+	}{
+		{"https://github.com/example/firmware", "[1,4]"},
+		{"https://github.com/evil/firmware", "[7]"},
+	} {
+		if _, err := d.db.Exec("INSERT INTO sourceCommits (sourceRepository, sourceCommit, revision, fwLogIndices) VALUES (?, ?, ?, ?)", r.repo, commit, 3, []byte(r.indices)); err != nil {
+			t.Fatalf("failed to write commit: %v", err)
+		}
+	}
+
+	cf, err := d.CommitFirmware(3, "https://github.com/example/firmware", commit)
+	if err != nil {
+		t.Fatalf("CommitFirmware(): %v", err)
+	}
+	if got, want := cf.FirmwareLogIndices, []uint64{1, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got indices %v, want %v", got, want)
+	}
+	if _, err := d.CommitFirmware(3, "https://github.com/other/firmware", commit); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("CommitFirmware() for other repository got err %v, want %v", err, sql.ErrNoRows)
+	}
+}
+
+func TestCheckUpdatableLegacyCommits(t *testing.T) {
+	d, err := NewMapDB(filepath.Join(t.TempDir(), "map.db"))
+	if err != nil {
+		t.Fatalf("NewMapDB(): %v", err)
+	}
+	if err := d.CheckUpdatable(3); err != nil {
+		t.Fatalf("CheckUpdatable() for empty revision: %v", err)
+	}
+	if _, err := d.db.Exec("INSERT INTO commits (sourceCommit, revision, fwLogIndices) VALUES (?, ?, ?)", "0123456789abcdef0123456789abcdef01234567", 3, []byte("[1]")); err != nil {
+		t.Fatalf("failed to write commit: %v", err)
+	}
+	if err := d.CheckUpdatable(3); err == nil {
+		t.Error("CheckUpdatable() succeeded for revision with commits without a repository")
+	}
+}
+
+func TestNextWriteRevision(t *testing.T) {
+	d, err := NewMapDB(filepath.Join(t.TempDir(), "map.db"))
+	if err != nil {
+		t.Fatalf("NewMapDB(): %v", err)
+	}
+	if got, err := d.NextWriteRevision(); err != nil || got != 0 {
+		t.Fatalf("NextWriteRevision() for empty DB got (%d, %v), want (0, nil)", got, err)
+	}
+	if _, err := d.db.Exec("INSERT INTO tiles (revision, path, tile) VALUES (?, ?, ?)", 2, []byte{}, []byte("{}")); err != nil {
+		t.Fatalf("failed to write tile: %v", err)
+	}
+	// A failed write may have got further with other tables than with the tiles.
+	if _, err := d.db.Exec("INSERT INTO aggregations (fwLogIndex, revision, good) VALUES (?, ?, ?)", 0, 3, 1); err != nil {
+		t.Fatalf("failed to write aggregation: %v", err)
+	}
+	if got, err := d.NextWriteRevision(); err != nil || got != 4 {
+		t.Errorf("NextWriteRevision() got (%d, %v), want (4, nil)", got, err)
+	}
+}
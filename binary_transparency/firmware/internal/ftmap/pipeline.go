@@ -32,6 +32,10 @@ func init() {
 	beam.RegisterFunction(parseStatementFn)
 	beam.RegisterFunction(parseFirmwareFn)
 	beam.RegisterFunction(parseAnnotationMalwareFn)
+	beam.RegisterFunction(parseRevocationFn)
+	beam.RegisterFunction(parseBuildFn)
+	beam.RegisterFunction(parseSBOMFn)
+	beam.RegisterFunction(parseVulnerabilityFn)
 	beam.RegisterFunction(partitionFn)
 	beam.RegisterType(reflect.TypeOf((*InputLogMetadata)(nil)).Elem())
 	beam.RegisterType(reflect.TypeOf((*InputLogLeaf)(nil)).Elem())
@@ -39,6 +43,10 @@ func init() {
 	beam.RegisterType(reflect.TypeOf((*loggedStatement)(nil)).Elem())
 	beam.RegisterType(reflect.TypeOf((*firmwareLogEntry)(nil)).Elem())
 	beam.RegisterType(reflect.TypeOf((*annotationMalwareLogEntry)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*revocationLogEntry)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*buildLogEntry)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*sbomLogEntry)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*vulnerabilityLogEntry)(nil)).Elem())
 }
 
 // InputLog allows access to entries from the FT Log.
@@ -70,6 +78,7 @@ type PipelineResult struct {
 	MapTiles           beam.PCollection
 	DeviceLogs         beam.PCollection
 	AggregatedFirmware beam.PCollection
+	CommitFirmware     beam.PCollection
 	Metadata           InputLogMetadata
 }
 
@@ -101,28 +110,20 @@ func (b *MapBuilder) Create(s beam.Scope, size int64) (*PipelineResult, error) {
 		return nil, err
 	}
 
-	// Read the log as a collection of InputLogLeaf.
-	inputLeaves := b.source.Entries(s.Scope("source"), 0, endID)
-	// Parse these into their loggedStatements.
-	statements := beam.ParDo(s.Scope("parseStatement"), parseStatementFn, inputLeaves)
-
-	// Partition into:
-	// 0: FW Metadata
-	// 1: Annotation malware
-	// 2: Everything else
-	partitions := beam.Partition(s.Scope("partition"), MaxPartitions, partitionFn, statements)
-
-	fws := beam.ParDo(s, parseFirmwareFn, partitions[FirmwareMetaPartition])
-	ams := beam.ParDo(s, parseAnnotationMalwareFn, partitions[MalwareStatementPartition])
+	// Read the log as a collection of InputLogLeaf, and parse the firmware and annotations.
+	fws, anns := parseEntries(s, b.source.Entries(s.Scope("source"), 0, endID))
 
 	// Branch 1: create the logs of firmware releases.
 	logEntries, logs := MakeReleaseLogs(s.Scope("makeLogs"), b.treeID, fws)
 
 	// Branch 2: aggregate firmware releases with their annotations.
-	annotationEntries, aggregated := Aggregate(s, b.treeID, fws, ams)
+	annotationEntries, aggregated := Aggregate(s, b.treeID, fws, anns)
+
+	// Branch 3: index firmware releases by the source commit they were built from.
+	commitEntries, commits := IndexCommits(s.Scope("indexCommits"), b.treeID, fws)
 
 	// Flatten the entries together to create a single unified map.
-	entries := beam.Flatten(s, logEntries, annotationEntries)
+	entries := beam.Flatten(s, logEntries, annotationEntries, commitEntries)
 
 	glog.Infof("Creating new map revision from range [0, %d)", endID)
 	tiles, err = batchmap.Create(s, entries, b.treeID, crypto.SHA512_256, b.prefixStrata)
@@ -131,6 +132,7 @@ func (b *MapBuilder) Create(s beam.Scope, size int64) (*PipelineResult, error) {
 		MapTiles:           tiles,
 		DeviceLogs:         logs,
 		AggregatedFirmware: aggregated,
+		CommitFirmware:     commits,
 		Metadata: InputLogMetadata{
 			Checkpoint: golden,
 			Entries:    endID,
@@ -138,6 +140,74 @@ func (b *MapBuilder) Create(s beam.Scope, size int64) (*PipelineResult, error) {
 	}, err
 }
 
+// Update builds a map using the last revision built, and updating it to
+// include all the first `size` entries from the input log. Only the entries
+// after those in the last revision are read, and only the device logs,
+// aggregations and commits which these change are rehashed into the map.
+// If there aren't enough entries then it will fail.
+// The PipelineResult returned contains every value in the new revision, and
+// can be passed to a later call to Update.
+func (b *MapBuilder) Update(s beam.Scope, last *PipelineResult, size int64) (*PipelineResult, error) {
+	endID, golden, err := b.getLogEnd(size)
+	if err != nil {
+		return nil, err
+	}
+
+	startID := last.Metadata.Entries
+	if startID >= endID {
+		return nil, fmt.Errorf("startID (%d) >= endID (%d)", startID, endID)
+	}
+
+	fws, anns := parseEntries(s, b.source.Entries(s.Scope("source"), startID, endID))
+
+	// Annotations can be for firmware in any revision, so these are applied to the last aggregations.
+	logEntries, logs := UpdateReleaseLogs(s.Scope("updateLogs"), b.treeID, last.DeviceLogs, fws)
+	annotationEntries, aggregated := UpdateAggregations(s.Scope("updateAggregations"), b.treeID, last.AggregatedFirmware, fws, anns)
+	commitEntries, commits := UpdateCommits(s.Scope("updateCommits"), b.treeID, last.CommitFirmware, fws)
+
+	entries := beam.Flatten(s, logEntries, annotationEntries, commitEntries)
+
+	glog.Infof("Updating map revision with range [%d, %d)", startID, endID)
+	tiles, err := batchmap.Update(s, last.MapTiles, entries, b.treeID, crypto.SHA512_256, b.prefixStrata)
+
+	return &PipelineResult{
+		MapTiles:           tiles,
+		DeviceLogs:         logs,
+		AggregatedFirmware: aggregated,
+		CommitFirmware:     commits,
+		Metadata: InputLogMetadata{
+			Checkpoint: golden,
+			Entries:    endID,
+		},
+	}, err
+}
+
+// parseEntries parses the InputLogLeafs into a PCollection of firmwareLogEntry,
+// and the Annotations on firmware.
+func parseEntries(s beam.Scope, inputLeaves beam.PCollection) (beam.PCollection, Annotations) {
+	// Parse these into their loggedStatements.
+	statements := beam.ParDo(s.Scope("parseStatement"), parseStatementFn, inputLeaves)
+
+	// Partition into:
+	// 0: FW Metadata
+	// 1: Annotation malware
+	// 2: Revocations
+	// 3: Build annotations
+	// 4: SBOM annotations
+	// 5: Vulnerability annotations
+	// 6: Everything else
+	partitions := beam.Partition(s.Scope("partition"), MaxPartitions, partitionFn, statements)
+
+	fws := beam.ParDo(s, parseFirmwareFn, partitions[FirmwareMetaPartition])
+	return fws, Annotations{
+		Malware:         beam.ParDo(s, parseAnnotationMalwareFn, partitions[MalwareStatementPartition]),
+		Revocations:     beam.ParDo(s, parseRevocationFn, partitions[RevocationPartition]),
+		Builds:          beam.ParDo(s, parseBuildFn, partitions[BuildStatementPartition]),
+		SBOMs:           beam.ParDo(s, parseSBOMFn, partitions[SBOMStatementPartition]),
+		Vulnerabilities: beam.ParDo(s, parseVulnerabilityFn, partitions[VulnerabilityPartition]),
+	}
+}
+
 func (b *MapBuilder) getLogEnd(requiredEntries int64) (int64, []byte, error) {
 	golden, totalLeaves, err := b.source.Head()
 	if err != nil {
@@ -160,6 +230,14 @@ const (
 	FirmwareMetaPartition = iota
 	// MalwareStatementPartition is the partition index for partition containing MalwareStatement
 	MalwareStatementPartition
+	// RevocationPartition is the partition index for partition containing RevocationStatement
+	RevocationPartition
+	// BuildStatementPartition is the partition index for partition containing BuildStatement
+	BuildStatementPartition
+	// SBOMStatementPartition is the partition index for partition containing SBOMStatement
+	SBOMStatementPartition
+	// VulnerabilityPartition is the partition index for partition containing VulnerabilityStatement
+	VulnerabilityPartition
 	// UnclassifiedStatementPartition is the partition index for partition containing anything not classified above
 	UnclassifiedStatementPartition
 	// MaxPartitions is the total number of supported partitions. Add new partitions here.
@@ -173,6 +251,14 @@ func partitionFn(s *loggedStatement) int {
 		return FirmwareMetaPartition
 	case api.MalwareStatementType:
 		return MalwareStatementPartition
+	case api.RevocationStatementType:
+		return RevocationPartition
+	case api.BuildStatementType:
+		return BuildStatementPartition
+	case api.SBOMStatementType:
+		return SBOMStatementPartition
+	case api.VulnerabilityStatementType:
+		return VulnerabilityPartition
 	default:
 		return UnclassifiedStatementPartition
 	}
@@ -227,3 +313,67 @@ func parseAnnotationMalwareFn(s *loggedStatement) (*annotationMalwareLogEntry, e
 		Annotation: a,
 	}, nil
 }
+
+type revocationLogEntry struct {
+	Index      int64
+	Revocation api.RevocationStatement
+}
+
+func parseRevocationFn(s *loggedStatement) (*revocationLogEntry, error) {
+	var r api.RevocationStatement
+	if err := json.Unmarshal(s.Statement.Statement, &r); err != nil {
+		return nil, err
+	}
+	return &revocationLogEntry{
+		Index:      s.Index,
+		Revocation: r,
+	}, nil
+}
+
+type buildLogEntry struct {
+	Index int64
+	Build api.BuildStatement
+}
+
+func parseBuildFn(s *loggedStatement) (*buildLogEntry, error) {
+	var b api.BuildStatement
+	if err := json.Unmarshal(s.Statement.Statement, &b); err != nil {
+		return nil, err
+	}
+	return &buildLogEntry{
+		Index: s.Index,
+		Build: b,
+	}, nil
+}
+
+type sbomLogEntry struct {
+	Index int64
+	SBOM  api.SBOMStatement
+}
+
+func parseSBOMFn(s *loggedStatement) (*sbomLogEntry, error) {
+	var b api.SBOMStatement
+	if err := json.Unmarshal(s.Statement.Statement, &b); err != nil {
+		return nil, err
+	}
+	return &sbomLogEntry{
+		Index: s.Index,
+		SBOM:  b,
+	}, nil
+}
+
+type vulnerabilityLogEntry struct {
+	Index         int64
+	Vulnerability api.VulnerabilityStatement
+}
+
+func parseVulnerabilityFn(s *loggedStatement) (*vulnerabilityLogEntry, error) {
+	var v api.VulnerabilityStatement
+	if err := json.Unmarshal(s.Statement.Statement, &v); err != nil {
+		return nil, err
+	}
+	return &vulnerabilityLogEntry{
+		Index:         s.Index,
+		Vulnerability: v,
+	}, nil
+}
@@ -18,6 +18,7 @@ import (
 	"crypto/sha512"
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/apache/beam/sdks/v2/go/pkg/beam"
@@ -31,6 +32,7 @@ import (
 func init() {
 	register.Function1x1(testLogToStringFn)
 	register.Function1x1(testRootToStringFn)
+	register.Function1x1(testAggregatedFirmwareToStringFn)
 }
 
 func TestCreate(t *testing.T) {
@@ -91,11 +93,119 @@ func TestCreate(t *testing.T) {
 		})
 	}
 }
+func TestCreateAndUpdateEquivalence(t *testing.T) {
+	fw := func(index int) api.FirmwareID {
+		fws := []api.FirmwareMetadata{createFW("dummy", 1), createFW("dummy", 5)}
+		return api.FirmwareID{LogIndex: uint64(index), FirmwareImageSHA512: fws[index].FirmwareImageSHA512}
+	}
+	commit := "0123456789abcdef0123456789abcdef01234567"
+	inputLog := fakeLog{
+		leaves: []api.SignedStatement{
+			createFWWithCommitSignedStatement("dummy", 1, commit),
+			createFWSignedStatement("dummy", 5),
+			createSignedStatement(api.BuildStatementType, api.BuildStatement{FirmwareID: fw(0), RebuiltImageSHA512: fw(0).FirmwareImageSHA512}),
+			createFWWithCommitSignedStatement("fish", 42, commit),
+			createSignedStatement(api.MalwareStatementType, api.MalwareStatement{FirmwareID: fw(0), Good: false}),
+			createSignedStatement(api.BuildStatementType, api.BuildStatement{FirmwareID: fw(0), RebuiltImageSHA512: []byte("a different image")}),
+			createSignedStatement(api.VulnerabilityStatementType, api.VulnerabilityStatement{FirmwareID: fw(1), ID: "CVE-2021-0001"}),
+			createFWSignedStatement("dummy", 3),
+			createSignedStatement(api.BuildStatementType, api.BuildStatement{FirmwareID: fw(1), RebuiltImageSHA512: []byte("a different image")}),
+			createSignedStatement(api.BuildStatementType, api.BuildStatement{FirmwareID: fw(1), RebuiltImageSHA512: fw(1).FirmwareImageSHA512}),
+			// The first revocation names firmware 1 but has the hash of firmware 0, so is ignored.
+			createSignedStatement(api.RevocationStatementType, api.RevocationStatement{FirmwareID: api.FirmwareID{LogIndex: 1, FirmwareImageSHA512: fw(0).FirmwareImageSHA512}}),
+			createSignedStatement(api.RevocationStatementType, api.RevocationStatement{FirmwareID: fw(0)}),
+		},
+		head: []byte("this is just passed around"),
+	}
+	tests := []struct {
+		name string
+		// sizes are the sizes of the map after the Create and each Update.
+		sizes []int64
+	}{
+		{
+			name:  "Single update",
+			sizes: []int64{1, 12},
+		},
+		{
+			name:  "Annotations for older firmware",
+			sizes: []int64{4, 12},
+		},
+		{
+			name:  "Rebuilt in different revisions",
+			sizes: []int64{3, 6, 9, 11, 12},
+		},
+		{
+			name:  "Update every entry",
+			sizes: []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			mb := NewMapBuilder(inputLog, 12345, 1)
+			p, s := beam.NewPipelineWithRoot()
+
+			created, err := mb.Create(s, int64(len(inputLog.leaves)))
+			if err != nil {
+				t.Fatalf("failed to Create(): %v", err)
+			}
+			updated, err := mb.Create(s, test.sizes[0])
+			if err != nil {
+				t.Fatalf("failed to Create(): %v", err)
+			}
+			for _, size := range test.sizes[1:] {
+				if updated, err = mb.Update(s, updated, size); err != nil {
+					t.Fatalf("failed to Update(): %v", err)
+				}
+			}
+
+			if got, want := updated.Metadata, created.Metadata; !reflect.DeepEqual(got, want) {
+				t.Errorf("update metadata != create metadata (%v != %v)", got, want)
+			}
+			passert.Equals(s, beam.ParDo(s, testRootToStringFn, updated.MapTiles), beam.ParDo(s, testRootToStringFn, created.MapTiles))
+			passert.Equals(s, beam.ParDo(s, testLogToStringFn, updated.DeviceLogs), beam.CreateList(s, []string{"dummy: [1 5 3]", "fish: [42]"}))
+			passert.Equals(s, beam.ParDo(s, testAggregatedFirmwareToStringFn, updated.AggregatedFirmware), beam.CreateList(s, []string{
+				"0: good false, revoked true, rebuilt true, reproducible false, vulnerabilities []",
+				"1: good true, revoked false, rebuilt true, reproducible false, vulnerabilities [CVE-2021-0001]",
+				"3: good true, revoked false, rebuilt false, reproducible false, vulnerabilities []",
+				"7: good true, revoked false, rebuilt false, reproducible false, vulnerabilities []",
+			}))
+			passert.Equals(s, beam.ParDo(s, testCommitToStringFn, updated.CommitFirmware), fmt.Sprintf("https://example.com/firmware.git@%s: [0 3]", commit))
+
+			err = ptest.Run(p)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestUpdateNoNewEntries(t *testing.T) {
+	inputLog := fakeLog{
+		leaves: []api.SignedStatement{createFWSignedStatement("dummy", 1)},
+		head:   []byte("this is just passed around"),
+	}
+	mb := NewMapBuilder(inputLog, 12345, 0)
+	_, s := beam.NewPipelineWithRoot()
+
+	created, err := mb.Create(s, 1)
+	if err != nil {
+		t.Fatalf("failed to Create(): %v", err)
+	}
+	if _, err := mb.Update(s, created, 1); err == nil {
+		t.Error("expected error from Update() with no new entries")
+	}
+}
+
 func testRootToStringFn(t *batchmap.Tile) string { return fmt.Sprintf("%x", t.RootHash) }
 func testLogToStringFn(l *api.DeviceReleaseLog) string {
 	return fmt.Sprintf("%s: %v", l.DeviceID, l.Revisions)
 }
 
+func testAggregatedFirmwareToStringFn(a *api.AggregatedFirmware) string {
+	return fmt.Sprintf("%d: good %t, revoked %t, rebuilt %t, reproducible %t, vulnerabilities %v", a.Index, a.Good, a.Revoked, a.Rebuilt, a.Reproducible, a.Vulnerabilities)
+}
+
 func createFW(device string, revision uint64) api.FirmwareMetadata {
 	image := fmt.Sprintf("this image is the firmware at revision %d for device %s.", revision, device)
 	imageHash := sha512.Sum512([]byte(image))
@@ -114,6 +224,21 @@ func createFWSignedStatement(device string, revision uint64) api.SignedStatement
 	}
 }
 
+func createFWWithCommitSignedStatement(device string, revision uint64, commit string) api.SignedStatement {
+	fw := createFW(device, revision)
+	fw.SourceRepository = "https://example.com/firmware.git"
+	fw.SourceCommit = commit
+	return createSignedStatement(api.FirmwareMetadataType, fw)
+}
+
+func createSignedStatement(t api.StatementType, statement interface{}) api.SignedStatement {
+	bs, _ := json.Marshal(statement)
+	return api.SignedStatement{
+		Type:      t,
+		Statement: bs,
+	}
+}
+
 type fakeLog struct {
 	leaves []api.SignedStatement
 	head   []byte
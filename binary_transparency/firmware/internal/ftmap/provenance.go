@@ -0,0 +1,146 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftmap
+
+import (
+	"crypto/sha512"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian/experimental/batchmap"
+	"github.com/google/trillian/merkle/coniks"
+	"github.com/google/trillian/merkle/smt/node"
+)
+
+func init() {
+	beam.RegisterFunction(sourceCommitFn)
+	beam.RegisterFunction(makeCommitFirmwareFn)
+	beam.RegisterFunction(updateCommitFirmwareFn)
+	beam.RegisterFunction(commitFirmwareCommitFn)
+	beam.RegisterType(reflect.TypeOf((*commitFirmwareHashFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*api.CommitFirmware)(nil)).Elem())
+}
+
+// IndexCommits takes all firmwareLogEntrys and groups those which have a
+// SourceCommit in their provenance by their SourceRepository and SourceCommit, so
+// that clients can look up all of the firmware built from a commit. This method
+// returns two PCollections:
+// 1. the first is of type Entry; the key/value data to include in the map
+// 2. the second is of type CommitFirmware.
+func IndexCommits(s beam.Scope, treeID int64, fws beam.PCollection) (beam.PCollection, beam.PCollection) {
+	keyed := beam.ParDo(s, sourceCommitFn, fws)
+	commits := beam.ParDo(s, makeCommitFirmwareFn, beam.GroupByKey(s, keyed))
+	return beam.ParDo(s, &commitFirmwareHashFn{TreeID: treeID}, commits), commits
+}
+
+// UpdateCommits adds the firmware logged since the previous revision of the map to the
+// commit index from that revision, which is in lastCommits. The PCollections returned are
+// as for IndexCommits, except that the entries are only for the commits which have changed.
+// The second PCollection contains every commit, so that the new revision is complete.
+func UpdateCommits(s beam.Scope, treeID int64, lastCommits, fws beam.PCollection) (beam.PCollection, beam.PCollection) {
+	keyedLast := beam.ParDo(s, commitFirmwareCommitFn, lastCommits)
+	keyed := beam.ParDo(s, sourceCommitFn, fws)
+	commits, changed := beam.ParDo2(s, updateCommitFirmwareFn, beam.CoGroupByKey(s, keyedLast, keyed))
+	return beam.ParDo(s, &commitFirmwareHashFn{TreeID: treeID}, changed), commits
+}
+
+// commitKey returns the key which groups firmware built from the same commit, which
+// is split again by splitCommitKey. The commit is hex, so never contains the separator.
+func commitKey(repository, commit string) string {
+	return repository + "@" + commit
+}
+
+func splitCommitKey(key string) (repository, commit string) {
+	i := strings.LastIndex(key, "@")
+	return key[:i], key[i+1:]
+}
+
+func commitFirmwareCommitFn(cf *api.CommitFirmware) (string, *api.CommitFirmware) {
+	return commitKey(cf.Repository, cf.Commit), cf
+}
+
+func sourceCommitFn(l *firmwareLogEntry, emit func(string, uint64)) {
+	if len(l.Firmware.SourceCommit) > 0 {
+		emit(commitKey(l.Firmware.SourceRepository, l.Firmware.SourceCommit), uint64(l.Index))
+	}
+}
+
+func makeCommitFirmwareFn(key string, iit func(*uint64) bool) *api.CommitFirmware {
+	repository, commit := splitCommitKey(key)
+	return &api.CommitFirmware{
+		Repository:         repository,
+		Commit:             commit,
+		FirmwareLogIndices: sortedIndices(iit),
+	}
+}
+
+// updateCommitFirmwareFn outputs the firmware built from a commit to emitAll, and also to
+// emitChanged if any firmware built from it has been logged since the previous revision.
+func updateCommitFirmwareFn(key string, lastit func(**api.CommitFirmware) bool, iit func(*uint64) bool, emitAll, emitChanged func(*api.CommitFirmware)) {
+	var indices []uint64
+	var last *api.CommitFirmware
+	if lastit(&last) {
+		indices = append(indices, last.FirmwareLogIndices...)
+	}
+	// The new indices are all greater than those in the previous revision.
+	added := sortedIndices(iit)
+	repository, commit := splitCommitKey(key)
+	cf := &api.CommitFirmware{
+		Repository:         repository,
+		Commit:             commit,
+		FirmwareLogIndices: append(indices, added...),
+	}
+	emitAll(cf)
+	if len(added) > 0 {
+		emitChanged(cf)
+	}
+}
+
+// sortedIndices returns the indices in ascending order, so that the value in the map is deterministic.
+func sortedIndices(iit func(*uint64) bool) []uint64 {
+	indices := make([]uint64, 0)
+	var i uint64
+	for iit(&i) {
+		indices = append(indices, i)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	return indices
+}
+
+type commitFirmwareHashFn struct {
+	TreeID int64
+}
+
+func (fn *commitFirmwareHashFn) ProcessElement(cf *api.CommitFirmware) (*batchmap.Entry, error) {
+	// The prefix keeps these keys distinct from the firmware summaries and device logs.
+	key := []byte(api.CommitMapKey(cf.Repository, cf.Commit))
+	value, err := json.Marshal(cf)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling CommitFirmware failed: %v", err)
+	}
+
+	kbs := sha512.Sum512_256(key)
+	leafID := node.NewID(string(kbs[:]), 256)
+	return &batchmap.Entry{
+		HashKey:   kbs[:],
+		HashValue: coniks.Default.HashLeaf(fn.TreeID, leafID, value),
+	}, nil
+}
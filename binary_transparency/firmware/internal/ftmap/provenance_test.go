@@ -0,0 +1,106 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftmap
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/register"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/passert"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+)
+
+func init() {
+	register.Function1x1(testCommitToStringFn)
+}
+
+func TestIndexCommits(t *testing.T) {
+	commitA, commitB := strings.Repeat("a", 40), strings.Repeat("b", 40)
+	repo, otherRepo := "https://github.com/example/firmware", "https://github.com/evil/firmware"
+	createFWFromRepoCommit := func(index int64, revision uint64, repo, commit string) *firmwareLogEntry {
+		fw := createFW("dummy", revision)
+		if len(commit) > 0 {
+			fw.SourceRepository = repo
+			fw.SourceCommit = commit
+		}
+		return &firmwareLogEntry{Index: index, Firmware: fw}
+	}
+	createFWFromCommit := func(index int64, revision uint64, commit string) *firmwareLogEntry {
+		return createFWFromRepoCommit(index, revision, repo, commit)
+	}
+	tests := []struct {
+		name string
+		fws  []*firmwareLogEntry
+
+		wantCommits []string
+		wantEntries int
+	}{
+		{
+			name: "No provenance",
+			fws: []*firmwareLogEntry{
+				createFWFromCommit(0, 1, ""),
+				createFWFromCommit(1, 2, ""),
+			},
+			wantCommits: []string{},
+		},
+		{
+			name: "Several commits",
+			fws: []*firmwareLogEntry{
+				createFWFromCommit(0, 1, commitA),
+				createFWFromCommit(3, 3, commitB),
+				createFWFromCommit(2, 2, ""),
+				createFWFromCommit(1, 1, commitA),
+				createFWFromCommit(4, 4, commitB),
+				createFWFromCommit(5, 5, commitA),
+			},
+			wantCommits: []string{repo + "@" + commitA + ": [0 1 5]", repo + "@" + commitB + ": [3 4]"},
+			wantEntries: 2,
+		},
+		{
+			name: "Same commit in different repositories",
+			fws: []*firmwareLogEntry{
+				createFWFromCommit(0, 1, commitA),
+				createFWFromRepoCommit(1, 2, otherRepo, commitA),
+				createFWFromCommit(2, 3, commitA),
+			},
+			wantCommits: []string{repo + "@" + commitA + ": [0 2]", otherRepo + "@" + commitA + ": [1]"},
+			wantEntries: 2,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			p, s := beam.NewPipelineWithRoot()
+			fws := beam.CreateList(s, test.fws)
+
+			entries, commits := IndexCommits(s, 12345, fws)
+
+			passert.Equals(s, beam.ParDo(s, testCommitToStringFn, commits), beam.CreateList(s, test.wantCommits))
+			passert.Count(s, entries, "entries", test.wantEntries)
+			err := ptest.Run(p)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func testCommitToStringFn(c *api.CommitFirmware) string {
+	return fmt.Sprintf("%s@%s: %v", c.Repository, c.Commit, c.FirmwareLogIndices)
+}
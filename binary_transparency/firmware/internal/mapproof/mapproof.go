@@ -0,0 +1,117 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mapproof builds inclusion proofs for keys in the FT map from its tiles.
+package mapproof
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian/merkle/coniks"
+	"github.com/google/trillian/merkle/smt"
+	"github.com/google/trillian/merkle/smt/node"
+)
+
+// Build returns the proof for the key, computed from the tiles along its path, in order
+// from the root, so tiles[i] has the path key[:i]. There is one tile for each stratum,
+// unless the path to the key passes through an empty subtree, in which case the tiles
+// stop at the one containing the empty subtree. If the key is not in the map then the
+// proof is a non-inclusion proof, with a nil Value.
+func Build(key []byte, tiles []api.MapTile) (api.MapInclusionProof, error) {
+	if got, max := len(tiles), api.MapPrefixStrata+1; got == 0 || got > max {
+		return api.MapInclusionProof{}, fmt.Errorf("got %d tiles, want between 1 and %d", got, max)
+	}
+	if last := tiles[len(tiles)-1]; len(tiles) <= api.MapPrefixStrata {
+		for _, l := range last.Leaves {
+			if p := append(append([]byte{}, last.Path...), l.Path...); bytes.HasPrefix(key, p) {
+				return api.MapInclusionProof{}, fmt.Errorf("missing tile %x below tile %x", p, last.Path)
+			}
+		}
+	}
+	ipt := newInclusionProofTree(api.MapTreeID, coniks.Default, key)
+	for i := len(tiles) - 1; i >= 0; i-- {
+		tile := tiles[i]
+		if len(tile.Leaves) == 0 {
+			return api.MapInclusionProof{}, fmt.Errorf("tile %x has no leaves", tile.Path)
+		}
+		nodes := make([]smt.Node, len(tile.Leaves))
+		for j, l := range tile.Leaves {
+			nodes[j] = toNode(tile.Path, l)
+		}
+		hs, err := smt.NewHStar3(nodes, ipt.hasher.HashChildren,
+			uint(len(tile.Path)+len(tile.Leaves[0].Path))*8, uint(len(tile.Path))*8)
+		if err != nil {
+			return api.MapInclusionProof{}, fmt.Errorf("failed to create HStar3 for tile %x: %v", tile.Path, err)
+		}
+		res, err := hs.Update(ipt)
+		if err != nil {
+			return api.MapInclusionProof{}, fmt.Errorf("failed to hash tile %x: %v", tile.Path, err)
+		} else if got, want := len(res), 1; got != want {
+			return api.MapInclusionProof{}, fmt.Errorf("wrong number of roots for tile %x: got %v, want %v", tile.Path, got, want)
+		}
+	}
+	return *ipt.proof, nil
+}
+
+// toNode converts a MapTileLeaf into the equivalent Node for HStar3.
+func toNode(prefix []byte, l api.MapTileLeaf) smt.Node {
+	path := make([]byte, 0, len(prefix)+len(l.Path))
+	path = append(append(path, prefix...), l.Path...)
+	return smt.Node{
+		ID:   node.NewID(string(path), uint(len(path))*8),
+		Hash: l.Hash,
+	}
+}
+
+// inclusionProofTree is a NodeAccessor for an empty tree with the given ID.
+// As values are set on the tree, an inclusion proof is generated containing
+// the siblings computed.
+type inclusionProofTree struct {
+	treeID int64
+	hasher *coniks.Hasher
+	target node.ID
+	proof  *api.MapInclusionProof
+}
+
+func newInclusionProofTree(treeID int64, hasher *coniks.Hasher, target []byte) inclusionProofTree {
+	return inclusionProofTree{
+		treeID: treeID,
+		hasher: hasher,
+		target: node.NewID(string(target), 256),
+		proof: &api.MapInclusionProof{
+			Key:   target,
+			Proof: make([][]byte, 256),
+		},
+	}
+}
+
+func (e inclusionProofTree) Get(id node.ID) ([]byte, error) {
+	return e.hasher.HashEmpty(e.treeID, id), nil
+}
+
+func (e inclusionProofTree) Set(id node.ID, hash []byte) {
+	if id == e.target {
+		e.proof.Value = hash
+		glog.V(2).Infof("inclusionProofTree: set value for target: %x", hash)
+		return
+	}
+	stem := e.target.Prefix(id.BitLen())
+	if stem == id.Sibling() {
+		e.proof.Proof[id.BitLen()-1] = hash
+		glog.V(2).Infof("inclusionProofTree: set sibling at depth %d: %x", id.BitLen(), hash)
+	}
+}
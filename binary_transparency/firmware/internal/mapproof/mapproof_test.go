@@ -0,0 +1,178 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mapproof_test
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/mapproof"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/verify"
+	"github.com/google/trillian/merkle/coniks"
+	"github.com/google/trillian/merkle/smt/node"
+)
+
+const (
+	// rootTile and the leaf tiles below are from a map containing the aggregations for two pieces of firmware.
+	rootTile  = `{"Path":"","Leaves":[{"Path":"Rg==","Hash":"M7DmUN5R2auo88WMjg+EcijUzfX085QdHuTzx7Rrwgs="},{"Path":"7A==","Hash":"fK4jTvvbd90D29jYrsBrmWNG83416K1WhgS5T5qYpEI="}]}`
+	leafTile0 = `{"Path":"Rg==","Leaves":[{"Path":"IRCmyCYwSorPfJ/NXqlkZdVYvs4KKtRYuV27zLJjCg==","Hash":"sE0GFv87tf0j7YciRBVFk4pFKExwVRhwykxdPz70Dxw="}]}`
+	leafTile1 = `{"Path":"7A==","Leaves":[{"Path":"Kk19hKuMqnXxlJGG0LQ5y8LbzyPhmCCDMxRmPAowFw==","Hash":"+d6n+Cubqrkvx6vwQg0f2M3ZPub3a8jf/HICam0T3sM="},{"Path":"z+HuPYEme3qpfllqffSoL8jKc8VLtf3njh/nVoksCA==","Hash":"rxQDwfN/PhVD+lF2FtVkzUb9ha1G+4OHE7ZaIvSow9Y="}]}`
+	mapRoot   = "0f3d3f78f57ed66fed386538d96c92a47ce83ec3fdfd687b989d02a3f0bfa5a8"
+)
+
+func mustParseTile(t *testing.T, js string) api.MapTile {
+	t.Helper()
+	var tile api.MapTile
+	if err := json.Unmarshal([]byte(js), &tile); err != nil {
+		t.Fatal(err)
+	}
+	return tile
+}
+
+// singleLeafMap returns the tiles along the path to the key, and the root hash, for a
+// map containing only the value under the key.
+func singleLeafMap(key, value []byte) ([]api.MapTile, []byte) {
+	hasher := coniks.Default
+	id := node.NewID(string(key), 256)
+	// hashes holds the hash of the subtree containing the key at each depth.
+	hashes := make([][]byte, 257)
+	hashes[256] = hasher.HashLeaf(api.MapTreeID, id, value)
+	for depth := 256; depth > 0; depth-- {
+		stem := id.Prefix(uint(depth))
+		left, right := hashes[depth], hasher.HashEmpty(api.MapTreeID, stem.Sibling())
+		if last, bits := stem.LastByte(); last&(1<<(8-bits)) != 0 {
+			left, right = right, left
+		}
+		hashes[depth-1] = hasher.HashChildren(left, right)
+	}
+	// Every stratum above the last is a single byte deep.
+	tiles := make([]api.MapTile, api.MapPrefixStrata+1)
+	for i := range tiles {
+		end := i + 1
+		if i == api.MapPrefixStrata {
+			end = len(key)
+		}
+		tiles[i] = api.MapTile{Path: key[:i], Leaves: []api.MapTileLeaf{{Path: key[i:end], Hash: hashes[end*8]}}}
+	}
+	return tiles, hashes[0]
+}
+
+// absentKey returns a key which is not in the map, and whose hash starts with the given byte.
+func absentKey(first byte) string {
+	for i := 0; ; i++ {
+		k := fmt.Sprintf("absent:%d", i)
+		if kbs := sha512.Sum512_256([]byte(k)); kbs[0] == first {
+			return k
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	root, _ := hex.DecodeString(mapRoot)
+	deviceKey := sha512.Sum512_256([]byte("dummy"))
+	deviceTiles, deviceRoot := singleLeafMap(deviceKey[:], []byte("log root"))
+	emptyTileKey := absentKey(0x00)
+	emptyLeafKey := absentKey(0xec)
+
+	for _, test := range []struct {
+		desc  string
+		key   string
+		tiles []api.MapTile
+		// value is nil if the key is absent from the map.
+		value   []byte
+		root    []byte
+		wantErr bool
+	}{
+		{
+			desc:  "aggregation 0",
+			key:   "summary:0",
+			tiles: []api.MapTile{mustParseTile(t, rootTile), mustParseTile(t, leafTile0)},
+			value: []byte(`{"Index":0,"Good":true}`),
+			root:  root,
+		}, {
+			desc:  "aggregation 1",
+			key:   "summary:1",
+			tiles: []api.MapTile{mustParseTile(t, rootTile), mustParseTile(t, leafTile1)},
+			value: []byte(`{"Index":1,"Good":false}`),
+			root:  root,
+		}, {
+			desc:  "single leaf",
+			key:   "dummy",
+			tiles: deviceTiles,
+			value: []byte("log root"),
+			root:  deviceRoot,
+		}, {
+			desc:  "absent in empty tile",
+			key:   emptyTileKey,
+			tiles: []api.MapTile{mustParseTile(t, rootTile)},
+			root:  root,
+		}, {
+			desc:  "absent in leaf tile",
+			key:   emptyLeafKey,
+			tiles: []api.MapTile{mustParseTile(t, rootTile), mustParseTile(t, leafTile1)},
+			root:  root,
+		}, {
+			desc:  "absent from single leaf map",
+			key:   emptyTileKey,
+			tiles: deviceTiles[:1],
+			root:  deviceRoot,
+		}, {
+			desc:    "missing tile",
+			key:     "summary:0",
+			tiles:   []api.MapTile{mustParseTile(t, rootTile)},
+			wantErr: true,
+		}, {
+			desc:    "empty tile",
+			key:     "summary:0",
+			tiles:   []api.MapTile{mustParseTile(t, rootTile), {Path: []byte{0x46}}},
+			wantErr: true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			kbs := sha512.Sum512_256([]byte(test.key))
+			proof, err := mapproof.Build(kbs[:], test.tiles)
+			switch {
+			case err != nil && !test.wantErr:
+				t.Fatalf("unexpected error: %v", err)
+			case err == nil && test.wantErr:
+				t.Fatal("expected error, got none")
+			case err != nil && test.wantErr:
+				return
+			}
+			if test.value == nil {
+				if err := verify.MapNonInclusion(test.root, kbs[:], proof); err != nil {
+					t.Errorf("MapNonInclusion(): %v", err)
+				}
+				if err := verify.MapNonInclusion([]byte("wrong root"), kbs[:], proof); err == nil {
+					t.Error("MapNonInclusion() succeeded for the wrong root")
+				}
+				return
+			}
+			if err := verify.MapInclusion(test.root, kbs[:], test.value, proof); err != nil {
+				t.Errorf("MapInclusion(): %v", err)
+			}
+			if err := verify.MapInclusion(test.root, kbs[:], []byte(fmt.Sprintf("not %s", test.value)), proof); err == nil {
+				t.Error("MapInclusion() succeeded for the wrong value")
+			}
+			if err := verify.MapNonInclusion(test.root, kbs[:], proof); err == nil {
+				t.Error("MapNonInclusion() succeeded for a key in the map")
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tiles
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	fmtlog "github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/serverless-log/api/layout"
+	"github.com/transparency-dev/serverless-log/pkg/log"
+)
+
+// maxBatchSize is the largest number of entries requested from the source log at once.
+const maxBatchSize = 256
+
+// Log is the source of entries for the tile-based log.
+type Log interface {
+	// LeavesByRange returns up to count entries starting at index start.
+	LeavesByRange(ctx context.Context, start, count uint64) ([][]byte, error)
+}
+
+// Publisher keeps the tile-based log in step with checkpoints issued by the source log.
+type Publisher struct {
+	log Log
+	st  *Storage
+	// cpRaw is the checkpoint in storage, and size is the number of entries it commits to.
+	cpRaw []byte
+	size  uint64
+}
+
+// NewPublisher returns a Publisher that copies entries from the log into the storage,
+// carrying on from the checkpoint already in storage, if any.
+func NewPublisher(l Log, st *Storage) (*Publisher, error) {
+	p := &Publisher{log: l, st: st}
+	cpRaw, err := st.ReadCheckpoint()
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	cp, err := parseCheckpoint(cpRaw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid checkpoint in storage: %w", err)
+	}
+	p.cpRaw, p.size = cpRaw, cp.Size
+	return p, nil
+}
+
+// Publish copies entries up to the size of the signed checkpoint from the log, builds
+// the tiles for them, and then publishes the checkpoint. The checkpoint is only published
+// if the tiles commit to the same root hash, so clients never see a checkpoint that they
+// can't build proofs for.
+func (p *Publisher) Publish(ctx context.Context, cpRaw []byte) error {
+	if bytes.Equal(cpRaw, p.cpRaw) {
+		return nil
+	}
+	cp, err := parseCheckpoint(cpRaw)
+	if err != nil {
+		return err
+	}
+	if cp.Size < p.size {
+		return fmt.Errorf("checkpoint size %d is smaller than published size %d", cp.Size, p.size)
+	}
+
+	for i := p.size; i < cp.Size; {
+		count := cp.Size - i
+		if count > maxBatchSize {
+			count = maxBatchSize
+		}
+		leaves, err := p.log.LeavesByRange(ctx, i, count)
+		if err != nil {
+			return fmt.Errorf("failed to fetch entries from %d: %w", i, err)
+		}
+		if len(leaves) == 0 {
+			return fmt.Errorf("log returned no entries from %d", i)
+		}
+		for _, l := range leaves {
+			// Entries may already be present if a previous call failed part way through.
+			if err := p.st.Assign(ctx, i, l); err != nil && !errors.Is(err, log.ErrSeqAlreadyAssigned) {
+				return fmt.Errorf("failed to store entry %d: %w", i, err)
+			}
+			i++
+		}
+	}
+
+	if cp.Size > p.size {
+		newCP, err := log.Integrate(ctx, p.size, p.st, rfc6962.DefaultHasher)
+		if err != nil {
+			return fmt.Errorf("failed to integrate entries: %w", err)
+		}
+		if newCP == nil || newCP.Size != cp.Size || !bytes.Equal(newCP.Hash, cp.Hash) {
+			return fmt.Errorf("tiles do not match checkpoint at size %d with root %x (got %+v)", cp.Size, cp.Hash, newCP)
+		}
+	}
+	if err := p.st.WriteCheckpoint(ctx, cpRaw); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	glog.V(1).Infof("Published tiles for tree size %d", cp.Size)
+	p.cpRaw, p.size = cpRaw, cp.Size
+	return nil
+}
+
+// parseCheckpoint returns the checkpoint in the body of a signed note.
+// Signatures are not checked, as the note was signed by the log itself.
+func parseCheckpoint(cpRaw []byte) (*fmtlog.Checkpoint, error) {
+	i := bytes.Index(cpRaw, []byte("\n\n"))
+	if i < 0 {
+		return nil, errors.New("checkpoint is not a signed note")
+	}
+	cp := &fmtlog.Checkpoint{}
+	if _, err := cp.Unmarshal(cpRaw[:i+1]); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint: %w", err)
+	}
+	return cp, nil
+}
+
+// Handler serves the files of the tile-based log under root.
+// Everything except the checkpoint is immutable once written, so can be cached indefinitely.
+func Handler(root string) http.Handler {
+	files := http.FileServer(http.Dir(root))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := path.Clean("/" + r.URL.Path)
+		// Don't serve directory listings, or cache a 404 for a file that will be written later.
+		if fi, err := os.Stat(filepath.Join(root, filepath.FromSlash(p))); err != nil || fi.IsDir() || strings.HasPrefix(path.Base(p), ".") {
+			http.NotFound(w, r)
+			return
+		}
+		if p == "/"+layout.CheckpointPath {
+			w.Header().Set("Cache-Control", "no-cache")
+		} else {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		}
+		files.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,187 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tiles
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
+	fmtlog "github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/merkle/compact"
+	"github.com/transparency-dev/merkle/rfc6962"
+	"golang.org/x/mod/sumdb/note"
+)
+
+type fakeLog [][]byte
+
+func (l fakeLog) LeavesByRange(_ context.Context, start, count uint64) ([][]byte, error) {
+	if start >= uint64(len(l)) {
+		return nil, fmt.Errorf("no leaves at %d", start)
+	}
+	end := start + count
+	if end > uint64(len(l)) {
+		end = uint64(len(l))
+	}
+	// Return fewer leaves than requested, as Trillian may.
+	if end-start > 100 {
+		end = start + 100
+	}
+	return l[start:end], nil
+}
+
+func newFakeLog(n int) fakeLog {
+	l := make(fakeLog, n)
+	for i := range l {
+		l[i] = []byte(fmt.Sprintf("leaf %d", i))
+	}
+	return l
+}
+
+// signedCheckpoint returns a checkpoint signed by the test log key committing to the first size leaves.
+func signedCheckpoint(t *testing.T, l fakeLog, size uint64) []byte {
+	t.Helper()
+	r := (&compact.RangeFactory{Hash: rfc6962.DefaultHasher.HashChildren}).NewEmptyRange(0)
+	for _, leaf := range l[:size] {
+		if err := r.Append(rfc6962.DefaultHasher.HashLeaf(leaf), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, err := r.GetRootHash(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signCheckpoint(t, size, root)
+}
+
+func signCheckpoint(t *testing.T, size uint64, root []byte) []byte {
+	t.Helper()
+	cp := api.LogCheckpoint{
+		Checkpoint:     fmtlog.Checkpoint{Origin: api.FTLogOrigin, Size: size, Hash: root},
+		TimestampNanos: 123,
+	}
+	s, err := note.NewSigner(crypto.TestFTPersonalityPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := note.Sign(&note.Note{Text: string(cp.Marshal())}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestPublish(t *testing.T) {
+	ctx := context.Background()
+	l := newFakeLog(600)
+	root := t.TempDir()
+	st, err := NewStorage(root)
+	if err != nil {
+		t.Fatalf("NewStorage(): %v", err)
+	}
+	p, err := NewPublisher(l, st)
+	if err != nil {
+		t.Fatalf("NewPublisher(): %v", err)
+	}
+
+	// Grow the log across tile boundaries, including an unchanged checkpoint.
+	for _, size := range []uint64{3, 3, 256, 300, 600} {
+		cp := signedCheckpoint(t, l, size)
+		if err := p.Publish(ctx, cp); err != nil {
+			t.Fatalf("Publish(%d): %v", size, err)
+		}
+		got, err := st.ReadCheckpoint()
+		if err != nil {
+			t.Fatalf("ReadCheckpoint(): %v", err)
+		}
+		if !bytes.Equal(got, cp) {
+			t.Errorf("got checkpoint %q, want %q", got, cp)
+		}
+	}
+
+	// A reloaded publisher carries on from the stored checkpoint.
+	p, err = NewPublisher(l, st)
+	if err != nil {
+		t.Fatalf("NewPublisher(): %v", err)
+	}
+	if p.size != 600 {
+		t.Errorf("got size %d after reloading, want 600", p.size)
+	}
+
+	// A checkpoint which doesn't match the entries is not published.
+	l = append(l, []byte("leaf 600"))
+	want, err := st.ReadCheckpoint()
+	if err != nil {
+		t.Fatalf("ReadCheckpoint(): %v", err)
+	}
+	if err := p.Publish(ctx, signCheckpoint(t, 601, []byte("not the root hash of the log"))); err == nil {
+		t.Error("Publish() with mismatched root hash succeeded")
+	}
+	if got, err := st.ReadCheckpoint(); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("checkpoint changed after failed Publish(): %q, %v", got, err)
+	}
+	if err := p.Publish(ctx, signedCheckpoint(t, l, 300)); err == nil {
+		t.Error("Publish() with smaller checkpoint succeeded")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	root := t.TempDir()
+	st, err := NewStorage(root)
+	if err != nil {
+		t.Fatalf("NewStorage(): %v", err)
+	}
+	l := newFakeLog(10)
+	p, err := NewPublisher(l, st)
+	if err != nil {
+		t.Fatalf("NewPublisher(): %v", err)
+	}
+	if err := p.Publish(context.Background(), signedCheckpoint(t, l, 10)); err != nil {
+		t.Fatalf("Publish(): %v", err)
+	}
+	ts := httptest.NewServer(Handler(root))
+	defer ts.Close()
+
+	for _, test := range []struct {
+		path             string
+		wantCode         int
+		wantCacheControl string
+	}{
+		{path: "/checkpoint", wantCode: http.StatusOK, wantCacheControl: "no-cache"},
+		{path: "/tile/00/0000/00/00/00.0a", wantCode: http.StatusOK, wantCacheControl: "public, max-age=31536000, immutable"},
+		{path: "/seq/00/00/00/00/09", wantCode: http.StatusOK, wantCacheControl: "public, max-age=31536000, immutable"},
+		{path: "/seq/00/00/00/00/0a", wantCode: http.StatusNotFound},
+		{path: "/tile/", wantCode: http.StatusNotFound},
+	} {
+		t.Run(test.path, func(t *testing.T) {
+			resp, err := http.Get(ts.URL + test.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if got, want := resp.StatusCode, test.wantCode; got != want {
+				t.Errorf("got status %d, want %d", got, want)
+			}
+			if got, want := resp.Header.Get("Cache-Control"), test.wantCacheControl; got != want {
+				t.Errorf("got Cache-Control %q, want %q", got, want)
+			}
+		})
+	}
+}
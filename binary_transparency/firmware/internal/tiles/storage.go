@@ -0,0 +1,185 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tiles publishes the FT log in the tile-based (serverless) layout, so that
+// clients can build proofs from static, cacheable files rather than asking the log.
+package tiles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/serverless-log/api"
+	"github.com/transparency-dev/serverless-log/api/layout"
+	"github.com/transparency-dev/serverless-log/pkg/log"
+)
+
+const (
+	dirPerm  = 0o755
+	filePerm = 0o644
+)
+
+// Storage keeps a tile-based copy of the log in a directory on the filesystem.
+// The on-disk structure follows the serverless log layout:
+//
+//	<root>/checkpoint
+//	<root>/seq/aa/bb/cc/dd/ee
+//	<root>/leaves/aa/bb/cc/ddeeff...
+//	<root>/tile/<level>/aaaa/bb/cc/dd[.xx]
+//
+// Entries are copied from the source log in order with Assign, rather than being
+// sequenced here. The functions on this struct are not thread-safe.
+type Storage struct {
+	root string
+}
+
+// NewStorage returns a Storage for the log under the given directory, creating it if needed.
+func NewStorage(root string) (*Storage, error) {
+	if len(root) == 0 {
+		return nil, errors.New("tiles directory is required")
+	}
+	for _, d := range []string{"seq", "leaves", "tile"} {
+		if err := os.MkdirAll(filepath.Join(root, d), dirPerm); err != nil {
+			return nil, fmt.Errorf("failed to create tiles directory: %w", err)
+		}
+	}
+	return &Storage{root: root}, nil
+}
+
+// Assign stores the entry at the given index, along with a file mapping its leaf hash
+// back to the index so that clients can look it up.
+// It returns log.ErrSeqAlreadyAssigned if there is already an entry at the index.
+func (s *Storage) Assign(_ context.Context, seq uint64, entry []byte) error {
+	seqDir, seqFile := layout.SeqPath(s.root, seq)
+	if err := os.MkdirAll(seqDir, dirPerm); err != nil {
+		return fmt.Errorf("failed to create seq directory: %w", err)
+	}
+	seqPath := filepath.Join(seqDir, seqFile)
+	if _, err := os.Stat(seqPath); err == nil {
+		return log.ErrSeqAlreadyAssigned
+	}
+	if err := writeAtomic(seqPath, entry); err != nil {
+		return fmt.Errorf("failed to write entry %d: %w", seq, err)
+	}
+
+	leafDir, leafFile := layout.LeafPath(s.root, rfc6962.DefaultHasher.HashLeaf(entry))
+	if err := os.MkdirAll(leafDir, dirPerm); err != nil {
+		return fmt.Errorf("failed to create leaves directory: %w", err)
+	}
+	leafPath := filepath.Join(leafDir, leafFile)
+	if _, err := os.Stat(leafPath); err == nil {
+		// Keep the first index for duplicate entries.
+		return nil
+	}
+	if err := writeAtomic(leafPath, []byte(strconv.FormatUint(seq, 16))); err != nil {
+		return fmt.Errorf("failed to write leaf index for entry %d: %w", seq, err)
+	}
+	return nil
+}
+
+// Sequence is not supported, as entries are assigned the index they have in the source log.
+func (s *Storage) Sequence(_ context.Context, _ []byte, _ []byte) (uint64, error) {
+	return 0, errors.New("entries must be assigned from the source log")
+}
+
+// ScanSequenced calls f for each contiguous entry starting at begin, and returns the
+// number of entries scanned.
+func (s *Storage) ScanSequenced(_ context.Context, begin uint64, f func(seq uint64, entry []byte) error) (uint64, error) {
+	end := begin
+	for {
+		entry, err := os.ReadFile(filepath.Join(layout.SeqPath(s.root, end)))
+		if errors.Is(err, os.ErrNotExist) {
+			return end - begin, nil
+		} else if err != nil {
+			return end - begin, fmt.Errorf("failed to read entry %d: %w", end, err)
+		}
+		if err := f(end, entry); err != nil {
+			return end - begin, err
+		}
+		end++
+	}
+}
+
+// GetTile returns the tile at the given level and index for a log of the given size.
+func (s *Storage) GetTile(_ context.Context, level, index, logSize uint64) (*api.Tile, error) {
+	tileSize := layout.PartialTileSize(level, index, logSize)
+	p := filepath.Join(layout.TilePath(s.root, level, index, tileSize))
+	t, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var tile api.Tile
+	if err := tile.UnmarshalText(t); err != nil {
+		return nil, fmt.Errorf("failed to parse tile at %q: %w", p, err)
+	}
+	return &tile, nil
+}
+
+// StoreTile writes the tile at the given level and index.
+// Partial tiles are stored with a suffix of their size, and are left in place once
+// the tile is full so that clients with an older checkpoint can still fetch them.
+func (s *Storage) StoreTile(_ context.Context, level, index uint64, tile *api.Tile) error {
+	tileSize := uint64(tile.NumLeaves)
+	if tileSize == 0 || tileSize > 256 {
+		return fmt.Errorf("tileSize %d must be > 0 and <= 256", tileSize)
+	}
+	t, err := tile.MarshalText()
+	if err != nil {
+		return fmt.Errorf("failed to marshal tile: %w", err)
+	}
+	tDir, tFile := layout.TilePath(s.root, level, index, tileSize%256)
+	if err := os.MkdirAll(tDir, dirPerm); err != nil {
+		return fmt.Errorf("failed to create tile directory: %w", err)
+	}
+	return writeAtomic(filepath.Join(tDir, tFile), t)
+}
+
+// WriteCheckpoint replaces the checkpoint served to clients.
+func (s *Storage) WriteCheckpoint(_ context.Context, cpRaw []byte) error {
+	return writeAtomic(filepath.Join(s.root, layout.CheckpointPath), cpRaw)
+}
+
+// ReadCheckpoint returns the checkpoint last written, or os.ErrNotExist if there is none.
+func (s *Storage) ReadCheckpoint() ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.root, layout.CheckpointPath))
+}
+
+// writeAtomic writes the data to a temporary file and renames it into place, so that
+// readers never see a partially written file.
+func writeAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Remove is a no-op failure once the file has been renamed into place.
+		_ = os.Remove(f.Name())
+	}()
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), filePerm); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -26,7 +26,6 @@ import (
 	"github.com/google/trillian-examples/binary_transparency/firmware/internal/crypto"
 	"github.com/transparency-dev/merkle/proof"
 	"github.com/transparency-dev/merkle/rfc6962"
-	"golang.org/x/mod/sumdb/note"
 )
 
 // ConsistencyProofFunc is a function which returns a consistency proof between two tree sizes.
@@ -36,13 +35,18 @@ type ConsistencyProofFunc func(from, to uint64) ([][]byte, error)
 // are all self-consistent, and that the provided firmware image hash matches
 // the one in the bundle. It also checks consistency proof between update log point
 // and device log point (for non zero device tree size). Upon successful verification
-// returns a proof bundle
-func BundleForUpdate(bundleRaw, fwHash []byte, dc api.LogCheckpoint, cpFunc ConsistencyProofFunc, logSigVerifier note.Verifier) (api.ProofBundle, api.FirmwareMetadata, error) {
-	proofBundle, fwMeta, err := verifyBundle(bundleRaw, logSigVerifier)
+// returns a proof bundle. The manifest must be signed by one of the claimants.
+// If installed is non-nil, the update must have a higher revision than the installed firmware.
+func BundleForUpdate(bundleRaw, fwHash []byte, dc api.LogCheckpoint, cpFunc ConsistencyProofFunc, logSigVerifier api.LogVerifier, claimants *crypto.ClaimantRegistry, installed *api.FirmwareMetadata) (api.ProofBundle, api.FirmwareMetadata, error) {
+	proofBundle, fwMeta, err := verifyBundle(bundleRaw, logSigVerifier, claimants)
 	if err != nil {
 		return proofBundle, fwMeta, err
 	}
 
+	if installed != nil && fwMeta.FirmwareRevision <= installed.FirmwareRevision {
+		return proofBundle, fwMeta, fmt.Errorf("firmware update revision %d is not newer than installed revision %d", fwMeta.FirmwareRevision, installed.FirmwareRevision)
+	}
+
 	if got, want := fwHash, fwMeta.FirmwareImageSHA512; !bytes.Equal(got, want) {
 		return proofBundle, fwMeta, fmt.Errorf("firmware update image hash does not match metadata (0x%x != 0x%x)", got, want)
 	}
@@ -68,7 +72,7 @@ func BundleForUpdate(bundleRaw, fwHash []byte, dc api.LogCheckpoint, cpFunc Cons
 }
 
 // BundleConsistency verifies the log checkpoint in the bundle is consistent against a given checkpoint (e.g. one fetched from a witness).
-func BundleConsistency(pb api.ProofBundle, rc api.LogCheckpoint, cpFunc ConsistencyProofFunc, logSigVerifier note.Verifier) error {
+func BundleConsistency(pb api.ProofBundle, rc api.LogCheckpoint, cpFunc ConsistencyProofFunc, logSigVerifier api.LogVerifier) error {
 
 	glog.V(1).Infof("Remote TreeSize=%d, Inclusion Index=%d \n", rc.Size, pb.InclusionProof.LeafIndex)
 	if rc.Size < pb.InclusionProof.LeafIndex {
@@ -95,11 +99,41 @@ func BundleConsistency(pb api.ProofBundle, rc api.LogCheckpoint, cpFunc Consiste
 	return nil
 }
 
+// WitnessedCheckpoint is a log checkpoint which has been countersigned by the named witness.
+type WitnessedCheckpoint struct {
+	Witness    string
+	Checkpoint api.LogCheckpoint
+}
+
+// BundleWitnessQuorum verifies the log checkpoint in the bundle is consistent with the checkpoints
+// from at least threshold distinct witnesses. Checkpoints which fail verification are logged and
+// do not count towards the threshold, nor do repeated checkpoints from the same witness.
+func BundleWitnessQuorum(pb api.ProofBundle, wcps []WitnessedCheckpoint, threshold int, cpFunc ConsistencyProofFunc, logSigVerifier api.LogVerifier) error {
+	if threshold <= 0 {
+		return fmt.Errorf("witness threshold must be positive, got %d", threshold)
+	}
+	consistent := make(map[string]bool)
+	for _, wcp := range wcps {
+		if consistent[wcp.Witness] {
+			continue
+		}
+		if err := BundleConsistency(pb, wcp.Checkpoint, cpFunc, logSigVerifier); err != nil {
+			glog.Warningf("Checkpoint from witness %q is not consistent with bundle: %v", wcp.Witness, err)
+			continue
+		}
+		consistent[wcp.Witness] = true
+	}
+	if got := len(consistent); got < threshold {
+		return fmt.Errorf("bundle checkpoint is consistent with %d witnesses, but %d are required", got, threshold)
+	}
+	return nil
+}
+
 // BundleForBoot checks that the manifest, checkpoint, and proofs in a bundle
 // are all self-consistent, and that the provided firmware measurement matches
-// the one expected by the bundle.
-func BundleForBoot(bundleRaw, measurement []byte, logSigVerifier note.Verifier) error {
-	_, fwMeta, err := verifyBundle(bundleRaw, logSigVerifier)
+// the one expected by the bundle. The manifest must be signed by one of the claimants.
+func BundleForBoot(bundleRaw, measurement []byte, logSigVerifier api.LogVerifier, claimants *crypto.ClaimantRegistry) error {
+	_, fwMeta, err := verifyBundle(bundleRaw, logSigVerifier, claimants)
 	if err != nil {
 		return err
 	}
@@ -111,7 +145,7 @@ func BundleForBoot(bundleRaw, measurement []byte, logSigVerifier note.Verifier)
 }
 
 // verifyBundle parses a proof bundle and verifies its self-consistency.
-func verifyBundle(bundleRaw []byte, logSigVerifier note.Verifier) (api.ProofBundle, api.FirmwareMetadata, error) {
+func verifyBundle(bundleRaw []byte, logSigVerifier api.LogVerifier, claimants *crypto.ClaimantRegistry) (api.ProofBundle, api.FirmwareMetadata, error) {
 	var pb api.ProofBundle
 	if err := json.Unmarshal(bundleRaw, &pb); err != nil {
 		return api.ProofBundle{}, api.FirmwareMetadata{}, fmt.Errorf("failed to parse proof bundle: %w", err)
@@ -127,7 +161,8 @@ func verifyBundle(bundleRaw []byte, logSigVerifier note.Verifier) (api.ProofBund
 		return api.ProofBundle{}, api.FirmwareMetadata{}, fmt.Errorf("failed to unmarshal SignedStatement: %w", err)
 	}
 	// Verify the statement signature:
-	if err := crypto.Publisher.VerifySignature(fwStatement.Type, fwStatement.Statement, fwStatement.Signature); err != nil {
+	publisher, err := claimants.VerifyStatement(fwStatement)
+	if err != nil {
 		return api.ProofBundle{}, api.FirmwareMetadata{}, fmt.Errorf("failed to verify signature on SignedStatement: %w", err)
 	}
 	if fwStatement.Type != api.FirmwareMetadataType {
@@ -144,6 +179,9 @@ func verifyBundle(bundleRaw []byte, logSigVerifier note.Verifier) (api.ProofBund
 	if err := json.Unmarshal(fwStatement.Statement, &fwMeta); err != nil {
 		return api.ProofBundle{}, api.FirmwareMetadata{}, fmt.Errorf("failed to unmarshal Metadata: %w", err)
 	}
+	if err := claimants.AuthorizeFirmware(fwMeta.DeviceID, publisher); err != nil {
+		return api.ProofBundle{}, api.FirmwareMetadata{}, fmt.Errorf("firmware publisher not authorized: %w", err)
+	}
 
 	return pb, fwMeta, nil
 }
@@ -38,9 +38,9 @@ const (
 	goldenFirmwareHashB64 = "6tUWyykzwfoonGugnf1dL+cgd6bEWUholANaDL8JYKWtdmPcEeYvgO6nMr/ILgWPcUYggvAArrtaSBman5H4Kg=="
 )
 
-func mustGetLogSigVerifier(t *testing.T) note.Verifier {
+func mustGetLogSigVerifier(t *testing.T) api.LogVerifier {
 	t.Helper()
-	v, err := note.NewVerifier(crypto.TestFTPersonalityPub)
+	v, err := api.NewLogVerifier(api.FTLogOrigin, crypto.TestFTPersonalityPub)
 	if err != nil {
 		t.Fatalf("failed to create verifier: %q", err)
 	}
@@ -68,9 +68,10 @@ func TestBundleForUpdate(t *testing.T) {
 	getProof := func(from, to uint64) ([][]byte, error) { return [][]byte{}, nil }
 
 	for _, test := range []struct {
-		desc    string
-		img     []byte
-		wantErr bool
+		desc      string
+		img       []byte
+		installed *api.FirmwareMetadata
+		wantErr   bool
 	}{
 		{
 			desc: "all good",
@@ -79,11 +80,25 @@ func TestBundleForUpdate(t *testing.T) {
 			desc:    "bad image hash",
 			img:     []byte("this is wrong"),
 			wantErr: true,
+		}, {
+			desc:      "newer than installed",
+			img:       []byte(goldenFirmwareImage),
+			installed: &api.FirmwareMetadata{DeviceID: "dummy", FirmwareRevision: 0},
+		}, {
+			desc:      "same as installed",
+			img:       []byte(goldenFirmwareImage),
+			installed: &api.FirmwareMetadata{DeviceID: "dummy", FirmwareRevision: 1},
+			wantErr:   true,
+		}, {
+			desc:      "older than installed",
+			img:       []byte(goldenFirmwareImage),
+			installed: &api.FirmwareMetadata{DeviceID: "dummy", FirmwareRevision: 2},
+			wantErr:   true,
 		},
 	} {
 		t.Run(test.desc, func(t *testing.T) {
 			imgHash := sha512.Sum512(test.img)
-			_, _, err := verify.BundleForUpdate([]byte(goldenProofBundle), imgHash[:], dc, getProof, mustGetLogSigVerifier(t))
+			_, _, err := verify.BundleForUpdate([]byte(goldenProofBundle), imgHash[:], dc, getProof, mustGetLogSigVerifier(t), crypto.TestClaimantRegistry(), test.installed)
 			if (err != nil) != test.wantErr {
 				var lve proof.RootMismatchError
 				if errors.As(err, &lve) {
@@ -96,6 +111,56 @@ func TestBundleForUpdate(t *testing.T) {
 	}
 }
 
+func TestBundlePublisherPolicy(t *testing.T) {
+	var dc api.LogCheckpoint
+	getProof := func(from, to uint64) ([][]byte, error) { return [][]byte{}, nil }
+	imgHash := sha512.Sum512([]byte(goldenFirmwareImage))
+
+	for _, test := range []struct {
+		desc    string
+		devices []crypto.DevicePolicyConfig
+		wantErr bool
+	}{
+		{
+			desc: "no policy",
+		}, {
+			desc:    "publisher authorized for device",
+			devices: []crypto.DevicePolicyConfig{{DeviceID: "dummy", Publishers: []string{"test-vendor"}}},
+		}, {
+			desc:    "publisher authorized for prefix",
+			devices: []crypto.DevicePolicyConfig{{DeviceIDPrefix: "dum", Publishers: []string{"test-vendor"}}},
+		}, {
+			desc:    "other publisher authorized for device",
+			devices: []crypto.DevicePolicyConfig{{DeviceID: "dummy", Publishers: []string{"other-vendor"}}},
+			wantErr: true,
+		}, {
+			desc:    "no policy for device",
+			devices: []crypto.DevicePolicyConfig{{DeviceID: "other-device", Publishers: []string{"test-vendor"}}},
+			wantErr: true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			claimants, err := crypto.NewClaimantRegistry(crypto.RegistryConfig{
+				Claimants: []crypto.ClaimantConfig{
+					{ID: "test-vendor", Type: "f", PublicKey: crypto.TestVendorRSAPub},
+					{ID: "other-vendor", Type: "f", PublicKey: crypto.TestAnnotationPub},
+				},
+				Devices: test.devices,
+			}, "")
+			if err != nil {
+				t.Fatalf("NewClaimantRegistry(): %v", err)
+			}
+			_, _, uerr := verify.BundleForUpdate([]byte(goldenProofBundle), imgHash[:], dc, getProof, mustGetLogSigVerifier(t), claimants, nil)
+			berr := verify.BundleForBoot([]byte(goldenProofBundle), b64Decode(t, goldenFirmwareHashB64), mustGetLogSigVerifier(t), claimants)
+			for name, err := range map[string]error{"BundleForUpdate": uerr, "BundleForBoot": berr} {
+				if (err != nil) != test.wantErr {
+					t.Errorf("%s: want err %v, got %q", name, test.wantErr, err)
+				}
+			}
+		})
+	}
+}
+
 func b64Decode(t *testing.T, b64 string) []byte {
 	t.Helper()
 	st, err := base64.StdEncoding.DecodeString(b64)
@@ -121,7 +186,7 @@ func TestBundleForBoot(t *testing.T) {
 		},
 	} {
 		t.Run(test.desc, func(t *testing.T) {
-			err := verify.BundleForBoot([]byte(goldenProofBundle), test.measurement, mustGetLogSigVerifier(t))
+			err := verify.BundleForBoot([]byte(goldenProofBundle), test.measurement, mustGetLogSigVerifier(t), crypto.TestClaimantRegistry())
 			if (err != nil) != test.wantErr {
 				t.Fatalf("want err %v, got %q", test.wantErr, err)
 			}
@@ -168,3 +233,56 @@ func TestGoldenBundleGeneration(t *testing.T) {
 	}
 
 }
+
+func TestBundleWitnessQuorum(t *testing.T) {
+	var pb api.ProofBundle
+	if err := json.Unmarshal([]byte(goldenProofBundle), &pb); err != nil {
+		t.Fatalf("failed to parse proof bundle: %v", err)
+	}
+	cp, err := api.ParseCheckpoint(pb.Checkpoint, mustGetLogSigVerifier(t))
+	if err != nil {
+		t.Fatalf("failed to parse bundle checkpoint: %v", err)
+	}
+	forked := *cp
+	forked.Hash = []byte("a different root hash")
+	getProof := func(from, to uint64) ([][]byte, error) { return [][]byte{}, nil }
+
+	for _, test := range []struct {
+		desc      string
+		wcps      []verify.WitnessedCheckpoint
+		threshold int
+		wantErr   bool
+	}{
+		{
+			desc:      "quorum",
+			wcps:      []verify.WitnessedCheckpoint{{"a", *cp}, {"b", *cp}, {"c", forked}},
+			threshold: 2,
+		}, {
+			desc:      "too few consistent",
+			wcps:      []verify.WitnessedCheckpoint{{"a", *cp}, {"b", forked}, {"c", forked}},
+			threshold: 2,
+			wantErr:   true,
+		}, {
+			desc:      "same witness twice",
+			wcps:      []verify.WitnessedCheckpoint{{"a", *cp}, {"a", *cp}},
+			threshold: 2,
+			wantErr:   true,
+		}, {
+			desc:      "no witnesses",
+			threshold: 1,
+			wantErr:   true,
+		}, {
+			desc:      "zero threshold",
+			wcps:      []verify.WitnessedCheckpoint{{"a", *cp}},
+			threshold: 0,
+			wantErr:   true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			err := verify.BundleWitnessQuorum(pb, test.wcps, test.threshold, getProof, mustGetLogSigVerifier(t))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("want err %v, got %q", test.wantErr, err)
+			}
+		})
+	}
+}
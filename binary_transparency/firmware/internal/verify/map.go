@@ -0,0 +1,95 @@
+// Copyright 2021 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/google/trillian-examples/binary_transparency/firmware/api"
+	"github.com/google/trillian/merkle/coniks"
+	"github.com/google/trillian/merkle/smt/node"
+)
+
+// MapInclusion checks that the inclusion proof commits to the value under the key
+// in the FT map with the given root hash. The value is the preimage of the leaf hash.
+func MapInclusion(root, key, value []byte, ip api.MapInclusionProof) error {
+	if ip.Value == nil {
+		return errors.New("proof is for an empty leaf")
+	}
+	leafID := node.NewID(string(key), uint(len(key))*8)
+	if want := coniks.Default.HashLeaf(api.MapTreeID, leafID, value); !bytes.Equal(ip.Value, want) {
+		return fmt.Errorf("proof is for value %x but wanted %x", ip.Value, want)
+	}
+	return verifyMapProof(root, key, ip)
+}
+
+// MapNonInclusion checks that the proof shows that there is no value under the key
+// in the FT map with the given root hash.
+func MapNonInclusion(root, key []byte, ip api.MapInclusionProof) error {
+	if ip.Value != nil {
+		return fmt.Errorf("proof is for value %x, not an empty leaf", ip.Value)
+	}
+	return verifyMapProof(root, key, ip)
+}
+
+// verifyMapProof checks that hashing up from the leaf in the proof, using the siblings
+// from the proof, leads to the root hash.
+func verifyMapProof(root, key []byte, ip api.MapInclusionProof) error {
+	if !bytes.Equal(ip.Key, key) {
+		return fmt.Errorf("proof is for key %x but wanted %x", ip.Key, key)
+	}
+	hasher := coniks.Default
+	if got, want := len(ip.Proof), hasher.BitLen(); got != want {
+		return fmt.Errorf("proof has %d siblings, want %d", got, want)
+	}
+	leafID := node.NewID(string(key), uint(len(key))*8)
+	// calc stays nil while the subtree containing the key is empty. The hash of an
+	// empty subtree depends only on its position, so it is filled in at the first
+	// level which also has a non-empty sibling.
+	calc := ip.Value
+	for pd := hasher.BitLen(); pd > 0; pd-- {
+		sib := ip.Proof[pd-1]
+		stem := leafID.Prefix(uint(pd))
+		if calc == nil {
+			if sib == nil {
+				continue
+			}
+			calc = hasher.HashEmpty(api.MapTreeID, stem)
+		}
+		if sib == nil {
+			sib = hasher.HashEmpty(api.MapTreeID, stem.Sibling())
+		}
+		left, right := calc, sib
+		if !isLeftChild(stem) {
+			left, right = right, left
+		}
+		calc = hasher.HashChildren(left, right)
+	}
+	if calc == nil {
+		calc = hasher.HashEmpty(api.MapTreeID, leafID.Prefix(0))
+	}
+	if !bytes.Equal(calc, root) {
+		return fmt.Errorf("proof calculated root %x but wanted %x", calc, root)
+	}
+	return nil
+}
+
+// isLeftChild returns whether the given node is a left child.
+func isLeftChild(id node.ID) bool {
+	last, bits := id.LastByte()
+	return last&(1<<(8-bits)) == 0
+}